@@ -0,0 +1,148 @@
+package bumper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+
+	"go.uber.org/zap"
+)
+
+// ChartResult is the outcome of one chart in a RunCharts batch.
+type ChartResult struct {
+	// Dir is the chart directory.
+	Dir string
+	// Result is the chart's result; nil if Err is set.
+	Result *Result
+	// Err is why the chart failed.
+	Err error
+}
+
+// FindCharts returns every directory under root that contains a Chart.yaml, sorted. Subcharts
+// under a chart's charts/ directory belong to that chart and are not returned, and hidden
+// directories such as .git are skipped.
+func FindCharts(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if p != root && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if d.Name() == "charts" && isChartDir(filepath.Dir(p)) {
+			return filepath.SkipDir
+		}
+		if isChartDir(p) {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find charts under %s: %w", root, err)
+	}
+	return dirs, nil
+}
+
+func isChartDir(dir string) bool {
+	fi, err := os.Stat(filepath.Join(dir, "Chart.yaml"))
+	return err == nil && !fi.IsDir()
+}
+
+// RunCharts runs the full pipeline for every chart FindCharts discovers under root, with cfg
+// applied to each. cfg must not name a single chart or base file: ChartPath, ChartDir,
+// BasePath, BaseOCI, BaseURL, BaseRefPath, ParentDir, ChangelogPath, ChangelogHints, and
+// Components are rejected. With BaseRef or BaseMergeBase, each chart is compared against the same ref at its
+// own repository-relative path under cfg.RepoRoot.
+//
+// RunCharts stops at the first failing chart and returns its error along with the results so
+// far. With keepGoing it processes every chart instead and returns all failures joined; the
+// failed charts' ChartResult.Err is set. Unless cfg.Resolver is set, the charts share one
+// Resolver.
+func RunCharts(ctx context.Context, root string, cfg Config, keepGoing bool) ([]ChartResult, error) {
+	if cfg.Logger != nil {
+		ctx = logutil.WithLogger(ctx, cfg.Logger)
+	}
+	log := logutil.FromContext(ctx).With(zap.String("func", "bumper.RunCharts"), zap.String("root", root))
+	for _, f := range []struct{ name, value string }{
+		{"ChartPath", cfg.ChartPath},
+		{"ChartDir", cfg.ChartDir},
+		{"BasePath", cfg.BasePath},
+		{"BaseOCI", cfg.BaseOCI},
+		{"BaseURL", cfg.BaseURL},
+		{"BaseRefPath", cfg.BaseRefPath},
+		{"ParentDir", cfg.ParentDir},
+		{"ChangelogPath", cfg.ChangelogPath},
+		{"ChangelogHints", cfg.ChangelogHints},
+	} {
+		if f.value != "" {
+			return nil, fmt.Errorf("%s cannot be used when processing every chart under a root", f.name)
+		}
+	}
+	if len(cfg.Components) > 0 {
+		return nil, fmt.Errorf("Components cannot be used when processing every chart under a root")
+	}
+	dirs, err := FindCharts(root)
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no charts found under %s", root)
+	}
+	log.Debug("found charts", zap.Strings("dirs", dirs))
+	if cfg.UpdateImages && cfg.Resolver == nil {
+		// One Resolver for every chart, so charts sharing images share lookups.
+		if cfg.Resolver, err = NewResolver(cfg); err != nil {
+			return nil, fmt.Errorf("set up registry access: %w", err)
+		}
+	}
+
+	repoRoot := cfg.RepoRoot
+	if repoRoot == "" {
+		repoRoot = "."
+	}
+	var results []ChartResult
+	var errs []error
+	for _, dir := range dirs {
+		c := cfg
+		c.ChartDir = dir
+		if c.BaseRef != "" || c.BaseMergeBase != "" {
+			rel, err := repoRelative(repoRoot, filepath.Join(dir, "Chart.yaml"))
+			if err != nil {
+				return results, err
+			}
+			c.BaseRefPath = rel
+		}
+		res, err := Run(ctx, c)
+		if err != nil {
+			err = fmt.Errorf("chart %s: %w", dir, err)
+			results = append(results, ChartResult{Dir: dir, Err: err})
+			if !keepGoing {
+				return results, err
+			}
+			log.Error("chart failed; continuing", zap.String("dir", dir), zap.Error(err))
+			errs = append(errs, err)
+			continue
+		}
+		results = append(results, ChartResult{Dir: dir, Result: res})
+	}
+	return results, errors.Join(errs...)
+}
+
+// repoRelative returns p relative to repoRoot, with forward slashes, as git stores it.
+func repoRelative(repoRoot, p string) (string, error) {
+	rel, err := filepath.Rel(absOrSelf(repoRoot), absOrSelf(p))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not inside repository %s", p, repoRoot)
+	}
+	return filepath.ToSlash(rel), nil
+}
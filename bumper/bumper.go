@@ -0,0 +1,938 @@
+// Package bumper is the helm-chart-bumper engine: it updates image directives and Helm
+// dependencies in a chart, then bumps the chart version according to what changed since a
+// base Chart.yaml. The helm-chart-bumper command is a thin wrapper around Run.
+package bumper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/changelog"
+	"github.com/joejulian/helm-chart-bumper-action/internal/chart"
+	"github.com/joejulian/helm-chart-bumper-action/internal/gitutil"
+	"github.com/joejulian/helm-chart-bumper-action/internal/helmdeps"
+	"github.com/joejulian/helm-chart-bumper-action/internal/imageresolver"
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+	"github.com/joejulian/helm-chart-bumper-action/internal/ocichart"
+	"github.com/joejulian/helm-chart-bumper-action/internal/semverutil"
+	"github.com/joejulian/helm-chart-bumper-action/internal/textdiff"
+	"github.com/joejulian/helm-chart-bumper-action/internal/yamlutil"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"go.uber.org/zap"
+)
+
+// DefaultScanGlob is the scan glob used when Config.ScanGlob is empty.
+const DefaultScanGlob = "Chart.yaml,values*.yaml"
+
+// Config holds the settings for one run. Its fields mirror the helm-chart-bumper flags.
+type Config struct {
+	// ChartPath is the current Chart.yaml. Its directory is the chart directory.
+	ChartPath string
+	// ChartDir is the chart directory, an alternative to ChartPath: the current Chart.yaml is
+	// the one inside it. Exactly one of ChartPath and ChartDir must be set.
+	ChartDir string
+
+	// Exactly one base source must be set: BasePath (a file), BaseRef (a git ref),
+	// BaseMergeBase (the merge-base of HEAD and a branch), BaseOCI (an OCI chart), or BaseURL
+	// (an HTTP(S) URL serving a raw Chart.yaml).
+	BasePath      string
+	BaseRef       string
+	BaseMergeBase string
+	BaseOCI       string
+	BaseURL       string
+	// BaseRefPath is the repository-relative Chart.yaml path for BaseRef and BaseMergeBase.
+	// It defaults to ChartPath.
+	BaseRefPath string
+	// RepoRoot is the git working tree used with BaseRef and BaseMergeBase. Defaults to ".".
+	RepoRoot string
+
+	// Write writes updated files to disk once every step has succeeded. Otherwise updates are
+	// only computed in memory.
+	Write bool
+	// Atomic, with Write, restores the files already written if writing a later one fails, so
+	// the run writes all of its files or none.
+	Atomic bool
+	// NoVersionBump leaves the chart version as it is, for charts versioned by hand or by
+	// another tool. Images and dependencies are still updated, and only their files are
+	// written. It cannot be combined with the options that shape the bump.
+	NoVersionBump bool
+	// RCWorkflow bumps the chart version as a release candidate (see chart.ApplyRCVersionBump).
+	RCWorkflow bool
+	// MaxBump caps the chart version bump at "patch", "minor", or "major" (the default). A
+	// larger detected change is clamped to the cap, or fails the run with
+	// ErrBumpExceedsMax if FailOnExceedingMax is set.
+	MaxBump            string
+	FailOnExceedingMax bool
+	// ChangelogHints, if set, is a Keep a Changelog style file whose Unreleased section can
+	// raise the bump level (see the README for the mapping).
+	ChangelogHints string
+	// Components are further base and current Chart.yaml pairs, e.g. of app components the
+	// chart aggregates. The chart is bumped by the largest change level across its own pair
+	// and these.
+	Components []ChartPair
+	// ChangelogPath, if set, is a CHANGELOG.md to prepend a section describing the bump to.
+	ChangelogPath string
+	// ParentDir, if set, is a parent chart whose dependency on this chart is set to the
+	// bumped version.
+	ParentDir string
+	// VerifyIdempotent re-runs the pipeline over its own output and fails unless the second
+	// pass changes nothing.
+	VerifyIdempotent bool
+	// Validate loads the updated chart with Helm before anything is written and fails the run
+	// if Helm rejects it.
+	Validate bool
+	// PatchOut, if set, is a directory the updated files are written under instead of in
+	// place, at their paths relative to RepoRoot, leaving the working tree untouched. It
+	// can't be combined with Write.
+	PatchOut string
+	// PostHook, if set, is a command run once Write has written at least one file, e.g.
+	// "helm lint {chartDir}", with {chartDir} replaced by the chart directory. It is split
+	// into arguments at whitespace, honoring quotes, and run without a shell. Its output goes
+	// to stderr, and a non-zero exit fails the run; the written files are left in place.
+	PostHook string
+
+	// UpdateImages processes '# bump:' directives in files matching ScanGlob.
+	UpdateImages bool
+	// ScanGlob is a comma-separated list of globs relative to the chart directory. A glob
+	// prefixed with '!' excludes matching files. Defaults to DefaultScanGlob.
+	ScanGlob string
+	// DirectiveConfig is a config file declaring directives by file and YAML path. Its entries
+	// are merged with inline directives, which win on conflicts. Defaults to .chart-bumper.yaml in the chart directory,
+	// if present.
+	DirectiveConfig string
+	// OnlyPaths, if set, applies only directives whose YAML path is one of these prefixes or
+	// nested under one (e.g. $.image covers $.image.tag). SkipPaths excludes directives the
+	// same way. Filtered directives are scanned but leave their values alone. Template
+	// directives have no YAML path, so OnlyPaths excludes them.
+	OnlyPaths []string
+	SkipPaths []string
+	// DefaultPlatform is the platform (e.g. linux/amd64) for directives without platform=,
+	// so strategy=digest directives can pick one image from a multi-platform index without
+	// repeating it.
+	DefaultPlatform string
+	// Pins maps an image repository to a tag that every directive for it is set to,
+	// whatever its strategy and constraint, e.g. to roll one base image version out across
+	// charts. The tag must exist. strategy=pinned-ref pins the tag's digest as usual;
+	// strategy=digest and strategy=label directives are unaffected, but read the pinned
+	// sibling tag.
+	Pins map[string]string
+	// RecordDigests also resolves the manifest digest of each image tag a directive selects,
+	// reported as ImageChange.Digest, e.g. for provenance in a commit message.
+	RecordDigests bool
+	// ValuesFiles are extra files to scan for '# bump:' directives, e.g. environment values
+	// files kept outside the chart directory. Relative paths are relative to RepoRoot. They
+	// are scanned regardless of ScanGlob.
+	ValuesFiles []string
+	// Resolver, if set, resolves image directives in place of one NewResolver builds from this
+	// Config's registry settings, which are then unused. Share one across runs, e.g. every
+	// chart of a batch, so they share its caches and rate limit.
+	Resolver *Resolver
+	// Keychain authenticates registry requests. Defaults to the Docker config keychain.
+	Keychain authn.Keychain
+	// GitHubTokenFile holds the GitHub token used for ghcr.io and GitHub API requests when
+	// $GITHUB_TOKEN is unset, in place of $GITHUB_TOKEN_FILE. It applies to the default
+	// Keychain only.
+	GitHubTokenFile string
+	// Concurrency is how many directives are resolved at once. Defaults to DefaultConcurrency.
+	Concurrency int
+	// DigestCacheTTL is how long resolved digests are cached. Defaults to 5 minutes.
+	DigestCacheTTL time.Duration
+	// DigestCacheFile, if set, persists the digest cache across runs.
+	DigestCacheFile string
+	// RegistryCacheDir, if set, holds an HTTP cache of registry responses.
+	RegistryCacheDir string
+	// TagSources are alternate version sources selected by a directive's source= field, by
+	// name. They are added to the built-in github-releases source, replacing it if they reuse
+	// its name.
+	TagSources map[string]TagResolver
+	// MaxTagPages, if positive, caps how many pages of a registry's tag list are read; past it
+	// the list is truncated with a warning. Zero or negative means no limit.
+	MaxTagPages int
+	// RegistryRPS caps registry requests per second across the whole run, e.g. to stay under
+	// Docker Hub's anonymous limits. Zero or negative means no limit.
+	RegistryRPS float64
+	// RegistryMirrors maps a registry or repository prefix to a pull-through mirror, e.g.
+	// docker.io to registry.internal/dockerhub. Lookups go to the mirror, but values are still
+	// written with the original repository.
+	RegistryMirrors map[string]string
+	// IgnoreTagsFile is an ignore list of tags that are never selected, such as yanked
+	// releases; see imageresolver.LoadIgnoreList for the format.
+	IgnoreTagsFile string
+	// PropagateGlobal also updates subchart overrides of an updated $.global.* value.
+	PropagateGlobal bool
+	// KeepOnFailure keeps a directive's current value when it fails to resolve; see
+	// Result.Kept.
+	KeepOnFailure bool
+	// WarnGroupMismatch logs, rather than fails on, group= members resolving differently.
+	WarnGroupMismatch bool
+	// RequireDirectives fails the run with ErrNothingFound when UpdateImages finds no
+	// '# bump:' directives or UpdateDeps finds no HTTP(S) dependencies, which usually means a
+	// misconfigured ScanGlob or chart path.
+	RequireDirectives bool
+	// RepinMovedTags re-pins strategy=pinned-ref tags whose digest changed instead of failing.
+	RepinMovedTags bool
+	// AllowDowngrade lets an image tag or dependency version move to a lower semver than
+	// its current value, e.g. when a registry mirror lags. Otherwise such an update is
+	// skipped with a warning.
+	AllowDowngrade bool
+
+	// UpdateDeps updates Chart.yaml dependencies from their Helm repositories.
+	UpdateDeps bool
+	// DepUpdateMode is the update mode for dependencies without a '# bump-dep:' directive:
+	// latest (the default), minor, or patch.
+	DepUpdateMode string
+	// DepRepositoryCache, if set, is a Helm repository cache directory to read and store
+	// index files in.
+	DepRepositoryCache string
+	// DepCredentialsFile, if set, is a YAML file of Helm repository credentials keyed by
+	// repository URL.
+	DepCredentialsFile string
+	// DepsRecursive also updates the dependencies of subcharts unpacked under charts/, at
+	// any depth.
+	DepsRecursive bool
+	// RewriteDepRepository also rewrites a dependency's repository when its version came from
+	// a mirror.
+	RewriteDepRepository bool
+	// UpdateLock rewrites Chart.lock, as helm dependency update would, when dependency
+	// versions change.
+	UpdateLock bool
+	// CreateLock, with UpdateLock, creates Chart.lock if the chart has none.
+	CreateLock bool
+	// CheckKubeVersion passes over dependency versions whose kubeVersion doesn't allow every
+	// Kubernetes release the chart's own kubeVersion allows, picking the highest compatible one.
+	CheckKubeVersion bool
+
+	// Logger receives the run's logs. If nil, the logger attached to the Run context is used,
+	// or none.
+	Logger *zap.Logger
+}
+
+// ChartPair names a base and a current Chart.yaml whose changes count toward the bump.
+type ChartPair struct {
+	Base string
+	Cur  string
+}
+
+// Result describes the outcome of Run.
+type Result struct {
+	// ChartYAML is the updated Chart.yaml.
+	ChartYAML string
+	// OldVersion and NewVersion are the chart version before and after the bump.
+	OldVersion string
+	NewVersion string
+	// Updated holds every file changed by the run, keyed by absolute path, whether or not it
+	// was written.
+	Updated map[string][]byte
+	// Original holds the bytes each Updated file had before the run (nil if it did not exist).
+	Original map[string][]byte
+	// Written lists the absolute paths written to disk (only with Config.Write).
+	Written []string
+	// Patched lists the paths written under Config.PatchOut.
+	Patched []string
+	// Kept lists directives whose value was kept because they failed to resolve.
+	Kept []KeptValue
+	// Images lists the values updated by image directives, in file and line order.
+	Images []ImageChange
+	// Dependencies lists the dependency versions updated, top-level chart first.
+	Dependencies []DependencyChange
+}
+
+// Diff returns a unified diff of every Updated file against its Original, in path order.
+// Paths are shown relative to the working directory when possible.
+func (r *Result) Diff() string {
+	paths := make([]string, 0, len(r.Updated))
+	for p := range r.Updated {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	wd, _ := os.Getwd()
+	var b strings.Builder
+	for _, p := range paths {
+		name := p
+		if rel, err := filepath.Rel(wd, p); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+		b.WriteString(textdiff.Unified(filepath.ToSlash(name), r.Original[p], r.Updated[p]))
+	}
+	return b.String()
+}
+
+// Summary returns a terse description of the run's changes, one per line: the chart version
+// ("Chart 0.3.1 -> 0.4.0"), each dependency update ("redis 19.0.3 -> 20.1.0"), and the
+// number of image values updated and of directives kept at their current value.
+func (r *Result) Summary() string {
+	var b strings.Builder
+	if r.OldVersion == r.NewVersion {
+		fmt.Fprintf(&b, "Chart %s unchanged\n", r.OldVersion)
+	} else {
+		fmt.Fprintf(&b, "Chart %s -> %s\n", r.OldVersion, r.NewVersion)
+	}
+	for _, d := range r.Dependencies {
+		fmt.Fprintf(&b, "%s %s -> %s\n", d.Name, d.Old, d.New)
+	}
+	if n := len(r.Images); n > 0 {
+		fmt.Fprintf(&b, "%d %s updated\n", n, plural(n, "image", "images"))
+	}
+	if n := len(r.Kept); n > 0 {
+		fmt.Fprintf(&b, "%d %s kept at the current value\n", n, plural(n, "directive", "directives"))
+	}
+	return b.String()
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// Changed reports whether the run wrote any file, in place or under Config.PatchOut.
+func (r *Result) Changed() bool {
+	return len(r.Written) > 0 || len(r.Patched) > 0
+}
+
+// Run executes the full pipeline described by cfg.
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if cfg.Logger != nil {
+		ctx = logutil.WithLogger(ctx, cfg.Logger)
+	}
+	if cfg.ChartDir != "" && cfg.ChartPath == "" {
+		cfg.ChartPath = filepath.Join(cfg.ChartDir, "Chart.yaml")
+	}
+	log := logutil.FromContext(ctx).With(zap.String("func", "bumper.Run"), zap.String("chartPath", cfg.ChartPath))
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Keychain == nil {
+		cfg.Keychain = imageresolver.NewKeychain(nil, cfg.GitHubTokenFile)
+	}
+	if cfg.ScanGlob == "" {
+		cfg.ScanGlob = DefaultScanGlob
+	}
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = DefaultConcurrency
+	}
+	if cfg.DigestCacheTTL == 0 {
+		cfg.DigestCacheTTL = 5 * time.Minute
+	}
+	var dopts *helmdeps.Options
+	if cfg.UpdateDeps {
+		var err error
+		if dopts, err = cfg.depOptions(); err != nil {
+			return nil, err
+		}
+	}
+
+	baseBytes, err := readBase(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	chartDir := filepath.Dir(cfg.ChartPath)
+	res := &Result{Updated: map[string][]byte{}, Original: map[string][]byte{}}
+	// stage records an updated file, keeping the bytes it had before the run. Nothing is
+	// written until every step has succeeded, so a failing run leaves the tree untouched.
+	stage := func(path string, b []byte) error {
+		if _, ok := res.Original[path]; !ok {
+			orig, err := os.ReadFile(path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			res.Original[path] = orig
+		}
+		res.Updated[path] = b
+		return nil
+	}
+
+	// Updates are applied in memory so the bump sees the updated appVersion and dependency
+	// versions.
+	var iopts imageUpdateOptions
+	if cfg.UpdateImages {
+		log.Debug("processing image bump directives", zap.Bool("write", cfg.Write))
+		resolver := cfg.Resolver
+		if resolver == nil {
+			var err error
+			if resolver, err = NewResolver(cfg); err != nil {
+				return nil, fmt.Errorf("set up registry access: %w", err)
+			}
+		}
+		iopts = imageUpdateOptions{
+			resolver:          resolver,
+			propagateGlobal:   cfg.PropagateGlobal,
+			warnGroupMismatch: cfg.WarnGroupMismatch,
+			keepOnFailure:     cfg.KeepOnFailure,
+			kept:              &res.Kept,
+			changes:           &res.Images,
+			repinMovedTags:    cfg.RepinMovedTags,
+			configPath:        cfg.DirectiveConfig,
+			concurrency:       cfg.Concurrency,
+			requireDirectives: cfg.RequireDirectives,
+			allowDowngrade:    cfg.AllowDowngrade,
+			onlyPaths:         cfg.OnlyPaths,
+			skipPaths:         cfg.SkipPaths,
+			recordDigests:     cfg.RecordDigests,
+			defaultPlatform:   cfg.DefaultPlatform,
+			pins:              cfg.Pins,
+		}
+		for _, p := range cfg.ValuesFiles {
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(cfg.RepoRoot, p)
+			}
+			iopts.extraFiles = append(iopts.extraFiles, absOrSelf(p))
+		}
+		files, changed, err := updateImagesInChartDir(ctx, chartDir, cfg.ScanGlob, iopts)
+		if err != nil {
+			return nil, fmt.Errorf("update images: %w", err)
+		}
+		paths := make([]string, 0, len(files))
+		for p := range files {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			if err := stage(p, files[p]); err != nil {
+				return nil, fmt.Errorf("update images: %w", err)
+			}
+		}
+		log.Debug("update images completed", zap.Bool("changed", changed))
+		if cfg.DigestCacheFile != "" {
+			if err := iopts.resolver.DigestCache().Save(cfg.DigestCacheFile); err != nil {
+				log.Warn("failed saving digest cache", zap.Error(err), zap.String("path", cfg.DigestCacheFile))
+			}
+		}
+	}
+	if cfg.UpdateDeps {
+		log.Debug("processing dependency updates", zap.Bool("write", cfg.Write))
+		if cfg.RequireDirectives {
+			if err := requireIndexDependencies(filepath.Join(chartDir, "Chart.yaml")); err != nil {
+				return nil, fmt.Errorf("update dependencies: %w", err)
+			}
+		}
+		chartDirs := []string{chartDir}
+		if cfg.DepsRecursive {
+			subs, err := subchartDirs(chartDir)
+			if err != nil {
+				return nil, fmt.Errorf("find subcharts: %w", err)
+			}
+			chartDirs = append(chartDirs, subs...)
+		}
+		changed := false
+		for _, dir := range chartDirs {
+			abs, err := filepath.Abs(filepath.Join(dir, "Chart.yaml"))
+			if err != nil {
+				return nil, err
+			}
+			// Start from any image updates already staged for this Chart.yaml.
+			b, c, err := updateDepsInChartYAML(ctx, dir, res.Updated[abs], dopts, cfg.RewriteDepRepository, &res.Dependencies)
+			if err != nil {
+				if dir != chartDir {
+					err = fmt.Errorf("%s: %w", dir, err)
+				}
+				return nil, fmt.Errorf("update dependencies: %w", err)
+			}
+			changed = changed || c
+			if b == nil {
+				continue
+			}
+			if err := stage(abs, b); err != nil {
+				return nil, fmt.Errorf("update dependencies: %w", err)
+			}
+			if cfg.UpdateLock {
+				if err := updateLockfile(ctx, dir, b, cfg.CreateLock, stage); err != nil {
+					return nil, fmt.Errorf("update %s: %w", helmdeps.LockFileName, err)
+				}
+			}
+		}
+		log.Debug("update deps completed", zap.Bool("changed", changed))
+	}
+
+	curKey, err := filepath.Abs(cfg.ChartPath)
+	if err != nil {
+		return nil, fmt.Errorf("resolve chart path: %w", err)
+	}
+	curBytes, ok := res.Updated[curKey]
+	if !ok {
+		if cfg.ChartDir != "" {
+			curBytes, err = chart.ReadChartYAML(cfg.ChartDir)
+		} else {
+			curBytes, err = os.ReadFile(cfg.ChartPath)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read current chart: %w", err)
+		}
+	}
+
+	baseMeta, err := chart.LoadMeta(baseBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse base chart metadata: %w", err)
+	}
+	curMeta, err := chart.LoadMeta(curBytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse current chart metadata: %w", err)
+	}
+
+	bopts := bumpOptions{skip: cfg.NoVersionBump, rcWorkflow: cfg.RCWorkflow, failOverMax: cfg.FailOnExceedingMax}
+	if cfg.MaxBump != "" {
+		// Validated by cfg.validate.
+		bopts.maxLevel, _ = semverutil.ParseChangeLevel(cfg.MaxBump)
+	}
+	if cfg.ChangelogHints != "" {
+		b, err := os.ReadFile(cfg.ChangelogHints)
+		if err != nil {
+			return nil, fmt.Errorf("read changelog hints: %w", err)
+		}
+		bopts.hintLevel = changelog.UnreleasedLevel(b)
+		log.Debug("read changelog hints", zap.String("path", cfg.ChangelogHints), zap.Stringer("level", bopts.hintLevel))
+	}
+	for _, pair := range cfg.Components {
+		lvl, err := componentChangeLevel(pair)
+		if err != nil {
+			return nil, err
+		}
+		log.Debug("computed component change level", zap.String("base", pair.Base), zap.String("cur", pair.Cur), zap.Stringer("level", lvl))
+		bopts.hintLevel = semverutil.Max(bopts.hintLevel, lvl)
+	}
+	ast, out, changed, err := bumpChartYAML(ctx, baseMeta, curBytes, bopts)
+	if err != nil {
+		return nil, err
+	}
+	res.ChartYAML = out
+	res.OldVersion = curMeta.Version
+	res.NewVersion, _, _ = yamlutil.GetString(ast, "$.version")
+
+	if cfg.VerifyIdempotent {
+		pass := passOptions{scanGlob: cfg.ScanGlob, bump: bopts, rewriteRepo: cfg.RewriteDepRepository}
+		// The hints describe the change from the base, which the second pass treats as done.
+		pass.bump.hintLevel = semverutil.NoChange
+		if cfg.UpdateImages {
+			second := iopts
+			second.kept = nil
+			pass.images = &second
+		}
+		if cfg.UpdateDeps {
+			pass.deps = dopts
+		}
+		if err := verifyIdempotent(ctx, chartDir, out, res.Updated, pass); err != nil {
+			return nil, fmt.Errorf("idempotency check: %w", err)
+		}
+		log.Debug("idempotency check passed")
+	}
+
+	if changed && !bytes.Equal(curBytes, []byte(out)) {
+		log.Debug("staging updated Chart.yaml", zap.String("path", cfg.ChartPath))
+		if err := stage(curKey, []byte(out)); err != nil {
+			return nil, fmt.Errorf("write Chart.yaml: %w", err)
+		}
+	}
+
+	if cfg.Validate {
+		if err := validateChart(chartDir, res.Updated); err != nil {
+			return nil, fmt.Errorf("validate chart: %w", err)
+		}
+		log.Debug("updated chart passed Helm validation")
+	}
+
+	// The changelog and parent chart are written with the run's files but aren't part of
+	// the chart, so they stay out of Updated and the diff.
+	toWrite := maps.Clone(res.Updated)
+	if cfg.ChangelogPath != "" && changed {
+		entry := changelog.Entry{Version: res.NewVersion, Date: time.Now().UTC(), Changes: chart.DescribeChanges(baseMeta, curMeta)}
+		b, err := prependChangelog(ctx, cfg.ChangelogPath, entry)
+		if err != nil {
+			return nil, fmt.Errorf("update changelog %s: %w", cfg.ChangelogPath, err)
+		}
+		if b != nil {
+			toWrite[absOrSelf(cfg.ChangelogPath)] = b
+		}
+	}
+
+	if cfg.ParentDir != "" {
+		b, err := updateParentDependency(ctx, cfg.ParentDir, curMeta.Name, res.NewVersion)
+		if err != nil {
+			return nil, fmt.Errorf("update parent chart %s: %w", cfg.ParentDir, err)
+		}
+		if b != nil {
+			toWrite[absOrSelf(filepath.Join(cfg.ParentDir, "Chart.yaml"))] = b
+		}
+	}
+
+	if cfg.Write {
+		if res.Written, err = writeAll(ctx, toWrite, cfg.Atomic); err != nil {
+			return nil, err
+		}
+		if cfg.PostHook != "" && len(res.Written) > 0 {
+			if err := runPostHook(ctx, cfg.PostHook, chartDir); err != nil {
+				return nil, fmt.Errorf("post-write hook: %w", err)
+			}
+		}
+	}
+	if cfg.PatchOut != "" {
+		if res.Patched, err = writePatch(ctx, cfg.PatchOut, cfg.RepoRoot, toWrite); err != nil {
+			return nil, fmt.Errorf("write patch files: %w", err)
+		}
+	}
+	return res, nil
+}
+
+// writeAll writes files, keyed by path, in path order and returns the paths written. If a
+// write fails and atomic is set, the files already written are restored first, so either
+// every file is written or none is.
+func writeAll(ctx context.Context, files map[string][]byte, atomic bool) ([]string, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "bumper.writeAll"))
+	var written []string
+	original := map[string][]byte{}
+	for _, p := range slices.Sorted(maps.Keys(files)) {
+		if atomic {
+			b, err := os.ReadFile(p)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				rollback(ctx, written, original)
+				return nil, fmt.Errorf("write %s: %w", p, err)
+			}
+			original[p] = b
+		}
+		log.Debug("writing file", zap.String("path", p))
+		if err := os.WriteFile(p, files[p], 0o644); err != nil {
+			if atomic {
+				rollback(ctx, written, original)
+				return nil, fmt.Errorf("write %s (earlier writes rolled back): %w", p, err)
+			}
+			return nil, fmt.Errorf("write %s: %w", p, err)
+		}
+		written = append(written, p)
+	}
+	return written, nil
+}
+
+// writePatch writes files, keyed by absolute path, under outDir at their paths relative to
+// repoRoot (default ".") and returns the paths written.
+func writePatch(ctx context.Context, outDir, repoRoot string, files map[string][]byte) ([]string, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "bumper.writePatch"), zap.String("outDir", outDir))
+	if repoRoot == "" {
+		repoRoot = "."
+	}
+	var written []string
+	for _, p := range slices.Sorted(maps.Keys(files)) {
+		rel, err := repoRelative(repoRoot, p)
+		if err != nil {
+			return nil, err
+		}
+		dst := filepath.Join(outDir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return nil, err
+		}
+		log.Debug("writing patch file", zap.String("path", dst))
+		if err := os.WriteFile(dst, files[p], 0o644); err != nil {
+			return nil, err
+		}
+		written = append(written, dst)
+	}
+	return written, nil
+}
+
+// rollback restores the written files to their original bytes, removing those that did not
+// exist before. Failures are logged, since the run is already failing.
+func rollback(ctx context.Context, written []string, original map[string][]byte) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "bumper.rollback"))
+	for _, p := range written {
+		var err error
+		if orig := original[p]; orig != nil {
+			err = os.WriteFile(p, orig, 0o644)
+		} else {
+			err = os.Remove(p)
+		}
+		if err != nil {
+			log.Warn("failed restoring file", zap.String("path", p), zap.Error(err))
+		}
+	}
+}
+
+func (cfg Config) validate() error {
+	if cfg.ChartPath == "" {
+		return errors.New("ChartPath or ChartDir is required")
+	}
+	if cfg.ChartDir != "" && cfg.ChartPath != filepath.Join(cfg.ChartDir, "Chart.yaml") {
+		return errors.New("only one of ChartPath and ChartDir may be set")
+	}
+	n := 0
+	for _, s := range []string{cfg.BasePath, cfg.BaseRef, cfg.BaseMergeBase, cfg.BaseOCI, cfg.BaseURL} {
+		if s != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("exactly one of BasePath, BaseRef, BaseMergeBase, BaseOCI, or BaseURL is required")
+	}
+	if cfg.BaseURL != "" {
+		if u, err := url.Parse(cfg.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("BaseURL %q is not an http:// or https:// URL", cfg.BaseURL)
+		}
+	}
+	if cfg.MaxBump != "" {
+		if _, err := semverutil.ParseChangeLevel(cfg.MaxBump); err != nil {
+			return fmt.Errorf("MaxBump: %w", err)
+		}
+	}
+	if cfg.NoVersionBump {
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"RCWorkflow", cfg.RCWorkflow},
+			{"FailOnExceedingMax", cfg.FailOnExceedingMax},
+			{"ChangelogHints", cfg.ChangelogHints != ""},
+			{"Components", len(cfg.Components) > 0},
+			{"ChangelogPath", cfg.ChangelogPath != ""},
+		} {
+			if f.set {
+				return fmt.Errorf("%s cannot be used with NoVersionBump", f.name)
+			}
+		}
+	}
+	for i, pair := range cfg.Components {
+		if pair.Base == "" || pair.Cur == "" {
+			return fmt.Errorf("Components[%d]: both Base and Cur are required", i)
+		}
+	}
+	if cfg.DefaultPlatform != "" {
+		if err := imageresolver.ValidatePlatform(cfg.DefaultPlatform); err != nil {
+			return fmt.Errorf("DefaultPlatform: %w", err)
+		}
+	}
+	if cfg.Write && cfg.PatchOut != "" {
+		return errors.New("only one of Write and PatchOut may be set")
+	}
+	if cfg.PostHook != "" {
+		if _, err := splitCommand(cfg.PostHook); err != nil {
+			return fmt.Errorf("PostHook: %w", err)
+		}
+	}
+	return nil
+}
+
+// componentChangeLevel is the change level from pair.Base to pair.Cur.
+func componentChangeLevel(pair ChartPair) (semverutil.ChangeLevel, error) {
+	var metas [2]chart.Meta
+	for i, p := range []string{pair.Base, pair.Cur} {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return semverutil.NoChange, fmt.Errorf("read component chart: %w", err)
+		}
+		if metas[i], err = chart.LoadMeta(b); err != nil {
+			return semverutil.NoChange, fmt.Errorf("parse component chart %s: %w", p, err)
+		}
+	}
+	return chart.ComputeChangeLevel(metas[0], metas[1]), nil
+}
+
+// depOptions builds the Helm dependency options from the Dep* fields.
+func (cfg Config) depOptions() (*helmdeps.Options, error) {
+	mode, err := helmdeps.ParseUpdateMode(cfg.DepUpdateMode)
+	if err != nil {
+		return nil, err
+	}
+	opts := &helmdeps.Options{RepositoryCache: cfg.DepRepositoryCache, Mode: mode, IndexCache: helmdeps.NewIndexCache(), AllowDowngrade: cfg.AllowDowngrade, CheckKubeVersion: cfg.CheckKubeVersion}
+	if cfg.DepCredentialsFile != "" {
+		if opts.Credentials, err = helmdeps.LoadCredentialsFile(cfg.DepCredentialsFile); err != nil {
+			return nil, fmt.Errorf("load Helm repository credentials: %w", err)
+		}
+	}
+	return opts, nil
+}
+
+// readBase returns the base Chart.yaml from the configured source.
+func readBase(ctx context.Context, cfg Config) ([]byte, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "bumper.readBase"))
+	switch {
+	case cfg.BaseOCI != "":
+		log.Debug("reading base chart from OCI registry", zap.String("ref", cfg.BaseOCI))
+		b, err := ocichart.ReadChartYAML(ctx, cfg.BaseOCI, cfg.Keychain)
+		if err != nil {
+			return nil, fmt.Errorf("read base chart from OCI registry: %w", err)
+		}
+		return b, nil
+	case cfg.BaseURL != "":
+		log.Debug("reading base chart from URL", zap.String("url", cfg.BaseURL))
+		b, err := fetchURL(ctx, cfg.BaseURL)
+		if err != nil {
+			return nil, fmt.Errorf("read base chart from URL: %w", err)
+		}
+		return b, nil
+	case cfg.BaseRef != "" || cfg.BaseMergeBase != "":
+		repoRoot := cfg.RepoRoot
+		if repoRoot == "" {
+			repoRoot = "."
+		}
+		p := cfg.BaseRefPath
+		if p == "" {
+			p = cfg.ChartPath
+		}
+		ref := cfg.BaseRef
+		if cfg.BaseMergeBase != "" {
+			var err error
+			ref, err = gitutil.MergeBase(ctx, repoRoot, "HEAD", cfg.BaseMergeBase)
+			if err != nil {
+				return nil, fmt.Errorf("compute merge-base with %s: %w", cfg.BaseMergeBase, err)
+			}
+		}
+		log.Debug("reading base chart from git ref", zap.String("repo", repoRoot), zap.String("ref", ref), zap.String("path", p))
+		b, err := gitutil.ReadFileAtRef(ctx, repoRoot, ref, p)
+		if err != nil {
+			return nil, fmt.Errorf("read base chart from git ref: %w", err)
+		}
+		return b, nil
+	default:
+		log.Debug("reading base chart from file", zap.String("path", cfg.BasePath))
+		b, err := os.ReadFile(cfg.BasePath)
+		if err != nil {
+			return nil, fmt.Errorf("read base chart: %w", err)
+		}
+		return b, nil
+	}
+}
+
+// maxBaseURLSize bounds the base Chart.yaml read by fetchURL.
+const maxBaseURLSize = 1 << 20
+
+// fetchURL returns the body of a GET of rawURL, which must answer 200 OK with at most
+// maxBaseURLSize bytes. The request is abandoned when ctx is done, so --timeout covers it.
+// Network failures and 5xx responses wrap ErrBaseURLUnavailable.
+func fetchURL(ctx context.Context, rawURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrBaseURLUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 5 {
+		return nil, fmt.Errorf("%w: GET %s: %s", ErrBaseURLUnavailable, rawURL, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxBaseURLSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: GET %s: %w", ErrBaseURLUnavailable, rawURL, err)
+	}
+	if len(b) > maxBaseURLSize {
+		return nil, fmt.Errorf("GET %s: response exceeds %d bytes", rawURL, maxBaseURLSize)
+	}
+	return b, nil
+}
+
+func absOrSelf(p string) string {
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// bumpOptions control how bumpChartYAML turns a change level into a new chart version.
+type bumpOptions struct {
+	// skip leaves the version alone, as if no change had been detected.
+	skip       bool
+	rcWorkflow bool
+	// maxLevel caps the applied level; NoChange means no cap.
+	maxLevel semverutil.ChangeLevel
+	// failOverMax fails instead of clamping a level above maxLevel.
+	failOverMax bool
+	// hintLevel is folded into the detected level, e.g. from a changelog's Unreleased section
+	// or from component charts.
+	hintLevel semverutil.ChangeLevel
+	// render renders the bumped Chart.yaml. Nil means yamlutil.Render.
+	render func(*yamlutil.File) (string, error)
+}
+
+// bumpChartYAML applies the chart version bump implied by the changes from base to curBytes
+// and returns the updated document, its rendering, and whether the version changed.
+func bumpChartYAML(ctx context.Context, base chart.Meta, curBytes []byte, opts bumpOptions) (*yamlutil.File, string, bool, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "bumpChartYAML"))
+	curMeta, err := chart.LoadMeta(curBytes)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("parse current chart metadata: %w", err)
+	}
+	if opts.skip {
+		log.Debug("skipping chart version bump")
+		ast, err := yamlutil.ParseBytes(curBytes)
+		if err != nil {
+			return nil, "", false, fmt.Errorf("parse current chart yaml: %w", err)
+		}
+		return ast, string(curBytes), false, nil
+	}
+
+	lvl := chart.ComputeChangeLevel(base, curMeta)
+	logutil.Detail(ctx, log, "computed change level",
+		zap.String("baseVersion", base.Version),
+		zap.String("baseAppVersion", base.AppVersion),
+		zap.String("curVersion", curMeta.Version),
+		zap.String("curAppVersion", curMeta.AppVersion),
+		zap.Stringer("level", lvl),
+		zap.Stringer("hintLevel", opts.hintLevel),
+	)
+	lvl = semverutil.Max(lvl, opts.hintLevel)
+	if opts.maxLevel != semverutil.NoChange && lvl > opts.maxLevel {
+		if opts.failOverMax {
+			return nil, "", false, fmt.Errorf("%w: detected a %s change, but the maximum is %s", ErrBumpExceedsMax, lvl, opts.maxLevel)
+		}
+		log.Info("capping chart version bump", zap.Stringer("detected", lvl), zap.Stringer("max", opts.maxLevel))
+		lvl = opts.maxLevel
+	}
+
+	ast, err := yamlutil.ParseBytes(curBytes)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("parse current chart yaml: %w", err)
+	}
+
+	applyBump := chart.ApplyChartVersionBump
+	if opts.rcWorkflow {
+		applyBump = chart.ApplyRCVersionBump
+	}
+	changed, err := applyBump(ast, lvl)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("apply chart version bump: %w", err)
+	}
+	log.Debug("applied chart version bump", zap.Bool("changed", changed))
+
+	render := opts.render
+	if render == nil {
+		render = yamlutil.Render
+	}
+	out, err := render(ast)
+	if err != nil {
+		return nil, "", false, fmt.Errorf("render chart yaml: %w", err)
+	}
+	return ast, out, changed, nil
+}
+
+// prependChangelog returns the changelog at path (empty if missing) with entry added, or nil
+// if it already has the entry.
+func prependChangelog(ctx context.Context, path string, entry changelog.Entry) ([]byte, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "prependChangelog"), zap.String("path", path), zap.String("version", entry.Version))
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	out, changed := changelog.Prepend(b, entry)
+	if !changed {
+		log.Debug("changelog already up to date")
+		return nil, nil
+	}
+	log.Debug("prepending changelog entry", zap.String("entry", entry.Render()))
+	return out, nil
+}
@@ -0,0 +1,2311 @@
+package bumper
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/chart"
+	"github.com/joejulian/helm-chart-bumper-action/internal/directives"
+	"github.com/joejulian/helm-chart-bumper-action/internal/imageresolver"
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+	"github.com/joejulian/helm-chart-bumper-action/internal/semverutil"
+	"github.com/joejulian/helm-chart-bumper-action/internal/yamlutil"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newTestRegistry starts an in-memory registry with repo populated with tags.
+func newTestRegistry(t *testing.T, repo string, tags ...string) string {
+	t.Helper()
+	srv := httptest.NewServer(registry.New())
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	pushTestTags(t, host, repo, tags...)
+	return host
+}
+
+// pushTestTags populates repo with tags on an existing test registry.
+func pushTestTags(t *testing.T, host, repo string, tags ...string) {
+	t.Helper()
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("random.Image: %v", err)
+	}
+	for _, tag := range tags {
+		ref, err := name.ParseReference(host + "/" + repo + ":" + tag)
+		if err != nil {
+			t.Fatalf("ParseReference: %v", err)
+		}
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatalf("remote.Write: %v", err)
+		}
+	}
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for n, c := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, n)), 0o755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, n), []byte(c), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+	return dir
+}
+
+// testImageOptions returns image update options for tests, with the resolver's registry
+// options adjusted by each of with.
+func testImageOptions(with ...func(*imageresolver.Options)) imageUpdateOptions {
+	ropts := imageresolver.Options{
+		Keychain:    authn.NewMultiKeychain(),
+		DigestCache: imageresolver.NewDigestCache(time.Minute),
+		TagCache:    imageresolver.NewTagListCache(),
+	}
+	for _, f := range with {
+		f(&ropts)
+	}
+	return imageUpdateOptions{resolver: imageresolver.NewResolver(ropts)}
+}
+
+// countingTransport counts registry tag-list requests.
+type countingTransport struct {
+	mu       sync.Mutex
+	tagLists map[string]int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if strings.HasSuffix(req.URL.Path, "/tags/list") {
+		c.mu.Lock()
+		c.tagLists[req.URL.Path]++
+		c.mu.Unlock()
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestSyncAppVersionDrivesMinorBump(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	baseChart := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":  baseChart,
+		"values.yaml": "image:\n  # bump: image=" + host + "/org/app sync=appVersion\n  tag: 1.2.3\n",
+	})
+
+	files, changed, err := updateImagesInChartDir(context.Background(), dir, "Chart.yaml,values*.yaml", testImageOptions())
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expected changes")
+	}
+	chartPath, _ := filepath.Abs(filepath.Join(dir, "Chart.yaml"))
+	curBytes, ok := files[chartPath]
+	if !ok {
+		t.Fatalf("expected Chart.yaml in updated files, got keys %v", keys(files))
+	}
+
+	baseMeta, _ := chart.LoadMeta([]byte(baseChart))
+	curMeta, err := chart.LoadMeta(curBytes)
+	if err != nil {
+		t.Fatalf("LoadMeta: %v", err)
+	}
+	if curMeta.AppVersion != "1.3.0" {
+		t.Fatalf("appVersion got %q want %q", curMeta.AppVersion, "1.3.0")
+	}
+	lvl := chart.ComputeChangeLevel(baseMeta, curMeta)
+	if lvl != semverutil.MinorChange {
+		t.Fatalf("change level got %v want %v", lvl, semverutil.MinorChange)
+	}
+
+	ast, err := yamlutil.ParseBytes(curBytes)
+	if err != nil {
+		t.Fatalf("ParseBytes: %v", err)
+	}
+	if _, err := chart.ApplyChartVersionBump(ast, lvl); err != nil {
+		t.Fatalf("ApplyChartVersionBump: %v", err)
+	}
+	if v, _, _ := yamlutil.GetString(ast, "$.version"); v != "0.5.0" {
+		t.Fatalf("version got %q want %q", v, "0.5.0")
+	}
+
+	// Dry run must not touch disk.
+	onDisk, _ := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if string(onDisk) != baseChart {
+		t.Fatalf("dry run modified Chart.yaml on disk:\n%s", onDisk)
+	}
+}
+
+func TestEmitEvents_SingleDirective(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	dir := writeFiles(t, map[string]string{
+		"values.yaml": "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n",
+	})
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	ctx := logutil.WithEvents(logutil.WithLogger(context.Background(), zap.New(core)), true)
+	if _, _, err := updateImagesInChartDir(ctx, dir, "values*.yaml", testImageOptions()); err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+
+	var got []string
+	for _, e := range logs.All() {
+		if ev, ok := e.ContextMap()["event"].(string); ok {
+			got = append(got, ev)
+		}
+	}
+	want := []string{
+		logutil.EventDirectiveDiscovered,
+		logutil.EventTagsListed,
+		logutil.EventCandidateSelected,
+		logutil.EventValueWritten,
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("events got %v want %v", got, want)
+	}
+	written := logs.FilterField(zap.String("event", logutil.EventValueWritten)).All()
+	if f := written[0].ContextMap(); f["old"] != "1.2.3" || f["new"] != "1.3.0" || f["yamlPath"] != "$.image.tag" {
+		t.Fatalf("unexpected value_written fields: %v", f)
+	}
+}
+
+func keys(m map[string][]byte) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+func TestGroupedDirectives(t *testing.T) {
+	host := newTestRegistry(t, "org/server", "1.2.3", "1.3.0")
+	pushTestTags(t, host, "org/agent", "1.2.3", "1.3.0")
+	pushTestTags(t, host, "org/agent-lagging", "1.2.3")
+
+	values := func(agent string) string {
+		return "server:\n  # bump: image=" + host + "/org/server group=release\n  tag: 1.2.3\n" +
+			"agent:\n  # bump: image=" + host + "/org/" + agent + " group=release\n  tag: 1.2.3\n"
+	}
+
+	t.Run("consistent", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{"values.yaml": values("agent")})
+		_, changed, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", testImageOptions())
+		if err != nil {
+			t.Fatalf("updateImagesInChartDir: %v", err)
+		}
+		if !changed {
+			t.Fatalf("expected changes")
+		}
+	})
+
+	t.Run("inconsistent fails", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{"values.yaml": values("agent-lagging")})
+		files, _, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", testImageOptions())
+		if err == nil || !strings.Contains(err.Error(), `group "release"`) {
+			t.Fatalf("expected group mismatch error, got %v", err)
+		}
+		if files != nil {
+			t.Fatalf("failed run staged updates: %v", relKeys(dir, files))
+		}
+	})
+
+	t.Run("inconsistent warns", func(t *testing.T) {
+		dir := writeFiles(t, map[string]string{"values.yaml": values("agent-lagging")})
+		core, logs := observer.New(zapcore.WarnLevel)
+		ctx := logutil.WithLogger(context.Background(), zap.New(core))
+		opts := testImageOptions()
+		opts.warnGroupMismatch = true
+		if _, _, err := updateImagesInChartDir(ctx, dir, "values*.yaml", opts); err != nil {
+			t.Fatalf("updateImagesInChartDir: %v", err)
+		}
+		if logs.Len() != 1 {
+			t.Fatalf("expected one warning, got %d", logs.Len())
+		}
+	})
+}
+
+func TestKeepOnFailure(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	values := "app:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n" +
+		"missing:\n  # bump: image=" + host + "/org/missing\n  tag: 0.9.0\n"
+	dir := writeFiles(t, map[string]string{"values.yaml": values})
+
+	if _, _, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", testImageOptions()); err == nil {
+		t.Fatalf("expected resolution error without keepOnFailure")
+	}
+
+	var kept []KeptValue
+	opts := testImageOptions()
+	opts.keepOnFailure = true
+	opts.kept = &kept
+	files, changed, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", opts)
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expected the resolvable directive to change")
+	}
+	valuesPath, _ := filepath.Abs(filepath.Join(dir, "values.yaml"))
+	ast, err := yamlutil.ParseBytes(files[valuesPath])
+	if err != nil {
+		t.Fatalf("ParseBytes: %v", err)
+	}
+	if v, _, _ := yamlutil.GetString(ast, "$.app.tag"); v != "1.3.0" {
+		t.Fatalf("app.tag got %q want %q", v, "1.3.0")
+	}
+	if v, _, _ := yamlutil.GetString(ast, "$.missing.tag"); v != "0.9.0" {
+		t.Fatalf("missing.tag got %q want kept %q", v, "0.9.0")
+	}
+	if len(kept) != 1 || kept[0].YAMLPath != "$.missing.tag" || kept[0].Value != "0.9.0" || kept[0].Err == nil {
+		t.Fatalf("unexpected kept values: %#v", kept)
+	}
+}
+
+func TestKeepOnFailure_DigestLookup(t *testing.T) {
+	// The tag list is served, but the selected tag's manifest is not, so only the digest
+	// lookup for {{.Digest}} after 1.3.0 is selected fails.
+	reg := registry.New()
+	var broken atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if broken.Load() && strings.HasSuffix(r.URL.Path, "/manifests/1.3.0") {
+			http.Error(w, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown"}]}`, http.StatusNotFound)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	pushTestTags(t, host, "org/app", "1.2.3", "1.3.0")
+	broken.Store(true)
+
+	values := "image:\n  # bump: image=" + host + "/org/app strategy=semver writeTransform=\"{{.Tag}}@{{.Digest}}\"\n  ref: 1.2.3\n"
+	dir := writeFiles(t, map[string]string{"values.yaml": values})
+
+	if _, _, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", testImageOptions()); err == nil {
+		t.Fatalf("expected the digest lookup to fail without keepOnFailure")
+	}
+
+	var kept []KeptValue
+	opts := testImageOptions()
+	opts.keepOnFailure = true
+	opts.kept = &kept
+	_, changed, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", opts)
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	if changed {
+		t.Fatalf("expected the current value to be kept")
+	}
+	if len(kept) != 1 || kept[0].YAMLPath != "$.image.ref" || kept[0].Value != "1.2.3" || kept[0].Err == nil {
+		t.Fatalf("unexpected kept values: %#v", kept)
+	}
+}
+
+func TestUpdateParentDependency(t *testing.T) {
+	parent := "apiVersion: v2\nname: umbrella\nversion: 1.0.0\ndependencies:\n- name: redis\n  version: 19.0.3\n  repository: https://charts.example.com\n- name: app\n  version: \"0.4.1\"\n  repository: file://../app\n"
+	dir := writeFiles(t, map[string]string{"Chart.yaml": parent})
+
+	b, err := updateParentDependency(context.Background(), dir, "app", "0.5.0")
+	if err != nil {
+		t.Fatalf("updateParentDependency: %v", err)
+	}
+	want := strings.Replace(parent, `version: "0.4.1"`, `version: "0.5.0"`, 1)
+	if string(b) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b, want)
+	}
+	// The caller writes the result; the file is left alone.
+	if onDisk, _ := os.ReadFile(filepath.Join(dir, "Chart.yaml")); string(onDisk) != parent {
+		t.Fatalf("parent Chart.yaml was modified:\n%s", onDisk)
+	}
+
+	if b, err := updateParentDependency(context.Background(), dir, "app", "0.4.1"); err != nil || b != nil {
+		t.Fatalf("up-to-date parent got %q, %v want nil", b, err)
+	}
+
+	if _, err := updateParentDependency(context.Background(), dir, "missing", "1.0.0"); err == nil {
+		t.Fatalf("expected error for a chart the parent does not depend on")
+	}
+}
+
+func TestVerifyIdempotent(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	base := "# Chart header\napiVersion: v2\nname: app # inline\n# version is bumped by CI\nversion: 0.4.1\nappVersion: \"1.2.3\"\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":  base,
+		"values.yaml": "# values header\nimage:\n  # bump: image=" + host + "/org/app sync=appVersion\n  tag: \"1.2.3\" # pinned by bumper\n",
+	})
+	opts := testImageOptions()
+
+	// First pass, in memory.
+	files, _, err := updateImagesInChartDir(context.Background(), dir, "Chart.yaml,values*.yaml", opts)
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	chartPath, _ := filepath.Abs(filepath.Join(dir, "Chart.yaml"))
+	baseMeta, _ := chart.LoadMeta([]byte(base))
+	_, out, changed, err := bumpChartYAML(context.Background(), baseMeta, files[chartPath], bumpOptions{})
+	if err != nil {
+		t.Fatalf("bumpChartYAML: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expected first pass to bump the chart version")
+	}
+
+	pass := passOptions{scanGlob: "Chart.yaml,values*.yaml", images: &opts}
+	if err := verifyIdempotent(context.Background(), dir, out, files, pass); err != nil {
+		t.Fatalf("verifyIdempotent: %v", err)
+	}
+
+	// Output that does not survive a parse/render round trip is reported.
+	unstable := strings.Replace(out, "apiVersion: v2", "apiVersion:   v2", 1)
+	if err := verifyIdempotent(context.Background(), dir, unstable, files, passOptions{}); err == nil || !strings.Contains(err.Error(), "not stable") {
+		t.Fatalf("expected render instability error, got %v", err)
+	}
+
+	// A pass that still has work to do is reported.
+	if err := verifyIdempotent(context.Background(), dir, out, nil, pass); err == nil {
+		t.Fatalf("expected the unapplied values.yaml update to be reported")
+	}
+}
+
+func TestVerifyIdempotent_InPlaceRewrite(t *testing.T) {
+	// Blank lines between comment blocks make this file lossy: goccy drops them when it
+	// re-encodes, so the version bump is rewritten in place instead.
+	base := "# Chart header\n\n# more header\napiVersion: v2\nname: app\n\n# bumped by CI\nversion: 0.4.1\nappVersion: \"1.2.3\"\n"
+	cur := strings.Replace(base, `appVersion: "1.2.3"`, `appVersion: "1.3.0"`, 1)
+	dir := writeFiles(t, map[string]string{"Chart.yaml": cur})
+	baseMeta, _ := chart.LoadMeta([]byte(base))
+
+	_, out, changed, err := bumpChartYAML(context.Background(), baseMeta, []byte(cur), bumpOptions{})
+	if err != nil {
+		t.Fatalf("bumpChartYAML: %v", err)
+	}
+	if want := strings.Replace(cur, "version: 0.4.1", "version: 0.5.0", 1); !changed || out != want {
+		t.Fatalf("bumpChartYAML got:\n%s\nwant:\n%s", out, want)
+	}
+	if err := verifyIdempotent(context.Background(), dir, out, nil, passOptions{}); err != nil {
+		t.Fatalf("verifyIdempotent: %v", err)
+	}
+
+	// A structural edit can't be made in place, so the file is re-encoded without its blank
+	// lines, and the second pass reports the difference.
+	addKey := func(f *yamlutil.File) (string, error) {
+		if _, err := yamlutil.SetString(f, "$.kubeVersion", ">=1.28.0"); err != nil {
+			return "", err
+		}
+		return yamlutil.Render(f)
+	}
+	err = verifyIdempotent(context.Background(), dir, out, nil, passOptions{bump: bumpOptions{render: addKey}})
+	if err == nil || !strings.Contains(err.Error(), "not stable") {
+		t.Fatalf("expected render instability error, got %v", err)
+	}
+}
+
+func TestVerifyIdempotent_NestedFiles(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":               chartYAML,
+		"templates/configmap.yaml": "apiVersion: v1\nkind: ConfigMap\n",
+		"charts/sub/Chart.yaml":    "apiVersion: v2\nname: sub\nversion: 0.1.0\n",
+		"charts/sub/values.yaml":   "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n",
+	})
+	const glob = "charts/*/values.yaml"
+	opts := testImageOptions()
+	files, changed, err := updateImagesInChartDir(context.Background(), dir, glob, opts)
+	if err != nil || !changed {
+		t.Fatalf("updateImagesInChartDir: changed=%v err=%v", changed, err)
+	}
+
+	pass := passOptions{scanGlob: glob, images: &opts}
+	if err := verifyIdempotent(context.Background(), dir, chartYAML, files, pass); err != nil {
+		t.Fatalf("verifyIdempotent: %v", err)
+	}
+	// The subchart's values are part of the snapshot, so an unapplied update is reported.
+	err = verifyIdempotent(context.Background(), dir, chartYAML, nil, pass)
+	if err == nil || !strings.Contains(err.Error(), filepath.Join("charts", "sub", "values.yaml")) {
+		t.Fatalf("expected the unapplied charts/sub/values.yaml update to be reported, got %v", err)
+	}
+}
+
+func TestDigestFormats(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3")
+	repo := host + "/org/app"
+	ref, _ := name.ParseReference(repo + ":1.2.3")
+	desc, err := remote.Head(ref)
+	if err != nil {
+		t.Fatalf("remote.Head: %v", err)
+	}
+	digest := desc.Digest.String()
+
+	for name, tc := range map[string]struct {
+		values string
+		path   string
+		want   string
+	}{
+		"bare digest": {
+			values: "image:\n  tag: 1.2.3\n  # bump: image=" + repo + " strategy=digest\n  digest: \"\"\n",
+			path:   "$.image.digest",
+			want:   digest,
+		},
+		"digest ref from sibling tag": {
+			values: "image:\n  tag: 1.2.3\n  # bump: image=" + repo + " strategy=digest format=digest-ref\n  ref: " + repo + "\n",
+			path:   "$.image.ref",
+			want:   repo + "@" + digest,
+		},
+		"digest ref keeps tag": {
+			values: "# bump: image=" + repo + " strategy=digest format=digest-ref\nimage: " + repo + ":1.2.3\n",
+			path:   "$.image",
+			want:   repo + ":1.2.3@" + digest,
+		},
+		"digest ref replaces stale digest": {
+			values: "# bump: image=" + repo + " strategy=digest format=digest-ref\nimage: " + repo + ":1.2.3@sha256:0000000000000000000000000000000000000000000000000000000000000000\n",
+			path:   "$.image",
+			want:   repo + ":1.2.3@" + digest,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"values.yaml": tc.values})
+			files, _, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", testImageOptions())
+			if err != nil {
+				t.Fatalf("updateImagesInChartDir: %v", err)
+			}
+			valuesPath, _ := filepath.Abs(filepath.Join(dir, "values.yaml"))
+			ast, err := yamlutil.ParseBytes(files[valuesPath])
+			if err != nil {
+				t.Fatalf("ParseBytes: %v", err)
+			}
+			if v, _, _ := yamlutil.GetString(ast, tc.path); v != tc.want {
+				t.Fatalf("%s got %q want %q", tc.path, v, tc.want)
+			}
+		})
+	}
+}
+
+func TestDigestRefRunTwice(t *testing.T) {
+	repo := newTestRegistry(t, "org/app", "1.2.3") + "/org/app"
+	dir := writeFiles(t, map[string]string{
+		"values.yaml": "# bump: image=" + repo + " strategy=digest format=digest-ref\nimage: " + repo + ":1.2.3\n",
+	})
+	valuesPath, _ := filepath.Abs(filepath.Join(dir, "values.yaml"))
+	files, changed, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", testImageOptions())
+	if err != nil || !changed {
+		t.Fatalf("first run: changed=%v err=%v", changed, err)
+	}
+	if err := os.WriteFile(valuesPath, files[valuesPath], 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	// The written reference still carries its tag, so the second run re-resolves it.
+	if _, changed, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", testImageOptions()); err != nil || changed {
+		t.Fatalf("second run: changed=%v err=%v", changed, err)
+	}
+}
+
+func TestTagFromImageRef(t *testing.T) {
+	for ref, want := range map[string]string{
+		"ghcr.io/org/app:1.2.3":                 "1.2.3",
+		"localhost:5000/org/app:1.2.3":          "1.2.3",
+		"ghcr.io/org/app:1.2.3@sha256:abc":      "1.2.3",
+		"localhost:5000/org/app":                "",
+		"ghcr.io/org/app@sha256:abcdef01234567": "",
+	} {
+		got, ok := tagFromImageRef(ref)
+		if got != want || ok != (want != "") {
+			t.Fatalf("tagFromImageRef(%q) = %q, %v want %q", ref, got, ok, want)
+		}
+	}
+}
+
+func TestWriteTransform(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: app\nversion: 0.1.0\nappVersion: 1.2.3\n",
+		"values.yaml": "image:\n  # bump: image=" + host + "/org/app writeTransform=\"v{{.Tag}}-{{.Platform}}\" platform=linux/amd64 sync=appVersion\n  tag: v1.2.3-linux/amd64\n",
+	})
+
+	files, changed, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", testImageOptions())
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expected changes")
+	}
+	valuesPath, _ := filepath.Abs(filepath.Join(dir, "values.yaml"))
+	ast, err := yamlutil.ParseBytes(files[valuesPath])
+	if err != nil {
+		t.Fatalf("ParseBytes: %v", err)
+	}
+	if v, _, _ := yamlutil.GetString(ast, "$.image.tag"); v != "v1.3.0-linux/amd64" {
+		t.Fatalf("tag got %q want %q", v, "v1.3.0-linux/amd64")
+	}
+
+	// appVersion follows the selected tag, not the transformed string.
+	chartPath, _ := filepath.Abs(filepath.Join(dir, "Chart.yaml"))
+	meta, err := chart.LoadMeta(files[chartPath])
+	if err != nil {
+		t.Fatalf("LoadMeta: %v", err)
+	}
+	if meta.AppVersion != "1.3.0" {
+		t.Fatalf("appVersion got %q want %q", meta.AppVersion, "1.3.0")
+	}
+}
+
+func TestPinnedRef(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3")
+	pushTestTags(t, host, "org/app", "1.3.0")
+	repo := host + "/org/app"
+	digestOf := func(tag string) string {
+		t.Helper()
+		ref, _ := name.ParseReference(repo + ":" + tag)
+		desc, err := remote.Head(ref)
+		if err != nil {
+			t.Fatalf("remote.Head: %v", err)
+		}
+		return desc.Digest.String()
+	}
+	dir := writeFiles(t, map[string]string{
+		"values.yaml": "# bump: image=" + repo + " strategy=pinned-ref\nimage: " + repo + ":1.2.3\n",
+	})
+	valuesPath := filepath.Join(dir, "values.yaml")
+	// run stages the updates and writes them, as Run does with Config.Write.
+	run := func(opts imageUpdateOptions) (bool, error) {
+		t.Helper()
+		files, changed, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", opts)
+		if err != nil {
+			return false, err
+		}
+		for p, b := range files {
+			if err := os.WriteFile(p, b, 0o644); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+		}
+		return changed, nil
+	}
+	pinned := func() string {
+		t.Helper()
+		b, _ := os.ReadFile(valuesPath)
+		ast, err := yamlutil.ParseBytes(b)
+		if err != nil {
+			t.Fatalf("ParseBytes: %v", err)
+		}
+		v, _, _ := yamlutil.GetString(ast, "$.image")
+		return v
+	}
+
+	// Initial pinning selects the newest tag and its digest.
+	if changed, err := run(testImageOptions()); err != nil || !changed {
+		t.Fatalf("initial run: changed=%v err=%v", changed, err)
+	}
+	want := repo + ":1.3.0@" + digestOf("1.3.0")
+	if got := pinned(); got != want {
+		t.Fatalf("pinned got %q want %q", got, want)
+	}
+
+	// Re-running against an unchanged registry is a no-op.
+	if changed, err := run(testImageOptions()); err != nil || changed {
+		t.Fatalf("re-run: changed=%v err=%v", changed, err)
+	}
+
+	// Move the tag: fail by default, re-pin when allowed.
+	pushTestTags(t, host, "org/app", "1.3.0")
+	moved := digestOf("1.3.0")
+	if _, err := run(testImageOptions()); err == nil || !strings.Contains(err.Error(), "moved") {
+		t.Fatalf("expected tag-moved error, got %v", err)
+	}
+	if got := pinned(); got != want {
+		t.Fatalf("failed run changed the pin to %q", got)
+	}
+	opts := testImageOptions()
+	opts.repinMovedTags = true
+	if changed, err := run(opts); err != nil || !changed {
+		t.Fatalf("re-pin run: changed=%v err=%v", changed, err)
+	}
+	if got, want := pinned(), repo+":1.3.0@"+moved; got != want {
+		t.Fatalf("re-pinned got %q want %q", got, want)
+	}
+}
+
+func TestSplitPinnedRef(t *testing.T) {
+	repo, tag, digest := splitPinnedRef("localhost:5000/org/app:1.2.3@sha256:abc")
+	if repo != "localhost:5000/org/app" || tag != "1.2.3" || digest != "sha256:abc" {
+		t.Fatalf("got %q %q %q", repo, tag, digest)
+	}
+	repo, tag, digest = splitPinnedRef("ghcr.io/org/app")
+	if repo != "ghcr.io/org/app" || tag != "" || digest != "" {
+		t.Fatalf("got %q %q %q", repo, tag, digest)
+	}
+}
+
+func TestWildcardPathDirective(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	repo := host + "/org/app"
+	dir := writeFiles(t, map[string]string{
+		"values.yaml": "# bump: image=" + repo + " path=$.spec.containers[*].image writeTransform=\"{{.Image}}:{{.Tag}}\"\n" +
+			"spec:\n  containers:\n  - name: server\n    image: " + repo + ":1.2.3\n  - name: agent\n    image: " + repo + ":1.2.3\n",
+	})
+
+	files, changed, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", testImageOptions())
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expected changes")
+	}
+	valuesPath, _ := filepath.Abs(filepath.Join(dir, "values.yaml"))
+	ast, err := yamlutil.ParseBytes(files[valuesPath])
+	if err != nil {
+		t.Fatalf("ParseBytes: %v", err)
+	}
+	for _, p := range []string{"$.spec.containers[0].image", "$.spec.containers[1].image"} {
+		if v, _, _ := yamlutil.GetString(ast, p); v != repo+":1.3.0" {
+			t.Fatalf("%s got %q want %q", p, v, repo+":1.3.0")
+		}
+	}
+}
+
+func TestJSONPointerPathDirective(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	repo := host + "/org/app"
+	dir := writeFiles(t, map[string]string{
+		"values.yaml": "# bump: image=" + repo + " path=/spec/containers/1/image writeTransform=\"{{.Image}}:{{.Tag}}\"\n" +
+			"spec:\n  containers:\n  - name: server\n    image: " + repo + ":1.2.3\n  - name: agent\n    image: " + repo + ":1.2.3\n",
+	})
+
+	files, changed, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", testImageOptions())
+	if err != nil || !changed {
+		t.Fatalf("updateImagesInChartDir: changed=%v err=%v", changed, err)
+	}
+	valuesPath, _ := filepath.Abs(filepath.Join(dir, "values.yaml"))
+	ast, err := yamlutil.ParseBytes(files[valuesPath])
+	if err != nil {
+		t.Fatalf("ParseBytes: %v", err)
+	}
+	for p, want := range map[string]string{"$.spec.containers[0].image": repo + ":1.2.3", "$.spec.containers[1].image": repo + ":1.3.0"} {
+		if v, _, _ := yamlutil.GetString(ast, p); v != want {
+			t.Fatalf("%s got %q want %q", p, v, want)
+		}
+	}
+}
+
+func TestDirectivePathMismatch(t *testing.T) {
+	// The scanner reads the multi-line quoted scalar's contents as YAML, so its paths disagree
+	// with the parsed document. Neither case should reach the (unresolvable) registry.
+	for name, tc := range map[string]struct{ values, want string }{
+		"different value": {
+			values: "image:\n  tag: 9.9.9\nscript: \"\n  image:\n    # bump: image=registry.invalid/org/app\n    tag: 1.2.3\n  end: \"\n",
+			want:   `directive path $.image.tag resolved to a different value: the document has "9.9.9" there, but the directive precedes tag: 1.2.3`,
+		},
+		"missing path": {
+			values: "script: \"\n  image:\n    # bump: image=registry.invalid/org/app\n    tag: 1.2.3\n  end: \"\n",
+			want:   "directive path $.image.tag does not exist in the document",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"values.yaml": tc.values})
+			_, _, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", testImageOptions())
+			if !errors.Is(err, directives.ErrMalformed) || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	baseChart := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":  baseChart,
+		"base.yaml":   baseChart,
+		"values.yaml": "image:\n  # bump: image=" + host + "/org/app sync=appVersion\n  tag: 1.2.3\n",
+	})
+	chartPath := filepath.Join(dir, "Chart.yaml")
+
+	res, err := Run(context.Background(), Config{
+		ChartPath:    chartPath,
+		BasePath:     filepath.Join(dir, "base.yaml"),
+		Write:        true,
+		UpdateImages: true,
+		ScanGlob:     "Chart.yaml,values*.yaml",
+		Keychain:     authn.NewMultiKeychain(),
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.OldVersion != "0.4.1" || res.NewVersion != "0.5.0" {
+		t.Fatalf("versions got %q -> %q want 0.4.1 -> 0.5.0", res.OldVersion, res.NewVersion)
+	}
+	if !res.Changed() || len(res.Written) != 2 {
+		t.Fatalf("expected Chart.yaml and values.yaml written, got %v", res.Written)
+	}
+
+	onDisk, _ := os.ReadFile(chartPath)
+	if string(onDisk) != res.ChartYAML {
+		t.Fatalf("Chart.yaml on disk does not match result:\n%s\nvs\n%s", onDisk, res.ChartYAML)
+	}
+	meta, err := chart.LoadMeta(onDisk)
+	if err != nil {
+		t.Fatalf("LoadMeta: %v", err)
+	}
+	if meta.Version != "0.5.0" || meta.AppVersion != "1.3.0" {
+		t.Fatalf("got version %q appVersion %q", meta.Version, meta.AppVersion)
+	}
+	values, _ := os.ReadFile(filepath.Join(dir, "values.yaml"))
+	if !strings.Contains(string(values), "tag: 1.3.0") {
+		t.Fatalf("values.yaml not updated:\n%s", values)
+	}
+	if len(res.Images) != 1 || res.Images[0].Old != "1.2.3" || res.Images[0].New != "1.3.0" {
+		t.Fatalf("unexpected image changes: %#v", res.Images)
+	}
+
+}
+
+func TestNoVersionBump(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	baseChart := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":  baseChart,
+		"base.yaml":   baseChart,
+		"values.yaml": "image:\n  # bump: image=" + host + "/org/app sync=appVersion\n  tag: 1.2.3\n",
+	})
+	cfg := Config{
+		ChartPath:     filepath.Join(dir, "Chart.yaml"),
+		BasePath:      filepath.Join(dir, "base.yaml"),
+		Write:         true,
+		UpdateImages:  true,
+		NoVersionBump: true,
+		Keychain:      authn.NewMultiKeychain(),
+	}
+
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.OldVersion != "0.4.1" || res.NewVersion != "0.4.1" {
+		t.Fatalf("versions got %q -> %q want 0.4.1 unchanged", res.OldVersion, res.NewVersion)
+	}
+	onDisk, _ := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	meta, err := chart.LoadMeta(onDisk)
+	if err != nil {
+		t.Fatalf("LoadMeta: %v", err)
+	}
+	if meta.Version != "0.4.1" || meta.AppVersion != "1.3.0" {
+		t.Fatalf("got version %q appVersion %q want 0.4.1 and 1.3.0", meta.Version, meta.AppVersion)
+	}
+	if !res.Changed() || len(res.Written) != 2 {
+		t.Fatalf("expected Chart.yaml and values.yaml written, got %v", res.Written)
+	}
+
+	// With the images already current, nothing changes even though appVersion differs from
+	// the base.
+	res, err = Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("second Run: %v", err)
+	}
+	if res.Changed() {
+		t.Fatalf("expected no changes, wrote %v", res.Written)
+	}
+
+	cfg.RCWorkflow = true
+	if _, err := Run(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "RCWorkflow") {
+		t.Fatalf("expected RCWorkflow to be rejected with NoVersionBump, got %v", err)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	base := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/charts/app/Chart.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(base))
+	}))
+	t.Cleanup(srv.Close)
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.3.0\n",
+	})
+
+	res, err := Run(context.Background(), Config{
+		ChartPath: filepath.Join(dir, "Chart.yaml"),
+		BaseURL:   srv.URL + "/charts/app/Chart.yaml",
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.OldVersion != "0.4.1" || res.NewVersion != "0.5.0" {
+		t.Fatalf("versions got %q -> %q want 0.4.1 -> 0.5.0", res.OldVersion, res.NewVersion)
+	}
+
+	_, err = Run(context.Background(), Config{
+		ChartPath: filepath.Join(dir, "Chart.yaml"),
+		BaseURL:   srv.URL + "/missing/Chart.yaml",
+	})
+	if err == nil || !strings.Contains(err.Error(), "404 Not Found") || IsTransient(err) {
+		t.Fatalf("expected a permanent 404 error, got %v", err)
+	}
+
+	for _, cfg := range []Config{
+		{ChartPath: filepath.Join(dir, "Chart.yaml"), BaseURL: "ftp://example.com/Chart.yaml"},
+		{ChartPath: filepath.Join(dir, "Chart.yaml"), BaseURL: srv.URL + "/charts/app/Chart.yaml", BasePath: filepath.Join(dir, "Chart.yaml")},
+	} {
+		if _, err := Run(context.Background(), cfg); err == nil {
+			t.Fatalf("expected %+v to be rejected", cfg)
+		}
+	}
+}
+
+func TestBaseURL_Errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/unavailable/Chart.yaml":
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+		case "/huge/Chart.yaml":
+			_, _ = w.Write([]byte(strings.Repeat("#", maxBaseURLSize+1)))
+		}
+	}))
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	t.Cleanup(srv.Close)
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.3.0\n",
+	})
+
+	for name, tc := range map[string]struct {
+		url       string
+		transient bool
+	}{
+		"server error":   {srv.URL + "/unavailable/Chart.yaml", true},
+		"unreachable":    {down.URL + "/Chart.yaml", true},
+		"oversized body": {srv.URL + "/huge/Chart.yaml", false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Run(context.Background(), Config{ChartPath: filepath.Join(dir, "Chart.yaml"), BaseURL: tc.url})
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if IsTransient(err) != tc.transient {
+				t.Fatalf("IsTransient got %v want %v: %v", !tc.transient, tc.transient, err)
+			}
+		})
+	}
+}
+
+func TestDirectiveConfig(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	pushTestTags(t, host, "org/sidecar", "0.1.0", "0.2.0")
+	dir := writeFiles(t, map[string]string{
+		"values.yaml": "image:\n  # bump: image=" + host + "/org/app constraint=~1.2.0\n  tag: 1.2.3\nsidecar:\n  tag: 0.1.0\n",
+		directives.ConfigFileName: "directives:\n" +
+			"  - file: values.yaml\n    path: $.image.tag\n    image: " + host + "/org/app\n" +
+			"  - file: values.yaml\n    path: $.sidecar.tag\n    image: " + host + "/org/sidecar\n",
+	})
+
+	files, changed, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml", testImageOptions())
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expected changes")
+	}
+	got := string(files[filepath.Join(dir, "values.yaml")])
+	// The inline constraint wins for image.tag; the config entry updates sidecar.tag.
+	if !strings.Contains(got, "tag: 1.2.3") || !strings.Contains(got, "tag: 0.2.0") {
+		t.Fatalf("unexpected values.yaml:\n%s", got)
+	}
+}
+
+func TestScanGlobExclusion(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	values := "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n"
+	dir := writeFiles(t, map[string]string{"values.yaml": values, "values.test.yaml": values})
+
+	files, _, err := updateImagesInChartDir(context.Background(), dir, "values*.yaml,!values.test.yaml", testImageOptions())
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	if _, ok := files[filepath.Join(dir, "values.yaml")]; !ok {
+		t.Fatalf("values.yaml not updated")
+	}
+	if got, ok := files[filepath.Join(dir, "values.test.yaml")]; ok {
+		t.Fatalf("excluded values.test.yaml was updated:\n%s", got)
+	}
+}
+
+func TestTemplateDirectives(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	tmpl := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ include "app.fullname" . }}
+  labels:
+    {{- include "app.labels" . | nindent 4 }}
+spec:
+  template:
+    spec:
+      containers:
+        - name: main
+          image: "{{ .Values.image.repository }}:{{ .Values.image.tag }}"
+        - name: helper
+          # bump: image=` + host + `/org/app strategy=semver
+          image: "1.2.3" # pinned
+{{- if .Values.extra }}
+        - name: extra
+{{- end }}
+`
+	dir := writeFiles(t, map[string]string{"templates/deployment.yaml": tmpl})
+
+	files, changed, err := updateImagesInChartDir(context.Background(), dir, "templates/*.yaml", testImageOptions())
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expected changes")
+	}
+	want := strings.Replace(tmpl, `image: "1.2.3" # pinned`, `image: "1.3.0" # pinned`, 1)
+	if got := string(files[filepath.Join(dir, "templates", "deployment.yaml")]); got != want {
+		t.Fatalf("unexpected template:\n%s", got)
+	}
+
+	// A directive above a templated value is rejected rather than corrupting the expression.
+	dir = writeFiles(t, map[string]string{"templates/deployment.yaml": "image:\n  # bump: image=" + host + "/org/app\n  tag: {{ .Values.tag }}\n"})
+	if _, _, err := updateImagesInChartDir(context.Background(), dir, "templates/*.yaml", testImageOptions()); !errors.Is(err, ErrMalformedDirective) {
+		t.Fatalf("expected ErrMalformedDirective, got %v", err)
+	}
+}
+
+func TestIsTransient(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	host := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	for name, tc := range map[string]struct {
+		values    string
+		transient bool
+		malformed bool
+	}{
+		"registry down":       {"image:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n", true, false},
+		"malformed directive": {"image:\n  # bump: image=app\n  tag: 1.2.3\n", false, true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"values.yaml": tc.values})
+			_, _, err := updateImagesInChartDir(context.Background(), dir, "values.yaml", testImageOptions())
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if IsTransient(err) != tc.transient {
+				t.Fatalf("IsTransient got %v want %v: %v", !tc.transient, tc.transient, err)
+			}
+			if errors.Is(err, ErrMalformedDirective) != tc.malformed {
+				t.Fatalf("errors.Is(ErrMalformedDirective) got %v want %v: %v", !tc.malformed, tc.malformed, err)
+			}
+		})
+	}
+}
+
+func TestConcurrentResolutionAppliesInOrder(t *testing.T) {
+	// The first directive's tag list is held until the second's has been served, so the
+	// second resolves first.
+	fastServed := make(chan struct{})
+	var once sync.Once
+	reg := registry.New()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/org/slow/tags/list":
+			select {
+			case <-fastServed:
+			case <-time.After(5 * time.Second):
+				t.Errorf("slow tag list was not resolved concurrently with the fast one")
+			}
+		case "/v2/org/fast/tags/list":
+			defer once.Do(func() { close(fastServed) })
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	pushTestTags(t, host, "org/slow", "1.0.0", "1.1.0")
+	pushTestTags(t, host, "org/fast", "2.0.0", "2.1.0")
+
+	dir := writeFiles(t, map[string]string{
+		"values.yaml": "slow:\n  # bump: image=" + host + "/org/slow\n  tag: 1.0.0\n" +
+			"fast:\n  # bump: image=" + host + "/org/fast\n  tag: 2.0.0\n",
+	})
+	core, logs := observer.New(zapcore.InfoLevel)
+	ctx := logutil.WithEvents(logutil.WithLogger(context.Background(), zap.New(core)), true)
+	opts := testImageOptions()
+	opts.concurrency = 2
+	files, _, err := updateImagesInChartDir(ctx, dir, "values.yaml", opts)
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+
+	var order []string
+	for _, e := range logs.FilterField(zap.String("event", logutil.EventValueWritten)).All() {
+		order = append(order, e.ContextMap()["yamlPath"].(string))
+	}
+	if strings.Join(order, ",") != "$.slow.tag,$.fast.tag" {
+		t.Fatalf("values applied in order %v, want file/line order", order)
+	}
+	got := string(files[filepath.Join(dir, "values.yaml")])
+	if !strings.Contains(got, "tag: 1.1.0") || !strings.Contains(got, "tag: 2.1.0") {
+		t.Fatalf("unexpected values.yaml:\n%s", got)
+	}
+}
+
+func TestTagListSharedAcrossDirectives(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	dir := writeFiles(t, map[string]string{
+		"values.yaml": "server:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n" +
+			"worker:\n  # bump: image=" + host + "/org/app constraint=~1.2.0\n  tag: 1.2.3\n" +
+			"pinned:\n  # bump: image=" + host + "/org/app strategy=pinned-ref\n  image: " + host + "/org/app:1.2.3\n",
+	})
+	counter := &countingTransport{tagLists: map[string]int{}}
+	opts := testImageOptions(func(o *imageresolver.Options) { o.Transport = counter })
+	opts.concurrency = 3
+	if _, _, err := updateImagesInChartDir(context.Background(), dir, "values.yaml", opts); err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	if n := counter.tagLists["/v2/org/app/tags/list"]; n != 1 {
+		t.Fatalf("tag list requested %d times, want 1 (%v)", n, counter.tagLists)
+	}
+}
+
+func TestResultDiff(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	baseChart := "apiVersion: v2\nname: app\nversion: 0.4.1\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":  baseChart,
+		"base.yaml":   baseChart,
+		"values.yaml": "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n",
+	})
+	t.Chdir(dir)
+
+	for _, write := range []bool{false, true} {
+		res, err := Run(context.Background(), Config{
+			ChartPath:    "Chart.yaml",
+			BasePath:     "base.yaml",
+			Write:        write,
+			UpdateImages: true,
+			ScanGlob:     "values.yaml",
+			Keychain:     authn.NewMultiKeychain(),
+		})
+		if err != nil {
+			t.Fatalf("Run(write=%v): %v", write, err)
+		}
+		want := "--- a/values.yaml\n+++ b/values.yaml\n@@ -1,3 +1,3 @@\n" +
+			" image:\n   # bump: image=" + host + "/org/app\n-  tag: 1.2.3\n+  tag: 1.3.0\n"
+		if got := res.Diff(); got != want {
+			t.Fatalf("Diff(write=%v) got:\n%s\nwant:\n%s", write, got, want)
+		}
+	}
+}
+
+func TestMaxBump(t *testing.T) {
+	base := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	cur := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 2.0.0\n"
+	for name, tc := range map[string]struct {
+		fail    bool
+		want    string
+		wantErr bool
+	}{
+		"clamp": {want: "0.5.0"},
+		"fail":  {fail: true, wantErr: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"Chart.yaml": cur, "base.yaml": base})
+			res, err := Run(context.Background(), Config{
+				ChartPath:          filepath.Join(dir, "Chart.yaml"),
+				BasePath:           filepath.Join(dir, "base.yaml"),
+				MaxBump:            "minor",
+				FailOnExceedingMax: tc.fail,
+			})
+			if tc.wantErr {
+				if !errors.Is(err, ErrBumpExceedsMax) {
+					t.Fatalf("expected ErrBumpExceedsMax, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			if res.NewVersion != tc.want {
+				t.Fatalf("version got %q want %q", res.NewVersion, tc.want)
+			}
+		})
+	}
+
+	dir := writeFiles(t, map[string]string{"Chart.yaml": cur, "base.yaml": base})
+	if _, err := Run(context.Background(), Config{ChartPath: filepath.Join(dir, "Chart.yaml"), BasePath: filepath.Join(dir, "base.yaml"), MaxBump: "huge"}); err == nil {
+		t.Fatalf("expected an invalid MaxBump to be rejected")
+	}
+}
+
+func TestChartDir(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml": "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.3.0\n",
+		"base.yaml":  "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n",
+	})
+	res, err := Run(context.Background(), Config{ChartDir: dir, BasePath: filepath.Join(dir, "base.yaml")})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.NewVersion != "0.5.0" {
+		t.Fatalf("version got %q want 0.5.0", res.NewVersion)
+	}
+
+	other := filepath.Join(dir, "base.yaml")
+	if _, err := Run(context.Background(), Config{ChartPath: other, ChartDir: dir, BasePath: other}); err == nil {
+		t.Fatalf("expected ChartPath and ChartDir together to be rejected")
+	}
+}
+
+// commitFiles makes dir a git repository with its current files committed on HEAD.
+func commitFiles(t *testing.T, dir string) {
+	t.Helper()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("PlainInit: %v", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Worktree: %v", err)
+	}
+	if err := wt.AddGlob("."); err != nil {
+		t.Fatalf("AddGlob: %v", err)
+	}
+	if _, err := wt.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "t", Email: "t@example.com", When: time.Now()}}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+}
+
+func TestRunCharts(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"charts/a/Chart.yaml":            "apiVersion: v2\nname: a\nversion: 0.1.0\nappVersion: 1.0.0\n",
+		"charts/b/Chart.yaml":            "apiVersion: v2\nname: b\nversion: 0.2.0\nappVersion: 2.0.0\n",
+		"charts/b/charts/sub/Chart.yaml": "apiVersion: v2\nname: sub\nversion: 0.0.1\n",
+		"charts/.hidden/Chart.yaml":      "apiVersion: v2\nname: hidden\nversion: 0.0.1\n",
+	})
+	commitFiles(t, dir)
+	if err := os.WriteFile(filepath.Join(dir, "charts/a/Chart.yaml"), []byte("apiVersion: v2\nname: a\nversion: 0.1.0\nappVersion: 1.1.0\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	root := filepath.Join(dir, "charts")
+	cfg := Config{BaseRef: "HEAD", RepoRoot: dir}
+
+	results, err := RunCharts(context.Background(), root, cfg, false)
+	if err != nil {
+		t.Fatalf("RunCharts: %v", err)
+	}
+	if len(results) != 2 || results[0].Dir != filepath.Join(root, "a") || results[1].Dir != filepath.Join(root, "b") {
+		t.Fatalf("unexpected charts: %+v", results)
+	}
+	if got := results[0].Result.NewVersion; got != "0.2.0" {
+		t.Fatalf("chart a version got %q want 0.2.0", got)
+	}
+	if results[1].Result.Changed() {
+		t.Fatalf("chart b should be unchanged")
+	}
+
+	// A chart missing at the base ref fails; it sorts first, so it stops the batch unless
+	// keepGoing is set.
+	if err := os.MkdirAll(filepath.Join(root, "0new"), 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "0new", "Chart.yaml"), []byte("apiVersion: v2\nname: new\nversion: 0.1.0\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if results, err = RunCharts(context.Background(), root, cfg, false); err == nil || len(results) != 1 {
+		t.Fatalf("expected the batch to stop at the failing chart; got %d results, err %v", len(results), err)
+	}
+	results, err = RunCharts(context.Background(), root, cfg, true)
+	if err == nil || len(results) != 3 || results[0].Err == nil || results[1].Result == nil || results[2].Result == nil {
+		t.Fatalf("expected every chart to be processed with one failure; got %+v, err %v", results, err)
+	}
+
+	if _, err := RunCharts(context.Background(), root, Config{BasePath: "base.yaml"}, false); err == nil {
+		t.Fatalf("expected BasePath to be rejected")
+	}
+}
+
+func TestComponents(t *testing.T) {
+	chartYAML := "apiVersion: v2\nname: platform\nversion: 0.4.1\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":       chartYAML,
+		"base.yaml":        chartYAML,
+		"api/base.yaml":    "apiVersion: v2\nname: api\nversion: 1.0.0\nappVersion: 2.3.0\n",
+		"api/cur.yaml":     "apiVersion: v2\nname: api\nversion: 1.0.0\nappVersion: 2.4.0\n",
+		"worker/base.yaml": "apiVersion: v2\nname: worker\nversion: 1.0.0\nappVersion: 1.0.4\n",
+		"worker/cur.yaml":  "apiVersion: v2\nname: worker\nversion: 1.0.0\nappVersion: 1.0.5\n",
+	})
+	cfg := Config{
+		ChartPath: filepath.Join(dir, "Chart.yaml"),
+		BasePath:  filepath.Join(dir, "base.yaml"),
+		Components: []ChartPair{
+			{Base: filepath.Join(dir, "worker/base.yaml"), Cur: filepath.Join(dir, "worker/cur.yaml")},
+			{Base: filepath.Join(dir, "api/base.yaml"), Cur: filepath.Join(dir, "api/cur.yaml")},
+		},
+	}
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// The worker's patch change and the api's minor change make a minor bump.
+	if res.NewVersion != "0.5.0" {
+		t.Fatalf("got version %q want 0.5.0", res.NewVersion)
+	}
+
+	cfg.Components = cfg.Components[:1]
+	if res, err := Run(context.Background(), cfg); err != nil || res.NewVersion != "0.4.2" {
+		t.Fatalf("patch component only got %v, %v want 0.4.2", res, err)
+	}
+
+	cfg.Components = []ChartPair{{Base: filepath.Join(dir, "api/base.yaml")}}
+	if _, err := Run(context.Background(), cfg); err == nil {
+		t.Fatalf("expected an error for a component without Cur")
+	}
+
+	if _, err := RunCharts(context.Background(), dir, Config{BaseRef: "HEAD", RepoRoot: dir, Components: cfg.Components}, false); err == nil {
+		t.Fatalf("expected Components to be rejected for a charts root")
+	}
+}
+
+func TestChangelogHints(t *testing.T) {
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":   chartYAML,
+		"base.yaml":    chartYAML,
+		"CHANGELOG.md": "# Changelog\n\n## [Unreleased]\n\n### Added\n\n- ingress.className\n\n## [0.4.1] - 2024-01-01\n\n### Fixed\n\n- probes\n",
+	})
+	res, err := Run(context.Background(), Config{
+		ChartPath:      filepath.Join(dir, "Chart.yaml"),
+		BasePath:       filepath.Join(dir, "base.yaml"),
+		ChangelogHints: filepath.Join(dir, "CHANGELOG.md"),
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.NewVersion != "0.5.0" {
+		t.Fatalf("version got %q want 0.5.0", res.NewVersion)
+	}
+}
+
+func TestUpdateLock(t *testing.T) {
+	t.Setenv("HELM_CACHE_HOME", t.TempDir())
+	t.Setenv("HELM_CONFIG_HOME", t.TempDir())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("apiVersion: v1\nentries:\n  redis:\n" +
+			"    - name: redis\n      version: 1.0.0\n      urls: [redis-1.0.0.tgz]\n" +
+			"    - name: redis\n      version: 1.0.1\n      urls: [redis-1.0.1.tgz]\n"))
+	}))
+	t.Cleanup(srv.Close)
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\ndependencies:\n  - name: redis\n    version: 1.0.0\n    repository: " + srv.URL + "\n"
+	lock := "dependencies:\n- name: redis\n  repository: " + srv.URL + "\n  version: 1.0.0\ndigest: sha256:stale\ngenerated: \"2024-01-01T00:00:00Z\"\n"
+
+	for name, tc := range map[string]struct {
+		files  map[string]string
+		create bool
+		want   bool
+	}{
+		"existing":        {files: map[string]string{"Chart.lock": lock}, want: true},
+		"missing":         {},
+		"missing created": {create: true, want: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			files := map[string]string{"Chart.yaml": chartYAML, "base.yaml": chartYAML}
+			for k, v := range tc.files {
+				files[k] = v
+			}
+			dir := writeFiles(t, files)
+			res, err := Run(context.Background(), Config{
+				ChartPath:     filepath.Join(dir, "Chart.yaml"),
+				BasePath:      filepath.Join(dir, "base.yaml"),
+				UpdateDeps:    true,
+				DepUpdateMode: "patch",
+				UpdateLock:    true,
+				CreateLock:    tc.create,
+			})
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			got, ok := res.Updated[filepath.Join(dir, "Chart.lock")]
+			if ok != tc.want {
+				t.Fatalf("Chart.lock updated got %v want %v", ok, tc.want)
+			}
+			if !tc.want {
+				return
+			}
+			l, err := yamlutil.ParseBytes(got)
+			if err != nil {
+				t.Fatalf("parse Chart.lock: %v\n%s", err, got)
+			}
+			if v, _, _ := yamlutil.GetString(l, "$.dependencies[0].version"); v != "1.0.1" {
+				t.Fatalf("unexpected Chart.lock:\n%s", got)
+			}
+			if d, _, _ := yamlutil.GetString(l, "$.digest"); !strings.HasPrefix(d, "sha256:") || d == "sha256:stale" {
+				t.Fatalf("digest not recomputed:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestRequireDirectives(t *testing.T) {
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\n"
+	for name, cfg := range map[string]Config{
+		"no directives": {UpdateImages: true, ScanGlob: "values*.yaml"},
+		"no http deps":  {UpdateDeps: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{
+				"Chart.yaml":  chartYAML + "dependencies:\n  - name: common\n    version: 1.0.0\n    repository: oci://ghcr.io/example/charts\n",
+				"base.yaml":   chartYAML,
+				"values.yaml": "image:\n  tag: 1.2.3\n",
+			})
+			cfg.ChartPath = filepath.Join(dir, "Chart.yaml")
+			cfg.BasePath = filepath.Join(dir, "base.yaml")
+			if _, err := Run(context.Background(), cfg); err != nil {
+				t.Fatalf("Run without RequireDirectives: %v", err)
+			}
+			cfg.RequireDirectives = true
+			_, err := Run(context.Background(), cfg)
+			if !errors.Is(err, ErrNothingFound) {
+				t.Fatalf("expected ErrNothingFound, got %v", err)
+			}
+			if cfg.UpdateImages && !strings.Contains(err.Error(), "values.yaml") {
+				t.Fatalf("expected the scanned files in the error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestExactStrategy(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.2.4", "1.3.0")
+	for value, wantErr := range map[string]bool{"1.2.4": false, "1.2.5": true} {
+		dir := writeFiles(t, map[string]string{"values.yaml": "image:\n  # bump: image=" + host + "/org/app strategy=exact value=" + value + "\n  tag: 1.2.3\n"})
+		files, _, err := updateImagesInChartDir(context.Background(), dir, "values.yaml", testImageOptions())
+		if wantErr {
+			if !errors.Is(err, imageresolver.ErrTagNotFound) {
+				t.Fatalf("value=%s: expected ErrTagNotFound, got %v", value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("value=%s: %v", value, err)
+		}
+		if got := string(files[filepath.Join(dir, "values.yaml")]); !strings.Contains(got, "tag: 1.2.4") {
+			t.Fatalf("value=%s: unexpected values.yaml:\n%s", value, got)
+		}
+	}
+}
+
+// fakeTagSource is a TagResolver that returns a fixed tag.
+type fakeTagSource struct {
+	tag string
+	got TagSpec
+}
+
+func (f *fakeTagSource) ResolveTag(_ context.Context, spec TagSpec) (string, error) {
+	f.got = spec
+	return f.tag, nil
+}
+
+func TestTagSource(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"values.yaml": "image:\n  # bump: image=ghcr.io/org/app source=custom repo=org/app\n  tag: 1.2.3\n"})
+	fake := &fakeTagSource{tag: "1.4.0"}
+	opts := testImageOptions(func(o *imageresolver.Options) { o.Sources = map[string]TagResolver{"custom": fake} })
+	files, _, err := updateImagesInChartDir(context.Background(), dir, "values.yaml", opts)
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	if got := string(files[filepath.Join(dir, "values.yaml")]); !strings.Contains(got, "tag: 1.4.0") {
+		t.Fatalf("unexpected values.yaml:\n%s", got)
+	}
+	if fake.got.Repo != "org/app" || fake.got.CurrentTag != "1.2.3" || fake.got.Strategy != "semver" {
+		t.Fatalf("unexpected spec %#v", fake.got)
+	}
+}
+
+func TestValidate_ValidChart(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	baseChart := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":  baseChart,
+		"base.yaml":   baseChart,
+		"values.yaml": "image:\n  # bump: image=" + host + "/org/app sync=appVersion\n  tag: 1.2.3\n",
+	})
+
+	res, err := Run(context.Background(), Config{
+		ChartPath:    filepath.Join(dir, "Chart.yaml"),
+		BasePath:     filepath.Join(dir, "base.yaml"),
+		Write:        true,
+		UpdateImages: true,
+		ScanGlob:     "Chart.yaml,values*.yaml",
+		Keychain:     authn.NewMultiKeychain(),
+		Validate:     true,
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if res.NewVersion != "0.5.0" || len(res.Written) != 2 {
+		t.Fatalf("expected the bump to pass validation and be written, got %s and %v", res.NewVersion, res.Written)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	chartYAML := `apiVersion: v2
+name: app
+version: 0.4.1
+dependencies:
+  - name: sidecar
+    version: 1.0.0
+    repository: https://charts.example.com
+    # bump: image=ghcr.io/org/sidecar source=custom repo=org/sidecar
+    alias: sidecar
+`
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":  chartYAML,
+		"base.yaml":   chartYAML,
+		"values.yaml": "replicas: 1\n",
+	})
+	cfg := Config{
+		ChartPath:    filepath.Join(dir, "Chart.yaml"),
+		BasePath:     filepath.Join(dir, "base.yaml"),
+		Write:        true,
+		UpdateImages: true,
+		ScanGlob:     "Chart.yaml",
+		Keychain:     authn.NewMultiKeychain(),
+		// The alias must be a plain name, so Helm rejects a tag like 1.4.0.
+		TagSources: map[string]TagResolver{"custom": &fakeTagSource{tag: "1.4.0"}},
+	}
+	if _, err := Run(context.Background(), cfg); err != nil {
+		t.Fatalf("Run without Validate: %v", err)
+	}
+	if err := os.WriteFile(cfg.ChartPath, []byte(chartYAML), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg.Validate = true
+	_, err := Run(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "disallowed characters in the alias") {
+		t.Fatalf("Run got %v, want an alias validation error", err)
+	}
+	if got, _ := os.ReadFile(cfg.ChartPath); string(got) != chartYAML {
+		t.Fatalf("Chart.yaml written despite failing validation:\n%s", got)
+	}
+}
+
+func TestFailedRunWritesNothing(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	valuesA := "image:\n  # bump: image=" + host + "/org/app sync=appVersion\n  tag: 1.2.3\n"
+	// values-b.yaml sorts after values-a.yaml, so its directive fails after the first resolved.
+	valuesB := "image:\n  # bump: image=" + host + "/org/missing\n  tag: 1.0.0\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":    chartYAML,
+		"base.yaml":     chartYAML,
+		"values-a.yaml": valuesA,
+		"values-b.yaml": valuesB,
+	})
+
+	_, err := Run(context.Background(), Config{
+		ChartPath:    filepath.Join(dir, "Chart.yaml"),
+		BasePath:     filepath.Join(dir, "base.yaml"),
+		Write:        true,
+		UpdateImages: true,
+		ScanGlob:     "values*.yaml",
+		Keychain:     authn.NewMultiKeychain(),
+	})
+	if err == nil {
+		t.Fatalf("Run: expected an error for the missing image")
+	}
+	for name, want := range map[string]string{"Chart.yaml": chartYAML, "values-a.yaml": valuesA, "values-b.yaml": valuesB} {
+		if got, _ := os.ReadFile(filepath.Join(dir, name)); string(got) != want {
+			t.Fatalf("failed run modified %s:\n%s", name, got)
+		}
+	}
+
+	// A dependency failure after the images resolved must not leave the images written either.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv("HELM_CACHE_HOME", t.TempDir())
+	withDeps := chartYAML + "dependencies:\n  - name: redis\n    version: 1.0.0\n    repository: " + srv.URL + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte(withDeps), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	_, err = Run(context.Background(), Config{
+		ChartPath:    filepath.Join(dir, "Chart.yaml"),
+		BasePath:     filepath.Join(dir, "base.yaml"),
+		Write:        true,
+		UpdateImages: true,
+		UpdateDeps:   true,
+		ScanGlob:     "values-a.yaml",
+		Keychain:     authn.NewMultiKeychain(),
+	})
+	if err == nil {
+		t.Fatalf("Run: expected an error for the unavailable repository")
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "values-a.yaml")); string(got) != valuesA {
+		t.Fatalf("failed run modified values-a.yaml:\n%s", got)
+	}
+}
+
+func TestAtomic(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	values := "image:\n  # bump: image=" + host + "/org/app sync=appVersion\n  tag: 1.2.3\n"
+
+	for _, atomic := range []bool{false, true} {
+		dir := writeFiles(t, map[string]string{"Chart.yaml": chartYAML, "base.yaml": chartYAML, "values.yaml": values})
+		// Files are written in path order, so Chart.yaml is written before the changelog
+		// fails because its directory does not exist.
+		_, err := Run(context.Background(), Config{
+			ChartPath:     filepath.Join(dir, "Chart.yaml"),
+			BasePath:      filepath.Join(dir, "base.yaml"),
+			Write:         true,
+			Atomic:        atomic,
+			UpdateImages:  true,
+			ScanGlob:      "values*.yaml",
+			ChangelogPath: filepath.Join(dir, "missing", "CHANGELOG.md"),
+			Keychain:      authn.NewMultiKeychain(),
+		})
+		if err == nil {
+			t.Fatalf("atomic=%v: expected the changelog write to fail", atomic)
+		}
+		got, _ := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+		if restored := string(got) == chartYAML; restored != atomic {
+			t.Fatalf("atomic=%v: Chart.yaml restored=%v:\n%s", atomic, restored, got)
+		}
+		if got, _ := os.ReadFile(filepath.Join(dir, "values.yaml")); string(got) != values {
+			t.Fatalf("atomic=%v: values.yaml written after the failure:\n%s", atomic, got)
+		}
+	}
+}
+
+func TestPostHook(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	values := "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n"
+
+	for hook, wantErr := range map[string]bool{
+		"test -f {chartDir}/values.yaml": false,
+		"test -f {chartDir}/missing":     true,
+	} {
+		// A space in the chart directory must not split the substituted argument.
+		dir := filepath.Join(t.TempDir(), "my chart")
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatalf("Mkdir: %v", err)
+		}
+		for name, content := range map[string]string{"Chart.yaml": chartYAML, "base.yaml": chartYAML, "values.yaml": values} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+		}
+		_, err := Run(context.Background(), Config{
+			ChartPath:    filepath.Join(dir, "Chart.yaml"),
+			BasePath:     filepath.Join(dir, "base.yaml"),
+			Write:        true,
+			UpdateImages: true,
+			ScanGlob:     "values*.yaml",
+			Keychain:     authn.NewMultiKeychain(),
+			PostHook:     hook,
+		})
+		if (err != nil) != wantErr {
+			t.Fatalf("%s: Run got %v, want error %v", hook, err, wantErr)
+		}
+		if got, _ := os.ReadFile(filepath.Join(dir, "values.yaml")); !strings.Contains(string(got), "tag: 1.3.0") {
+			t.Fatalf("%s: values.yaml not written:\n%s", hook, got)
+		}
+	}
+
+	// Nothing written, so the hook doesn't run.
+	dir := writeFiles(t, map[string]string{"Chart.yaml": chartYAML, "base.yaml": chartYAML})
+	if _, err := Run(context.Background(), Config{
+		ChartPath: filepath.Join(dir, "Chart.yaml"),
+		BasePath:  filepath.Join(dir, "base.yaml"),
+		Write:     true,
+		PostHook:  "false",
+	}); err != nil {
+		t.Fatalf("Run with nothing to write: %v", err)
+	}
+}
+
+func TestPatchOut(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	values := "image:\n  # bump: image=" + host + "/org/app sync=appVersion\n  tag: 1.2.3\n"
+	root := writeFiles(t, map[string]string{
+		"charts/app/Chart.yaml":  chartYAML,
+		"charts/app/values.yaml": values,
+		"base.yaml":              chartYAML,
+	})
+	out := t.TempDir()
+
+	res, err := Run(context.Background(), Config{
+		ChartDir:      filepath.Join(root, "charts", "app"),
+		BasePath:      filepath.Join(root, "base.yaml"),
+		RepoRoot:      root,
+		PatchOut:      out,
+		UpdateImages:  true,
+		ScanGlob:      "values*.yaml",
+		ChangelogPath: filepath.Join(root, "CHANGELOG.md"),
+		Keychain:      authn.NewMultiKeychain(),
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	want := []string{
+		filepath.Join(out, "CHANGELOG.md"),
+		filepath.Join(out, "charts", "app", "Chart.yaml"),
+		filepath.Join(out, "charts", "app", "values.yaml"),
+	}
+	if !slices.Equal(res.Patched, want) {
+		t.Fatalf("Patched got %q want %q", res.Patched, want)
+	}
+	if !res.Changed() {
+		t.Fatalf("Changed() = false for a run that wrote patch files")
+	}
+	for _, p := range want[1:] {
+		rel, _ := filepath.Rel(out, p)
+		if got, _ := os.ReadFile(p); string(got) != string(res.Updated[filepath.Join(root, rel)]) {
+			t.Fatalf("%s doesn't hold the updated %s:\n%s", p, rel, got)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(root, "charts", "app", "values.yaml")); string(got) != values {
+		t.Fatalf("values.yaml modified in place:\n%s", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "CHANGELOG.md")); err == nil {
+		t.Fatalf("CHANGELOG.md written in place")
+	}
+}
+
+func TestPins(t *testing.T) {
+	host := newTestRegistry(t, "org/base", "1.2.3", "1.3.0", "2.0.0")
+	pushTestTags(t, host, "org/app", "1.2.3", "1.3.0")
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\n"
+	values := "base:\n  # bump: image=" + host + "/org/base constraint=^1.0.0\n  tag: 1.3.0\n" +
+		"app:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n"
+	dir := writeFiles(t, map[string]string{"Chart.yaml": chartYAML, "base.yaml": chartYAML, "values.yaml": values})
+	cfg := Config{
+		ChartPath:    filepath.Join(dir, "Chart.yaml"),
+		BasePath:     filepath.Join(dir, "base.yaml"),
+		UpdateImages: true,
+		ScanGlob:     "values*.yaml",
+		Keychain:     authn.NewMultiKeychain(),
+		Pins:         map[string]string{host + "/org/base": "2.0.0"},
+	}
+
+	res, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	// The pin wins over the directive's constraint; the unpinned image follows its strategy.
+	want := "base:\n  # bump: image=" + host + "/org/base constraint=^1.0.0\n  tag: 2.0.0\n" +
+		"app:\n  # bump: image=" + host + "/org/app\n  tag: 1.3.0\n"
+	if got := string(res.Updated[filepath.Join(dir, "values.yaml")]); got != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	// A pin below the current tag is applied, not skipped as a downgrade.
+	cfg.Pins = map[string]string{host + "/org/base": "1.2.3"}
+	if res, err = Run(context.Background(), cfg); err != nil || !strings.Contains(string(res.Updated[filepath.Join(dir, "values.yaml")]), "tag: 1.2.3\napp:") {
+		t.Fatalf("Run with a lower pin got %v", err)
+	}
+
+	cfg.Pins = map[string]string{host + "/org/base": "9.9.9"}
+	if _, err := Run(context.Background(), cfg); err == nil {
+		t.Fatalf("expected a pin to a missing tag to fail")
+	}
+}
+
+func TestParsePin(t *testing.T) {
+	image, version, err := ParsePin(" version=1.2.3  image=ghcr.io/org/base/ ")
+	if err != nil || image != "ghcr.io/org/base" || version != "1.2.3" {
+		t.Fatalf("ParsePin got %q, %q, %v", image, version, err)
+	}
+	for _, spec := range []string{"", "image=ghcr.io/org/base", "version=1.2.3", "image=ghcr.io/org/base version=1.2.3 tag=x", "image=base version=1.2.3", "image=a/b image=c/d version=1"} {
+		if _, _, err := ParsePin(spec); err == nil {
+			t.Fatalf("ParsePin(%q): expected an error", spec)
+		}
+	}
+}
+
+func TestDefaultPlatform(t *testing.T) {
+	host := newTestRegistry(t, "org/other")
+	// Each platform's image carries its architecture as a label, so strategy=label shows
+	// which one was read.
+	var idx v1.ImageIndex = empty.Index
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatalf("random.Image: %v", err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatalf("ConfigFile: %v", err)
+		}
+		cfg = cfg.DeepCopy()
+		cfg.Config.Labels = map[string]string{"arch": arch}
+		if img, err = mutate.ConfigFile(img, cfg); err != nil {
+			t.Fatalf("mutate.ConfigFile: %v", err)
+		}
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{Add: img, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}}})
+	}
+	ref, err := name.ParseReference(host + "/org/app:1.2.3")
+	if err != nil {
+		t.Fatalf("ParseReference: %v", err)
+	}
+	if err := remote.WriteIndex(ref, idx); err != nil {
+		t.Fatalf("remote.WriteIndex: %v", err)
+	}
+
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\n"
+	for _, tc := range []struct {
+		name, platform, directive, want string
+	}{
+		{"default applied", "linux/arm64", "", "arm64"},
+		{"directive overrides", "linux/arm64", " platform=linux/amd64", "amd64"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{
+				"Chart.yaml":  chartYAML,
+				"base.yaml":   chartYAML,
+				"values.yaml": "image:\n  tag: 1.2.3\n  # bump: image=" + host + "/org/app strategy=label label=arch" + tc.directive + "\n  arch: \"\"\n",
+			})
+			res, err := Run(context.Background(), Config{
+				ChartPath:       filepath.Join(dir, "Chart.yaml"),
+				BasePath:        filepath.Join(dir, "base.yaml"),
+				UpdateImages:    true,
+				ScanGlob:        "values*.yaml",
+				Keychain:        authn.NewMultiKeychain(),
+				DefaultPlatform: tc.platform,
+			})
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+			got := string(res.Updated[filepath.Join(dir, "values.yaml")])
+			if !strings.Contains(got, "arch: \""+tc.want+"\"\n") {
+				t.Fatalf("values.yaml doesn't hold the %s label:\n%s", tc.want, got)
+			}
+		})
+	}
+
+	if _, err := Run(context.Background(), Config{ChartPath: "Chart.yaml", BasePath: "base.yaml", DefaultPlatform: "amd64"}); err == nil {
+		t.Fatalf("expected an invalid DefaultPlatform to be rejected")
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	got, err := splitCommand(` helm  template "{chartDir}" --set 'a=b c';rm `)
+	if want := []string{"helm", "template", "{chartDir}", "--set", "a=b c;rm"}; err != nil || !slices.Equal(got, want) {
+		t.Fatalf("splitCommand got %q, %v want %q", got, err, want)
+	}
+	for _, s := range []string{"", "  ", `helm lint "{chartDir}`} {
+		if _, err := splitCommand(s); err == nil {
+			t.Fatalf("splitCommand(%q): expected an error", s)
+		}
+	}
+}
+
+func TestOCIDependencyDirective(t *testing.T) {
+	host := newTestRegistry(t, "charts/redis", "1.0.0", "1.1.0", "2.0.0")
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\ndependencies:\n" +
+		"  - name: redis\n    # bump: source=oci constraint=^1.0.0\n    version: 1.0.0\n    repository: oci://" + host + "/charts\n"
+	dir := writeFiles(t, map[string]string{"Chart.yaml": chartYAML, "base.yaml": chartYAML})
+
+	res, err := Run(context.Background(), Config{
+		ChartPath:    filepath.Join(dir, "Chart.yaml"),
+		BasePath:     filepath.Join(dir, "base.yaml"),
+		UpdateImages: true,
+		ScanGlob:     "Chart.yaml",
+		Keychain:     authn.NewMultiKeychain(),
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !strings.Contains(res.ChartYAML, "version: 1.1.0\n") {
+		t.Fatalf("OCI dependency not updated:\n%s", res.ChartYAML)
+	}
+	if len(res.Images) != 1 || res.Images[0].Image != host+"/charts/redis" || res.Images[0].YAMLPath != "$.dependencies[0].version" {
+		t.Fatalf("unexpected changes: %#v", res.Images)
+	}
+
+	// Without image=, the directive must target an oci:// dependency's version.
+	for name, content := range map[string]string{
+		"http repository": strings.Replace(chartYAML, "oci://", "https://", 1),
+		"not a version":   "apiVersion: v2\nname: app\nversion: 0.4.1\n# bump: source=oci\nappVersion: 1.0.0\n",
+	} {
+		dir := writeFiles(t, map[string]string{"Chart.yaml": content})
+		if _, _, err := updateImagesInChartDir(context.Background(), dir, "Chart.yaml", testImageOptions()); err == nil || !strings.Contains(err.Error(), "source=oci") {
+			t.Fatalf("%s: got %v, want a source=oci error", name, err)
+		}
+	}
+}
+
+func TestCRLFValues(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	in := "# app image\r\nimage:\r\n  # bump: image=" + host + "/org/app\r\n  tag: \"1.2.3\" # pinned\r\n"
+	dir := writeFiles(t, map[string]string{"values.yaml": in, "templates/deploy.yaml": in})
+	files, _, err := updateImagesInChartDir(context.Background(), dir, "values.yaml,templates/*.yaml", testImageOptions())
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	want := strings.Replace(in, "1.2.3", "1.3.0", 1)
+	for _, name := range []string{"values.yaml", "templates/deploy.yaml"} {
+		if got := string(files[filepath.Join(dir, name)]); got != want {
+			t.Fatalf("%s: got %q want %q", name, got, want)
+		}
+	}
+}
+
+func TestImageReferenceValue(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	for name, tc := range map[string]struct {
+		values  string
+		want    string
+		wantErr string
+	}{
+		"inferred repo": {
+			values: "app:\n  # bump: strategy=semver\n  image: " + host + "/org/app:1.2.3\n",
+			want:   "image: " + host + "/org/app:1.3.0",
+		},
+		"matching repo": {
+			values: "app:\n  # bump: image=" + host + "/org/app\n  image: " + host + "/org/app:1.2.3\n",
+			want:   "image: " + host + "/org/app:1.3.0",
+		},
+		"mismatched repo": {
+			values:  "app:\n  # bump: image=" + host + "/org/other\n  image: " + host + "/org/app:1.2.3\n",
+			wantErr: "does not match repository",
+		},
+		"nothing to infer": {
+			values:  "app:\n  # bump: strategy=semver\n  tag: 1.2.3\n",
+			wantErr: "missing required image=",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"values.yaml": tc.values})
+			files, _, err := updateImagesInChartDir(context.Background(), dir, "values.yaml", testImageOptions())
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("updateImagesInChartDir: %v", err)
+			}
+			if got := string(files[filepath.Join(dir, "values.yaml")]); !strings.Contains(got, tc.want) {
+				t.Fatalf("unexpected values.yaml:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestPathFilters(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	values := "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n" +
+		"images:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n"
+	for name, tc := range map[string]struct {
+		only, skip  []string
+		wantChanged bool
+		want        string
+	}{
+		"only image":     {only: []string{"$.image"}, wantChanged: true, want: "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.3.0\nimages:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n"},
+		"skip image":     {skip: []string{"image"}, wantChanged: true, want: "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\nimages:\n  # bump: image=" + host + "/org/app\n  tag: 1.3.0\n"},
+		"only elsewhere": {only: []string{"$.sidecar"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := writeFiles(t, map[string]string{"values.yaml": values})
+			opts := testImageOptions()
+			opts.onlyPaths, opts.skipPaths = tc.only, tc.skip
+			files, changed, err := updateImagesInChartDir(context.Background(), dir, "values.yaml", opts)
+			if err != nil {
+				t.Fatalf("updateImagesInChartDir: %v", err)
+			}
+			if changed != tc.wantChanged {
+				t.Fatalf("changed=%v want %v", changed, tc.wantChanged)
+			}
+			if got := string(files[filepath.Join(dir, "values.yaml")]); tc.wantChanged && got != tc.want {
+				t.Fatalf("unexpected values.yaml:\n%s", got)
+			}
+		})
+	}
+}
+
+func TestValuesFiles(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	dir := writeFiles(t, map[string]string{
+		"charts/app/Chart.yaml":   chartYAML,
+		"base.yaml":               chartYAML,
+		"env/prod/values.yaml":    "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n",
+		"env/staging/values.yaml": "image:\n  tag: 1.2.3\n",
+	})
+	res, err := Run(context.Background(), Config{
+		ChartDir:         filepath.Join(dir, "charts/app"),
+		BasePath:         filepath.Join(dir, "base.yaml"),
+		RepoRoot:         dir,
+		UpdateImages:     true,
+		ValuesFiles:      []string{"env/prod/values.yaml", filepath.Join(dir, "env/staging/values.yaml")},
+		Keychain:         authn.NewMultiKeychain(),
+		VerifyIdempotent: true,
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	prod := filepath.Join(dir, "env/prod/values.yaml")
+	want := "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.3.0\n"
+	if got := string(res.Updated[prod]); got != want {
+		t.Fatalf("unexpected %s:\n%s", prod, got)
+	}
+	if len(res.Updated) != 1 {
+		t.Fatalf("expected only the prod values updated, got %d files", len(res.Updated))
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	// The registry never answers; only the deadline ends the run.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "http://")
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":  chartYAML,
+		"base.yaml":   chartYAML,
+		"values.yaml": "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n",
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := Run(ctx, Config{
+		ChartPath:     filepath.Join(dir, "Chart.yaml"),
+		BasePath:      filepath.Join(dir, "base.yaml"),
+		UpdateImages:  true,
+		Keychain:      authn.NewMultiKeychain(),
+		KeepOnFailure: true,
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline error despite KeepOnFailure, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "values.yaml:2") {
+		t.Fatalf("error should name the directive being resolved: %v", err)
+	}
+}
+
+func TestRecordDigests(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	for _, record := range []bool{false, true} {
+		dir := writeFiles(t, map[string]string{"values.yaml": "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n"})
+		var changes []ImageChange
+		opts := testImageOptions()
+		opts.changes, opts.recordDigests = &changes, record
+		if _, _, err := updateImagesInChartDir(context.Background(), dir, "values.yaml", opts); err != nil {
+			t.Fatalf("recordDigests=%v: %v", record, err)
+		}
+		if len(changes) != 1 || changes[0].New != "1.3.0" {
+			t.Fatalf("recordDigests=%v: unexpected changes %+v", record, changes)
+		}
+		if got := changes[0].Digest; record != strings.HasPrefix(got, "sha256:") {
+			t.Fatalf("recordDigests=%v: digest %q", record, got)
+		}
+	}
+}
+
+func TestRegistryMirror(t *testing.T) {
+	host := newTestRegistry(t, "dockerhub/org/app", "1.2.3", "1.3.0")
+	dir := writeFiles(t, map[string]string{
+		"values.yaml": "image:\n  # bump: image=registry.invalid/org/app\n  tag: 1.2.3\n  # bump: strategy=pinned-ref\n  ref: registry.invalid/org/app:1.2.3@sha256:" + strings.Repeat("0", 64) + "\n",
+	})
+	opts := testImageOptions(func(o *imageresolver.Options) { o.Mirrors = map[string]string{"registry.invalid": host + "/dockerhub"} })
+	files, _, err := updateImagesInChartDir(context.Background(), dir, "values.yaml", opts)
+	if err != nil {
+		t.Fatalf("updateImagesInChartDir: %v", err)
+	}
+	valuesPath, _ := filepath.Abs(filepath.Join(dir, "values.yaml"))
+	got := string(files[valuesPath])
+	if !strings.Contains(got, "tag: 1.3.0\n") || !strings.Contains(got, "ref: registry.invalid/org/app:1.3.0@sha256:") {
+		t.Fatalf("expected the canonical image to be recorded, got:\n%s", got)
+	}
+}
+
+func TestDowngradeGuard(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.2.4")
+	for _, allow := range []bool{false, true} {
+		dir := writeFiles(t, map[string]string{"values.yaml": "image:\n  # bump: image=" + host + "/org/app strategy=semver\n  tag: 1.3.0\n"})
+		opts := testImageOptions()
+		opts.allowDowngrade = allow
+		files, changed, err := updateImagesInChartDir(context.Background(), dir, "values.yaml", opts)
+		if err != nil {
+			t.Fatalf("allowDowngrade=%v: %v", allow, err)
+		}
+		if !allow {
+			if changed {
+				t.Fatalf("downgrade written:\n%s", files[filepath.Join(dir, "values.yaml")])
+			}
+			continue
+		}
+		if got := string(files[filepath.Join(dir, "values.yaml")]); !strings.Contains(got, "tag: 1.2.4") {
+			t.Fatalf("allowed downgrade not written:\n%s", got)
+		}
+	}
+}
+
+func TestDepsRecursive(t *testing.T) {
+	t.Setenv("HELM_CACHE_HOME", t.TempDir())
+	t.Setenv("HELM_CONFIG_HOME", t.TempDir())
+	var indexRequests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		indexRequests++
+		_, _ = w.Write([]byte("apiVersion: v1\nentries:\n" +
+			"  redis:\n    - name: redis\n      version: 1.1.0\n      urls: [redis-1.1.0.tgz]\n" +
+			"  common:\n    - name: common\n      version: 2.3.0\n      urls: [common-2.3.0.tgz]\n"))
+	}))
+	t.Cleanup(srv.Close)
+	umbrella := "apiVersion: v2\nname: umbrella\nversion: 0.4.1\ndependencies:\n  - name: redis\n    version: ^1.0.0\n    repository: " + srv.URL + "\n  - name: sub\n    version: 0.1.0\n"
+	sub := "apiVersion: v2\nname: sub\nversion: 0.1.0\ndependencies:\n  - name: common\n    version: ^2.0.0\n    repository: " + srv.URL + "\n"
+	dir := writeFiles(t, map[string]string{"Chart.yaml": umbrella, "base.yaml": umbrella, "charts/sub/Chart.yaml": sub})
+
+	res, err := Run(context.Background(), Config{
+		ChartPath:     filepath.Join(dir, "Chart.yaml"),
+		BasePath:      filepath.Join(dir, "base.yaml"),
+		UpdateDeps:    true,
+		DepsRecursive: true,
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if !strings.Contains(res.ChartYAML, "version: 1.1.0") {
+		t.Fatalf("umbrella dependency not updated:\n%s", res.ChartYAML)
+	}
+	if got := string(res.Updated[filepath.Join(dir, "charts", "sub", "Chart.yaml")]); !strings.Contains(got, "version: 2.3.0") {
+		t.Fatalf("subchart dependency not updated:\n%s", got)
+	}
+	if indexRequests != 1 {
+		t.Fatalf("index downloaded %d times, want 1", indexRequests)
+	}
+	if len(res.Dependencies) != 2 || res.Dependencies[0].Name != "redis" || res.Dependencies[1].New != "2.3.0" {
+		t.Fatalf("unexpected dependency changes: %#v", res.Dependencies)
+	}
+}
+
+func TestDepsKeepStagedImageUpdates(t *testing.T) {
+	t.Setenv("HELM_CACHE_HOME", t.TempDir())
+	t.Setenv("HELM_CONFIG_HOME", t.TempDir())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("apiVersion: v1\nentries:\n  redis:\n    - name: redis\n      version: 1.1.0\n      urls: [redis-1.1.0.tgz]\n"))
+	}))
+	t.Cleanup(srv.Close)
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\nappVersion: 1.2.3\ndependencies:\n  - name: redis\n    version: ^1.0.0\n    repository: " + srv.URL + "\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":  chartYAML,
+		"base.yaml":   chartYAML,
+		"values.yaml": "image:\n  # bump: image=" + host + "/org/app sync=appVersion\n  tag: 1.2.3\n",
+	})
+
+	// The synced appVersion is only staged when the dependencies are resolved, so they must
+	// be applied on top of it rather than on the Chart.yaml on disk.
+	res, err := Run(context.Background(), Config{
+		ChartPath:    filepath.Join(dir, "Chart.yaml"),
+		BasePath:     filepath.Join(dir, "base.yaml"),
+		Write:        true,
+		UpdateImages: true,
+		UpdateDeps:   true,
+		Keychain:     authn.NewMultiKeychain(),
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	onDisk, _ := os.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if string(onDisk) != res.ChartYAML || !strings.Contains(res.ChartYAML, "appVersion: 1.3.0") || !strings.Contains(res.ChartYAML, "version: 1.1.0") {
+		t.Fatalf("Chart.yaml lost an update:\n%s", onDisk)
+	}
+}
+
+func TestInfoLogsSummarizeChanges(t *testing.T) {
+	t.Setenv("HELM_CACHE_HOME", t.TempDir())
+	t.Setenv("HELM_CONFIG_HOME", t.TempDir())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("apiVersion: v1\nentries:\n  redis:\n    - name: redis\n      version: 1.1.0\n      urls: [redis-1.1.0.tgz]\n"))
+	}))
+	t.Cleanup(srv.Close)
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	chartYAML := "apiVersion: v2\nname: app\nversion: 0.4.1\ndependencies:\n  - name: redis\n    version: ^1.0.0\n    repository: " + srv.URL + "\n"
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":  chartYAML,
+		"base.yaml":   chartYAML,
+		"values.yaml": "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.2.3\n  # bump: image=" + host + "/org/app\n  unchanged: 1.3.0\n",
+	})
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	_, err := Run(context.Background(), Config{
+		ChartPath:    filepath.Join(dir, "Chart.yaml"),
+		BasePath:     filepath.Join(dir, "base.yaml"),
+		UpdateImages: true,
+		UpdateDeps:   true,
+		Keychain:     authn.NewMultiKeychain(),
+		Logger:       zap.New(core),
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	var updated []string
+	for _, e := range logs.FilterMessageSnippet("updated ").All() {
+		updated = append(updated, e.Message)
+	}
+	want := []string{
+		"updated $.image.tag: 1.2.3 -> 1.3.0 (" + host + "/org/app, semver)",
+		"updated dependency redis: ^1.0.0 -> 1.1.0 (" + srv.URL + ")",
+	}
+	if strings.Join(updated, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("info logs got %q want %q", updated, want)
+	}
+	img := logs.FilterMessageSnippet("updated $.image.tag").All()[0].ContextMap()
+	if img["yamlPath"] != "$.image.tag" || img["old"] != "1.2.3" || img["new"] != "1.3.0" || img["image"] != host+"/org/app" || img["strategy"] != "semver" {
+		t.Fatalf("image change fields got %v", img)
+	}
+	dep := logs.FilterMessageSnippet("updated dependency").All()[0].ContextMap()
+	if dep["name"] != "redis" || dep["old"] != "^1.0.0" || dep["new"] != "1.1.0" || dep["repo"] != srv.URL {
+		t.Fatalf("dependency change fields got %v", dep)
+	}
+	if logs.FilterMessage("$.image.unchanged already up to date: 1.3.0 ("+host+"/org/app, semver)").Len() != 1 || logs.FilterMessage("all images up to date").Len() != 0 {
+		t.Fatalf("expected a per-directive up-to-date log and no all-up-to-date log, got %#v", logs.All())
+	}
+}
+
+func TestInfoLogsUpToDate(t *testing.T) {
+	host := newTestRegistry(t, "org/app", "1.2.3", "1.3.0")
+	dir := writeFiles(t, map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: app\nversion: 0.4.1\n",
+		"values.yaml": "image:\n  # bump: image=" + host + "/org/app\n  tag: 1.3.0\n",
+	})
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	res, err := Run(context.Background(), Config{
+		ChartPath:    filepath.Join(dir, "Chart.yaml"),
+		BasePath:     filepath.Join(dir, "Chart.yaml"),
+		UpdateImages: true,
+		Keychain:     authn.NewMultiKeychain(),
+		Logger:       zap.New(core),
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(res.Images) != 0 {
+		t.Fatalf("expected no image changes, got %#v", res.Images)
+	}
+	for _, msg := range []string{
+		"$.image.tag already up to date: 1.3.0 (" + host + "/org/app, semver)",
+		"all images up to date",
+	} {
+		if logs.FilterMessage(msg).Len() != 1 {
+			t.Fatalf("expected info log %q, got %#v", msg, logs.All())
+		}
+	}
+
+	// A chart without directives says so instead.
+	dir = writeFiles(t, map[string]string{
+		"Chart.yaml":  "apiVersion: v2\nname: app\nversion: 0.4.1\n",
+		"values.yaml": "image:\n  tag: 1.3.0\n",
+	})
+	core, logs = observer.New(zapcore.InfoLevel)
+	if _, err := Run(context.Background(), Config{
+		ChartPath:    filepath.Join(dir, "Chart.yaml"),
+		BasePath:     filepath.Join(dir, "Chart.yaml"),
+		UpdateImages: true,
+		Keychain:     authn.NewMultiKeychain(),
+		Logger:       zap.New(core),
+	}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if logs.FilterMessage("no bump directives found").Len() != 1 || logs.FilterMessage("all images up to date").Len() != 0 {
+		t.Fatalf("expected only the no-directives log, got %#v", logs.All())
+	}
+}
+
+func TestResultSummary(t *testing.T) {
+	res := &Result{
+		OldVersion:   "0.3.1",
+		NewVersion:   "0.4.0",
+		Dependencies: []DependencyChange{{Chart: "Chart.yaml", Name: "redis", Old: "19.0.3", New: "20.1.0"}},
+		Images: []ImageChange{
+			{File: "values.yaml", YAMLPath: "$.app.tag", Image: "ghcr.io/org/app", Old: "1.4", New: "1.5"},
+			{File: "values.yaml", YAMLPath: "$.agent.tag", Image: "ghcr.io/org/agent", Old: "1.4", New: "1.5"},
+			{File: "values.yaml", YAMLPath: "$.proxy.tag", Image: "ghcr.io/org/proxy", Old: "2.0", New: "2.1"},
+		},
+	}
+	want := "Chart 0.3.1 -> 0.4.0\nredis 19.0.3 -> 20.1.0\n3 images updated\n"
+	if got := res.Summary(); got != want {
+		t.Fatalf("Summary got:\n%s\nwant:\n%s", got, want)
+	}
+
+	res = &Result{OldVersion: "0.3.1", NewVersion: "0.3.1", Kept: []KeptValue{{File: "values.yaml", Line: 2}}}
+	if got, want := res.Summary(), "Chart 0.3.1 unchanged\n1 directive kept at the current value\n"; got != want {
+		t.Fatalf("Summary got %q want %q", got, want)
+	}
+}
+
+func TestCommitMessage(t *testing.T) {
+	deps := []DependencyChange{{Chart: "Chart.yaml", Name: "redis", Old: "19.0.0", New: "20.1.2"}}
+	images := []ImageChange{
+		{File: "values.yaml", Line: 2, YAMLPath: "$.image.tag", Image: "ghcr.io/org/app", Old: "1.4", New: "1.5"},
+		{File: "values-prod.yaml", Line: 2, YAMLPath: "$.image.tag", Image: "ghcr.io/org/app", Old: "1.4", New: "1.5"},
+	}
+	for name, tc := range map[string]struct {
+		res  Result
+		want string
+	}{
+		"deps only":   {Result{OldVersion: "0.3.1", NewVersion: "0.4.0", Dependencies: deps}, "chore(deps): bump redis 19.0.0→20.1.2; chart 0.3.1→0.4.0"},
+		"images only": {Result{OldVersion: "0.3.1", NewVersion: "0.3.2", Images: images}, "chore(deps): bump app image 1.4→1.5; chart 0.3.1→0.3.2"},
+		"combined":    {Result{OldVersion: "0.3.1", NewVersion: "0.4.0", Dependencies: deps, Images: images}, "chore(deps): bump redis 19.0.0→20.1.2, app image 1.4→1.5; chart 0.3.1→0.4.0"},
+		"no changes":  {Result{OldVersion: "0.3.1", NewVersion: "0.3.2"}, "chore: bump chart 0.3.1→0.3.2"},
+		"no bump":     {Result{OldVersion: "0.3.1", NewVersion: "0.3.1", Images: images}, "chore(deps): bump app image 1.4→1.5"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			got, err := tc.res.CommitMessage("")
+			if err != nil {
+				t.Fatalf("CommitMessage: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %q want %q", got, tc.want)
+			}
+		})
+	}
+
+	res := Result{OldVersion: "0.3.1", NewVersion: "0.4.0", Dependencies: deps, Images: images}
+	got, err := res.CommitMessage("Bump to {{.NewVersion}}\n{{range .Images}}\n- {{.File}}: {{.Old}} -> {{.New}}{{end}}")
+	if err != nil {
+		t.Fatalf("CommitMessage(custom): %v", err)
+	}
+	if want := "Bump to 0.4.0\n\n- values.yaml: 1.4 -> 1.5\n- values-prod.yaml: 1.4 -> 1.5"; got != want {
+		t.Fatalf("custom template got %q want %q", got, want)
+	}
+	if _, err := res.CommitMessage("{{.Missing"); err == nil {
+		t.Fatalf("expected a template parse error")
+	}
+}
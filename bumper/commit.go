@@ -0,0 +1,63 @@
+package bumper
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// DefaultCommitMessageTemplate renders a conventional-commits style summary such as
+// "chore(deps): bump redis 19.0.0→20.1.2, app image 1.4→1.5; chart 0.3.1→0.4.0". The chart
+// part is left out when the version did not change, as with Config.NoVersionBump.
+const DefaultCommitMessageTemplate = `{{if .Changes}}chore(deps): bump {{join .Changes ", "}}{{if ne .OldVersion .NewVersion}}; chart {{.OldVersion}}→{{.NewVersion}}{{end}}{{else}}chore: bump chart {{.OldVersion}}→{{.NewVersion}}{{end}}`
+
+// CommitMessageData is the data a commit message template is executed with.
+type CommitMessageData struct {
+	OldVersion string
+	NewVersion string
+	// Images and Dependencies are the run's change records (see Result).
+	Images       []ImageChange
+	Dependencies []DependencyChange
+	// Changes summarizes each distinct update as "<name> <old>→<new>": dependencies first,
+	// then images, named by the last element of their repository with an " image" suffix.
+	Changes []string
+}
+
+// CommitMessage renders tmpl, a text/template over CommitMessageData with a join function
+// (strings.Join), describing the run's changes. An empty tmpl means
+// DefaultCommitMessageTemplate.
+func (r *Result) CommitMessage(tmpl string) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultCommitMessageTemplate
+	}
+	t, err := template.New("commit-message").Funcs(template.FuncMap{"join": strings.Join}).Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("parse commit message template: %w", err)
+	}
+	data := CommitMessageData{
+		OldVersion:   r.OldVersion,
+		NewVersion:   r.NewVersion,
+		Images:       r.Images,
+		Dependencies: r.Dependencies,
+	}
+	seen := map[string]bool{}
+	add := func(s string) {
+		if !seen[s] {
+			seen[s] = true
+			data.Changes = append(data.Changes, s)
+		}
+	}
+	for _, d := range r.Dependencies {
+		add(fmt.Sprintf("%s %s→%s", d.Name, d.Old, d.New))
+	}
+	for _, c := range r.Images {
+		add(fmt.Sprintf("%s image %s→%s", path.Base(c.Image), c.Old, c.New))
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("render commit message: %w", err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,266 @@
+package bumper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/helmdeps"
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+	"github.com/joejulian/helm-chart-bumper-action/internal/yamlutil"
+
+	"go.uber.org/zap"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// DependencyChange records a Chart.yaml dependency version updated by the run.
+type DependencyChange struct {
+	// Chart is the path of the Chart.yaml declaring the dependency.
+	Chart string
+	Name  string
+	Old   string
+	New   string
+}
+
+// updateDepsInChartYAML resolves dependency version updates and applies them to cur, or to
+// chartDir/Chart.yaml on disk if cur is nil, returning the updated Chart.yaml bytes. It never
+// writes to disk; the caller stages the result.
+// If rewriteRepo=true, dependencies resolved from a mirror also get their repository rewritten.
+// Each updated version is appended to changes, if set.
+func updateDepsInChartYAML(ctx context.Context, chartDir string, cur []byte, dopts *helmdeps.Options, rewriteRepo bool, changes *[]DependencyChange) ([]byte, bool, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "updateDepsInChartYAML"), zap.String("chartDir", chartDir))
+	chartPath := filepath.Join(chartDir, "Chart.yaml")
+	log.Debug("resolving dependency updates", zap.String("chartPath", chartPath))
+
+	resolved, skipped, err := helmdeps.ResolveLatestDependencies(ctx, chartPath, dopts)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, sd := range skipped {
+		fields := []zap.Field{
+			zap.String("name", sd.Name),
+			zap.Int("index", sd.Index),
+			zap.String("version", sd.Version),
+			zap.String("repo", sd.Repository),
+			zap.String("reason", string(sd.Reason)),
+		}
+		if sd.Reason == helmdeps.SkipDowngrade {
+			log.Warn("prevented dependency downgrade (use --allow-downgrade to permit)", fields...)
+			continue
+		}
+		log.Info("skipped dependency", fields...)
+	}
+	log.Debug("resolved dependency candidates", zap.Int("count", len(resolved)))
+	if len(resolved) == 0 {
+		return nil, false, nil
+	}
+
+	b := cur
+	if b == nil {
+		if b, err = os.ReadFile(chartPath); err != nil {
+			return nil, false, err
+		}
+	}
+	ast, err := yamlutil.ParseBytes(b)
+	if err != nil {
+		return nil, false, err
+	}
+
+	changed := false
+	for _, r := range resolved {
+		logutil.Detail(ctx, log, "dependency resolution",
+			zap.String("name", r.Name),
+			zap.Int("index", r.Index),
+			zap.String("repo", r.Repository),
+			zap.String("resolvedRepo", r.ResolvedRepository),
+			zap.String("old", r.OldVersion),
+			zap.String("new", r.NewVersion),
+		)
+		if r.NewVersion == "" || r.NewVersion == r.OldVersion {
+			continue
+		}
+		p := fmt.Sprintf("$.dependencies[%d].version", r.Index)
+		c, err := yamlutil.SetString(ast, p, r.NewVersion)
+		if err != nil {
+			return nil, false, fmt.Errorf("Chart.yaml dependency %q: %w", r.Name, err)
+		}
+		changed = changed || c
+		if c {
+			log.Info(fmt.Sprintf("updated dependency %s: %s -> %s (%s)", r.Name, r.OldVersion, r.NewVersion, r.Repository),
+				zap.String("chartPath", chartPath),
+				zap.String("name", r.Name),
+				zap.String("old", r.OldVersion),
+				zap.String("new", r.NewVersion),
+				zap.String("repo", r.Repository),
+			)
+		}
+		if c && changes != nil {
+			*changes = append(*changes, DependencyChange{Chart: chartPath, Name: r.Name, Old: r.OldVersion, New: r.NewVersion})
+		}
+		if rewriteRepo && r.ResolvedRepository != "" && r.ResolvedRepository != r.Repository {
+			rp := fmt.Sprintf("$.dependencies[%d].repository", r.Index)
+			c, err := yamlutil.SetString(ast, rp, r.ResolvedRepository)
+			if err != nil {
+				return nil, false, fmt.Errorf("Chart.yaml dependency %q: %w", r.Name, err)
+			}
+			changed = changed || c
+		}
+	}
+	if !changed {
+		log.Debug("no dependency versions changed")
+		return nil, false, nil
+	}
+
+	out, err := yamlutil.Render(ast)
+	if err != nil {
+		return nil, false, err
+	}
+	outBytes := []byte(out)
+	if !bytes.Equal(b, outBytes) {
+		return outBytes, true, nil
+	}
+	log.Debug("rendered Chart.yaml identical after deps update")
+	return nil, false, nil
+}
+
+// subchartDirs returns the unpacked subcharts under chartDir/charts, recursively, parents
+// before their own subcharts. Packaged (.tgz) subcharts are skipped, and a directory reached
+// twice through symlinks is visited once.
+func subchartDirs(chartDir string) ([]string, error) {
+	root, err := filepath.EvalSymlinks(chartDir)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{root: true}
+	var out []string
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := os.ReadDir(filepath.Join(dir, "charts"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			sub := filepath.Join(dir, "charts", e.Name())
+			real, err := filepath.EvalSymlinks(sub)
+			if err != nil {
+				return err
+			}
+			if seen[real] {
+				continue
+			}
+			if st, err := os.Stat(filepath.Join(real, "Chart.yaml")); err != nil || !st.Mode().IsRegular() {
+				continue
+			}
+			seen[real] = true
+			out = append(out, sub)
+			if err := walk(sub); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(chartDir); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// requireIndexDependencies returns ErrNothingFound unless chartPath has a dependency from an
+// HTTP(S) or file:// repository index.
+func requireIndexDependencies(chartPath string) error {
+	meta, err := chartutil.LoadChartfile(chartPath)
+	if err != nil {
+		return err
+	}
+	for _, dep := range meta.Dependencies {
+		if dep != nil && helmdeps.IsIndexRepo(strings.TrimSpace(dep.Repository), filepath.Dir(chartPath)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s has no dependencies from HTTP(S) or file:// repository indexes", ErrNothingFound, chartPath)
+}
+
+// updateLockfile stages a Chart.lock for chartDir matching the dependencies in chartYAML. A
+// missing lockfile is created only if create is set.
+func updateLockfile(ctx context.Context, chartDir string, chartYAML []byte, create bool, stage func(string, []byte) error) error {
+	log := logutil.FromContext(ctx).With(zap.String("func", "updateLockfile"), zap.String("chartDir", chartDir))
+	lockPath, err := filepath.Abs(filepath.Join(chartDir, helmdeps.LockFileName))
+	if err != nil {
+		return err
+	}
+	existing, err := os.ReadFile(lockPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		if !create {
+			log.Debug("no lockfile to update")
+			return nil
+		}
+		existing = nil
+	} else if err != nil {
+		return err
+	}
+	b, changed, err := helmdeps.UpdateLock(chartYAML, existing, time.Now())
+	if err != nil {
+		return err
+	}
+	if !changed {
+		log.Debug("lockfile already in sync")
+		return nil
+	}
+	log.Debug("updating lockfile", zap.String("path", lockPath), zap.Bool("created", existing == nil))
+	return stage(lockPath, b)
+}
+
+// updateParentDependency returns parentDir/Chart.yaml with dependencies[].version set to
+// version for every dependency named name, or nil if they already match.
+func updateParentDependency(ctx context.Context, parentDir, name, version string) ([]byte, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "updateParentDependency"), zap.String("parentDir", parentDir), zap.String("name", name), zap.String("version", version))
+	parentPath := filepath.Join(parentDir, "Chart.yaml")
+	b, err := os.ReadFile(parentPath)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := chartutil.LoadChartfile(parentPath)
+	if err != nil {
+		return nil, err
+	}
+	ast, err := yamlutil.ParseBytes(b)
+	if err != nil {
+		return nil, err
+	}
+
+	found, changed := false, false
+	for i, dep := range meta.Dependencies {
+		if dep == nil || dep.Name != name {
+			continue
+		}
+		found = true
+		c, err := yamlutil.SetString(ast, fmt.Sprintf("$.dependencies[%d].version", i), version)
+		if err != nil {
+			return nil, fmt.Errorf("%s dependency %q: %w", parentPath, name, err)
+		}
+		changed = changed || c
+	}
+	if !found {
+		return nil, fmt.Errorf("%s has no dependency named %q", parentPath, name)
+	}
+	if !changed {
+		log.Debug("parent dependency already up to date")
+		return nil, nil
+	}
+
+	out, err := yamlutil.Render(ast)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("updating parent dependency version", zap.String("path", parentPath))
+	return []byte(out), nil
+}
@@ -0,0 +1,34 @@
+package bumper
+
+import (
+	"errors"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/directives"
+	"github.com/joejulian/helm-chart-bumper-action/internal/helmdeps"
+	"github.com/joejulian/helm-chart-bumper-action/internal/imageresolver"
+)
+
+// Errors returned by Run can be classified with errors.Is against these sentinels.
+var (
+	// ErrMalformedDirective means a '# bump:' directive or directive config entry is invalid.
+	ErrMalformedDirective = directives.ErrMalformed
+	// ErrRegistryUnavailable means a container registry could not be reached or failed.
+	ErrRegistryUnavailable = imageresolver.ErrRegistryUnavailable
+	// ErrIndexUnavailable means a Helm repository index could not be downloaded.
+	ErrIndexUnavailable = helmdeps.ErrIndexUnavailable
+	// ErrBaseURLUnavailable means Config.BaseURL could not be reached or answered with a
+	// server error.
+	ErrBaseURLUnavailable = errors.New("base chart URL unavailable")
+	// ErrBumpExceedsMax means the detected change exceeds Config.MaxBump and
+	// Config.FailOnExceedingMax is set.
+	ErrBumpExceedsMax = errors.New("chart version bump exceeds the maximum")
+	// ErrNothingFound means Config.RequireDirectives is set but there were no '# bump:'
+	// directives (with UpdateImages) or no HTTP(S) dependencies (with UpdateDeps) to update.
+	ErrNothingFound = errors.New("nothing to update")
+)
+
+// IsTransient reports whether err was caused by an unavailable registry, Helm repository, or
+// base chart URL, so that retrying the run later may succeed.
+func IsTransient(err error) bool {
+	return errors.Is(err, ErrRegistryUnavailable) || errors.Is(err, ErrIndexUnavailable) || errors.Is(err, ErrBaseURLUnavailable)
+}
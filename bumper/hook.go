@@ -0,0 +1,76 @@
+package bumper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+
+	"go.uber.org/zap"
+)
+
+// splitCommand splits a hook command line into arguments at unquoted whitespace. Single and
+// double quotes group words and are removed; there is no other shell syntax, so characters
+// like ; | $ and * are passed through literally.
+func splitCommand(s string) ([]string, error) {
+	var (
+		args  []string
+		cur   strings.Builder
+		inArg bool
+		quote rune
+	)
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, s)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return args, nil
+}
+
+// runPostHook runs command, with {chartDir} in each argument replaced by chartDir, and streams
+// its output to stderr. The substitution happens after splitting, so a chart directory with
+// spaces or shell metacharacters stays a single argument.
+func runPostHook(ctx context.Context, command, chartDir string) error {
+	log := logutil.FromContext(ctx).With(zap.String("func", "bumper.runPostHook"))
+	args, err := splitCommand(command)
+	if err != nil {
+		return err
+	}
+	for i, a := range args {
+		args[i] = strings.ReplaceAll(a, "{chartDir}", chartDir)
+	}
+	log.Info("running post-write hook", zap.Strings("args", args))
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	return nil
+}
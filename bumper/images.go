@@ -0,0 +1,686 @@
+package bumper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/chart"
+	"github.com/joejulian/helm-chart-bumper-action/internal/directives"
+	"github.com/joejulian/helm-chart-bumper-action/internal/imageresolver"
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+	"github.com/joejulian/helm-chart-bumper-action/internal/yamlutil"
+
+	"go.uber.org/zap"
+)
+
+// Resolver resolves image tags, digests, and labels with a run's registry access and caches;
+// see Config.Resolver.
+type Resolver = imageresolver.Resolver
+
+// NewResolver builds the Resolver a run with cfg uses for image directives from cfg's registry
+// settings: Keychain, GitHubTokenFile, the digest and registry caches, MaxTagPages,
+// RegistryRPS, RegistryMirrors, IgnoreTagsFile, and TagSources. Share it across runs with
+// Config.Resolver to share its caches and rate limit.
+func NewResolver(cfg Config) (*Resolver, error) {
+	keychain := cfg.Keychain
+	if keychain == nil {
+		keychain = imageresolver.NewKeychain(nil, cfg.GitHubTokenFile)
+	}
+	ttl := cfg.DigestCacheTTL
+	if ttl == 0 {
+		ttl = 5 * time.Minute
+	}
+	cache := imageresolver.NewDigestCache(ttl)
+	if cfg.DigestCacheFile != "" {
+		c, err := imageresolver.LoadDigestCache(cfg.DigestCacheFile, ttl)
+		if err != nil {
+			return nil, err
+		}
+		cache = c
+	}
+	opts := imageresolver.Options{
+		Keychain:    keychain,
+		DigestCache: cache,
+		TagCache:    imageresolver.NewTagListCache(),
+		MaxTagPages: cfg.MaxTagPages,
+		RateLimiter: imageresolver.NewRateLimiter(cfg.RegistryRPS, 1),
+		Mirrors:     cfg.RegistryMirrors,
+		Sources: map[string]imageresolver.TagResolver{
+			imageresolver.SourceGitHubReleases: &imageresolver.GitHubReleases{MaxPages: cfg.MaxTagPages, TokenFile: cfg.GitHubTokenFile},
+		},
+	}
+	maps.Copy(opts.Sources, cfg.TagSources)
+	if cfg.RegistryCacheDir != "" {
+		t, err := imageresolver.NewHTTPCache(cfg.RegistryCacheDir, nil)
+		if err != nil {
+			return nil, err
+		}
+		opts.Transport = t
+	}
+	if cfg.IgnoreTagsFile != "" {
+		l, err := imageresolver.LoadIgnoreList(cfg.IgnoreTagsFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.IgnoreTags = l
+	}
+	return imageresolver.NewResolver(opts), nil
+}
+
+// imageUpdateOptions carries per-run settings for image directive processing.
+type imageUpdateOptions struct {
+	resolver        *Resolver
+	propagateGlobal bool
+	// warnGroupMismatch logs, rather than fails on, group= members resolving differently.
+	warnGroupMismatch bool
+	// keepOnFailure retains a directive's current value when it fails to resolve. Each such
+	// directive is appended to kept, if set.
+	keepOnFailure bool
+	kept          *[]KeptValue
+	// changes, if set, receives each value written.
+	changes *[]ImageChange
+	// repinMovedTags lets strategy=pinned-ref replace a pinned digest when its tag now
+	// resolves elsewhere, instead of failing.
+	repinMovedTags bool
+	// configPath is a directive config file. If empty, directives.ConfigFileName in the chart
+	// directory is used when present.
+	configPath string
+	// concurrency bounds how many directives are resolved at once.
+	concurrency int
+	// requireDirectives fails with ErrNothingFound when the scanned files hold no directives.
+	requireDirectives bool
+	// allowDowngrade lets a selected tag replace a higher current version.
+	allowDowngrade bool
+	// onlyPaths, if set, limits the directives applied to those whose YAML path is under one
+	// of these prefixes; skipPaths excludes directives under its prefixes. Filtered
+	// directives are still scanned.
+	onlyPaths []string
+	skipPaths []string
+	// extraFiles are scanned in addition to the scan glob matches, wherever they are.
+	extraFiles []string
+	// recordDigests resolves the digest of every selected tag for ImageChange.Digest.
+	recordDigests bool
+	// defaultPlatform is the platform of directives without platform=.
+	defaultPlatform string
+	// pins maps an image repository to the tag its tag-selecting directives are set to.
+	pins map[string]string
+}
+
+// containsFile reports whether files already holds p, perhaps under a different but
+// equivalent path.
+func containsFile(files map[string]struct{}, p string) bool {
+	abs := absOrSelf(p)
+	for f := range files {
+		if absOrSelf(f) == abs {
+			return true
+		}
+	}
+	return false
+}
+
+// appliesTo reports whether the path filters allow applying a directive targeting yamlPath.
+func (o imageUpdateOptions) appliesTo(yamlPath string) bool {
+	if len(o.onlyPaths) > 0 && !hasPathPrefix(yamlPath, o.onlyPaths) {
+		return false
+	}
+	return !hasPathPrefix(yamlPath, o.skipPaths)
+}
+
+// hasPathPrefix reports whether yamlPath is, or is nested under, one of prefixes. Prefixes
+// match whole path elements: $.image matches $.image.tag and $.image[0], not $.images.
+func hasPathPrefix(yamlPath string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if !strings.HasPrefix(p, "$") {
+			p = "$." + p
+		}
+		rest, ok := strings.CutPrefix(yamlPath, p)
+		if ok && (rest == "" || rest[0] == '.' || rest[0] == '[') {
+			return true
+		}
+	}
+	return false
+}
+
+// KeptValue records a directive whose current value was kept because resolution failed.
+type KeptValue struct {
+	File     string
+	Line     int
+	YAMLPath string
+	Value    string
+	Err      error
+}
+
+// ImageChange records a value updated by an image directive.
+type ImageChange struct {
+	File     string
+	Line     int
+	YAMLPath string
+	Image    string
+	Old      string
+	New      string
+	// Digest is the manifest digest of the image at the selected tag, when it was resolved:
+	// for digest-pinning strategies, writeTransform templates using .Digest, and with
+	// Config.RecordDigests.
+	Digest string
+}
+
+// updateImagesInChartDir scans files for '# bump:' directives, resolves the new values, and
+// applies them, returning the updated bytes of each changed file keyed by absolute path. It
+// never writes to disk; the caller stages the result.
+func updateImagesInChartDir(ctx context.Context, chartDir, globCSV string, opts imageUpdateOptions) (map[string][]byte, bool, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "updateImagesInChartDir"), zap.String("chartDir", chartDir), zap.String("scanGlob", globCSV))
+	globs := SplitCSV(globCSV)
+	log.Debug("expanded scan globs", zap.Strings("globs", globs))
+
+	files := map[string]struct{}{}
+	var excludes []string
+	for _, g := range globs {
+		if ex, ok := strings.CutPrefix(g, "!"); ok {
+			excludes = append(excludes, filepath.Join(chartDir, ex))
+			continue
+		}
+		pattern := filepath.Join(chartDir, g)
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, false, err
+		}
+		log.Debug("glob matches", zap.String("pattern", pattern), zap.Int("matches", len(matches)))
+		for _, m := range matches {
+			// Only regular files.
+			st, err := os.Stat(m)
+			if err != nil {
+				return nil, false, err
+			}
+			if st.Mode().IsRegular() {
+				files[m] = struct{}{}
+			}
+		}
+	}
+
+	// Exclusions ('!' globs) apply to the combined include set, regardless of order.
+	for m := range files {
+		for _, ex := range excludes {
+			if ok, err := filepath.Match(ex, m); err != nil {
+				return nil, false, err
+			} else if ok {
+				log.Debug("excluded by scan glob", zap.String("file", m), zap.String("pattern", ex))
+				delete(files, m)
+				break
+			}
+		}
+	}
+
+	// Extra files are not subject to the scan glob or its exclusions.
+	for _, p := range opts.extraFiles {
+		st, err := os.Stat(p)
+		if err != nil {
+			return nil, false, err
+		}
+		if !st.Mode().IsRegular() {
+			return nil, false, fmt.Errorf("%s is not a regular file", p)
+		}
+		if !containsFile(files, p) {
+			files[p] = struct{}{}
+		}
+	}
+
+	configDirs, err := loadDirectiveConfig(chartDir, opts.configPath)
+	if err != nil {
+		return nil, false, err
+	}
+	byFile := map[string][]directives.ImageDirective{}
+	for _, d := range configDirs {
+		byFile[d.FilePath] = append(byFile[d.FilePath], d)
+		files[d.FilePath] = struct{}{}
+	}
+	log.Debug("loaded directive config", zap.Int("directives", len(configDirs)))
+
+	updated := map[string][]byte{}
+	anyChanged := false
+	// appVersion is synced after all files are processed so Chart.yaml edits from its own
+	// directives are not lost; the change-level computation then sees the synced value.
+	syncAppVersion := ""
+	groups := map[string][]groupMember{}
+	paths := make([]string, 0, len(files))
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var docs []*imageFile
+	for _, p := range paths {
+		fileLog := log.With(zap.String("file", p))
+		template := isTemplatePath(chartDir, p)
+		scan := directives.ScanFileForImageDirectives
+		if template {
+			scan = directives.ScanTemplateForImageDirectives
+			if len(byFile[p]) > 0 {
+				return nil, false, fmt.Errorf("%s: directive config entries cannot target templates; use inline directives", p)
+			}
+		}
+		dirs, err := scan(ctx, p)
+		if err != nil {
+			return nil, false, err
+		}
+		dirs = directives.MergeDirectives(dirs, byFile[p])
+		for i := range dirs {
+			if dirs[i].Platform == "" {
+				dirs[i].Platform = opts.defaultPlatform
+			}
+		}
+		fileLog.Debug("scanned for bump directives", zap.Int("directives", len(dirs)))
+		if len(dirs) == 0 {
+			continue
+		}
+
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, false, err
+		}
+		doc := &imageFile{path: p, orig: b, log: fileLog, dirs: dirs}
+		if template {
+			// Templates are edited line by line; they do not parse as YAML.
+			doc.lines = splitLinesAfter(string(b))
+		} else {
+			if doc.ast, err = yamlutil.ParseBytes(b); err != nil {
+				return nil, false, err
+			}
+			// A path= JSON pointer is resolved against this document once, so later path
+			// handling only sees the $.key syntax.
+			for i := range doc.dirs {
+				if doc.dirs[i].YAMLPath, err = yamlutil.NormalizePath(doc.ast, doc.dirs[i].YAMLPath); err != nil {
+					return nil, false, fmt.Errorf("%s:%d: %w", p, doc.dirs[i].Line, err)
+				}
+			}
+			if err := checkDirectivePaths(doc); err != nil {
+				return nil, false, err
+			}
+		}
+		for _, d := range dirs {
+			logutil.Event(ctx, logutil.EventDirectiveDiscovered,
+				zap.String("file", p),
+				zap.Int("line", d.Line),
+				zap.String("yamlPath", d.YAMLPath),
+				zap.String("image", d.Image),
+				zap.String("strategy", d.Strategy),
+			)
+		}
+		docs = append(docs, doc)
+	}
+
+	if len(docs) == 0 && !opts.requireDirectives {
+		log.Info("no bump directives found", zap.Int("scannedFiles", len(paths)))
+	}
+	if len(docs) == 0 && opts.requireDirectives {
+		scanned := make([]string, 0, len(paths))
+		for _, p := range paths {
+			if rel, err := filepath.Rel(chartDir, p); err == nil {
+				p = rel
+			}
+			scanned = append(scanned, p)
+		}
+		if len(scanned) == 0 {
+			return nil, false, fmt.Errorf("%w: no files in %s match scan glob %q", ErrNothingFound, chartDir, globCSV)
+		}
+		return nil, false, fmt.Errorf("%w: no bump directives in %s (scanned %s)", ErrNothingFound, chartDir, strings.Join(scanned, ", "))
+	}
+
+	// upToDate and held count the directives that kept their value because it is current, or
+	// because resolution failed or would have downgraded it.
+	var upToDate, held int
+	// apply writes one resolved directive into its document. It runs serially, in file and line
+	// order, so results do not depend on which registry answered first.
+	apply := func(j *imageJob) error {
+		d, p, dLog := j.d, j.doc.path, j.log
+		if j.err != nil {
+			return fmt.Errorf("%s:%d: %w", p, d.Line, j.err)
+		}
+		if j.resolveErr != nil {
+			// A run that timed out or was canceled fails rather than keeping every value.
+			if !opts.keepOnFailure || ctx.Err() != nil {
+				return fmt.Errorf("%s:%d: %w", p, d.Line, j.resolveErr)
+			}
+			held++
+			dLog.Warn("resolution failed; keeping current value", zap.String("current", j.oldValue), zap.Error(j.resolveErr))
+			if opts.kept != nil {
+				*opts.kept = append(*opts.kept, KeptValue{File: p, Line: d.Line, YAMLPath: d.YAMLPath, Value: j.oldValue, Err: j.resolveErr})
+			}
+			return nil
+		}
+
+		if !opts.allowDowngrade && j.downgrades() {
+			held++
+			dLog.Warn("prevented downgrade; keeping current value (use --allow-downgrade to permit)", zap.String("current", j.oldValue), zap.String("resolved", j.newValue))
+			return nil
+		}
+
+		// sync and group= compare the resolved value; only the written scalar is transformed.
+		newValue, resolved := j.newValue, j.newValue
+		if j.refRepo != "" && d.WriteTransform == "" {
+			newValue = j.refRepo + ":" + newValue
+		}
+		if d.WriteTransform != "" {
+			vars := directives.WriteVars{Image: d.Image, Tag: j.tag, Digest: j.digest, Platform: d.Platform, Value: newValue}
+			var err error
+			if newValue, err = directives.RenderWriteTransform(d.WriteTransform, vars); err != nil {
+				return fmt.Errorf("%s:%d: %w", p, d.Line, err)
+			}
+			logutil.Detail(ctx, dLog, "applied write transform", zap.String("resolved", resolved), zap.String("transformed", newValue))
+		}
+
+		logutil.Detail(ctx, dLog, "resolved new value", zap.String("current", d.CurrentText), zap.String("new", newValue))
+		c, err := j.doc.set(d, newValue)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", p, d.Line, err)
+		}
+		j.doc.changed = j.doc.changed || c
+		if c && opts.changes != nil {
+			*opts.changes = append(*opts.changes, ImageChange{File: p, Line: d.Line, YAMLPath: d.YAMLPath, Image: d.Image, Old: j.oldValue, New: newValue, Digest: j.digest})
+		}
+		target := d.YAMLPath
+		if target == "" {
+			target = fmt.Sprintf("%s on line %d", d.Key, d.TargetLine)
+		}
+		if c {
+			// One line per change at info level, so CI logs say what changed without -v 6.
+			logutil.FromContext(ctx).Info(fmt.Sprintf("updated %s: %s -> %s (%s, %s)", target, j.oldValue, newValue, d.Image, j.strategy),
+				zap.String("file", p),
+				zap.String("yamlPath", d.YAMLPath),
+				zap.String("old", j.oldValue),
+				zap.String("new", newValue),
+				zap.String("image", d.Image),
+				zap.String("strategy", j.strategy),
+			)
+			logutil.Event(ctx, logutil.EventValueWritten,
+				zap.String("file", p),
+				zap.String("yamlPath", d.YAMLPath),
+				zap.String("old", j.oldValue),
+				zap.String("new", newValue),
+			)
+		} else {
+			// Also at info level, so CI logs show the directive was checked.
+			upToDate++
+			logutil.FromContext(ctx).Info(fmt.Sprintf("%s already up to date: %s (%s, %s)", target, newValue, d.Image, j.strategy),
+				zap.String("file", p),
+				zap.String("yamlPath", d.YAMLPath),
+				zap.String("value", newValue),
+				zap.String("image", d.Image),
+				zap.String("strategy", j.strategy),
+			)
+		}
+		if d.Sync == "appVersion" {
+			syncAppVersion = resolved
+		}
+		if d.Group != "" {
+			groups[d.Group] = append(groups[d.Group], groupMember{file: p, line: d.Line, image: d.Image, value: resolved})
+		}
+		if opts.propagateGlobal && c && j.doc.ast != nil {
+			subcharts, err := subchartKeys(chartDir, p)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", p, d.Line, err)
+			}
+			propagated, err := chart.PropagateGlobal(j.doc.ast, subcharts, d.Image, d.YAMLPath, j.oldValue, newValue)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", p, d.Line, err)
+			}
+			if len(propagated) > 0 {
+				dLog.Debug("propagated global value", zap.Strings("paths", propagated))
+			}
+		}
+		return nil
+	}
+
+	// strategy=digest and strategy=label read a sibling tag that another directive may update,
+	// so they are resolved in a second stage, after the first stage's values are applied.
+	for _, siblingStage := range []bool{false, true} {
+		var jobs []*imageJob
+		for _, doc := range docs {
+			for _, d := range doc.dirs {
+				if readsSiblingTag(d) != siblingStage {
+					continue
+				}
+				if !opts.appliesTo(d.YAMLPath) {
+					doc.log.Debug("directive filtered out by path", zap.Int("line", d.Line), zap.String("yamlPath", d.YAMLPath))
+					continue
+				}
+				j, err := prepareImageJob(doc, d)
+				if err != nil {
+					return nil, false, err
+				}
+				jobs = append(jobs, j)
+			}
+		}
+		resolveImageJobs(ctx, jobs, opts)
+		for _, j := range jobs {
+			if err := apply(j); err != nil {
+				return nil, false, err
+			}
+		}
+	}
+
+	for _, doc := range docs {
+		if !doc.changed {
+			doc.log.Debug("no changes to apply")
+			continue
+		}
+		outBytes, err := doc.render()
+		if err != nil {
+			return nil, false, err
+		}
+		if !bytes.Equal(doc.orig, outBytes) {
+			anyChanged = true
+			abs, err := filepath.Abs(doc.path)
+			if err != nil {
+				return nil, false, err
+			}
+			updated[abs] = outBytes
+		} else {
+			doc.log.Debug("rendered file identical; skipping write")
+		}
+	}
+
+	if err := checkGroups(groups); err != nil {
+		if !opts.warnGroupMismatch {
+			return nil, false, err
+		}
+		log.Warn("grouped directives resolved to different values", zap.Error(err))
+	}
+	if syncAppVersion != "" {
+		changed, err := syncChartAppVersion(ctx, chartDir, syncAppVersion, updated)
+		if err != nil {
+			return nil, false, err
+		}
+		anyChanged = anyChanged || changed
+	}
+	if !anyChanged && held == 0 && upToDate > 0 {
+		log.Info("all images up to date", zap.Int("directives", upToDate))
+	}
+	return updated, anyChanged, nil
+}
+
+// isTemplatePath reports whether p lies in chartDir's templates directory, where files are Helm
+// templates rather than YAML.
+func isTemplatePath(chartDir, p string) bool {
+	rel, err := filepath.Rel(chartDir, p)
+	if err != nil {
+		return false
+	}
+	first, _, _ := strings.Cut(filepath.ToSlash(rel), "/")
+	return first == "templates"
+}
+
+// subchartKeys returns the values keys of the subcharts of the chart that owns the values file
+// p: the chart beside p, or chartDir's chart for a values file outside any chart.
+func subchartKeys(chartDir, p string) ([]string, error) {
+	b, err := chart.ReadChartYAML(filepath.Dir(p))
+	if errors.Is(err, os.ErrNotExist) {
+		b, err = chart.ReadChartYAML(chartDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	m, err := chart.LoadMeta(b)
+	if err != nil {
+		return nil, err
+	}
+	return m.SubchartKeys(), nil
+}
+
+// loadDirectiveConfig reads the directive config at configPath, or the chart directory's
+// directives.ConfigFileName if configPath is empty and that file exists.
+func loadDirectiveConfig(chartDir, configPath string) ([]directives.ImageDirective, error) {
+	if configPath == "" {
+		configPath = filepath.Join(chartDir, directives.ConfigFileName)
+		if _, err := os.Stat(configPath); errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+	}
+	return directives.LoadConfig(configPath, chartDir)
+}
+
+// groupMember is one resolved directive that carries a group= tag.
+type groupMember struct {
+	file  string
+	line  int
+	image string
+	value string
+}
+
+// checkGroups reports every group whose members did not all resolve to the same value.
+func checkGroups(groups map[string][]groupMember) error {
+	names := make([]string, 0, len(groups))
+	for n := range groups {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var msgs []string
+	for _, n := range names {
+		members := groups[n]
+		consistent := true
+		for _, m := range members[1:] {
+			if m.value != members[0].value {
+				consistent = false
+				break
+			}
+		}
+		if consistent {
+			continue
+		}
+		parts := make([]string, 0, len(members))
+		for _, m := range members {
+			parts = append(parts, fmt.Sprintf("%s:%d %s=%s", m.file, m.line, m.image, m.value))
+		}
+		msgs = append(msgs, fmt.Sprintf("group %q resolved inconsistently: %s", n, strings.Join(parts, ", ")))
+	}
+	if len(msgs) > 0 {
+		return errors.New(strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// syncChartAppVersion sets Chart.yaml appVersion to v, starting from any in-memory update of
+// Chart.yaml in updated. The result is stored in updated.
+func syncChartAppVersion(ctx context.Context, chartDir, v string, updated map[string][]byte) (bool, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "syncChartAppVersion"), zap.String("appVersion", v))
+	chartPath := filepath.Join(chartDir, "Chart.yaml")
+	abs, err := filepath.Abs(chartPath)
+	if err != nil {
+		return false, err
+	}
+	b, ok := updated[abs]
+	if !ok {
+		b, err = os.ReadFile(chartPath)
+		if err != nil {
+			return false, err
+		}
+	}
+	ast, err := yamlutil.ParseBytes(b)
+	if err != nil {
+		return false, err
+	}
+	c, err := yamlutil.SetString(ast, "$.appVersion", v)
+	if err != nil {
+		return false, fmt.Errorf("%s: failed to sync appVersion: %w", chartPath, err)
+	}
+	if !c {
+		log.Debug("appVersion already in sync")
+		return false, nil
+	}
+	out, err := yamlutil.Render(ast)
+	if err != nil {
+		return false, err
+	}
+	outBytes := []byte(out)
+	if bytes.Equal(b, outBytes) {
+		return false, nil
+	}
+	log.Debug("synced appVersion", zap.String("path", chartPath))
+	updated[abs] = outBytes
+	return true, nil
+}
+
+// splitPinnedRef splits repo:tag@digest into its parts; missing parts are empty.
+func splitPinnedRef(ref string) (repo, tag, digest string) {
+	ref, digest, _ = strings.Cut(strings.TrimSpace(ref), "@")
+	tag, ok := tagFromImageRef(ref)
+	if !ok {
+		return ref, "", digest
+	}
+	return strings.TrimSuffix(ref, ":"+tag), tag, digest
+}
+
+// tagFromImageRef returns the tag of an image reference like ghcr.io/org/app:1.2.3 or
+// ghcr.io/org/app:1.2.3@sha256:..., ignoring any registry port.
+func tagFromImageRef(ref string) (string, bool) {
+	ref, _, _ = strings.Cut(strings.TrimSpace(ref), "@")
+	i := strings.LastIndex(ref, ":")
+	if i < 0 || i < strings.LastIndex(ref, "/") {
+		return "", false
+	}
+	return ref[i+1:], true
+}
+
+// SplitCSV splits a comma-separated flag value, trimming spaces and dropping empty entries.
+func SplitCSV(s string) []string {
+	parts := strings.Split(s, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// splitLinesAfter splits s after each line ending ("\n", "\r\n", or a lone "\r"), keeping the
+// endings, so lines match those the directive scanner numbers.
+func splitLinesAfter(s string) []string {
+	var out []string
+	for len(s) > 0 {
+		i := strings.IndexAny(s, "\r\n")
+		if i < 0 {
+			break
+		}
+		n := i + 1
+		if s[i] == '\r' && n < len(s) && s[n] == '\n' {
+			n++
+		}
+		out = append(out, s[:n])
+		s = s[n:]
+	}
+	if len(s) > 0 {
+		out = append(out, s)
+	}
+	return out
+}
@@ -0,0 +1,31 @@
+package bumper
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/imageref"
+)
+
+// ParsePin parses a Config.Pins entry written as "image=<repo> version=<tag>", e.g.
+// "image=ghcr.io/org/base version=1.2.3".
+func ParsePin(spec string) (image, version string, err error) {
+	for _, f := range strings.Fields(spec) {
+		k, v, ok := strings.Cut(f, "=")
+		switch {
+		case ok && k == "image" && image == "":
+			image = strings.TrimSuffix(v, "/")
+		case ok && k == "version" && version == "":
+			version = v
+		default:
+			return "", "", fmt.Errorf("invalid pin %q: unexpected %q, expected image=<repo> version=<tag>", spec, f)
+		}
+	}
+	if image == "" || version == "" {
+		return "", "", fmt.Errorf("invalid pin %q, expected image=<repo> version=<tag>", spec)
+	}
+	if err := imageref.ValidateRepository(image); err != nil {
+		return "", "", fmt.Errorf("invalid pin %q: %w", spec, err)
+	}
+	return image, version, nil
+}
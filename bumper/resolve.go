@@ -0,0 +1,409 @@
+package bumper
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/directives"
+	"github.com/joejulian/helm-chart-bumper-action/internal/imageresolver"
+	"github.com/joejulian/helm-chart-bumper-action/internal/yamlutil"
+
+	"github.com/Masterminds/semver/v3"
+	"go.uber.org/zap"
+)
+
+// TagResolver selects an image tag from an alternate version source; see Config.TagSources.
+type TagResolver = imageresolver.TagResolver
+
+// TagSpec is the tag selection a TagResolver is asked to make.
+type TagSpec = imageresolver.TagSpec
+
+// DefaultConcurrency is the number of directives resolved at once when Config.Concurrency is
+// unset.
+const DefaultConcurrency = 4
+
+// imageFile is a scanned file whose directives are being applied to its parsed document, or,
+// for a Helm template, to its lines.
+type imageFile struct {
+	path    string
+	orig    []byte
+	ast     *yamlutil.File
+	lines   []string
+	log     *zap.Logger
+	dirs    []directives.ImageDirective
+	changed bool
+}
+
+// get returns the current value targeted by d.
+func (f *imageFile) get(d directives.ImageDirective) string {
+	if d.TargetLine > 0 {
+		v, _ := yamlutil.LineValue(f.lines[d.TargetLine-1])
+		return v
+	}
+	v, _, _ := yamlutil.GetString(f.ast, d.YAMLPath)
+	return v
+}
+
+// checkDirectivePaths reads each inline directive's computed YAMLPath back from the parsed
+// document and confirms it holds the value the scanner saw on the line after the directive.
+// The scanner tracks paths by indentation, so this catches a wrong path before any registry
+// call is made.
+func checkDirectivePaths(f *imageFile) error {
+	for _, d := range f.dirs {
+		if d.CurrentText == "" {
+			continue
+		}
+		want, err := yamlutil.ScalarValue(d.CurrentText)
+		if err != nil {
+			return &directives.DirectiveError{Path: f.path, Line: d.Line, Err: err}
+		}
+		got, ok, err := yamlutil.GetString(f.ast, d.YAMLPath)
+		if err != nil {
+			return &directives.DirectiveError{Path: f.path, Line: d.Line, Err: fmt.Errorf("read %s: %w", d.YAMLPath, err)}
+		}
+		if !ok && want != "" {
+			return &directives.DirectiveError{Path: f.path, Line: d.Line, Err: fmt.Errorf("directive path %s does not exist in the document; the directive precedes %s: %s", d.YAMLPath, d.Key, d.CurrentText)}
+		}
+		if got != want {
+			return &directives.DirectiveError{Path: f.path, Line: d.Line, Err: fmt.Errorf("directive path %s resolved to a different value: the document has %q there, but the directive precedes %s: %s", d.YAMLPath, got, d.Key, d.CurrentText)}
+		}
+	}
+	return nil
+}
+
+// set writes v to the value targeted by d and reports whether it changed.
+func (f *imageFile) set(d directives.ImageDirective, v string) (bool, error) {
+	if d.TargetLine > 0 {
+		line, c, err := yamlutil.SetLineValue(f.lines[d.TargetLine-1], v)
+		if err != nil {
+			return false, fmt.Errorf("failed to set %s on line %d: %w", d.Key, d.TargetLine, err)
+		}
+		f.lines[d.TargetLine-1] = line
+		return c, nil
+	}
+	c, err := yamlutil.SetString(f.ast, d.YAMLPath, v)
+	if err != nil {
+		return false, fmt.Errorf("failed to set %s: %w", d.YAMLPath, err)
+	}
+	return c, nil
+}
+
+// render returns the document's current bytes.
+func (f *imageFile) render() ([]byte, error) {
+	if f.ast == nil {
+		return []byte(strings.Join(f.lines, "")), nil
+	}
+	out, err := yamlutil.Render(f.ast)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// imageJob is one directive's resolution. Inputs are read from the document before resolving;
+// outputs are filled in by resolve, which may run concurrently with other jobs and so must not
+// touch the document.
+type imageJob struct {
+	doc      *imageFile
+	d        directives.ImageDirective
+	log      *zap.Logger
+	strategy string
+	oldValue string
+	// refRepo is set when the target holds a full reference (repo:tag) for a tag-selecting
+	// strategy: the resolved tag is written back as refRepo:tag. oldTag is the current tag,
+	// from the reference or the value itself.
+	refRepo string
+	oldTag  string
+	tag     string
+	// tagInRef is set when format=digest-ref read tag from the targeted reference rather than
+	// a sibling key, so the written reference must keep it for the next run.
+	tagInRef bool
+	// pinned is set when a Config.Pins entry chose the tag instead of the strategy.
+	pinned bool
+
+	newValue string
+	// digest is the manifest digest of the selected tag, for writeTransform and
+	// ImageChange.Digest.
+	digest string
+	// resolveErr is a registry or selection failure, which keepOnFailure may tolerate; err is
+	// any other failure.
+	resolveErr error
+	err        error
+}
+
+// readsSiblingTag reports whether d reads a sibling 'tag' value.
+func readsSiblingTag(d directives.ImageDirective) bool {
+	switch strings.ToLower(d.Strategy) {
+	case "digest", "label":
+		return true
+	}
+	return false
+}
+
+// prepareImageJob reads the inputs for d from its document.
+func prepareImageJob(doc *imageFile, d directives.ImageDirective) (*imageJob, error) {
+	p := doc.path
+	strategy := strings.ToLower(d.Strategy)
+	if strategy == "" {
+		strategy = "semver"
+	}
+	oldValue := doc.get(d)
+	oldTag, refRepo := oldValue, ""
+	// A value holding a full reference names its repository, so image= may be inferred
+	// from it.
+	if repo, tag, digest := splitPinnedRef(oldValue); strings.Contains(repo, "/") {
+		if d.Image == "" {
+			d.Image = repo
+		}
+		switch strategy {
+		case "semver", "regex", "literal", "exact":
+			if tag == "" {
+				break
+			}
+			oldTag = tag
+			if d.WriteTransform != "" {
+				// The transform renders the written value itself.
+				break
+			}
+			if repo != d.Image {
+				return nil, fmt.Errorf("%s:%d: image=%s does not match repository %s in the current value %q", p, d.Line, d.Image, repo, oldValue)
+			}
+			if digest != "" {
+				return nil, fmt.Errorf("%s:%d: current value %q pins a digest; use strategy=pinned-ref to update it", p, d.Line, oldValue)
+			}
+			refRepo = repo
+		}
+	}
+	if d.Image == "" && d.Source == imageresolver.SourceOCI {
+		chartRepo, err := ociDependencyChart(doc, d)
+		if err != nil {
+			return nil, err
+		}
+		d.Image = chartRepo
+	}
+	// Full image path is required.
+	if d.Image == "" {
+		return nil, fmt.Errorf("%s:%d: bump directive missing required image=<full repo path>, and the current value is not a full image reference to infer it from", p, d.Line)
+	}
+	j := &imageJob{
+		doc:      doc,
+		d:        d,
+		strategy: strategy,
+		oldValue: oldValue,
+		oldTag:   oldTag,
+		refRepo:  refRepo,
+		log: doc.log.With(
+			zap.Int("line", d.Line),
+			zap.String("yamlPath", d.YAMLPath),
+			zap.String("image", d.Image),
+			zap.String("strategy", d.Strategy),
+			zap.String("constraint", d.Constraint),
+			zap.String("tagRegex", d.TagRegex),
+			zap.Bool("allowPrerelease", d.AllowPrerelease),
+			zap.Bool("preferStableOnGraduation", d.PreferStableOnGraduation),
+			zap.String("platform", d.Platform),
+			zap.String("label", d.Label),
+			zap.String("sync", d.Sync),
+			zap.String("selectExpr", d.SelectExpr),
+			zap.String("selectOrder", d.SelectOrder),
+			zap.Duration("minAge", d.MinAge),
+			zap.String("channel", d.Channel),
+			zap.String("suffix", d.Suffix),
+			zap.String("variant", d.Variant),
+			zap.String("format", d.Format),
+			zap.String("writeTransform", d.WriteTransform),
+			zap.String("value", d.Value),
+			zap.String("source", d.Source),
+		),
+	}
+
+	switch strategy {
+	case "digest", "label":
+		tagPath := yamlutil.ParentPath(d.YAMLPath) + ".tag"
+		var tag string
+		var ok bool
+		if doc.ast != nil {
+			tag, ok, _ = yamlutil.GetString(doc.ast, tagPath)
+		}
+		// format=digest-ref may take the tag from the targeted reference itself (repo:tag).
+		if (!ok || strings.TrimSpace(tag) == "") && strategy == "digest" && d.Format == "digest-ref" {
+			tag, ok = tagFromImageRef(j.oldValue)
+			j.tagInRef = ok
+		}
+		if (!ok || strings.TrimSpace(tag) == "") && doc.ast == nil {
+			return nil, fmt.Errorf("%s:%d: strategy=%s in a template cannot read a sibling 'tag' key; only strategy=digest with format=digest-ref is supported", p, d.Line, strategy)
+		}
+		if !ok || strings.TrimSpace(tag) == "" {
+			return nil, fmt.Errorf("%s:%d: strategy=%s requires a sibling 'tag' key (looked for %s)", p, d.Line, strategy, tagPath)
+		}
+		j.log.Debug("read sibling tag", zap.String("tagPath", tagPath), zap.String("tag", tag))
+		j.tag = tag
+	case "literal", "regex", "semver", "pinned-ref", "exact":
+	default:
+		return nil, fmt.Errorf("%s:%d: unknown strategy %q", p, d.Line, d.Strategy)
+	}
+	return j, nil
+}
+
+// reDependencyVersion matches the path of a Chart.yaml dependency's version.
+var reDependencyVersion = regexp.MustCompile(`^\$\.dependencies\[\d+\]\.version$`)
+
+// ociDependencyChart returns the OCI chart repository for a source=oci directive without
+// image=: the oci:// repository of the Chart.yaml dependency whose version d targets, joined
+// with the dependency's name.
+func ociDependencyChart(doc *imageFile, d directives.ImageDirective) (string, error) {
+	if doc.ast == nil || filepath.Base(doc.path) != "Chart.yaml" || !reDependencyVersion.MatchString(d.YAMLPath) {
+		return "", fmt.Errorf("%s:%d: source=oci without image= must be on a Chart.yaml dependency's version (targets %s)", doc.path, d.Line, d.YAMLPath)
+	}
+	dep := yamlutil.ParentPath(d.YAMLPath)
+	repo, _, _ := yamlutil.GetString(doc.ast, dep+".repository")
+	name, _, _ := yamlutil.GetString(doc.ast, dep+".name")
+	if !strings.HasPrefix(repo, "oci://") || name == "" {
+		return "", fmt.Errorf("%s:%d: source=oci without image= requires a named dependency with an oci:// repository; got name %q, repository %q", doc.path, d.Line, name, repo)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(repo, "oci://"), "/") + "/" + name, nil
+}
+
+// resolveImageJobs resolves jobs with at most opts.concurrency in flight.
+func resolveImageJobs(ctx context.Context, jobs []*imageJob, opts imageUpdateOptions) {
+	n := opts.concurrency
+	if n < 1 {
+		n = 1
+	}
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	for _, j := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(j *imageJob) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			j.resolve(ctx, opts)
+		}(j)
+	}
+	wg.Wait()
+}
+
+// tagSpec is the tag selection for the directive under strategy, starting from current.
+func (j *imageJob) tagSpec(strategy, current string) imageresolver.TagSpec {
+	d := j.d
+	return imageresolver.TagSpec{
+		Image:                    d.Image,
+		Strategy:                 strategy,
+		Constraint:               d.Constraint,
+		TagRegex:                 d.TagRegex,
+		AllowPrerelease:          d.AllowPrerelease,
+		CurrentTag:               current,
+		PreferStableOnGraduation: d.PreferStableOnGraduation,
+		SelectExpr:               d.SelectExpr,
+		SelectOrder:              d.SelectOrder,
+		MinAge:                   d.MinAge,
+		Channel:                  d.Channel,
+		Suffix:                   d.Suffix,
+		Variant:                  d.Variant,
+		Repo:                     d.Repo,
+	}
+}
+
+// resolve looks up the directive's new value in its registry, or the directive's source=.
+func (j *imageJob) resolve(ctx context.Context, opts imageUpdateOptions) {
+	d, dLog := j.d, j.log
+	strategy := j.strategy
+	if pin, ok := opts.pins[d.Image]; ok && !readsSiblingTag(d) {
+		// A pin replaces how the tag is chosen; strategy=pinned-ref still pins it by digest.
+		dLog.Debug("pinning tag", zap.String("version", pin))
+		j.pinned, d.Value = true, pin
+		if strategy != "pinned-ref" {
+			strategy = "exact"
+		}
+	}
+	switch strategy {
+	case "digest":
+		dLog.Debug("resolving digest from tag", zap.String("tag", j.tag))
+		j.newValue, j.resolveErr = opts.resolver.ResolveDigest(ctx, d.Image, j.tag, d.Platform)
+		j.digest = j.newValue
+		if j.resolveErr == nil && d.Format == "digest-ref" {
+			if j.tagInRef {
+				j.newValue = d.Image + ":" + j.tag + "@" + j.newValue
+			} else {
+				j.newValue = d.Image + "@" + j.newValue
+			}
+		}
+		return
+	case "label":
+		// Read an image config label for the sibling tag.
+		dLog.Debug("resolving label from tag", zap.String("tag", j.tag))
+		j.newValue, j.resolveErr = opts.resolver.ResolveLabel(ctx, d.Image, j.tag, d.Label, d.Platform)
+	case "literal", "regex", "semver":
+		dLog.Debug("resolving tag")
+		spec := j.tagSpec(j.strategy, j.oldTag)
+		if opts.recordDigests || directives.UsesDigest(d.WriteTransform) {
+			j.newValue, j.digest, j.resolveErr = opts.resolver.ResolveTagAndDigest(ctx, d.Source, spec, d.Platform)
+		} else {
+			j.newValue, j.resolveErr = opts.resolver.ResolveTagFrom(ctx, d.Source, spec)
+		}
+		j.tag = j.newValue
+	case "exact":
+		dLog.Debug("verifying exact tag", zap.String("value", d.Value))
+		j.newValue, j.resolveErr = opts.resolver.ResolveExactTag(ctx, d.Image, d.Value)
+		j.tag = j.newValue
+	case "pinned-ref":
+		// Select a tag as strategy=semver does, then pin it by digest: image:tag@sha256:...
+		dLog.Debug("resolving pinned reference")
+		_, curTag, curDigest := splitPinnedRef(j.oldValue)
+		if j.pinned {
+			j.tag, j.resolveErr = opts.resolver.ResolveExactTag(ctx, d.Image, d.Value)
+		} else {
+			j.tag, j.resolveErr = opts.resolver.ResolveTagFrom(ctx, d.Source, j.tagSpec("semver", curTag))
+		}
+		if j.resolveErr != nil {
+			return
+		}
+		if j.digest, j.resolveErr = opts.resolver.ResolveDigest(ctx, d.Image, j.tag, d.Platform); j.resolveErr != nil {
+			return
+		}
+		if j.tag == curTag && curDigest != "" && j.digest != curDigest {
+			if !opts.repinMovedTags {
+				j.err = fmt.Errorf("tag %s:%s moved from %s to %s (use --repin-moved-tags to re-pin)", d.Image, j.tag, curDigest, j.digest)
+				return
+			}
+			dLog.Warn("tag moved; re-pinning", zap.String("tag", j.tag), zap.String("pinned", curDigest), zap.String("current", j.digest))
+		}
+		j.newValue = d.Image + ":" + j.tag + "@" + j.digest
+		return
+	}
+	if j.resolveErr == nil && j.digest == "" && (opts.recordDigests || directives.UsesDigest(d.WriteTransform)) {
+		j.digest, j.resolveErr = opts.resolver.ResolveDigest(ctx, d.Image, j.tag, d.Platform)
+	}
+}
+
+// downgrades reports whether the tag selected for a version-choosing strategy is a lower
+// semver than the current one. Tags that are not versions, and pinned tags, never count as
+// downgrades.
+func (j *imageJob) downgrades() bool {
+	if j.pinned {
+		return false
+	}
+	cur, next := j.oldTag, j.newValue
+	switch j.strategy {
+	case "semver", "regex", "literal":
+	case "pinned-ref":
+		_, cur, _ = splitPinnedRef(j.oldValue)
+		next = j.tag
+	default:
+		return false
+	}
+	c, err := semver.NewVersion(strings.TrimSpace(cur))
+	if err != nil {
+		return false
+	}
+	n, err := semver.NewVersion(strings.TrimSpace(next))
+	if err != nil {
+		return false
+	}
+	return n.LessThan(c)
+}
@@ -0,0 +1,227 @@
+package bumper
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/chart"
+	"github.com/joejulian/helm-chart-bumper-action/internal/helmdeps"
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+
+	"go.uber.org/zap"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/ignore"
+)
+
+// passOptions selects the update steps verifyIdempotent re-runs. Nil images or deps skip
+// that step.
+type passOptions struct {
+	scanGlob    string
+	images      *imageUpdateOptions
+	deps        *helmdeps.Options
+	rewriteRepo bool
+	bump        bumpOptions
+}
+
+// verifyIdempotent treats a run's output as committed and runs the pipeline again over it in
+// memory: chartYAML is the rendered Chart.yaml and updated holds other changed files keyed by
+// absolute path (files already written to disk need not be included). It returns an error
+// describing the first instability if the second pass changes anything.
+func verifyIdempotent(ctx context.Context, chartDir, chartYAML string, updated map[string][]byte, opts passOptions) error {
+	log := logutil.FromContext(ctx).With(zap.String("func", "verifyIdempotent"), zap.String("chartDir", chartDir))
+	dir, err := os.MkdirTemp("", "helm-chart-bumper-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// Snapshot the first pass's view of the whole chart tree, including templates/ and
+	// charts/*, which --scan-glob may reach.
+	absChartDir, err := filepath.Abs(chartDir)
+	if err != nil {
+		return err
+	}
+	copied := map[string]bool{}
+	err = filepath.WalkDir(absChartDir, func(src string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(absChartDir, src)
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, rel), 0o700)
+		}
+		if !e.Type().IsRegular() {
+			return nil
+		}
+		b, ok := updated[src]
+		if !ok {
+			if b, err = os.ReadFile(src); err != nil {
+				return err
+			}
+		}
+		copied[src] = true
+		return os.WriteFile(filepath.Join(dir, rel), b, 0o600)
+	})
+	if err != nil {
+		return err
+	}
+	// Updated files need not exist on disk yet.
+	for src, b := range updated {
+		rel, err := filepath.Rel(absChartDir, src)
+		if err != nil || copied[src] || !filepath.IsLocal(rel) {
+			continue
+		}
+		dst := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, b, 0o600); err != nil {
+			return err
+		}
+	}
+	chartPath := filepath.Join(dir, "Chart.yaml")
+	if err := os.WriteFile(chartPath, []byte(chartYAML), 0o600); err != nil {
+		return err
+	}
+
+	if opts.images != nil {
+		images := *opts.images
+		// Extra values files live outside the chart directory; snapshot them alongside it.
+		images.extraFiles = nil
+		for i, src := range opts.images.extraFiles {
+			b, ok := updated[src]
+			if !ok {
+				if b, err = os.ReadFile(src); err != nil {
+					return err
+				}
+			}
+			dst := filepath.Join(dir, ".values-files", strconv.Itoa(i), filepath.Base(src))
+			if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+				return err
+			}
+			if err := os.WriteFile(dst, b, 0o600); err != nil {
+				return err
+			}
+			images.extraFiles = append(images.extraFiles, dst)
+		}
+		f, changed, err := updateImagesInChartDir(ctx, dir, opts.scanGlob, images)
+		if err != nil {
+			return fmt.Errorf("second image pass: %w", err)
+		}
+		if changed {
+			return fmt.Errorf("second image pass changed %s", strings.Join(relKeys(dir, f), ", "))
+		}
+	}
+	if opts.deps != nil {
+		_, changed, err := updateDepsInChartYAML(ctx, dir, nil, opts.deps, opts.rewriteRepo, nil)
+		if err != nil {
+			return fmt.Errorf("second dependency pass: %w", err)
+		}
+		if changed {
+			return fmt.Errorf("second dependency pass changed Chart.yaml")
+		}
+	}
+
+	base, err := chart.LoadMeta([]byte(chartYAML))
+	if err != nil {
+		return err
+	}
+	_, out, changed, err := bumpChartYAML(ctx, base, []byte(chartYAML), opts.bump)
+	if err != nil {
+		return fmt.Errorf("second bump pass: %w", err)
+	}
+	if changed {
+		return fmt.Errorf("second bump pass changed the chart version")
+	}
+	if out != chartYAML {
+		return fmt.Errorf("re-rendering Chart.yaml is not stable:\n--- first\n%s--- second\n%s", chartYAML, out)
+	}
+	log.Debug("second pass produced no changes")
+	return nil
+}
+
+// relKeys returns the keys of files relative to dir, sorted.
+func relKeys(dir string, files map[string][]byte) []string {
+	out := make([]string, 0, len(files))
+	for k := range files {
+		if r, err := filepath.Rel(dir, k); err == nil {
+			k = r
+		}
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// validateChart loads the chart in chartDir with Helm, as helm lint or helm package would,
+// with the files in updated (keyed by absolute path) in place of their contents on disk. It
+// returns Helm's error if the chart no longer parses, e.g. because a directive wrote a value
+// Chart.yaml does not allow.
+func validateChart(chartDir string, updated map[string][]byte) error {
+	absChartDir, err := filepath.Abs(chartDir)
+	if err != nil {
+		return err
+	}
+	rules := ignore.Empty()
+	if b, err := os.ReadFile(filepath.Join(absChartDir, ignore.HelmIgnore)); err == nil {
+		if rules, err = ignore.Parse(bytes.NewReader(b)); err != nil {
+			return fmt.Errorf("parse %s: %w", ignore.HelmIgnore, err)
+		}
+	}
+	rules.AddDefaults()
+
+	files := map[string][]byte{}
+	err = filepath.Walk(absChartDir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		n, err := filepath.Rel(absChartDir, p)
+		if err != nil || n == "." {
+			return err
+		}
+		n = filepath.ToSlash(n)
+		if rules.Ignore(n, fi) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		files[n] = b
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Updated files may not exist on disk yet (without Write, or a new Chart.lock).
+	for p, b := range updated {
+		if n, err := filepath.Rel(absChartDir, p); err == nil && !strings.HasPrefix(n, "..") {
+			files[filepath.ToSlash(n)] = b
+		}
+	}
+
+	buffered := make([]*loader.BufferedFile, 0, len(files))
+	for _, n := range slices.Sorted(maps.Keys(files)) {
+		buffered = append(buffered, &loader.BufferedFile{Name: n, Data: files[n]})
+	}
+	_, err = loader.LoadFiles(buffered)
+	return err
+}
@@ -1,527 +1,592 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/joejulian/helm-chart-bumper-action/internal/chart"
-	"github.com/joejulian/helm-chart-bumper-action/internal/directives"
-	"github.com/joejulian/helm-chart-bumper-action/internal/gitutil"
+	"github.com/joejulian/helm-chart-bumper-action/bumper"
 	"github.com/joejulian/helm-chart-bumper-action/internal/helmdeps"
 	"github.com/joejulian/helm-chart-bumper-action/internal/imageresolver"
 	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
-	"github.com/joejulian/helm-chart-bumper-action/internal/yamlutil"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
+// Exit codes distinguish failures worth retrying from ones that need a fix.
+const (
+	// exitUserError covers invalid flags, malformed directives, and other failures that will
+	// recur until the input changes.
+	exitUserError = 2
+	// exitTransient means a registry, Helm repository, or base chart URL was unavailable.
+	exitTransient = 3
+	// exitNothingFound means --require-directives found no directives or dependencies.
+	exitNothingFound = 4
+	// exitTimeout means --timeout expired before the run finished.
+	exitTimeout = 5
+)
+
 func main() {
 	var (
-		basePath    = flag.String("base", "", "Path to base Chart.yaml")
-		baseRef     = flag.String("base-ref", "", "Git ref to read the base Chart.yaml from (e.g. 'refs/remotes/origin/main' or 'HEAD~1')")
-		baseRefPath = flag.String("base-ref-path", "", "Repository-relative path to base Chart.yaml when using --base-ref (defaults to --cur)")
-		repoRoot    = flag.String("repo", ".", "Path to the git working tree (used with --base-ref)")
-		curPath     = flag.String("cur", "", "Path to current Chart.yaml")
-		write       = flag.Bool("write", false, "Write updated files back to disk")
+		basePath       = flag.String("base", "", "Path to base Chart.yaml")
+		baseRef        = flag.String("base-ref", "", "Git ref to read the base Chart.yaml from (e.g. 'refs/remotes/origin/main', 'HEAD~1', a full commit SHA, or a range 'A..B', which uses A)")
+		baseMerge      = flag.String("base-merge-base", "", "Read the base Chart.yaml from the merge-base of HEAD and this branch (e.g. 'origin/main')")
+		baseRefPath    = flag.String("base-ref-path", "", "Repository-relative path to base Chart.yaml when using --base-ref or --base-merge-base (defaults to --cur)")
+		baseOCI        = flag.String("base-oci", "", "OCI chart reference to read the base Chart.yaml from (e.g. 'oci://ghcr.io/org/charts/app:1.2.3')")
+		baseURL        = flag.String("base-url", "", "HTTP(S) URL of a raw base Chart.yaml (e.g. 'https://example.github.io/charts/app/Chart.yaml')")
+		repoRoot       = flag.String("repo", ".", "Path to the git working tree (used with --base-ref)")
+		timeout        = flag.Duration("timeout", 0, "Deadline for the whole run (e.g. 5m); on expiry the run fails with exit code 5 (0 for no deadline)")
+		curPath        = flag.String("cur", "", "Path to current Chart.yaml")
+		chartDir       = flag.String("chart-dir", "", "Chart directory containing the current Chart.yaml (alternative to --cur)")
+		chartsRoot     = flag.String("charts-root", "", "Process every chart directory found under this root instead of a single chart (with --base-ref or --base-merge-base)")
+		keepGoing      = flag.Bool("keep-going", false, "With --charts-root, keep processing the remaining charts after one fails")
+		write          = flag.Bool("write", false, "Write updated files back to disk")
+		atomic         = flag.Bool("atomic", false, "With --write, restore the files already written if writing a later one fails, so the run writes all of its files or none")
+		commitTmpl     = flag.String("commit-message-template", "", "text/template for the commit message describing the changes (default: a conventional-commits summary)")
+		commitFile     = flag.String("commit-message-file", "", "Write the rendered commit message to this file")
+		showDiff       = flag.Bool("diff", false, "Print a unified diff of every changed file to stdout instead of the rendered Chart.yaml")
+		patchOut       = flag.String("patch-out", "", "Directory to write the updated files under, at their paths relative to --repo, instead of updating them in place")
+		summary        = flag.Bool("summary", false, "Print a short summary of the changes (chart version, dependencies, image count) to stdout instead of the rendered Chart.yaml")
+		changelogHints = flag.String("changelog", "", "Keep a Changelog style file whose Unreleased section can raise the bump level (Added: minor, Changed/Fixed: patch, Removed/breaking: major)")
+		changelogPath  = flag.String("prepend-changelog", "", "Path to a CHANGELOG.md to prepend a dated section describing the bump to (written only with --write)")
+		parentDir      = flag.String("update-parent", "", "Parent chart directory whose Chart.yaml dependencies[].version for this chart is set to the bumped version")
+		noVersionBump  = flag.Bool("no-version-bump", false, "Leave the chart version alone and only update images and dependencies; 'changed' then reflects only those files")
+		rcWorkflow     = flag.Bool("rc-workflow", false, "Bump the chart version as a release candidate: increment -rc.N while in prerelease, or start -rc.1 on a new release line")
+		maxBump        = flag.String("max-bump", "major", "Largest chart version bump to apply: patch, minor, or major; larger detected changes are clamped")
+		failOverMax    = flag.Bool("fail-on-exceeding-max", false, "Fail instead of clamping when the detected change exceeds --max-bump")
 
 		updateImages = flag.Bool("update-images", false, "Update image versions based on '# bump:' directives in Chart.yaml and values*.yaml")
 		updateDeps   = flag.Bool("update-deps", false, "Update Chart.yaml dependencies to latest versions from their Helm repositories")
+		depsRecurse  = flag.Bool("deps-recursive", false, "With --update-deps, also update the dependencies of subcharts unpacked under charts/, recursively")
+		updateLock   = flag.Bool("update-lock", false, "With --update-deps, rewrite Chart.lock to match updated dependency versions, as helm dependency update would")
+		createLock   = flag.Bool("create-lock", false, "Like --update-lock, but also create Chart.lock if the chart has none")
+		rewriteRepo  = flag.Bool("rewrite-dep-repository", false, "When a dependency version is resolved from a mirror, also rewrite dependencies[].repository to that mirror")
+		helmCreds    = flag.String("helm-repo-credentials", "", "YAML file of Helm repository credentials keyed by repository URL (used with --update-deps)")
+		checkKube    = flag.Bool("check-kube-version", false, "With --update-deps, skip dependency versions whose kubeVersion doesn't cover the chart's kubeVersion")
+		depMode      = flag.String("dep-update-mode", "latest", "How far dependencies pinned to an exact version may move without a bump-dep directive: latest, patch, or minor")
+		helmCache    = flag.String("helm-repo-cache", "", "Existing Helm repository cache directory; cached index files are reused instead of downloaded")
+		verifyIdem   = flag.Bool("verify-idempotent", false, "Re-run the update pipeline in memory on its own output and fail unless the second pass changes nothing")
+		validate     = flag.Bool("validate", false, "Load the updated chart with Helm and fail, writing nothing, if Helm can't parse it")
+		postHook     = flag.String("post-hook", "", "Command to run after --write changed files, e.g. \"helm lint {chartDir}\"; run without a shell, and a non-zero exit fails the run")
+		propagate    = flag.Bool("propagate-global", false, "When a directive updates a $.global.* value, also update subchart overrides that carry the same value")
+		requireDirs  = flag.Bool("require-directives", false, "Fail (exit 4) when --update-images finds no '# bump:' directives or --update-deps finds no HTTP(S) dependencies")
+		repinMoved   = flag.Bool("repin-moved-tags", false, "For strategy=pinned-ref, re-pin a tag whose digest changed instead of failing")
+		allowDown    = flag.Bool("allow-downgrade", false, "Allow an image tag or dependency version to move to a lower version than the current one (by default such updates are skipped with a warning)")
+		keepOnFail   = flag.Bool("keep-on-failure", false, "When a directive fails to resolve, keep its current value and report the error instead of failing the run")
+		groupPolicy  = flag.String("group-mismatch", "fail", "What to do when directives sharing a group= resolve to different values: fail or warn")
 		scanGlob     = flag.String("scan-glob", "Chart.yaml,values*.yaml", "Comma-separated glob(s) relative to the chart directory to scan for '# bump:' directives")
-
-		verbosity = flag.Int("v", 0, "Verbosity level. Set -v 6 for debug logs.")
+		concurrency  = flag.Int("concurrency", bumper.DefaultConcurrency, "How many image directives to resolve at once")
+		onlyPaths    = flag.String("only-paths", "", "Comma-separated YAML path prefixes (e.g. '$.image,$.sidecar'); only directives targeting these paths are applied")
+		skipPaths    = flag.String("skip-paths", "", "Comma-separated YAML path prefixes whose directives are not applied")
+		recordDigest = flag.Bool("record-digests", false, "Also resolve the manifest digest of each selected image tag, available as .Digest of .Images in --commit-message-template")
+		defaultPlat  = flag.String("default-platform", "", "Platform (os/arch, e.g. linux/amd64) for directives without platform=, e.g. to pick one image of a multi-platform index with strategy=digest")
+		valuesFiles  stringsFlag
+		compBases    stringsFlag
+		compCurs     stringsFlag
+		pinSpecs     stringsFlag
+		directiveCfg = flag.String("config", "", "Directive config file (default: .chart-bumper.yaml in the chart directory, if present)")
+
+		registryAuth    = flag.String("registry-auth", "", "Comma-separated per-registry credentials as host=USERNAME_ENV:PASSWORD_ENV; values are read from those environment variables")
+		ignoreTagsFile  = flag.String("ignore-tags-file", "", "File listing tags never to select, one per line as <tag> (every image) or <image> <tag>; # starts a comment")
+		tokenFile       = flag.String("token-file", "", "File holding the GitHub token for ghcr.io and GitHub release lookups, used when GITHUB_TOKEN is unset (default: $GITHUB_TOKEN_FILE)")
+		registryMirror  = flag.String("registry-mirror", "", "Comma-separated pull-through mirrors as from=to (e.g. docker.io=registry.internal/dockerhub); lookups go to the mirror, files keep the original image")
+		digestCacheTTL  = flag.Duration("digest-cache-ttl", 5*time.Minute, "How long resolved image digests are cached for a (repo, tag, platform)")
+		digestCacheFile = flag.String("digest-cache-file", "", "Optional JSON file to persist the digest cache across runs")
+		maxTagPages     = flag.Int("max-tag-pages", 0, "Maximum pages of a registry tag list to read; a longer list is truncated with a warning (0 for no limit)")
+		registryRPS     = flag.Float64("registry-rps", 0, "Maximum registry requests per second across the run (0 for no limit)")
+		httpCacheDir    = flag.String("registry-cache-dir", "", "Optional directory for an HTTP cache of registry tag-list and manifest responses, honoring Cache-Control and ETag")
+
+		verbosity  = flag.Int("v", 0, "Verbosity level: 1 adds what each directive resolved to, 3 debug logs, 6 debug logs with callers and stack traces")
+		logFormat  = flag.String("log-format", "json", "Log encoding: json (default, for CI log parsers) or console (human-readable)")
+		emitEvents = flag.Bool("emit-events", false, "Log a structured entry with a stable 'event' field for each lifecycle step (directive discovered, tags listed, candidate selected, value written)")
 	)
+	flag.Var(&valuesFiles, "values-file", "Extra file (absolute or relative to --repo) to scan for '# bump:' directives regardless of --scan-glob, e.g. environment values kept outside the chart; repeatable")
+	flag.Var(&compBases, "component-base", "Base Chart.yaml of a component whose change also counts toward the bump; repeatable, paired in order with --component-cur")
+	flag.Var(&compCurs, "component-cur", "Current Chart.yaml of the component named by the matching --component-base; repeatable")
+	flag.Var(&pinSpecs, "pin", "Set every directive for an image to one tag regardless of its strategy, as \"image=<repo> version=<tag>\" (e.g. \"image=ghcr.io/org/base version=1.2.3\"); repeatable")
 	flag.Parse()
 
-	log := newLogger(*verbosity)
+	log := newLogger(*verbosity, *logFormat)
 	defer func() { _ = log.Sync() }()
+	if *logFormat != "json" && *logFormat != "console" {
+		log.Error("invalid --log-format", zap.String("value", *logFormat), zap.String("want", "json or console"))
+		os.Exit(exitUserError)
+	}
 
 	ctx := logutil.WithLogger(context.Background(), log)
+	ctx = logutil.WithEvents(ctx, *emitEvents)
+	ctx = logutil.WithVerbosity(ctx, *verbosity)
 	log = logutil.FromContext(ctx).With(zap.String("func", "main"))
 
 	log.Debug("parsed flags",
 		zap.String("base", *basePath),
 		zap.String("baseRef", *baseRef),
+		zap.String("baseMergeBase", *baseMerge),
 		zap.String("baseRefPath", *baseRefPath),
+		zap.String("baseOCI", *baseOCI),
+		zap.String("baseURL", *baseURL),
 		zap.String("repo", *repoRoot),
+		zap.Duration("timeout", *timeout),
 		zap.String("cur", *curPath),
+		zap.String("chartDir", *chartDir),
+		zap.String("chartsRoot", *chartsRoot),
+		zap.Bool("keepGoing", *keepGoing),
 		zap.Bool("write", *write),
+		zap.Bool("atomic", *atomic),
+		zap.Bool("diff", *showDiff),
+		zap.Bool("summary", *summary),
+		zap.String("patchOut", *patchOut),
+		zap.Bool("noVersionBump", *noVersionBump),
+		zap.Bool("rcWorkflow", *rcWorkflow),
+		zap.String("maxBump", *maxBump),
+		zap.String("changelogHints", *changelogHints),
+		zap.Strings("componentBases", compBases),
+		zap.Strings("componentCurs", compCurs),
+		zap.Bool("failOnExceedingMax", *failOverMax),
+		zap.String("prependChangelog", *changelogPath),
+		zap.String("updateParent", *parentDir),
+		zap.Bool("verifyIdempotent", *verifyIdem),
+		zap.Bool("validate", *validate),
+		zap.String("postHook", *postHook),
 		zap.Bool("updateImages", *updateImages),
 		zap.Bool("updateDeps", *updateDeps),
+		zap.Bool("rewriteRepo", *rewriteRepo),
+		zap.String("helmRepoCredentials", *helmCreds),
+		zap.String("helmRepoCache", *helmCache),
+		zap.String("depUpdateMode", *depMode),
+		zap.String("groupMismatch", *groupPolicy),
+		zap.Bool("keepOnFailure", *keepOnFail),
+		zap.Bool("repinMovedTags", *repinMoved),
+		zap.Bool("propagateGlobal", *propagate),
 		zap.String("scanGlob", *scanGlob),
+		zap.Strings("valuesFiles", valuesFiles),
+		zap.Strings("pins", pinSpecs),
+		zap.String("config", *directiveCfg),
+		zap.Int("concurrency", *concurrency),
+		zap.String("registryAuth", *registryAuth),
+		zap.String("registryMirror", *registryMirror),
+		zap.String("tokenFile", *tokenFile),
+		zap.String("ignoreTagsFile", *ignoreTagsFile),
+		zap.Duration("digestCacheTTL", *digestCacheTTL),
+		zap.String("digestCacheFile", *digestCacheFile),
+		zap.String("registryCacheDir", *httpCacheDir),
 		zap.Int("v", *verbosity),
+		zap.Bool("emitEvents", *emitEvents),
 	)
 
-	if *curPath == "" || (*basePath == "" && *baseRef == "") || (*basePath != "" && *baseRef != "") {
+	baseSources := 0
+	for _, s := range []string{*basePath, *baseRef, *baseMerge, *baseOCI, *baseURL} {
+		if s != "" {
+			baseSources++
+		}
+	}
+	chartSources := 0
+	for _, s := range []string{*curPath, *chartDir, *chartsRoot} {
+		if s != "" {
+			chartSources++
+		}
+	}
+	if chartSources != 1 || baseSources != 1 {
 		log.Error("invalid arguments",
-			zap.String("usage", "helm-chart-bumper (--base path/to/base/Chart.yaml | --base-ref <git-ref> [--base-ref-path path/in/repo/Chart.yaml]) --cur path/to/cur/Chart.yaml [--repo path/to/repo] [--write] [--update-images] [--update-deps]"),
+			zap.String("usage", "helm-chart-bumper (--base path/to/base/Chart.yaml | --base-ref <git-ref> | --base-merge-base <branch> [--base-ref-path path/in/repo/Chart.yaml] | --base-oci oci://registry/repo:version | --base-url https://host/path/Chart.yaml) (--cur path/to/cur/Chart.yaml | --chart-dir path/to/chart | --charts-root path/to/charts [--keep-going]) [--repo path/to/repo] [--write] [--update-images] [--update-deps]"),
 		)
-		os.Exit(2)
+		os.Exit(exitUserError)
 	}
 
-	var baseBytes []byte
-	var err error
-	if *baseRef != "" {
-		p := *baseRefPath
-		if p == "" {
-			p = *curPath
-		}
-		log.Debug("reading base chart from git ref",
-			zap.String("repo", *repoRoot),
-			zap.String("ref", *baseRef),
-			zap.String("path", p),
-		)
-		baseBytes, err = gitutil.ReadFileAtRef(ctx, *repoRoot, *baseRef, p)
-		if err != nil {
-			log.Error("failed reading base chart from git ref", zap.Error(err))
-			os.Exit(2)
-		}
-	} else {
-		log.Debug("reading base chart from file", zap.String("path", *basePath))
-		baseBytes, err = os.ReadFile(*basePath)
-		if err != nil {
-			log.Error("failed reading base chart from file", zap.Error(err))
-			os.Exit(2)
-		}
+	if len(compBases) != len(compCurs) {
+		log.Error("--component-base and --component-cur must be given the same number of times", zap.Int("bases", len(compBases)), zap.Int("curs", len(compCurs)))
+		os.Exit(exitUserError)
+	}
+	var components []bumper.ChartPair
+	for i := range compBases {
+		components = append(components, bumper.ChartPair{Base: compBases[i], Cur: compCurs[i]})
+	}
+	if *chartsRoot != "" && len(components) > 0 {
+		log.Error("--component-base and --component-cur cannot be used with --charts-root")
+		os.Exit(exitUserError)
 	}
 
-	// read current Chart.yaml
-	chartDir := filepath.Dir(*curPath)
-	chartBytes, err := chart.ReadChartYAML(chartDir)
-	if err != nil {
-		log.Fatal("failed to read Chart.yaml", zap.Error(err))
+	if *showDiff && *summary {
+		log.Error("--diff and --summary are mutually exclusive")
+		os.Exit(exitUserError)
 	}
-	meta, err := chart.LoadMeta(chartBytes)
-	if err != nil {
-		log.Fatal("failed to parse Chart.yaml", zap.Error(err))
-	}
-	log.Debug("loaded chart metadata", zap.String("name", meta.Name), zap.String("appVersion", meta.AppVersion))
-
-	// Optional: update images and/or deps (write to disk only when --write is set).
-	// Even in non-write mode, we apply the updates in-memory so stdout reflects the
-	// updated Chart.yaml and change detection sees the updated appVersion.
-	anyFileWritten := false
-	updatedFiles := map[string][]byte{}
-
-	if *updateImages {
-		log.Debug("processing image bump directives", zap.Bool("write", *write))
-		if *write {
-			changed, err := updateImagesInChartDir(ctx, chartDir, *scanGlob)
-			if err != nil {
-				log.Error("update images failed", zap.Error(err))
-				os.Exit(2)
-			}
-			anyFileWritten = anyFileWritten || changed
-			log.Debug("update images completed", zap.Bool("changed", changed))
-		} else {
-			files, changed, err := updateImagesInChartDirMaybeWrite(ctx, chartDir, *scanGlob, false)
-			if err != nil {
-				log.Error("update images failed", zap.Error(err))
-				os.Exit(2)
-			}
-			for k, v := range files {
-				updatedFiles[k] = v
-			}
-			log.Debug("update images completed", zap.Bool("changed", changed))
-		}
+
+	if *write && *patchOut != "" {
+		log.Error("--write and --patch-out are mutually exclusive")
+		os.Exit(exitUserError)
 	}
-	if *updateDeps {
-		log.Debug("processing dependency updates", zap.Bool("write", *write))
-		if *write {
-			changed, err := updateDepsInChartYAML(ctx, chartDir)
-			if err != nil {
-				log.Error("update deps failed", zap.Error(err))
-				os.Exit(2)
-			}
-			anyFileWritten = anyFileWritten || changed
-			log.Debug("update deps completed", zap.Bool("changed", changed))
-		} else {
-			b, changed, err := updateDepsInChartYAMLMaybeWrite(ctx, chartDir, false)
-			if err != nil {
-				log.Error("update deps failed", zap.Error(err))
-				os.Exit(2)
-			}
-			if b != nil {
-				updatedFiles[filepath.Join(chartDir, "Chart.yaml")] = b
-			}
-			log.Debug("update deps completed", zap.Bool("changed", changed))
-		}
+
+	if *groupPolicy != "fail" && *groupPolicy != "warn" {
+		log.Error("invalid --group-mismatch", zap.String("value", *groupPolicy), zap.String("want", "fail or warn"))
+		os.Exit(exitUserError)
 	}
 
-	curKey := *curPath
-	if !filepath.IsAbs(curKey) {
-		// Most callers pass a repo-relative path.
-		curKey = filepath.Clean(filepath.Join(*repoRoot, curKey))
+	var auths []imageresolver.RegistryAuth
+	for _, spec := range bumper.SplitCSV(*registryAuth) {
+		a, err := imageresolver.ParseRegistryAuth(spec)
+		if err != nil {
+			log.Error("invalid --registry-auth", zap.Error(err))
+			os.Exit(exitUserError)
+		}
+		auths = append(auths, a)
 	}
-	curBytes, ok := updatedFiles[curKey]
-	if !ok {
-		// Also try as provided (for callers passing absolute paths).
-		curBytes, ok = updatedFiles[*curPath]
+	keychain := imageresolver.NewKeychain(auths, *tokenFile)
+	if _, err := imageresolver.GitHubToken(*tokenFile); err != nil {
+		log.Error("invalid --token-file", zap.Error(err))
+		os.Exit(exitUserError)
 	}
-	if !ok {
-		curBytes, err = os.ReadFile(*curPath)
+
+	mirrors := map[string]string{}
+	for _, spec := range bumper.SplitCSV(*registryMirror) {
+		from, to, err := imageresolver.ParseMirror(spec)
 		if err != nil {
-			log.Error("failed reading current chart", zap.Error(err), zap.String("path", *curPath))
-			os.Exit(2)
+			log.Error("invalid --registry-mirror", zap.Error(err))
+			os.Exit(exitUserError)
 		}
+		mirrors[from] = to
 	}
 
-	baseMeta, err := chart.LoadMeta(baseBytes)
-	if err != nil {
-		log.Error("failed parsing base chart metadata", zap.Error(err))
-		os.Exit(2)
+	var pins map[string]string
+	for _, spec := range pinSpecs {
+		image, version, err := bumper.ParsePin(spec)
+		if err != nil {
+			log.Error("invalid --pin", zap.Error(err))
+			os.Exit(exitUserError)
+		}
+		if v, ok := pins[image]; ok && v != version {
+			log.Error("conflicting --pin versions", zap.String("image", image), zap.Strings("versions", []string{v, version}))
+			os.Exit(exitUserError)
+		}
+		if pins == nil {
+			pins = map[string]string{}
+		}
+		pins[image] = version
+	}
+
+	cfg := bumper.Config{
+		ChartPath:          *curPath,
+		ChartDir:           *chartDir,
+		BasePath:           *basePath,
+		BaseRef:            *baseRef,
+		BaseMergeBase:      *baseMerge,
+		BaseOCI:            *baseOCI,
+		BaseURL:            *baseURL,
+		BaseRefPath:        *baseRefPath,
+		RepoRoot:           *repoRoot,
+		Write:              *write,
+		Atomic:             *atomic,
+		PatchOut:           *patchOut,
+		NoVersionBump:      *noVersionBump,
+		RCWorkflow:         *rcWorkflow,
+		MaxBump:            *maxBump,
+		ChangelogHints:     *changelogHints,
+		Components:         components,
+		FailOnExceedingMax: *failOverMax,
+		ChangelogPath:      *changelogPath,
+		ParentDir:          *parentDir,
+		VerifyIdempotent:   *verifyIdem,
+		Validate:           *validate,
+		PostHook:           *postHook,
+
+		UpdateImages:      *updateImages,
+		ScanGlob:          *scanGlob,
+		DirectiveConfig:   *directiveCfg,
+		OnlyPaths:         bumper.SplitCSV(*onlyPaths),
+		SkipPaths:         bumper.SplitCSV(*skipPaths),
+		ValuesFiles:       valuesFiles,
+		Pins:              pins,
+		RecordDigests:     *recordDigest,
+		DefaultPlatform:   *defaultPlat,
+		Concurrency:       *concurrency,
+		Keychain:          keychain,
+		GitHubTokenFile:   *tokenFile,
+		DigestCacheTTL:    *digestCacheTTL,
+		DigestCacheFile:   *digestCacheFile,
+		RegistryCacheDir:  *httpCacheDir,
+		MaxTagPages:       *maxTagPages,
+		RegistryRPS:       *registryRPS,
+		RegistryMirrors:   mirrors,
+		IgnoreTagsFile:    *ignoreTagsFile,
+		PropagateGlobal:   *propagate,
+		KeepOnFailure:     *keepOnFail,
+		WarnGroupMismatch: *groupPolicy == "warn",
+		RepinMovedTags:    *repinMoved,
+		RequireDirectives: *requireDirs,
+		AllowDowngrade:    *allowDown,
+
+		UpdateDeps:           *updateDeps,
+		DepUpdateMode:        *depMode,
+		DepRepositoryCache:   *helmCache,
+		DepCredentialsFile:   *helmCreds,
+		RewriteDepRepository: *rewriteRepo,
+		DepsRecursive:        *depsRecurse,
+		UpdateLock:           *updateLock || *createLock,
+		CreateLock:           *createLock,
+		CheckKubeVersion:     *checkKube,
+	}
+	if _, err := helmdeps.ParseUpdateMode(*depMode); err != nil {
+		log.Error("invalid --dep-update-mode", zap.Error(err))
+		os.Exit(exitUserError)
+	}
+	if cfg.UpdateImages {
+		// One Resolver serves the whole run, including every chart under --charts-root.
+		r, err := bumper.NewResolver(cfg)
+		if err != nil {
+			log.Error("set up registry access", zap.Error(err))
+			os.Exit(exitUserError)
+		}
+		cfg.Resolver = r
 	}
-	curMeta, err := chart.LoadMeta(curBytes)
-	if err != nil {
-		log.Error("failed parsing current chart metadata", zap.Error(err))
-		os.Exit(2)
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
 	}
 
-	lvl := chart.ComputeChangeLevel(baseMeta, curMeta)
-	log.Debug("computed change level",
-		zap.String("baseVersion", baseMeta.Version),
-		zap.String("baseAppVersion", baseMeta.AppVersion),
-		zap.String("curVersion", curMeta.Version),
-		zap.String("curAppVersion", curMeta.AppVersion),
-		zap.String("level", string(rune(lvl))),
-	)
+	if *chartsRoot != "" {
+		os.Exit(runCharts(ctx, *chartsRoot, cfg, *keepGoing, *showDiff, *summary, *commitTmpl, *commitFile))
+	}
 
-	ast, err := yamlutil.ParseBytes(curBytes)
+	res, err := bumper.Run(ctx, cfg)
 	if err != nil {
-		log.Error("failed parsing current chart yaml", zap.Error(err))
-		os.Exit(2)
+		os.Exit(failed(ctx, err))
 	}
+	reportKeptValues(ctx, res.Kept)
 
-	changed, err := chart.ApplyChartVersionBump(ast, lvl)
-	if err != nil {
-		log.Error("failed applying chart version bump", zap.Error(err))
-		os.Exit(2)
+	printResult(os.Stdout, res, *showDiff, *summary, *write)
+
+	writeGithubOutputChanged(ctx, res.Changed())
+	if err := writeCommitMessage(ctx, []*bumper.Result{res}, *commitTmpl, *commitFile); err != nil {
+		log.Error("commit message failed", zap.Error(err))
+		os.Exit(exitUserError)
 	}
-	log.Debug("applied chart version bump", zap.Bool("changed", changed))
+	log.Debug("done", zap.Bool("changed", res.Changed()), zap.String("oldVersion", res.OldVersion), zap.String("newVersion", res.NewVersion))
+}
 
-	out, err := yamlutil.Render(ast)
-	if err != nil {
-		log.Error("failed rendering chart yaml", zap.Error(err))
-		os.Exit(2)
-	}
-
-	didWriteChart := false
-	if *write && changed {
-		outBytes := []byte(out)
-		// Don’t touch the file if the rendered bytes are identical.
-		if !bytes.Equal(curBytes, outBytes) {
-			log.Debug("writing updated Chart.yaml", zap.String("path", *curPath))
-			if err := os.WriteFile(*curPath, outBytes, 0o644); err != nil {
-				log.Error("failed writing updated Chart.yaml", zap.Error(err))
-				os.Exit(2)
-			}
-			didWriteChart = true
-		} else {
-			log.Debug("rendered Chart.yaml identical; skipping write")
+// printResult prints res to w: its diff with --diff, its summary with --summary, and
+// otherwise, without --write, the resulting Chart.yaml.
+func printResult(w io.Writer, res *bumper.Result, showDiff, summary, write bool) {
+	switch {
+	case showDiff:
+		fmt.Fprint(w, res.Diff())
+	case summary:
+		fmt.Fprint(w, res.Summary())
+	case !write:
+		// Tool contract: emit resulting Chart.yaml to stdout.
+		fmt.Fprint(w, res.ChartYAML)
+	}
+}
+
+// runCharts runs every chart under root and reports each one, returning the exit code. The
+// changed output is true if any chart changed. Without --write, the charts' resulting
+// Chart.yaml files are printed as one multi-document stream.
+func runCharts(ctx context.Context, root string, cfg bumper.Config, keepGoing, showDiff, summary bool, commitTmpl, commitFile string) int {
+	log := logutil.FromContext(ctx).With(zap.String("func", "runCharts"), zap.String("root", root))
+	results, err := bumper.RunCharts(ctx, root, cfg, keepGoing)
+
+	var ok []*bumper.Result
+	changed := false
+	for _, r := range results {
+		if r.Err != nil {
+			log.Error("chart failed", zap.String("dir", r.Dir), zap.Error(r.Err))
+			continue
 		}
+		res := r.Result
+		log.Info("chart processed", zap.String("dir", r.Dir), zap.Bool("changed", res.Changed()), zap.String("oldVersion", res.OldVersion), zap.String("newVersion", res.NewVersion))
+		reportKeptValues(ctx, res.Kept)
+		switch {
+		case showDiff:
+			fmt.Print(res.Diff())
+		case summary:
+			fmt.Printf("# %s\n%s", r.Dir, res.Summary())
+		case !cfg.Write:
+			fmt.Printf("---\n# Source: %s\n%s", filepath.Join(r.Dir, "Chart.yaml"), res.ChartYAML)
+		}
+		changed = changed || res.Changed()
+		ok = append(ok, res)
 	}
 
-	if !*write {
-		// Tool contract: emit resulting Chart.yaml to stdout.
-		fmt.Print(out)
+	writeGithubOutputChanged(ctx, changed)
+	if err := writeCommitMessage(ctx, ok, commitTmpl, commitFile); err != nil {
+		log.Error("commit message failed", zap.Error(err))
+		return exitUserError
 	}
+	if err != nil {
+		return failed(ctx, err)
+	}
+	log.Debug("done", zap.Int("charts", len(results)), zap.Bool("changed", changed))
+	return 0
+}
 
-	writeGithubOutputChanged(ctx, anyFileWritten || didWriteChart)
-	log.Debug("done", zap.Bool("changed", anyFileWritten || didWriteChart))
+// failed logs a failed run's error and returns the process exit code for it. A run that hit
+// --timeout is reported as such; its error is wrapped by each step, so it names the
+// operation that was in flight.
+func failed(ctx context.Context, err error) int {
+	log := logutil.FromContext(ctx).With(zap.String("func", "main"))
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Error("run timed out", zap.Error(err))
+		return exitTimeout
+	}
+	log.Error("bump failed", zap.Error(err), zap.Bool("transient", bumper.IsTransient(err)))
+	if bumper.IsTransient(err) {
+		return exitTransient
+	}
+	if errors.Is(err, bumper.ErrNothingFound) {
+		return exitNothingFound
+	}
+	return exitUserError
 }
 
-func newLogger(verbosity int) *zap.Logger {
+func newLogger(verbosity int, format string) *zap.Logger {
+	stack := zapcore.ErrorLevel
+	if verbosity >= logutil.TraceVerbosity {
+		stack = zapcore.WarnLevel
+	}
+	log, err := loggerConfig(verbosity, format).Build(zap.AddStacktrace(stack))
+	if err != nil {
+		// As a last resort. If zap can't build, we still need *some* output.
+		return zap.NewNop()
+	}
+	return log
+}
+
+// loggerConfig returns the logger configuration for verbosity and format. format "console"
+// selects zap's human-readable development encoder; anything else keeps production JSON.
+func loggerConfig(verbosity int, format string) zap.Config {
 	cfg := zap.NewProductionConfig()
+	if format == "console" {
+		cfg.Encoding = "console"
+		cfg.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	}
 	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
 	cfg.Level = zap.NewAtomicLevelAt(levelForVerbosity(verbosity))
-	// In debug, make it easier to correlate logs with code.
-	if verbosity >= 6 {
+	// Plain debug drops callers to keep its extra volume down; full debug adds them back,
+	// where they make it easier to correlate logs with code.
+	cfg.DisableCaller = verbosity >= logutil.DebugVerbosity && verbosity < logutil.TraceVerbosity
+	if verbosity >= logutil.TraceVerbosity {
 		cfg.EncoderConfig.CallerKey = "caller"
 		cfg.EncoderConfig.EncodeCaller = zapcore.ShortCallerEncoder
 		cfg.Development = true
 	}
-	log, err := cfg.Build(zap.AddStacktrace(zapcore.ErrorLevel))
-	if err != nil {
-		// As a last resort. If zap can't build, we still need *some* output.
-		return zap.NewNop()
-	}
-	return log
+	return cfg
 }
 
 func levelForVerbosity(v int) zapcore.Level {
 	// Convention for this repo:
-	// -v 0 : info+error (quiet)
-	// -v 6+: debug
-	if v >= 6 {
+	// -v 0   : info+error (quiet)
+	// -v 1..2: info, plus logutil.Detail entries
+	// -v 3..5: debug, without callers
+	// -v 6+  : debug with callers and stack traces (see loggerConfig)
+	if v >= logutil.DebugVerbosity {
 		return zapcore.DebugLevel
 	}
 	return zapcore.InfoLevel
 }
 
-func updateDepsInChartYAML(ctx context.Context, chartDir string) (bool, error) {
-	_, changed, err := updateDepsInChartYAMLMaybeWrite(ctx, chartDir, true)
-	return changed, err
-}
-
-// updateDepsInChartYAMLMaybeWrite resolves dependency version updates and applies them.
-// If write=false, it returns the would-be updated Chart.yaml bytes without touching disk.
-// If write=true, it writes Chart.yaml if it changed and returns nil bytes.
-func updateDepsInChartYAMLMaybeWrite(ctx context.Context, chartDir string, write bool) ([]byte, bool, error) {
-	log := logutil.FromContext(ctx).With(zap.String("func", "updateDepsInChartYAMLMaybeWrite"), zap.String("chartDir", chartDir))
-	chartPath := filepath.Join(chartDir, "Chart.yaml")
-	log.Debug("resolving dependency updates", zap.String("chartPath", chartPath))
-
-	resolved, err := helmdeps.ResolveLatestDependencies(ctx, chartPath)
-	if err != nil {
-		return nil, false, err
+// reportKeptValues logs each directive kept at its current value and, when running in GitHub
+// Actions, lists them in the job summary.
+func reportKeptValues(ctx context.Context, kept []bumper.KeptValue) {
+	if len(kept) == 0 {
+		return
 	}
-	log.Debug("resolved dependency candidates", zap.Int("count", len(resolved)))
-	if len(resolved) == 0 {
-		return nil, false, nil
+	log := logutil.FromContext(ctx).With(zap.String("func", "reportKeptValues"))
+	for _, k := range kept {
+		log.Warn("unresolved, kept current value", zap.String("file", k.File), zap.Int("line", k.Line), zap.String("yamlPath", k.YAMLPath), zap.String("value", k.Value), zap.Error(k.Err))
 	}
 
-	b, err := os.ReadFile(chartPath)
-	if err != nil {
-		return nil, false, err
+	summaryPath := os.Getenv("GITHUB_STEP_SUMMARY")
+	if summaryPath == "" {
+		return
 	}
-	ast, err := yamlutil.ParseBytes(b)
+	f, err := os.OpenFile(summaryPath, os.O_APPEND|os.O_WRONLY, 0)
 	if err != nil {
-		return nil, false, err
+		log.Debug("failed opening GITHUB_STEP_SUMMARY", zap.Error(err), zap.String("path", summaryPath))
+		return
 	}
-
-	changed := false
-	for _, r := range resolved {
-		log.Debug("dependency resolution",
-			zap.String("name", r.Name),
-			zap.Int("index", r.Index),
-			zap.String("repo", r.Repository),
-			zap.String("old", r.OldVersion),
-			zap.String("new", r.NewVersion),
-		)
-		if r.NewVersion == "" || r.NewVersion == r.OldVersion {
-			continue
-		}
-		p := fmt.Sprintf("$.dependencies[%d].version", r.Index)
-		c, err := yamlutil.SetString(ast, p, r.NewVersion)
-		if err != nil {
-			return nil, false, fmt.Errorf("Chart.yaml dependency %q: %w", r.Name, err)
-		}
-		changed = changed || c
+	defer f.Close()
+	_, _ = fmt.Fprintln(f, "### Unresolved directives (current values kept)")
+	_, _ = fmt.Fprintln(f)
+	for _, k := range kept {
+		_, _ = fmt.Fprintf(f, "- `%s:%d` `%s` kept `%s`: %v\n", k.File, k.Line, k.YAMLPath, k.Value, k.Err)
 	}
-	if !changed {
-		log.Debug("no dependency versions changed")
-		return nil, false, nil
+}
+
+func writeGithubOutputChanged(ctx context.Context, changed bool) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "writeGithubOutputChanged"), zap.Bool("changed", changed))
+	outPath := os.Getenv("GITHUB_OUTPUT")
+	if outPath == "" {
+		log.Debug("GITHUB_OUTPUT not set; skipping")
+		return
 	}
 
-	out, err := yamlutil.Render(ast)
+	f, err := os.OpenFile(outPath, os.O_APPEND|os.O_WRONLY, 0)
 	if err != nil {
-		return nil, false, err
-	}
-	outBytes := []byte(out)
-	if !bytes.Equal(b, outBytes) {
-		if write {
-			log.Debug("writing updated Chart.yaml deps", zap.String("path", chartPath))
-			if err := os.WriteFile(chartPath, outBytes, 0o644); err != nil {
-				return nil, false, err
-			}
-			return nil, true, nil
-		}
-		return outBytes, true, nil
+		log.Debug("failed opening GITHUB_OUTPUT", zap.Error(err), zap.String("path", outPath))
+		return
 	}
-	log.Debug("rendered Chart.yaml identical after deps update; skipping write")
-	return nil, false, nil
-}
+	defer f.Close()
 
-func updateImagesInChartDir(ctx context.Context, chartDir, globCSV string) (bool, error) {
-	_, changed, err := updateImagesInChartDirMaybeWrite(ctx, chartDir, globCSV, true)
-	return changed, err
+	if changed {
+		_, _ = fmt.Fprintln(f, "changed=true")
+		return
+	}
+	_, _ = fmt.Fprintln(f, "changed=false")
 }
 
-// updateImagesInChartDirMaybeWrite scans files for '# bump:' directives, resolves the new values,
-// applies them, and either writes to disk (write=true) or returns the updated bytes (write=false).
-// Returned map keys are absolute file paths.
-func updateImagesInChartDirMaybeWrite(ctx context.Context, chartDir, globCSV string, write bool) (map[string][]byte, bool, error) {
-	log := logutil.FromContext(ctx).With(zap.String("func", "updateImagesInChartDirMaybeWrite"), zap.String("chartDir", chartDir), zap.String("scanGlob", globCSV))
-	globs := splitCSV(globCSV)
-	log.Debug("expanded scan globs", zap.Strings("globs", globs))
-
-	files := map[string]struct{}{}
-	for _, g := range globs {
-		pattern := filepath.Join(chartDir, g)
-		matches, err := filepath.Glob(pattern)
-		if err != nil {
-			return nil, false, err
-		}
-		log.Debug("glob matches", zap.String("pattern", pattern), zap.Int("matches", len(matches)))
-		for _, m := range matches {
-			// Only regular files.
-			st, err := os.Stat(m)
-			if err != nil {
-				return nil, false, err
-			}
-			if st.Mode().IsRegular() {
-				files[m] = struct{}{}
-			}
-		}
+// writeCommitMessage renders the commit message to file, if set, and, when running in GitHub
+// Actions, to the commit_message output. With several results, the messages of the changed
+// ones are joined by blank lines.
+func writeCommitMessage(ctx context.Context, results []*bumper.Result, tmpl, file string) error {
+	log := logutil.FromContext(ctx).With(zap.String("func", "writeCommitMessage"))
+	outPath := os.Getenv("GITHUB_OUTPUT")
+	if file == "" && outPath == "" {
+		return nil
 	}
-
-	updated := map[string][]byte{}
-	anyChanged := false
-	for p := range files {
-		fileLog := log.With(zap.String("file", p))
-		dirs, err := directives.ScanFileForImageDirectives(ctx, p)
-		if err != nil {
-			return nil, false, err
-		}
-		fileLog.Debug("scanned for bump directives", zap.Int("directives", len(dirs)))
-		if len(dirs) == 0 {
+	var msgs []string
+	for _, res := range results {
+		if len(results) > 1 && !res.Changed() {
 			continue
 		}
-
-		b, err := os.ReadFile(p)
-		if err != nil {
-			return nil, false, err
-		}
-		ast, err := yamlutil.ParseBytes(b)
-		if err != nil {
-			return nil, false, err
-		}
-
-		fileChanged := false
-		for _, d := range dirs {
-			dLog := fileLog.With(
-				zap.Int("line", d.Line),
-				zap.String("yamlPath", d.YAMLPath),
-				zap.String("image", d.Image),
-				zap.String("strategy", d.Strategy),
-				zap.String("constraint", d.Constraint),
-				zap.String("tagRegex", d.TagRegex),
-				zap.Bool("allowPrerelease", d.AllowPrerelease),
-				zap.String("platform", d.Platform),
-			)
-
-			// Full image path is required.
-			if d.Image == "" {
-				return nil, false, fmt.Errorf("%s:%d: bump directive missing required image=<full repo path>", p, d.Line)
-			}
-			strategy := d.Strategy
-			if strategy == "" {
-				strategy = "semver"
-			}
-
-			var newValue string
-			switch strings.ToLower(strategy) {
-			case "digest":
-				// Resolve digest from sibling tag.
-				parentPath := parentYAMLPath(d.YAMLPath)
-				tagPath := parentPath + ".tag"
-				tag, ok, _ := yamlutil.GetString(ast, tagPath)
-				if !ok || strings.TrimSpace(tag) == "" {
-					return nil, false, fmt.Errorf("%s:%d: strategy=digest requires a sibling 'tag' key (looked for %s)", p, d.Line, tagPath)
-				}
-				dLog.Debug("resolving digest from tag", zap.String("tagPath", tagPath), zap.String("tag", tag))
-				digest, err := imageresolver.ResolveDigest(ctx, d.Image, tag, d.Platform, nil)
-				if err != nil {
-					return nil, false, fmt.Errorf("%s:%d: %w", p, d.Line, err)
-				}
-				newValue = digest
-			case "literal", "regex", "semver":
-				dLog.Debug("resolving tag")
-				tag, err := imageresolver.ResolveTag(ctx, d.Image, strings.ToLower(strategy), d.Constraint, d.TagRegex, d.AllowPrerelease, nil)
-				if err != nil {
-					return nil, false, fmt.Errorf("%s:%d: %w", p, d.Line, err)
-				}
-				newValue = tag
-			default:
-				return nil, false, fmt.Errorf("%s:%d: unknown strategy %q", p, d.Line, d.Strategy)
-			}
-
-			dLog.Debug("resolved new value", zap.String("current", d.CurrentText), zap.String("new", newValue))
-			c, err := yamlutil.SetString(ast, d.YAMLPath, newValue)
-			if err != nil {
-				return nil, false, fmt.Errorf("%s:%d: failed to set %s: %w", p, d.Line, d.YAMLPath, err)
-			}
-			fileChanged = fileChanged || c
-		}
-
-		if !fileChanged {
-			fileLog.Debug("no changes to apply")
-			continue
-		}
-
-		out, err := yamlutil.Render(ast)
+		m, err := res.CommitMessage(tmpl)
 		if err != nil {
-			return nil, false, err
-		}
-		outBytes := []byte(out)
-		if !bytes.Equal(b, outBytes) {
-			anyChanged = true
-			abs, err := filepath.Abs(p)
-			if err != nil {
-				return nil, false, err
-			}
-			updated[abs] = outBytes
-			if write {
-				fileLog.Debug("writing updated file")
-				if err := os.WriteFile(p, outBytes, 0o644); err != nil {
-					return nil, false, err
-				}
-			}
-		} else {
-			fileLog.Debug("rendered file identical; skipping write")
+			return err
 		}
+		msgs = append(msgs, m)
 	}
-	return updated, anyChanged, nil
-}
-
-func splitCSV(s string) []string {
-	parts := strings.Split(s, ",")
-	out := make([]string, 0, len(parts))
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p == "" {
-			continue
+	msg := strings.Join(msgs, "\n\n")
+	if file != "" {
+		if err := os.WriteFile(file, []byte(msg+"\n"), 0o644); err != nil {
+			return err
 		}
-		out = append(out, p)
-	}
-	return out
-}
-
-func parentYAMLPath(p string) string {
-	// Expect $.a.b.c
-	if !strings.HasPrefix(p, "$.") {
-		return p
 	}
-	idx := strings.LastIndex(p, ".")
-	if idx <= 1 {
-		return "$"
-	}
-	return p[:idx]
-}
-
-func writeGithubOutputChanged(ctx context.Context, changed bool) {
-	log := logutil.FromContext(ctx).With(zap.String("func", "writeGithubOutputChanged"), zap.Bool("changed", changed))
-	outPath := os.Getenv("GITHUB_OUTPUT")
 	if outPath == "" {
-		log.Debug("GITHUB_OUTPUT not set; skipping")
-		return
+		return nil
 	}
-
 	f, err := os.OpenFile(outPath, os.O_APPEND|os.O_WRONLY, 0)
 	if err != nil {
 		log.Debug("failed opening GITHUB_OUTPUT", zap.Error(err), zap.String("path", outPath))
-		return
+		return nil
 	}
 	defer f.Close()
+	// The delimiter form allows a multi-line message.
+	_, _ = fmt.Fprintf(f, "commit_message<<HELM_CHART_BUMPER_EOF\n%s\nHELM_CHART_BUMPER_EOF\n", msg)
+	return nil
+}
 
-	if changed {
-		_, _ = fmt.Fprintln(f, "changed=true")
-		return
-	}
-	_, _ = fmt.Fprintln(f, "changed=false")
+// stringsFlag is a flag that may be repeated, collecting each value.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/joejulian/helm-chart-bumper-action/bumper"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLoggerConfigFormat(t *testing.T) {
+	logLine := func(t *testing.T, format string) string {
+		t.Helper()
+		out := filepath.Join(t.TempDir(), "log")
+		cfg := loggerConfig(0, format)
+		cfg.OutputPaths = []string{out}
+		log, err := cfg.Build()
+		if err != nil {
+			t.Fatalf("Build: %v", err)
+		}
+		log.Info("updated $.image.tag", zap.String("file", "values.yaml"))
+		_ = log.Sync()
+		b, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		return strings.TrimSpace(string(b))
+	}
+
+	var entry map[string]any
+	if line := logLine(t, "json"); json.Unmarshal([]byte(line), &entry) != nil || entry["msg"] != "updated $.image.tag" || entry["file"] != "values.yaml" {
+		t.Fatalf("json format got %q", line)
+	}
+
+	line := logLine(t, "console")
+	if strings.HasPrefix(line, "{") {
+		t.Fatalf("console format produced JSON: %q", line)
+	}
+	fields := strings.Split(line, "\t")
+	if len(fields) < 4 || fields[1] != "INFO" || !strings.Contains(line, "\tupdated $.image.tag\t") || !strings.HasSuffix(line, `{"file": "values.yaml"}`) {
+		t.Fatalf("console format got %q", line)
+	}
+}
+
+func TestLevelForVerbosity(t *testing.T) {
+	for _, c := range []struct {
+		v      int
+		level  zapcore.Level
+		caller bool
+	}{
+		{0, zapcore.InfoLevel, true},
+		{1, zapcore.InfoLevel, true},
+		{2, zapcore.InfoLevel, true},
+		{3, zapcore.DebugLevel, false},
+		{5, zapcore.DebugLevel, false},
+		{6, zapcore.DebugLevel, true},
+		{9, zapcore.DebugLevel, true},
+	} {
+		if got := levelForVerbosity(c.v); got != c.level {
+			t.Fatalf("levelForVerbosity(%d) = %v, want %v", c.v, got, c.level)
+		}
+		cfg := loggerConfig(c.v, "json")
+		if got := cfg.Level.Level(); got != c.level {
+			t.Fatalf("loggerConfig(%d) level = %v, want %v", c.v, got, c.level)
+		}
+		if got := !cfg.DisableCaller; got != c.caller {
+			t.Fatalf("loggerConfig(%d) caller = %v, want %v", c.v, got, c.caller)
+		}
+	}
+}
+
+func TestPrintResultSummary(t *testing.T) {
+	res := &bumper.Result{
+		ChartYAML:    "apiVersion: v2\nname: app\nversion: 0.4.0\n",
+		OldVersion:   "0.3.1",
+		NewVersion:   "0.4.0",
+		Dependencies: []bumper.DependencyChange{{Name: "redis", Old: "19.0.3", New: "20.1.0"}},
+		Images:       []bumper.ImageChange{{Image: "ghcr.io/org/app", Old: "1.4", New: "1.5"}},
+	}
+	var out bytes.Buffer
+	printResult(&out, res, false, true, false)
+	if got, want := out.String(), "Chart 0.3.1 -> 0.4.0\nredis 19.0.3 -> 20.1.0\n1 image updated\n"; got != want {
+		t.Fatalf("summary got %q want %q", got, want)
+	}
+	if strings.Contains(out.String(), "apiVersion:") {
+		t.Fatalf("summary included the rendered Chart.yaml:\n%s", out.String())
+	}
+
+	// Without --summary, the dry run still prints the rendered Chart.yaml.
+	out.Reset()
+	printResult(&out, res, false, false, false)
+	if out.String() != res.ChartYAML {
+		t.Fatalf("default output got %q", out.String())
+	}
+}
@@ -9,6 +9,7 @@ require (
 	github.com/google/go-containerregistry v0.20.3
 	go.uber.org/zap v1.26.0
 	helm.sh/helm/v3 v3.16.2
+	sigs.k8s.io/yaml v1.4.0
 )
 
 require (
@@ -292,7 +293,6 @@ require (
 	sigs.k8s.io/kustomize/api v0.17.2 // indirect
 	sigs.k8s.io/kustomize/kyaml v0.17.1 // indirect
 	sigs.k8s.io/structured-merge-diff/v4 v4.4.1 // indirect
-	sigs.k8s.io/yaml v1.4.0 // indirect
 )
 
 tool github.com/golangci/golangci-lint/cmd/golangci-lint
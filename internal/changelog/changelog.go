@@ -0,0 +1,87 @@
+package changelog
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Entry is one release section of a CHANGELOG.md.
+type Entry struct {
+	Version string
+	Date    time.Time
+	Changes []string
+}
+
+// Render formats e as a markdown section:
+//
+//	## 1.3.0 - 2024-05-01
+//
+//	- appVersion: 2.0.0 -> 2.1.0
+func (e Entry) Render() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", e.heading())
+	for _, c := range e.Changes {
+		fmt.Fprintf(&b, "- %s\n", c)
+	}
+	return b.String()
+}
+
+func (e Entry) heading() string {
+	return fmt.Sprintf("## %s - %s", e.Version, e.Date.Format("2006-01-02"))
+}
+
+// Prepend inserts e at the top of existing, below a leading `# ` title (and any
+// paragraph that directly follows it), preserving the rest of the file.
+//
+// It is idempotent: an entry with no changes, or for a version that already has a section,
+// leaves existing untouched and returns changed=false.
+func Prepend(existing []byte, e Entry) ([]byte, bool) {
+	if len(e.Changes) == 0 || hasSection(existing, e.Version) {
+		return existing, false
+	}
+
+	lines := strings.SplitAfter(string(existing), "\n")
+	insertAt := 0
+	if len(lines) > 0 && strings.HasPrefix(lines[0], "# ") {
+		// Skip the title and its intro paragraph, up to the first heading or after the
+		// blank line ending the paragraph.
+		insertAt = 1
+		for insertAt < len(lines) && strings.TrimSpace(lines[insertAt]) == "" {
+			insertAt++
+		}
+		for insertAt < len(lines) && strings.TrimSpace(lines[insertAt]) != "" && !strings.HasPrefix(lines[insertAt], "#") {
+			insertAt++
+		}
+		for insertAt < len(lines) && strings.TrimSpace(lines[insertAt]) == "" {
+			insertAt++
+		}
+	}
+
+	var out bytes.Buffer
+	head := strings.Join(lines[:insertAt], "")
+	out.WriteString(head)
+	if head != "" && !strings.HasSuffix(head, "\n\n") {
+		if !strings.HasSuffix(head, "\n") {
+			out.WriteString("\n")
+		}
+		out.WriteString("\n")
+	}
+	out.WriteString(e.Render())
+	if rest := strings.Join(lines[insertAt:], ""); rest != "" {
+		out.WriteString("\n")
+		out.WriteString(rest)
+	}
+	return out.Bytes(), true
+}
+
+func hasSection(b []byte, version string) bool {
+	prefix := "## " + version + " "
+	for _, l := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(l, prefix) || strings.TrimSpace(l) == "## "+version {
+			return true
+		}
+	}
+	return false
+}
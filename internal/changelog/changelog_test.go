@@ -0,0 +1,96 @@
+package changelog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/semverutil"
+)
+
+func TestPrepend(t *testing.T) {
+	existing, err := os.ReadFile(filepath.Join("testdata", "CHANGELOG.md"))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	e := Entry{
+		Version: "1.3.0",
+		Date:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Changes: []string{"appVersion: 2.0.0 -> 2.1.0"},
+	}
+
+	got, changed := Prepend(existing, e)
+	if !changed {
+		t.Fatalf("expected changed=true")
+	}
+	want := `# Changelog
+
+All notable changes to this chart are documented here.
+
+## 1.3.0 - 2024-05-01
+
+- appVersion: 2.0.0 -> 2.1.0
+
+## 1.2.0 - 2024-04-01
+
+- appVersion: 1.9.0 -> 2.0.0
+`
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	again, changed := Prepend(got, e)
+	if changed || string(again) != string(got) {
+		t.Fatalf("expected second prepend to be a no-op, got:\n%s", again)
+	}
+}
+
+func TestPrepend_NoChangesIsNoop(t *testing.T) {
+	in := []byte("# Changelog\n")
+	got, changed := Prepend(in, Entry{Version: "1.0.1", Date: time.Now()})
+	if changed || string(got) != string(in) {
+		t.Fatalf("expected no-op, got changed=%v:\n%s", changed, got)
+	}
+}
+
+func TestPrepend_EmptyFile(t *testing.T) {
+	got, changed := Prepend(nil, Entry{Version: "0.1.0", Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Changes: []string{"initial"}})
+	if !changed {
+		t.Fatalf("expected changed=true")
+	}
+	if want := "## 0.1.0 - 2024-01-02\n\n- initial\n"; string(got) != want {
+		t.Fatalf("got %q want %q", got, want)
+	}
+}
+
+func TestUnreleasedLevel(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content string
+		want    semverutil.ChangeLevel
+	}{
+		"added":      {"## [Unreleased]\n\n### Added\n\n- a new knob\n", semverutil.MinorChange},
+		"deprecated": {"## [Unreleased]\n### Deprecated\n- old knob\n", semverutil.MinorChange},
+		"changed":    {"## [Unreleased]\n### Changed\n- default replicas\n", semverutil.PatchChange},
+		"fixed":      {"## Unreleased\n### Fixed\n- probe path\n", semverutil.PatchChange},
+		"security":   {"## [Unreleased]\n### Security\n- bump base image\n", semverutil.PatchChange},
+		"removed":    {"## [Unreleased]\n### Removed\n- legacy ingress\n", semverutil.MajorChange},
+		"breaking":   {"## [Unreleased]\n### Breaking Changes\n- renamed values\n", semverutil.MajorChange},
+		"breaking entry": {
+			"## [Unreleased]\n### Changed\n- BREAKING: renamed values\n", semverutil.MajorChange,
+		},
+		"highest wins": {"## [Unreleased]\n### Fixed\n- x\n### Added\n- y\n", semverutil.MinorChange},
+		"empty unreleased": {
+			"# Changelog\n\n## [Unreleased]\n\n### Added\n\n## [1.0.0] - 2024-01-01\n### Removed\n- z\n", semverutil.NoChange,
+		},
+		"no unreleased":   {"# Changelog\n\n## 1.0.0 - 2024-01-01\n### Added\n- z\n", semverutil.NoChange},
+		"unknown section": {"## [Unreleased]\n### Notes\n- thanks\n", semverutil.NoChange},
+		"empty file":      {"", semverutil.NoChange},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := UnreleasedLevel([]byte(tc.content)); got != tc.want {
+				t.Fatalf("got %v want %v", got, tc.want)
+			}
+		})
+	}
+}
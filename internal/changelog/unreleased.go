@@ -0,0 +1,52 @@
+package changelog
+
+import (
+	"strings"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/semverutil"
+)
+
+// sectionLevels maps Keep a Changelog change types to the bump they imply.
+var sectionLevels = map[string]semverutil.ChangeLevel{
+	"added":      semverutil.MinorChange,
+	"deprecated": semverutil.MinorChange,
+	"changed":    semverutil.PatchChange,
+	"fixed":      semverutil.PatchChange,
+	"security":   semverutil.PatchChange,
+	"removed":    semverutil.MajorChange,
+}
+
+// UnreleasedLevel infers a bump level from the "Unreleased" section of a Keep a Changelog
+// style file (https://keepachangelog.com): Added and Deprecated entries imply a minor bump,
+// Changed, Fixed, and Security a patch, and Removed (or any "Breaking" heading or entry
+// marked BREAKING) a major one.
+//
+// Only `###` subsections with at least one entry count. A missing or empty Unreleased
+// section, or unknown subsections, yield NoChange.
+func UnreleasedLevel(b []byte) semverutil.ChangeLevel {
+	lvl := semverutil.NoChange
+	inUnreleased := false
+	section := semverutil.NoChange
+	for _, line := range strings.Split(string(b), "\n") {
+		trim := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trim, "## "):
+			title := strings.Trim(strings.TrimSpace(trim[3:]), "[]")
+			inUnreleased = strings.EqualFold(title, "unreleased")
+			section = semverutil.NoChange
+		case !inUnreleased:
+		case strings.HasPrefix(trim, "### "):
+			title := strings.ToLower(strings.TrimSpace(trim[4:]))
+			section = sectionLevels[title]
+			if strings.Contains(title, "breaking") {
+				section = semverutil.MajorChange
+			}
+		case trim != "" && section != semverutil.NoChange:
+			lvl = semverutil.Max(lvl, section)
+			if strings.Contains(trim, "BREAKING") {
+				lvl = semverutil.MajorChange
+			}
+		}
+	}
+	return lvl
+}
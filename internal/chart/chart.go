@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	yaml "github.com/goccy/go-yaml"
 	"github.com/joejulian/helm-chart-bumper-action/internal/semverutil"
@@ -12,6 +14,7 @@ import (
 
 type Dependency struct {
 	Name       string `yaml:"name"`
+	Alias      string `yaml:"alias"`
 	Version    string `yaml:"version"`
 	Repository string `yaml:"repository"`
 }
@@ -43,23 +46,73 @@ func ReadChartYAML(chartDir string) ([]byte, error) {
 // ComputeChangeLevel determines the bump level using your rules based on changes in:
 // - appVersion
 // - dependency versions (by name)
+// - dependency repositories (by name); a moved repository is at least a patch change
 func ComputeChangeLevel(base, cur Meta) semverutil.ChangeLevel {
 	lvl := semverutil.Compare(base.AppVersion, cur.AppVersion)
 
-	baseDeps := map[string]string{}
+	baseDeps := map[string]Dependency{}
 	for _, d := range base.Dependencies {
-		baseDeps[d.Name] = d.Version
+		baseDeps[d.Name] = d
 	}
 	for _, d := range cur.Dependencies {
-		if old, ok := baseDeps[d.Name]; ok {
-			lvl = semverutil.Max(lvl, semverutil.Compare(old, d.Version))
+		old, ok := baseDeps[d.Name]
+		if !ok {
+			continue
+		}
+		lvl = semverutil.Max(lvl, semverutil.Compare(old.Version, d.Version))
+		if repositoryChanged(old, d) {
+			lvl = semverutil.Max(lvl, semverutil.PatchChange)
 		}
 	}
 	return lvl
 }
 
+// repositoryChanged reports whether a dependency moved to a different repository URL,
+// ignoring surrounding whitespace and trailing slashes.
+func repositoryChanged(old, cur Dependency) bool {
+	norm := func(u string) string { return strings.TrimRight(strings.TrimSpace(u), "/") }
+	return norm(old.Repository) != norm(cur.Repository)
+}
+
+// DescribeChanges lists the appVersion, dependency version, and dependency repository changes
+// from base to cur as human-readable lines (e.g. "appVersion: 1.2.3 -> 1.3.0"), in Chart.yaml
+// order.
+func DescribeChanges(base, cur Meta) []string {
+	var out []string
+	if base.AppVersion != cur.AppVersion {
+		out = append(out, fmt.Sprintf("appVersion: %s -> %s", base.AppVersion, cur.AppVersion))
+	}
+	baseDeps := map[string]Dependency{}
+	for _, d := range base.Dependencies {
+		baseDeps[d.Name] = d
+	}
+	for _, d := range cur.Dependencies {
+		old, ok := baseDeps[d.Name]
+		if !ok {
+			continue
+		}
+		if old.Version != d.Version {
+			out = append(out, fmt.Sprintf("dependency %s: %s -> %s", d.Name, old.Version, d.Version))
+		}
+		if repositoryChanged(old, d) {
+			out = append(out, fmt.Sprintf("dependency %s repository: %s -> %s", d.Name, old.Repository, d.Repository))
+		}
+	}
+	return out
+}
+
 // ApplyChartVersionBump sets $.version in Chart.yaml AST.
 func ApplyChartVersionBump(ast *yamlutil.File, lvl semverutil.ChangeLevel) (bool, error) {
+	return applyVersion(ast, lvl, semverutil.BumpChartVersion)
+}
+
+// ApplyRCVersionBump sets $.version in Chart.yaml AST using the release-candidate
+// workflow (see semverutil.BumpRCVersion).
+func ApplyRCVersionBump(ast *yamlutil.File, lvl semverutil.ChangeLevel) (bool, error) {
+	return applyVersion(ast, lvl, semverutil.BumpRCVersion)
+}
+
+func applyVersion(ast *yamlutil.File, lvl semverutil.ChangeLevel, bump func(string, semverutil.ChangeLevel) (string, error)) (bool, error) {
 	curVer, ok, err := yamlutil.GetString(ast, "$.version")
 	if err != nil {
 		return false, err
@@ -67,7 +120,7 @@ func ApplyChartVersionBump(ast *yamlutil.File, lvl semverutil.ChangeLevel) (bool
 	if !ok {
 		return false, fmt.Errorf("Chart.yaml missing version")
 	}
-	newVer, err := semverutil.BumpChartVersion(curVer, lvl)
+	newVer, err := bump(curVer, lvl)
 	if err != nil {
 		return false, err
 	}
@@ -76,3 +129,66 @@ func ApplyChartVersionBump(ast *yamlutil.File, lvl semverutil.ChangeLevel) (bool
 	}
 	return yamlutil.SetString(ast, "$.version", newVer)
 }
+
+// SubchartKeys returns the top-level values keys that configure m's subcharts: each
+// dependency's alias, or its name if it has none.
+func (m Meta) SubchartKeys() []string {
+	keys := make([]string, 0, len(m.Dependencies))
+	for _, d := range m.Dependencies {
+		if d.Alias != "" {
+			keys = append(keys, d.Alias)
+		} else {
+			keys = append(keys, d.Name)
+		}
+	}
+	return keys
+}
+
+// PropagateGlobal copies a value written at a `$.global.<rest>` path into subchart override
+// sections that reference it: every key K in subcharts whose `$.K.<rest>` currently holds
+// oldValue is set to newValue. If image is set and `$.K.<rest>` has a `repository` sibling,
+// that repository must name image too, so an override of another image that happens to hold
+// the same value is left alone. It returns the paths it updated. Paths outside `$.global.`
+// are left alone.
+func PropagateGlobal(ast *yamlutil.File, subcharts []string, image, globalPath, oldValue, newValue string) ([]string, error) {
+	rest, ok := strings.CutPrefix(globalPath, "$.global.")
+	if !ok || oldValue == newValue {
+		return nil, nil
+	}
+	keys, ok := yamlutil.MapKeys(ast, "$")
+	if !ok {
+		return nil, nil
+	}
+
+	var updated []string
+	for _, k := range keys {
+		if k == "global" || !slices.Contains(subcharts, k) {
+			continue
+		}
+		p := "$." + yamlutil.QuoteKey(k) + "." + rest
+		cur, ok, _ := yamlutil.GetString(ast, p)
+		if !ok || cur != oldValue {
+			continue
+		}
+		if i := strings.LastIndex(rest, "."); i >= 0 && image != "" {
+			sibling := "$." + yamlutil.QuoteKey(k) + "." + rest[:i] + ".repository"
+			if repo, ok, _ := yamlutil.GetString(ast, sibling); ok && !sameRepository(repo, image) {
+				continue
+			}
+		}
+		changed, err := yamlutil.SetString(ast, p, newValue)
+		if err != nil {
+			return updated, fmt.Errorf("propagate %s to %s: %w", globalPath, p, err)
+		}
+		if changed {
+			updated = append(updated, p)
+		}
+	}
+	return updated, nil
+}
+
+// sameRepository reports whether repo, read from values, names image. Values often keep the
+// registry host in a separate key, so repo may omit it.
+func sameRepository(repo, image string) bool {
+	return repo == image || strings.HasSuffix(image, "/"+repo)
+}
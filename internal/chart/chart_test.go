@@ -3,6 +3,7 @@ package chart
 import (
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/joejulian/helm-chart-bumper-action/internal/semverutil"
@@ -46,6 +47,33 @@ func TestComputeChangeLevel_UsesMaxOfAppVersionAndDeps(t *testing.T) {
 	}
 }
 
+func TestComputeChangeLevel_RepositoryChange(t *testing.T) {
+	base := Meta{AppVersion: "1.2.3", Dependencies: []Dependency{{Name: "redis", Version: "19.0.0", Repository: "https://charts.example.com"}}}
+	cur := Meta{AppVersion: "1.2.3", Dependencies: []Dependency{{Name: "redis", Version: "19.0.0", Repository: "oci://registry.example.com/charts"}}}
+	if got := ComputeChangeLevel(base, cur); got != semverutil.PatchChange {
+		t.Fatalf("got %v want %v", got, semverutil.PatchChange)
+	}
+	want := []string{"dependency redis repository: https://charts.example.com -> oci://registry.example.com/charts"}
+	if got := DescribeChanges(base, cur); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	cur.Dependencies[0].Repository = "https://charts.example.com/"
+	if got := ComputeChangeLevel(base, cur); got != semverutil.NoChange {
+		t.Fatalf("trailing slash: got %v want %v", got, semverutil.NoChange)
+	}
+}
+
+func TestDescribeChanges(t *testing.T) {
+	base := Meta{AppVersion: "1.2.3", Dependencies: []Dependency{{Name: "redis", Version: "19.0.0"}, {Name: "pg", Version: "1.0.0"}}}
+	cur := Meta{AppVersion: "1.3.0", Dependencies: []Dependency{{Name: "redis", Version: "20.0.0"}, {Name: "pg", Version: "1.0.0"}}}
+	got := DescribeChanges(base, cur)
+	want := []string{"appVersion: 1.2.3 -> 1.3.0", "dependency redis: 19.0.0 -> 20.0.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
+
 func TestApplyChartVersionBump(t *testing.T) {
 	ast, err := yamlutil.ParseBytes([]byte("name: x\nversion: 1.2.3\nappVersion: 1.2.3\n"))
 	if err != nil {
@@ -66,3 +94,107 @@ func TestApplyChartVersionBump(t *testing.T) {
 		t.Fatalf("version got %q want %q", ver, "1.2.4")
 	}
 }
+
+func TestApplyRCVersionBump(t *testing.T) {
+	ast, err := yamlutil.ParseBytes([]byte("name: x\nversion: 1.2.3\nappVersion: 1.2.3\n"))
+	if err != nil {
+		t.Fatalf("ParseBytes: %v", err)
+	}
+	if _, err := ApplyRCVersionBump(ast, semverutil.MinorChange); err != nil {
+		t.Fatalf("ApplyRCVersionBump: %v", err)
+	}
+	ver, _, _ := yamlutil.GetString(ast, "$.version")
+	if ver != "1.3.0-rc.1" {
+		t.Fatalf("version got %q want %q", ver, "1.3.0-rc.1")
+	}
+}
+
+func TestPropagateGlobal(t *testing.T) {
+	in := []byte(`global:
+  image:
+    tag: 1.2.3
+frontend:
+  image:
+    tag: 1.2.3
+backend:
+  image:
+    tag: 1.2.3
+pinned:
+  image:
+    tag: 0.9.0
+`)
+	ast, err := yamlutil.ParseBytes(in)
+	if err != nil {
+		t.Fatalf("ParseBytes: %v", err)
+	}
+	if _, err := yamlutil.SetString(ast, "$.global.image.tag", "1.3.0"); err != nil {
+		t.Fatalf("SetString: %v", err)
+	}
+	got, err := PropagateGlobal(ast, []string{"frontend", "backend", "pinned"}, "", "$.global.image.tag", "1.2.3", "1.3.0")
+	if err != nil {
+		t.Fatalf("PropagateGlobal: %v", err)
+	}
+	want := []string{"$.frontend.image.tag", "$.backend.image.tag"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("updated got %v want %v", got, want)
+	}
+	for p, v := range map[string]string{
+		"$.global.image.tag":   "1.3.0",
+		"$.frontend.image.tag": "1.3.0",
+		"$.backend.image.tag":  "1.3.0",
+		"$.pinned.image.tag":   "0.9.0",
+	} {
+		if cur, _, _ := yamlutil.GetString(ast, p); cur != v {
+			t.Fatalf("%s got %q want %q", p, cur, v)
+		}
+	}
+}
+
+func TestPropagateGlobal_OnlyMatchingSubcharts(t *testing.T) {
+	in := []byte(`global:
+  image:
+    tag: 1.2.3
+frontend:
+  image:
+    repository: org/app
+    tag: 1.2.3
+sidecar:
+  image:
+    repository: org/proxy
+    tag: 1.2.3
+migrations:
+  image:
+    tag: 1.2.3
+`)
+	ast, err := yamlutil.ParseBytes(in)
+	if err != nil {
+		t.Fatalf("ParseBytes: %v", err)
+	}
+	// migrations holds the same value but is not a subchart; sidecar is one, for another image.
+	got, err := PropagateGlobal(ast, []string{"frontend", "sidecar"}, "ghcr.io/org/app", "$.global.image.tag", "1.2.3", "1.3.0")
+	if err != nil {
+		t.Fatalf("PropagateGlobal: %v", err)
+	}
+	if want := []string{"$.frontend.image.tag"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("updated got %v want %v", got, want)
+	}
+	for p, v := range map[string]string{
+		"$.frontend.image.tag":   "1.3.0",
+		"$.sidecar.image.tag":    "1.2.3",
+		"$.migrations.image.tag": "1.2.3",
+	} {
+		if cur, _, _ := yamlutil.GetString(ast, p); cur != v {
+			t.Fatalf("%s got %q want %q", p, cur, v)
+		}
+	}
+}
+
+func TestSubchartKeys(t *testing.T) {
+	m, err := LoadMeta([]byte("name: app\nversion: 0.1.0\ndependencies:\n- name: redis\n  version: 1.0.0\n- name: postgresql\n  alias: db\n  version: 2.0.0\n"))
+	if err != nil {
+		t.Fatalf("LoadMeta: %v", err)
+	}
+	if got, want := m.SubchartKeys(), []string{"redis", "db"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+}
@@ -0,0 +1,83 @@
+package directives
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml "github.com/goccy/go-yaml"
+)
+
+// ConfigFileName is the directive config file looked up in a chart directory.
+const ConfigFileName = ".chart-bumper.yaml"
+
+// LoadConfig reads a directive config file. Each entry under `directives` names a file
+// (relative to chartDir) and a YAML path, plus the same fields as an inline `# bump:`
+// directive:
+//
+//	directives:
+//	  - file: values.yaml
+//	    path: $.image.tag
+//	    image: ghcr.io/org/app
+//	    constraint: ^1.0.0
+//
+// The returned directives have FilePath joined onto chartDir and a Line of 0.
+func LoadConfig(path, chartDir string) ([]ImageDirective, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Directives []map[string]any `yaml:"directives"`
+	}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	out := make([]ImageDirective, 0, len(cfg.Directives))
+	for i, entry := range cfg.Directives {
+		kv := map[string]string{}
+		for k, v := range entry {
+			if v == nil {
+				continue
+			}
+			kv[k] = strings.TrimSpace(fmt.Sprint(v))
+		}
+		file := kv["file"]
+		delete(kv, "file")
+		if file == "" || kv["path"] == "" {
+			return nil, malformedf(path, 0, "directive %d: file and path are required", i+1)
+		}
+		d, err := directiveFromFields(kv)
+		if err != nil {
+			return nil, &DirectiveError{Path: path, Err: fmt.Errorf("directive %d: %w", i+1, err)}
+		}
+		d.FilePath = filepath.Join(chartDir, file)
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+// MergeDirectives combines the inline directives of a file with config directives for the
+// same file. An inline directive wins over a config directive with the same YAMLPath.
+func MergeDirectives(inline, config []ImageDirective) []ImageDirective {
+	seen := map[string]bool{}
+	for _, d := range inline {
+		seen[d.FilePath+"\x00"+d.YAMLPath] = true
+	}
+	out := append([]ImageDirective(nil), inline...)
+	for _, d := range config {
+		if !seen[d.FilePath+"\x00"+d.YAMLPath] {
+			out = append(out, d)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].FilePath != out[j].FilePath {
+			return out[i].FilePath < out[j].FilePath
+		}
+		return out[i].Line < out[j].Line
+	})
+	return out
+}
@@ -2,15 +2,21 @@ package directives
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/joejulian/helm-chart-bumper-action/internal/imageref"
 	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+	"github.com/joejulian/helm-chart-bumper-action/internal/selectexpr"
+	"github.com/joejulian/helm-chart-bumper-action/internal/yamlutil"
 
 	"go.uber.org/zap"
 )
@@ -28,6 +34,14 @@ import (
 //
 // Example YAMLPath: $.image.tag or $.containers[0].image.tag
 //
+// A `path=` argument sets YAMLPath explicitly instead; the directive then stands alone and
+// may use [*] to target every element of a sequence (see yamlutil.ExpandPath). It may also be
+// an RFC 6901 JSON Pointer such as /spec/image, which the caller resolves against the
+// document (see yamlutil.NormalizePath).
+//
+// Directives may also be declared outside the file in a config file (see LoadConfig); those
+// have a Line of 0.
+//
 // NOTE: This is not a full YAML parser. It is intentionally strict and deterministic.
 // If it can't unambiguously target a scalar assignment, it returns an error.
 type ImageDirective struct {
@@ -37,18 +51,132 @@ type ImageDirective struct {
 	YAMLPath    string
 	CurrentText string
 
-	Image          string
-	Strategy       string
-	Constraint     string
-	TagRegex       string
+	Image           string
+	Strategy        string
+	Constraint      string
+	TagRegex        string
 	AllowPrerelease bool
-	Platform       string
+	Platform        string
+	// Label is the image config label read by strategy=label.
+	Label string
+	// PreferStableOnGraduation picks the stable release of a prerelease current value once it
+	// exists, over any higher prerelease.
+	PreferStableOnGraduation bool
+	// SelectExpr filters semver candidates with a selectexpr expression.
+	SelectExpr string
+	// SelectOrder ranks the candidates SelectExpr keeps with a selectexpr expression.
+	SelectOrder string
+	// MinAge skips tags whose image was created less than MinAge ago.
+	MinAge time.Duration
+	// Channel and Suffix restrict semver candidates to one release line, e.g. channel=16
+	// suffix=-alpine for postgres' 16.x-alpine tags.
+	Channel string
+	Suffix  string
+	// Variant selects tags like 1.21.4-alpine3.18 for variant=alpine, ordered by the leading
+	// version and then by the number after the variant.
+	Variant string
+	// Sync names a Chart.yaml field to keep in sync with the resolved value. Only
+	// "appVersion" is supported.
+	Sync string
+	// Format controls how strategy=digest writes its result. "digest-ref" writes
+	// image@sha256:... instead of the bare digest, or image:tag@sha256:... when the tag was
+	// read from the targeted reference.
+	Format string
+	// WriteTransform is a text/template over WriteVars producing the string written instead
+	// of the resolved value.
+	WriteTransform string
+	// Group names a set of directives expected to resolve to the same value (e.g. the server
+	// and agent images of one release).
+	Group string
+	// Value is the tag strategy=exact verifies and writes.
+	Value string
+	// Source names where strategy=semver, regex, literal, or pinned-ref lists versions from:
+	// the image's registry (the default), oci for a Helm chart's versions in an OCI registry,
+	// or an alternate source such as github-releases.
+	Source string
+	// Repo identifies the project at Source, e.g. org/proj for github-releases.
+	Repo string
+	// TargetLine is set for directives in Helm templates (see ScanTemplateForImageDirectives)
+	// to the line holding the value; YAMLPath is then empty.
+	TargetLine int
 }
 
 var (
 	reDirective = regexp.MustCompile(`^\s*#\s*bump:\s*(.*)$`)
+	// reContinuation continues the directive on the line above, for directives too long for
+	// one comment line.
+	reContinuation = regexp.MustCompile(`^\s*#\s*bump-cont:\s*(.*)$`)
+	// reBlockScalar matches a literal or folded block scalar header (|, >-, |2, ...) with an
+	// optional trailing comment.
+	reBlockScalar = regexp.MustCompile(`^[|>][-+1-9]*(\s+#.*)?$`)
 )
 
+// newLineScanner returns a scanner over r's lines, split at "\n", "\r\n", or a lone "\r",
+// without their line endings.
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	s := bufio.NewScanner(r)
+	// Allow longer lines (some values files can be large). 1MB cap.
+	buf := make([]byte, 0, 64*1024)
+	s.Buffer(buf, 1024*1024)
+	s.Split(scanLines)
+	return s
+}
+
+// scanLines is bufio.ScanLines, also ending lines at a lone "\r".
+func scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		// A "\r" may be the first half of a "\r\n" not yet read.
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// rawDirective is a `# bump:` line's arguments, with those of any `# bump-cont:` lines that
+// directly follow it appended.
+type rawDirective struct {
+	line int
+	args string
+}
+
+// continueDirective appends the continuation on lineNo to open, which is nil if the line
+// above did not start or continue a directive.
+func continueDirective(path string, lineNo int, open *rawDirective, args string) error {
+	if open == nil {
+		return malformedf(path, lineNo, "'# bump-cont:' must directly follow a '# bump:' line or another continuation")
+	}
+	open.args += " " + args
+	return nil
+}
+
+// parse parses the accumulated arguments into a directive found in path.
+func (r *rawDirective) parse(path string) (ImageDirective, error) {
+	d, err := parseDirectiveArgs(r.args)
+	if err != nil {
+		return ImageDirective{}, &DirectiveError{Path: path, Line: r.line, Err: err}
+	}
+	d.FilePath = path
+	d.Line = r.line
+	return d, nil
+}
+
 // ScanFileForImageDirectives reads a YAML file as text and returns directives.
 func ScanFileForImageDirectives(ctx context.Context, path string) ([]ImageDirective, error) {
 	log := logutil.FromContext(ctx).With(zap.String("func", "directives.ScanFileForImageDirectives"), zap.String("path", path))
@@ -59,31 +187,57 @@ func ScanFileForImageDirectives(ctx context.Context, path string) ([]ImageDirect
 	}
 	defer f.Close()
 
-	s := bufio.NewScanner(f)
-	// Allow longer lines (some values files can be large). 1MB cap.
-	buf := make([]byte, 0, 64*1024)
-	s.Buffer(buf, 1024*1024)
+	s := newLineScanner(f)
 
 	var out []ImageDirective
 	var pending *ImageDirective
+	var open *rawDirective
+	finish := func() error {
+		d, err := open.parse(path)
+		open = nil
+		if err != nil {
+			return err
+		}
+		if d.YAMLPath != "" {
+			// An explicit path= target does not depend on the following line.
+			out = append(out, d)
+			return nil
+		}
+		pending = &d
+		return nil
+	}
 
 	// indentation-driven path tracking
 	stack := newPathStack()
+	// blockIndent is the indentation a block scalar's body lines exceed, or -1 outside one.
+	blockIndent := -1
 
 	lineNo := 0
 	for s.Scan() {
 		lineNo++
 		line := s.Text()
 
-		m := reDirective.FindStringSubmatch(line)
-		if m != nil {
-			d, err := parseDirectiveArgs(m[1])
-			if err != nil {
-				return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		// A block scalar's body is text, even where it looks like YAML, a comment, or a tab.
+		if blockIndent >= 0 {
+			if strings.TrimSpace(line) == "" || len(line)-len(strings.TrimLeft(line, " ")) > blockIndent {
+				continue
 			}
-			d.FilePath = path
-			d.Line = lineNo
-			pending = &d
+			blockIndent = -1
+		}
+
+		if m := reContinuation.FindStringSubmatch(line); m != nil {
+			if err := continueDirective(path, lineNo, open, m[1]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if open != nil {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+		}
+		if m := reDirective.FindStringSubmatch(line); m != nil {
+			open = &rawDirective{line: lineNo, args: m[1]}
 			continue
 		}
 
@@ -95,14 +249,31 @@ func ScanFileForImageDirectives(ctx context.Context, path string) ([]ImageDirect
 		// Update stack based on this YAML content line.
 		info, err := parseYAMLContentLine(line)
 		if err != nil {
-			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			return nil, &DirectiveError{Path: path, Line: lineNo, Err: err}
 		}
 		stack.applyLine(info)
+		if info.blockScalar {
+			blockIndent = info.indent
+			if info.isListItem && info.key != "" {
+				blockIndent += 2
+			}
+		}
 
 		// If we have a pending directive, it applies here.
 		if pending != nil {
+			if info.flow != "" {
+				return nil, flowStyleError(path, lineNo, info)
+			}
 			if !info.isScalarKV {
-				return nil, fmt.Errorf("%s:%d: bump directive must precede a scalar key (e.g. tag: \"1.2.3\"), but found a non-scalar line", path, lineNo)
+				return nil, malformedf(path, lineNo, "bump directive must precede a scalar key (e.g. tag: \"1.2.3\"), but found a non-scalar line")
+			}
+			// Setting the scalar would replace an alias with a literal or drop an anchor that
+			// other nodes reference; refuse rather than silently break the document.
+			if strings.HasPrefix(info.valueText, "*") {
+				return nil, malformedf(path, lineNo, "bump directive targets %q, whose value is a YAML alias (%s); put the directive above the anchored value instead", info.key, info.valueText)
+			}
+			if strings.HasPrefix(info.valueText, "&") {
+				return nil, malformedf(path, lineNo, "bump directive targets %q, whose value defines a YAML anchor (%s); updating it would change every alias", info.key, strings.Fields(info.valueText)[0])
 			}
 			pending.Key = info.key
 			pending.CurrentText = info.valueText
@@ -114,8 +285,13 @@ func ScanFileForImageDirectives(ctx context.Context, path string) ([]ImageDirect
 	if err := s.Err(); err != nil {
 		return nil, err
 	}
+	if open != nil {
+		if err := finish(); err != nil {
+			return nil, err
+		}
+	}
 	if pending != nil {
-		return nil, fmt.Errorf("%s:%d: bump directive had no following YAML key", pending.FilePath, pending.Line)
+		return nil, malformedf(pending.FilePath, pending.Line, "bump directive had no following YAML key")
 	}
 
 	// stable order
@@ -129,7 +305,8 @@ func ScanFileForImageDirectives(ctx context.Context, path string) ([]ImageDirect
 }
 
 // parseDirectiveArgs parses `k=v` tokens separated by spaces.
-// Values may be quoted with single or double quotes.
+// Values may be quoted with single or double quotes, and may reference environment
+// variables (see expandEnv).
 func parseDirectiveArgs(argStr string) (ImageDirective, error) {
 	args, err := splitArgs(argStr)
 	if err != nil {
@@ -142,20 +319,70 @@ func parseDirectiveArgs(argStr string) (ImageDirective, error) {
 			return ImageDirective{}, fmt.Errorf("invalid directive token %q (expected key=value)", a)
 		}
 		k = strings.TrimSpace(k)
-		v = strings.TrimSpace(v)
+		if v, err = expandEnv(strings.TrimSpace(v)); err != nil {
+			return ImageDirective{}, fmt.Errorf("%s: %w", k, err)
+		}
 		if k == "" || v == "" {
 			return ImageDirective{}, fmt.Errorf("invalid directive token %q (empty key or value)", a)
 		}
 		kv[k] = v
 	}
+	return directiveFromFields(kv)
+}
 
-	img := kv["image"]
-	if img == "" {
-		return ImageDirective{}, fmt.Errorf("missing required directive field: image=")
+var reEnvName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// expandEnv replaces ${NAME} with the environment variable NAME, which must be set, and
+// ${NAME:-default} with NAME or, if NAME is unset or empty, default. Only the braced form is
+// expanded, so a bare $ (a regex anchor) is left alone; $${ writes a literal ${.
+func expandEnv(s string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i])
+			b.WriteString("{")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s)
+		}
+		ref := s[i+2 : i+end]
+		name, def, hasDef := strings.Cut(ref, ":-")
+		if !reEnvName.MatchString(name) {
+			return "", fmt.Errorf("invalid environment variable reference ${%s}", ref)
+		}
+		v, set := os.LookupEnv(name)
+		switch {
+		case hasDef && v == "":
+			v = def
+		case !set:
+			return "", fmt.Errorf("environment variable %s is not set (use ${%s:-default} for a fallback)", name, name)
+		}
+		b.WriteString(s[:i])
+		b.WriteString(v)
+		s = s[i+end+1:]
 	}
+}
+
+// directiveFromFields validates directive fields keyed by their argument names (image,
+// strategy, ...) and builds the directive. It is shared by inline directives and config
+// file entries.
+func directiveFromFields(kv map[string]string) (ImageDirective, error) {
+	// image= may be omitted when the target holds a full reference (ghcr.io/org/app:1.2.3);
+	// the repository is then taken from the value when the directive is applied.
+	img := kv["image"]
 	// Require full path; no normalization.
-	if !strings.Contains(img, "/") || !strings.Contains(img, ".") {
-		return ImageDirective{}, fmt.Errorf("image must be a fully-qualified repository (e.g. ghcr.io/org/app); got %q", img)
+	if img != "" {
+		if err := imageref.ValidateRepository(img); err != nil {
+			return ImageDirective{}, fmt.Errorf("image must be a fully-qualified repository (e.g. ghcr.io/org/app or localhost:5000/app): %w", err)
+		}
 	}
 
 	strategy := kv["strategy"]
@@ -163,6 +390,98 @@ func parseDirectiveArgs(argStr string) (ImageDirective, error) {
 		strategy = "semver"
 	}
 
+	if strings.EqualFold(strategy, "label") && kv["label"] == "" {
+		return ImageDirective{}, fmt.Errorf("strategy=label requires label=<name>")
+	}
+	if strings.EqualFold(strategy, "exact") != (kv["value"] != "") {
+		return ImageDirective{}, fmt.Errorf("strategy=exact requires value=<tag>, and value= is only valid with strategy=exact")
+	}
+
+	if kv["source"] == "oci" {
+		// The chart repository comes from image= or the enclosing Chart.yaml dependency.
+		switch strings.ToLower(strategy) {
+		case "semver", "regex", "literal":
+		default:
+			return ImageDirective{}, fmt.Errorf("source=oci is only valid with strategy=semver, regex, or literal")
+		}
+		if kv["repo"] != "" {
+			return ImageDirective{}, fmt.Errorf("repo= is not valid with source=oci; name the chart repository with image= or an oci:// dependency repository")
+		}
+	} else if src := kv["source"]; src != "" && src != "registry" {
+		switch strings.ToLower(strategy) {
+		case "semver", "regex", "literal", "pinned-ref":
+		default:
+			return ImageDirective{}, fmt.Errorf("source=%s is only valid with strategy=semver, regex, literal, or pinned-ref", src)
+		}
+		if kv["repo"] == "" {
+			return ImageDirective{}, fmt.Errorf("source=%s requires repo=<project>", src)
+		}
+	} else if kv["repo"] != "" {
+		return ImageDirective{}, fmt.Errorf("repo= is only valid with a non-registry source=")
+	}
+
+	if e := kv["selectExpr"]; e != "" {
+		if _, err := selectexpr.Compile(e); err != nil {
+			return ImageDirective{}, fmt.Errorf("selectExpr: %w", err)
+		}
+	}
+	if e := kv["selectOrder"]; e != "" {
+		if kv["selectExpr"] == "" {
+			return ImageDirective{}, fmt.Errorf("selectOrder requires selectExpr")
+		}
+		if _, err := selectexpr.Compile(e); err != nil {
+			return ImageDirective{}, fmt.Errorf("selectOrder: %w", err)
+		}
+	}
+
+	if kv["channel"] != "" || kv["suffix"] != "" || kv["variant"] != "" {
+		switch strings.ToLower(strategy) {
+		case "semver", "pinned-ref":
+		default:
+			return ImageDirective{}, fmt.Errorf("channel, suffix, and variant are only valid with strategy=semver or pinned-ref")
+		}
+	}
+	if kv["variant"] != "" && kv["suffix"] != "" {
+		return ImageDirective{}, fmt.Errorf("variant and suffix cannot be combined")
+	}
+
+	var minAge time.Duration
+	if a := kv["minAge"]; a != "" {
+		switch strings.ToLower(strategy) {
+		case "semver", "regex", "literal", "pinned-ref":
+		default:
+			return ImageDirective{}, fmt.Errorf("minAge is only valid with strategy=semver, regex, literal, or pinned-ref")
+		}
+		d, err := selectexpr.ParseDuration(a)
+		if err != nil {
+			return ImageDirective{}, fmt.Errorf("minAge: %w", err)
+		}
+		minAge = d
+	}
+
+	if f := kv["format"]; f != "" {
+		if f != "digest-ref" {
+			return ImageDirective{}, fmt.Errorf("unsupported format %q (only format=digest-ref is supported)", f)
+		}
+		if !strings.EqualFold(strategy, "digest") {
+			return ImageDirective{}, fmt.Errorf("format=digest-ref requires strategy=digest")
+		}
+	}
+
+	if p := kv["path"]; p != "" && !strings.HasPrefix(p, "$.") && !strings.HasPrefix(p, "/") {
+		return ImageDirective{}, fmt.Errorf("path must be a YAML path starting with $. (e.g. $.spec.containers[*].image) or a JSON pointer starting with / (e.g. /spec/image); got %q", p)
+	}
+
+	if wt := kv["writeTransform"]; wt != "" {
+		if err := validateWriteTransform(wt); err != nil {
+			return ImageDirective{}, err
+		}
+	}
+
+	if sync := kv["sync"]; sync != "" && sync != "appVersion" {
+		return ImageDirective{}, fmt.Errorf("unsupported sync target %q (only sync=appVersion is supported)", sync)
+	}
+
 	allowPrerelease := false
 	if s, ok := kv["allowPrerelease"]; ok {
 		b, err := strconv.ParseBool(s)
@@ -172,6 +491,15 @@ func parseDirectiveArgs(argStr string) (ImageDirective, error) {
 		allowPrerelease = b
 	}
 
+	preferStable := false
+	if s, ok := kv["preferStableOnGraduation"]; ok {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return ImageDirective{}, fmt.Errorf("preferStableOnGraduation must be true/false, got %q", s)
+		}
+		preferStable = b
+	}
+
 	return ImageDirective{
 		Image:           img,
 		Strategy:        strategy,
@@ -179,6 +507,23 @@ func parseDirectiveArgs(argStr string) (ImageDirective, error) {
 		TagRegex:        kv["tagRegex"],
 		AllowPrerelease: allowPrerelease,
 		Platform:        kv["platform"],
+		Label:           kv["label"],
+		Sync:            kv["sync"],
+		Group:           kv["group"],
+		Format:          kv["format"],
+		WriteTransform:  kv["writeTransform"],
+		YAMLPath:        kv["path"],
+		SelectExpr:      kv["selectExpr"],
+		SelectOrder:     kv["selectOrder"],
+		MinAge:          minAge,
+		Channel:         kv["channel"],
+		Suffix:          kv["suffix"],
+		Variant:         kv["variant"],
+		Value:           kv["value"],
+		Source:          kv["source"],
+		Repo:            kv["repo"],
+
+		PreferStableOnGraduation: preferStable,
 	}, nil
 }
 
@@ -254,9 +599,25 @@ type lineInfo struct {
 	valueText  string
 	isScalarKV bool
 	isMapStart bool
+	// flow is "mapping" or "sequence" when the line's value (or list item) is a single-line
+	// flow collection like {a: 1} or [a, b]. Such values are not scalars.
+	flow string
+	// blockScalar is set when the value is a | or > block scalar whose body follows.
+	blockScalar bool
 	// if true, this line indicates a list item but has no inline key
 }
 
+// flowKind reports whether an unquoted value starts a flow mapping or sequence.
+func flowKind(val string) string {
+	switch {
+	case strings.HasPrefix(val, "{"):
+		return "mapping"
+	case strings.HasPrefix(val, "["):
+		return "sequence"
+	}
+	return ""
+}
+
 func parseYAMLContentLine(line string) (lineInfo, error) {
 	indent := 0
 	for indent < len(line) && line[indent] == ' ' {
@@ -266,6 +627,11 @@ func parseYAMLContentLine(line string) (lineInfo, error) {
 	if trim == "" {
 		return lineInfo{}, nil
 	}
+	// YAML forbids tabs in the indentation of mapping and sequence lines, and counting them as
+	// spaces would compute a wrong path. Block scalar bodies never reach here.
+	if strings.Contains(line[:len(line)-len(strings.TrimLeft(line, " \t"))], "\t") {
+		return lineInfo{}, fmt.Errorf("YAML indentation uses tabs; convert to spaces")
+	}
 
 	// List item?
 	if strings.HasPrefix(strings.TrimLeft(line, " "), "-") {
@@ -278,36 +644,94 @@ func parseYAMLContentLine(line string) (lineInfo, error) {
 		if rest == "" {
 			return lineInfo{indent: indent, isListItem: true}, nil
 		}
+		if reBlockScalar.MatchString(rest) {
+			return lineInfo{indent: indent, isListItem: true, valueText: rest, blockScalar: true}, nil
+		}
+		// A flow collection item (- {name: a}) has no inline key of its own.
+		if f := flowKind(rest); f != "" {
+			return lineInfo{indent: indent, isListItem: true, valueText: rest, flow: f}, nil
+		}
 		// Inline mapping: - key: value
-		k, v, ok := strings.Cut(rest, ":")
+		key, val, ok := cutMappingKey(rest)
 		if ok {
-			key := strings.TrimSpace(k)
-			val := strings.TrimSpace(v)
 			if key == "" {
 				return lineInfo{}, fmt.Errorf("invalid list item mapping")
 			}
 			if val == "" {
 				return lineInfo{indent: indent, isListItem: true, key: key, isMapStart: true, isScalarKV: false}, nil
 			}
-			return lineInfo{indent: indent, isListItem: true, key: key, valueText: val, isScalarKV: true}, nil
+			if f := flowKind(val); f != "" {
+				return lineInfo{indent: indent, isListItem: true, key: key, valueText: val, flow: f}, nil
+			}
+			return lineInfo{indent: indent, isListItem: true, key: key, valueText: val, isScalarKV: true, blockScalar: reBlockScalar.MatchString(val)}, nil
 		}
 		return lineInfo{indent: indent, isListItem: true}, nil
 	}
 
 	// Map key
-	k, v, ok := strings.Cut(strings.TrimLeft(line, " "), ":")
+	key, val, ok := cutMappingKey(strings.TrimLeft(line, " "))
 	if !ok {
 		return lineInfo{}, fmt.Errorf("unsupported YAML line (expected key: value)")
 	}
-	key := strings.TrimSpace(k)
-	val := strings.TrimSpace(v)
 	if key == "" {
 		return lineInfo{}, fmt.Errorf("empty key")
 	}
 	if val == "" {
 		return lineInfo{indent: indent, key: key, isMapStart: true}, nil
 	}
-	return lineInfo{indent: indent, key: key, valueText: val, isScalarKV: true}, nil
+	if f := flowKind(val); f != "" {
+		return lineInfo{indent: indent, key: key, valueText: val, flow: f}, nil
+	}
+	return lineInfo{indent: indent, key: key, valueText: val, isScalarKV: true, blockScalar: reBlockScalar.MatchString(val)}, nil
+}
+
+// cutMappingKey splits a `key: value` line at the colon ending the key, trimming both sides.
+// A quoted key ("a.b": 1 or 'a.b': 1) may contain colons and is returned unquoted.
+func cutMappingKey(s string) (key, val string, ok bool) {
+	if s == "" || (s[0] != '"' && s[0] != '\'') {
+		k, v, ok := strings.Cut(s, ":")
+		return strings.TrimSpace(k), strings.TrimSpace(v), ok
+	}
+	var rest string
+	if s[0] == '"' {
+		q, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return "", "", false
+		}
+		key, _ = strconv.Unquote(q)
+		rest = s[len(q):]
+	} else {
+		// In single quotes, '' is an escaped quote.
+		end := 1
+		for ; end < len(s); end++ {
+			if s[end] == '\'' {
+				if end+1 < len(s) && s[end+1] == '\'' {
+					end++
+					continue
+				}
+				break
+			}
+		}
+		if end >= len(s) {
+			return "", "", false
+		}
+		key = strings.ReplaceAll(s[1:end], "''", "'")
+		rest = s[end+1:]
+	}
+	v, ok := strings.CutPrefix(strings.TrimLeft(rest, " "), ":")
+	if !ok || key == "" {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(v), true
+}
+
+// flowStyleError explains that a directive cannot target a flow collection line.
+func flowStyleError(path string, line int, info lineInfo) error {
+	what := "a list item"
+	if info.key != "" {
+		what = fmt.Sprintf("%q", info.key)
+	}
+	return malformedf(path, line, "bump directive targets %s, whose value is a YAML flow %s (%s); directives need a scalar on its own line, so rewrite it in block style (one `key: value` per line) and put the directive above the value to update", what, info.flow, info.valueText)
 }
 
 type stackStep struct {
@@ -328,13 +752,15 @@ func newPathStack() *pathStack {
 }
 
 func (ps *pathStack) applyLine(li lineInfo) {
+	// Popping clears the list index at this indent, which a sibling item continues from.
+	prev, hadPrev := ps.listIndexByIndent[li.indent]
 	// Pop to correct indent.
 	ps.popToIndent(li.indent)
 
 	if li.isListItem {
 		// increment list index at this indent
 		idx := 0
-		if prev, ok := ps.listIndexByIndent[li.indent]; ok {
+		if hadPrev {
 			idx = prev + 1
 		}
 		ps.listIndexByIndent[li.indent] = idx
@@ -391,7 +817,7 @@ func (ps *pathStack) currentPathWithLeaf(li lineInfo) string {
 		switch st.kind {
 		case "key":
 			b.WriteString(".")
-			b.WriteString(st.key)
+			b.WriteString(yamlutil.QuoteKey(st.key))
 		case "index":
 			b.WriteString("[")
 			b.WriteString(strconv.Itoa(st.index))
@@ -400,7 +826,7 @@ func (ps *pathStack) currentPathWithLeaf(li lineInfo) string {
 	}
 	if li.key != "" {
 		b.WriteString(".")
-		b.WriteString(li.key)
+		b.WriteString(yamlutil.QuoteKey(li.key))
 	}
 	return b.String()
 }
@@ -0,0 +1,379 @@
+package directives
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scan(t *testing.T, content string) ([]ImageDirective, error) {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "values.yaml")
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return ScanFileForImageDirectives(context.Background(), p)
+}
+
+func TestScanFileForImageDirectives(t *testing.T) {
+	got, err := scan(t, "image:\n  # bump: image=ghcr.io/org/app\n  tag: \"1.2.3\"\n")
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	if len(got) != 1 || got[0].YAMLPath != "$.image.tag" || got[0].Strategy != "semver" {
+		t.Fatalf("unexpected directives: %#v", got)
+	}
+}
+
+func TestScanFileForImageDirectives_CRLF(t *testing.T) {
+	got, err := scan(t, "image:\r\n  # bump: image=ghcr.io/org/app\r\n  tag: \"1.2.3\"\r\nsidecar:\r  # bump: image=ghcr.io/org/side\r  tag: 2.0.0\r")
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	if len(got) != 2 || got[0].YAMLPath != "$.image.tag" || got[0].CurrentText != `"1.2.3"` || got[1].YAMLPath != "$.sidecar.tag" || got[1].CurrentText != "2.0.0" || got[1].Line != 5 {
+		t.Fatalf("unexpected directives: %#v", got)
+	}
+}
+
+func TestScanFileForImageDirectives_Continuation(t *testing.T) {
+	got, err := scan(t, "image:\n  # bump: image=ghcr.io/org/app strategy=regex\n  # bump-cont: tagRegex=^v(\\d+\\.\\d+\\.\\d+)$\n  tag: v1.2.3\n")
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	if len(got) != 1 || got[0].YAMLPath != "$.image.tag" || got[0].Strategy != "regex" || got[0].TagRegex != `^v(\d+\.\d+\.\d+)$` || got[0].Line != 2 {
+		t.Fatalf("unexpected directives: %#v", got)
+	}
+
+	_, err = scan(t, "image:\n  # bump-cont: tagRegex=^v(.*)$\n  tag: v1.2.3\n")
+	var de *DirectiveError
+	if !errors.As(err, &de) || de.Line != 2 {
+		t.Fatalf("expected a DirectiveError on line 2 for an orphan continuation, got %v", err)
+	}
+}
+
+func TestScanFileForImageDirectives_EnvExpansion(t *testing.T) {
+	t.Setenv("BUMP_TEST_IMAGE", "ghcr.io/org/app")
+	t.Setenv("BUMP_TEST_EMPTY", "")
+	got, err := scan(t, "image:\n  # bump: image=${BUMP_TEST_IMAGE} constraint=\"${BUMP_TEST_EMPTY:-~1.2}\" strategy=regex tagRegex=^v(\\d+)$$${x}$\n  tag: v1\n")
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	if len(got) != 1 || got[0].Image != "ghcr.io/org/app" || got[0].Constraint != "~1.2" || got[0].TagRegex != `^v(\d+)$${x}$` {
+		t.Fatalf("unexpected directives: %#v", got)
+	}
+
+	_, err = scan(t, "image:\n  # bump: image=${BUMP_TEST_UNDEFINED}\n  tag: 1.2.3\n")
+	if !errors.Is(err, ErrMalformed) || !strings.Contains(err.Error(), "BUMP_TEST_UNDEFINED is not set") {
+		t.Fatalf("expected an undefined variable error, got %v", err)
+	}
+}
+
+func TestScanFileForImageDirectives_RejectsAnchorsAndAliases(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content string
+		want    string
+	}{
+		"alias reference": {
+			content: "defaults:\n  tag: &defaultTag 1.2.3\nimage:\n  # bump: image=ghcr.io/org/app\n  tag: *defaultTag\n",
+			want:    "YAML alias",
+		},
+		"anchor definition": {
+			content: "defaults:\n  # bump: image=ghcr.io/org/app\n  tag: &defaultTag 1.2.3\nimage:\n  tag: *defaultTag\n",
+			want:    "YAML anchor (&defaultTag)",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := scan(t, tc.content)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestScanFileForImageDirectives_QuotedStarIsScalar(t *testing.T) {
+	got, err := scan(t, "image:\n  # bump: image=ghcr.io/org/app strategy=literal\n  tag: \"*latest\"\n")
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("unexpected directives: %#v", got)
+	}
+}
+
+func TestScanFileForImageDirectives_WriteTransform(t *testing.T) {
+	got, err := scan(t, "image:\n  # bump: image=ghcr.io/org/app writeTransform=\"{{.Tag}}-{{.Platform}}\" platform=linux/amd64\n  tag: 1.2.3-linux/amd64\n")
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	if len(got) != 1 || got[0].WriteTransform != "{{.Tag}}-{{.Platform}}" {
+		t.Fatalf("unexpected directives: %#v", got)
+	}
+
+	for name, tmpl := range map[string]string{
+		"unknown field": "{{.Version}}",
+		"syntax":        "{{.Tag",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := scan(t, "image:\n  # bump: image=ghcr.io/org/app writeTransform=\""+tmpl+"\"\n  tag: 1.2.3\n")
+			if err == nil || !strings.Contains(err.Error(), "writeTransform") {
+				t.Fatalf("expected writeTransform error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRenderWriteTransform(t *testing.T) {
+	got, err := RenderWriteTransform("{{.Tag}}-{{.Platform}}", WriteVars{Tag: "1.3.0", Platform: "linux/arm64"})
+	if err != nil {
+		t.Fatalf("RenderWriteTransform: %v", err)
+	}
+	if got != "1.3.0-linux/arm64" {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := RenderWriteTransform("{{.Digest}}", WriteVars{}); err == nil {
+		t.Fatalf("expected error for empty result")
+	}
+}
+
+func TestScanFileForImageDirectives_MinAge(t *testing.T) {
+	got, err := scan(t, "image:\n  # bump: image=ghcr.io/org/app minAge=2d\n  tag: 1.2.3\n")
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	if len(got) != 1 || got[0].MinAge != 48*time.Hour {
+		t.Fatalf("unexpected directives: %#v", got)
+	}
+}
+
+func TestScanFileForImageDirectives_SelectOrder(t *testing.T) {
+	got, err := scan(t, "image:\n  # bump: image=ghcr.io/org/app selectExpr=\"major == 1\" selectOrder=-age\n  tag: 1.2.3\n")
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	if len(got) != 1 || got[0].SelectOrder != "-age" {
+		t.Fatalf("unexpected directives: %#v", got)
+	}
+	for _, args := range []string{"selectOrder=age", "selectExpr=true selectOrder=\"age >\""} {
+		if _, err := scan(t, "image:\n  # bump: image=ghcr.io/org/app "+args+"\n  tag: 1.2.3\n"); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("%s: expected ErrMalformed, got %v", args, err)
+		}
+	}
+}
+
+func TestScanFileForImageDirectives_RegistryPorts(t *testing.T) {
+	for _, img := range []string{"localhost:5000/app", "reg.io:5000/org/app"} {
+		got, err := scan(t, "image:\n  # bump: image="+img+"\n  tag: 1.2.3\n")
+		if err != nil {
+			t.Fatalf("%s: %v", img, err)
+		}
+		if len(got) != 1 || got[0].Image != img {
+			t.Fatalf("%s: unexpected directives: %#v", img, got)
+		}
+	}
+	for _, img := range []string{"app", "org/app", "ghcr.io/org/App"} {
+		if _, err := scan(t, "image:\n  # bump: image="+img+"\n  tag: 1.2.3\n"); !errors.Is(err, ErrMalformed) {
+			t.Fatalf("%s: expected ErrMalformed, got %v", img, err)
+		}
+	}
+}
+
+func TestScanFileForImageDirectives_TabIndent(t *testing.T) {
+	_, err := scan(t, "image:\n\t# bump: image=ghcr.io/org/app\n\ttag: 1.2.3\n")
+	var de *DirectiveError
+	if !errors.As(err, &de) || de.Line != 3 || !strings.Contains(err.Error(), "indentation uses tabs") {
+		t.Fatalf("expected a tab indentation error on line 3, got %v", err)
+	}
+}
+
+func TestScanFileForImageDirectives_BlockScalar(t *testing.T) {
+	// Block scalar bodies may hold tabs, colons, and comment-like lines; none is YAML structure.
+	got, err := scan(t, "script: |\n  all:\n  \techo hi\n  # bump: not a directive\n\n  done\njobs:\n- run: >-\n    \tmake\n  name: build\n- |\n  plain\nimage:\n  # bump: image=ghcr.io/org/app\n  tag: 1.2.3\n")
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	if len(got) != 1 || got[0].YAMLPath != "$.image.tag" {
+		t.Fatalf("got %+v", got)
+	}
+}
+
+func TestScanFileForImageDirectives_ExplicitPath(t *testing.T) {
+	got, err := scan(t, "# bump: image=ghcr.io/org/app path=$.spec.containers[*].image\nspec:\n  containers:\n  - image: ghcr.io/org/app:1.2.3\n")
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	if len(got) != 1 || got[0].YAMLPath != "$.spec.containers[*].image" {
+		t.Fatalf("unexpected directives: %#v", got)
+	}
+
+	if got, err = scan(t, "# bump: image=ghcr.io/org/app path=/spec/image\nspec:\n  image: ghcr.io/org/app:1.2.3\n"); err != nil || got[0].YAMLPath != "/spec/image" {
+		t.Fatalf("JSON pointer path: %#v, %v", got, err)
+	}
+
+	if _, err := scan(t, "# bump: image=ghcr.io/org/app path=spec.containers\nspec: {}\n"); err == nil {
+		t.Fatalf("expected error for a path not starting with $. or /")
+	}
+}
+
+func TestScanFileForImageDirectives_DottedKeys(t *testing.T) {
+	got, err := scan(t, `metadata:
+  annotations:
+    # bump: image=ghcr.io/org/app strategy=literal
+    app.example.com/version: 1.2.3
+    # bump: image=ghcr.io/org/app strategy=literal
+    "example.com/image:tag": 1.2.3
+    # bump: image=ghcr.io/org/app strategy=literal
+    'it''s': 1.2.3
+`)
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	want := []string{
+		`$.metadata.annotations.'app.example.com/version'`,
+		`$.metadata.annotations.'example.com/image:tag'`,
+		`$.metadata.annotations.it's`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d directives, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].YAMLPath != w {
+			t.Fatalf("directive %d path got %q want %q", i, got[i].YAMLPath, w)
+		}
+	}
+}
+
+func TestLoadConfigMergesWithInline(t *testing.T) {
+	dir := t.TempDir()
+	values := filepath.Join(dir, "values.yaml")
+	content := "image:\n  # bump: image=ghcr.io/org/app constraint=^1.0.0\n  tag: 1.2.3\nsidecar:\n  tag: 0.1.0\n"
+	if err := os.WriteFile(values, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	cfg := filepath.Join(dir, ConfigFileName)
+	cfgContent := `directives:
+  - file: values.yaml
+    path: $.image.tag
+    image: ghcr.io/org/other
+  - file: values.yaml
+    path: $.sidecar.tag
+    image: ghcr.io/org/sidecar
+    allowPrerelease: true
+`
+	if err := os.WriteFile(cfg, []byte(cfgContent), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	fromConfig, err := LoadConfig(cfg, dir)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	inline, err := ScanFileForImageDirectives(context.Background(), values)
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	got := MergeDirectives(inline, fromConfig)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 directives, got %#v", got)
+	}
+	byPath := map[string]ImageDirective{}
+	for _, d := range got {
+		if d.FilePath != values {
+			t.Fatalf("FilePath got %q want %q", d.FilePath, values)
+		}
+		byPath[d.YAMLPath] = d
+	}
+	if d := byPath["$.image.tag"]; d.Image != "ghcr.io/org/app" || d.Constraint != "^1.0.0" {
+		t.Fatalf("inline directive should win, got %#v", d)
+	}
+	if d := byPath["$.sidecar.tag"]; d.Image != "ghcr.io/org/sidecar" || !d.AllowPrerelease || d.Strategy != "semver" {
+		t.Fatalf("unexpected config directive %#v", d)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		content string
+		want    string
+	}{
+		"missing path":  {"directives:\n  - file: values.yaml\n    image: ghcr.io/org/app\n", "file and path are required"},
+		"invalid field": {"directives:\n  - file: values.yaml\n    path: $.tag\n    image: app\n", "directive 1: image must be a fully-qualified"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), ConfigFileName)
+			if err := os.WriteFile(p, []byte(tc.content), 0o600); err != nil {
+				t.Fatalf("WriteFile: %v", err)
+			}
+			_, err := LoadConfig(p, filepath.Dir(p))
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+func TestMalformedErrors(t *testing.T) {
+	for name, content := range map[string]string{
+		"bad argument":        "image:\n  # bump: image=app\n  tag: 1.2.3\n",
+		"non-scalar":          "# bump: image=ghcr.io/org/app\nimage:\n  tag: 1.2.3\n",
+		"no key follows":      "image:\n  tag: 1.2.3\n# bump: image=ghcr.io/org/app\n",
+		"exact without value": "image:\n  # bump: image=ghcr.io/org/app strategy=exact\n  tag: 1.2.3\n",
+		"value without exact": "image:\n  # bump: image=ghcr.io/org/app value=1.2.4\n  tag: 1.2.3\n",
+		"source without repo": "image:\n  # bump: image=ghcr.io/org/app source=github-releases\n  tag: 1.2.3\n",
+		"repo without source": "image:\n  # bump: image=ghcr.io/org/app repo=org/app\n  tag: 1.2.3\n",
+		"source with digest":  "image:\n  # bump: image=ghcr.io/org/app strategy=digest source=github-releases repo=org/app\n  tag: 1.2.3\n",
+		"bad minAge":          "image:\n  # bump: image=ghcr.io/org/app minAge=2days\n  tag: 1.2.3\n",
+		"channel with regex":  "image:\n  # bump: image=ghcr.io/org/app strategy=regex tagRegex=x channel=16\n  tag: 1.2.3\n",
+		"variant with suffix": "image:\n  # bump: image=ghcr.io/org/app variant=alpine suffix=-alpine\n  tag: 1.2.3-alpine3.18\n",
+		"minAge with digest":  "image:\n  # bump: image=ghcr.io/org/app strategy=digest minAge=2d\n  tag: 1.2.3\n",
+		"oci with repo":       "dependencies:\n  - name: redis\n    # bump: source=oci repo=org/redis\n    version: 1.0.0\n",
+		"oci with pinned-ref": "dependencies:\n  - name: redis\n    # bump: source=oci strategy=pinned-ref\n    version: 1.0.0\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := scan(t, content)
+			if !errors.Is(err, ErrMalformed) {
+				t.Fatalf("expected ErrMalformed, got %v", err)
+			}
+			var de *DirectiveError
+			if !errors.As(err, &de) || de.Line == 0 {
+				t.Fatalf("expected DirectiveError with a line, got %#v", err)
+			}
+		})
+	}
+
+	p := filepath.Join(t.TempDir(), ConfigFileName)
+	if err := os.WriteFile(p, []byte("directives:\n  - file: values.yaml\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := LoadConfig(p, filepath.Dir(p)); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected ErrMalformed from LoadConfig, got %v", err)
+	}
+}
+
+func TestScanFileForImageDirectives_FlowStyle(t *testing.T) {
+	for name, content := range map[string]string{
+		"flow mapping":      "image: {repo: ghcr.io/org/app, tag: \"1.2\"}\n# bump: image=ghcr.io/org/app\nresources: { limits: { cpu: \"1\" } }\n",
+		"flow sequence":     "# bump: image=ghcr.io/org/app\ntags: [1.2.3, latest]\n",
+		"flow mapping item": "containers:\n  # bump: image=ghcr.io/org/app\n  - {name: app, image: ghcr.io/org/app:1.2.3}\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := scan(t, content)
+			if !errors.Is(err, ErrMalformed) || !strings.Contains(err.Error(), "flow") {
+				t.Fatalf("expected a flow style error, got %v", err)
+			}
+		})
+	}
+
+	// Flow lines elsewhere don't disturb path tracking.
+	got, err := scan(t, "labels: {app: x}\ncontainers:\n  - {name: sidecar, image: busybox}\n  - name: main\n    # bump: image=ghcr.io/org/app\n    tag: \"1.2.3\"\n")
+	if err != nil {
+		t.Fatalf("ScanFileForImageDirectives: %v", err)
+	}
+	if len(got) != 1 || got[0].YAMLPath != "$.containers[1].tag" {
+		t.Fatalf("unexpected directives: %#v", got)
+	}
+}
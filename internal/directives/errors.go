@@ -0,0 +1,34 @@
+package directives
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrMalformed matches (via errors.Is) every DirectiveError: a directive or directive config
+// entry that cannot be parsed or applied as written.
+var ErrMalformed = errors.New("malformed bump directive")
+
+// DirectiveError reports a malformed directive and where it was found. Line is 0 for errors
+// in a directive config file.
+type DirectiveError struct {
+	Path string
+	Line int
+	Err  error
+}
+
+func (e *DirectiveError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %v", e.Path, e.Line, e.Err)
+}
+
+func (e *DirectiveError) Unwrap() error { return e.Err }
+
+// Is reports whether target is ErrMalformed.
+func (e *DirectiveError) Is(target error) bool { return target == ErrMalformed }
+
+func malformedf(path string, line int, format string, args ...any) error {
+	return &DirectiveError{Path: path, Line: line, Err: fmt.Errorf(format, args...)}
+}
@@ -0,0 +1,98 @@
+package directives
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+	"github.com/joejulian/helm-chart-bumper-action/internal/yamlutil"
+
+	"go.uber.org/zap"
+)
+
+// ScanTemplateForImageDirectives reads a Helm template as text and returns its directives.
+//
+// Templates are not YAML until rendered, so no YAMLPath is computed. Each directive must
+// immediately precede a `key: literal` line, which becomes its TargetLine; Go template
+// syntax elsewhere in the file is ignored. path= is not supported.
+func ScanTemplateForImageDirectives(ctx context.Context, path string) ([]ImageDirective, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "directives.ScanTemplateForImageDirectives"), zap.String("path", path))
+	log.Debug("scanning template for bump directives")
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := newLineScanner(f)
+
+	var out []ImageDirective
+	var pending *ImageDirective
+	var open *rawDirective
+	finish := func() error {
+		d, err := open.parse(path)
+		open = nil
+		if err != nil {
+			return err
+		}
+		if d.YAMLPath != "" {
+			return malformedf(path, d.Line, "path= is not supported in templates; put the directive directly above the `key: value` line")
+		}
+		pending = &d
+		return nil
+	}
+	lineNo := 0
+	for s.Scan() {
+		lineNo++
+		line := s.Text()
+
+		if m := reContinuation.FindStringSubmatch(line); m != nil {
+			if err := continueDirective(path, lineNo, open, m[1]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if open != nil {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+		}
+		if m := reDirective.FindStringSubmatch(line); m != nil {
+			open = &rawDirective{line: lineNo, args: m[1]}
+			continue
+		}
+
+		trim := strings.TrimSpace(line)
+		if pending == nil || trim == "" || strings.HasPrefix(trim, "#") {
+			continue
+		}
+		info, err := parseYAMLContentLine(line)
+		if err == nil && info.flow != "" {
+			return nil, flowStyleError(path, lineNo, info)
+		}
+		if err != nil || !info.isScalarKV {
+			return nil, malformedf(path, lineNo, "bump directive in a template must precede a `key: literal` line")
+		}
+		if _, err := yamlutil.LineValue(line); err != nil {
+			return nil, malformedf(path, lineNo, "bump directive in a template must precede a `key: literal` line: %v", err)
+		}
+		pending.Key = info.key
+		pending.CurrentText = info.valueText
+		pending.TargetLine = lineNo
+		out = append(out, *pending)
+		pending = nil
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if open != nil {
+		if err := finish(); err != nil {
+			return nil, err
+		}
+	}
+	if pending != nil {
+		return nil, malformedf(pending.FilePath, pending.Line, "bump directive had no following YAML key")
+	}
+	return out, nil
+}
@@ -0,0 +1,63 @@
+package directives
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// WriteVars are the values available to a writeTransform template.
+type WriteVars struct {
+	// Image is the directive's image repository.
+	Image string
+	// Tag is the selected tag, or for strategy=digest and strategy=label, the sibling tag
+	// the value was resolved from.
+	Tag string
+	// Digest is the manifest digest of Image:Tag. It is only looked up when the template
+	// uses it.
+	Digest string
+	// Platform is the directive's platform, if any.
+	Platform string
+	// Value is the resolved value that would be written without a transform.
+	Value string
+}
+
+// UsesDigest reports whether a writeTransform template references .Digest.
+func UsesDigest(tmpl string) bool {
+	return strings.Contains(tmpl, ".Digest")
+}
+
+// RenderWriteTransform executes a writeTransform template against vars.
+func RenderWriteTransform(tmpl string, vars WriteVars) (string, error) {
+	t, err := parseWriteTransform(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("writeTransform: %w", err)
+	}
+	out := b.String()
+	if strings.TrimSpace(out) == "" {
+		return "", fmt.Errorf("writeTransform %q produced an empty value", tmpl)
+	}
+	return out, nil
+}
+
+// validateWriteTransform parses tmpl and executes it against placeholder values, so unknown
+// fields (e.g. {{.Version}}) are reported when the directive is scanned.
+func validateWriteTransform(tmpl string) error {
+	_, err := RenderWriteTransform(tmpl, WriteVars{Image: "x", Tag: "x", Digest: "x", Platform: "x", Value: "x"})
+	if err != nil {
+		return fmt.Errorf("invalid writeTransform (available: .Image .Tag .Digest .Platform .Value): %w", err)
+	}
+	return nil
+}
+
+func parseWriteTransform(tmpl string) (*template.Template, error) {
+	t, err := template.New("writeTransform").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("writeTransform: %w", err)
+	}
+	return t, nil
+}
@@ -5,13 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"go.uber.org/zap"
 )
 
@@ -32,12 +35,6 @@ func ReadFileAtRef(ctx context.Context, repoRoot, ref, repoRelativePath string)
 		zap.String("path", repoRelativePath),
 	)
 
-	log.Debug("opening git repository")
-	repo, err := git.PlainOpenWithOptions(repoRoot, &git.PlainOpenOptions{DetectDotGit: true})
-	if err != nil {
-		return nil, fmt.Errorf("open git repo at %q: %w", repoRoot, err)
-	}
-
 	// Git stores paths with forward slashes regardless of OS.
 	p := filepath.ToSlash(repoRelativePath)
 	p = strings.TrimPrefix(p, "./")
@@ -45,16 +42,10 @@ func ReadFileAtRef(ctx context.Context, repoRoot, ref, repoRelativePath string)
 		return nil, errors.New("empty repoRelativePath")
 	}
 
-	hash, err := resolveRevision(ctx, repo, ref)
+	commit, err := commitAtRef(ctx, repoRoot, ref)
 	if err != nil {
 		return nil, err
 	}
-	log.Debug("resolved git revision", zap.String("hash", hash.String()))
-
-	commit, err := repo.CommitObject(*hash)
-	if err != nil {
-		return nil, fmt.Errorf("resolve commit for ref %q: %w", ref, err)
-	}
 
 	file, err := commit.File(p)
 	if err != nil {
@@ -75,8 +66,178 @@ func ReadFileAtRef(ctx context.Context, repoRoot, ref, repoRelativePath string)
 	return b, nil
 }
 
+// ListFilesAtRef returns the repository-relative paths of all blobs under dirPrefix in the
+// commit tree at ref, sorted. An empty dirPrefix (or ".") lists the whole tree.
+//
+// Example:
+//
+//	ListFilesAtRef(ctx, ".", "origin/main", "charts/foo")
+func ListFilesAtRef(ctx context.Context, repoRoot, ref, dirPrefix string) ([]string, error) {
+	log := logutil.FromContext(ctx).With(
+		zap.String("func", "gitutil.ListFilesAtRef"),
+		zap.String("repo", repoRoot),
+		zap.String("ref", ref),
+		zap.String("dir", dirPrefix),
+	)
+
+	commit, err := commitAtRef(ctx, repoRoot, ref)
+	if err != nil {
+		return nil, err
+	}
+	out, err := listFiles(commit, ref, dirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("listed files", zap.Int("count", len(out)))
+	return out, nil
+}
+
+// ReadFilesAtRef returns the contents of every blob under dirPrefix at ref, keyed by
+// repository-relative path.
+func ReadFilesAtRef(ctx context.Context, repoRoot, ref, dirPrefix string) (map[string][]byte, error) {
+	commit, err := commitAtRef(ctx, repoRoot, ref)
+	if err != nil {
+		return nil, err
+	}
+	paths, err := listFiles(commit, ref, dirPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string][]byte, len(paths))
+	for _, p := range paths {
+		f, err := commit.File(p)
+		if err != nil {
+			return nil, fmt.Errorf("read %q at ref %q: %w", p, ref, err)
+		}
+		c, err := f.Contents()
+		if err != nil {
+			return nil, fmt.Errorf("read %q at ref %q: %w", p, ref, err)
+		}
+		out[p] = []byte(c)
+	}
+	return out, nil
+}
+
+func listFiles(commit *object.Commit, ref, dirPrefix string) ([]string, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("read tree at ref %q: %w", ref, err)
+	}
+
+	prefix := strings.Trim(strings.TrimPrefix(filepath.ToSlash(dirPrefix), "./"), "/")
+	if prefix == "." {
+		prefix = ""
+	}
+	if prefix != "" {
+		tree, err = tree.Tree(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("read directory %q at ref %q: %w", prefix, ref, err)
+		}
+	}
+
+	var out []string
+	err = tree.Files().ForEach(func(f *object.File) error {
+		p := f.Name
+		if prefix != "" {
+			p = path.Join(prefix, p)
+		}
+		out = append(out, p)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %q at ref %q: %w", prefix, ref, err)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// MergeBase returns the hash of the best common ancestor of refA and refB.
+// If there are several equally good candidates, the first one is returned.
+//
+// Example:
+//
+//	MergeBase(ctx, ".", "HEAD", "origin/main")
+func MergeBase(ctx context.Context, repoRoot, refA, refB string) (string, error) {
+	log := logutil.FromContext(ctx).With(
+		zap.String("func", "gitutil.MergeBase"),
+		zap.String("repo", repoRoot),
+		zap.String("refA", refA),
+		zap.String("refB", refB),
+	)
+
+	a, err := commitAtRef(ctx, repoRoot, refA)
+	if err != nil {
+		return "", err
+	}
+	b, err := commitAtRef(ctx, repoRoot, refB)
+	if err != nil {
+		return "", err
+	}
+	bases, err := a.MergeBase(b)
+	if err != nil {
+		return "", fmt.Errorf("merge-base of %q and %q: %w", refA, refB, err)
+	}
+	if len(bases) == 0 {
+		return "", fmt.Errorf("%q and %q have no common ancestor", refA, refB)
+	}
+	h := bases[0].Hash.String()
+	log.Debug("resolved merge-base", zap.String("hash", h), zap.Int("candidates", len(bases)))
+	return h, nil
+}
+
+func commitAtRef(ctx context.Context, repoRoot, ref string) (*object.Commit, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "gitutil.commitAtRef"), zap.String("repo", repoRoot), zap.String("ref", ref))
+	log.Debug("opening git repository")
+	repo, err := git.PlainOpenWithOptions(repoRoot, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("open git repo at %q: %w", repoRoot, err)
+	}
+
+	hash, err := resolveRevision(ctx, repo, ref)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("resolved git revision", zap.String("hash", hash.String()))
+
+	target, err := peelToCommit(repo, *hash)
+	if err != nil {
+		return nil, fmt.Errorf("dereference ref %q: %w", ref, err)
+	}
+	if target != *hash {
+		log.Debug("dereferenced annotated tag", zap.String("commit", target.String()))
+	}
+
+	commit, err := repo.CommitObject(target)
+	if err != nil {
+		return nil, fmt.Errorf("resolve commit for ref %q: %w", ref, err)
+	}
+	return commit, nil
+}
+
+// resolveRevision resolves ref to an object hash. A commit range A..B resolves to its start,
+// A, after checking that B resolves too. A full 40-character hash is used as-is, without the
+// branch and tag conveniences that could resolve it to a ref that happens to share the name.
 func resolveRevision(ctx context.Context, repo *git.Repository, ref string) (*plumbing.Hash, error) {
 	log := logutil.FromContext(ctx).With(zap.String("func", "gitutil.resolveRevision"), zap.String("ref", ref))
+	if start, end, ok := strings.Cut(ref, ".."); ok {
+		if start == "" || end == "" || strings.HasPrefix(end, ".") {
+			return nil, fmt.Errorf("unsupported git range %q: want <start>..<end>", ref)
+		}
+		if _, err := resolveRevision(ctx, repo, end); err != nil {
+			return nil, fmt.Errorf("range %q: %w", ref, err)
+		}
+		log.Debug("using start of commit range", zap.String("start", start))
+		return resolveRevision(ctx, repo, start)
+	}
+	if len(ref) == 40 && plumbing.IsHash(ref) {
+		h := plumbing.NewHash(ref)
+		if _, err := repo.Storer.EncodedObject(plumbing.AnyObject, h); err != nil {
+			return nil, fmt.Errorf("unable to resolve git hash %s: %w", ref, err)
+		}
+		return &h, nil
+	}
+
 	// Try user-provided ref as-is.
 	try := []string{ref}
 
@@ -87,6 +248,7 @@ func resolveRevision(ctx context.Context, repo *git.Repository, ref string) (*pl
 	if !strings.HasPrefix(ref, "refs/") {
 		try = append(try, "refs/heads/"+ref)
 		try = append(try, "refs/remotes/origin/"+ref)
+		try = append(try, "refs/tags/"+ref)
 	}
 
 	log.Debug("resolving revision", zap.Strings("candidates", try))
@@ -102,3 +264,21 @@ func resolveRevision(ctx context.Context, repo *git.Repository, ref string) (*pl
 
 	return nil, fmt.Errorf("unable to resolve git ref %q (tried %v): %w", ref, try, lastErr)
 }
+
+// peelToCommit follows annotated tag objects until it reaches a non-tag object.
+// Lightweight tags and commits are returned unchanged.
+func peelToCommit(repo *git.Repository, h plumbing.Hash) (plumbing.Hash, error) {
+	for {
+		tag, err := repo.TagObject(h)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			return h, nil
+		}
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if tag.TargetType != plumbing.CommitObject && tag.TargetType != plumbing.TagObject {
+			return plumbing.ZeroHash, fmt.Errorf("tag %q points to a %s, not a commit", tag.Name, tag.TargetType)
+		}
+		h = tag.Target
+	}
+}
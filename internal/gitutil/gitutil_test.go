@@ -0,0 +1,228 @@
+package gitutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// initRepo creates a git repository in a temp dir with files committed on the default branch.
+func initRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, _ := initRepoWithHead(t, files)
+	return dir
+}
+
+func initRepoWithHead(t *testing.T, files map[string]string) (string, *git.Repository) {
+	t.Helper()
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("PlainInit: %v", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Worktree: %v", err)
+	}
+	for p, c := range files {
+		full := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+		if err := os.WriteFile(full, []byte(c), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if _, err := wt.Add(p); err != nil {
+			t.Fatalf("Add %s: %v", p, err)
+		}
+	}
+	_, err = wt.Commit("init", &git.CommitOptions{Author: testSignature()})
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	return dir, repo
+}
+
+func testSignature() *object.Signature {
+	return &object.Signature{Name: "t", Email: "t@example.com", When: time.Now()}
+}
+
+func TestReadFileAtRef(t *testing.T) {
+	dir := initRepo(t, map[string]string{"charts/foo/Chart.yaml": "name: foo\n"})
+	got, err := ReadFileAtRef(context.Background(), dir, "HEAD", "charts/foo/Chart.yaml")
+	if err != nil {
+		t.Fatalf("ReadFileAtRef: %v", err)
+	}
+	if string(got) != "name: foo\n" {
+		t.Fatalf("got %q", string(got))
+	}
+}
+
+func TestListAndReadFilesAtRef(t *testing.T) {
+	dir := initRepo(t, map[string]string{
+		"charts/foo/Chart.yaml":                "name: foo\n",
+		"charts/foo/templates/deployment.yaml": "kind: Deployment\n",
+		"charts/foo/templates/sub/cm.yaml":     "kind: ConfigMap\n",
+		"charts/bar/Chart.yaml":                "name: bar\n",
+		"README.md":                            "hi\n",
+	})
+
+	got, err := ListFilesAtRef(context.Background(), dir, "HEAD", "charts/foo")
+	if err != nil {
+		t.Fatalf("ListFilesAtRef: %v", err)
+	}
+	want := []string{
+		"charts/foo/Chart.yaml",
+		"charts/foo/templates/deployment.yaml",
+		"charts/foo/templates/sub/cm.yaml",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+
+	all, err := ListFilesAtRef(context.Background(), dir, "HEAD", "")
+	if err != nil {
+		t.Fatalf("ListFilesAtRef(root): %v", err)
+	}
+	if len(all) != 5 {
+		t.Fatalf("expected 5 files at root, got %v", all)
+	}
+
+	contents, err := ReadFilesAtRef(context.Background(), dir, "HEAD", "./charts/foo/templates/")
+	if err != nil {
+		t.Fatalf("ReadFilesAtRef: %v", err)
+	}
+	if len(contents) != 2 || string(contents["charts/foo/templates/sub/cm.yaml"]) != "kind: ConfigMap\n" {
+		t.Fatalf("unexpected contents: %v", contents)
+	}
+}
+
+func TestReadFileAtRef_Tags(t *testing.T) {
+	dir, repo := initRepoWithHead(t, map[string]string{"Chart.yaml": "version: 1.2.0\n"})
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatalf("Head: %v", err)
+	}
+	if _, err := repo.CreateTag("v1.2.0", head.Hash(), nil); err != nil {
+		t.Fatalf("CreateTag(lightweight): %v", err)
+	}
+	if _, err := repo.CreateTag("v1.2.0-annotated", head.Hash(), &git.CreateTagOptions{Tagger: testSignature(), Message: "release"}); err != nil {
+		t.Fatalf("CreateTag(annotated): %v", err)
+	}
+
+	for _, ref := range []string{"v1.2.0", "v1.2.0-annotated", "refs/tags/v1.2.0-annotated"} {
+		got, err := ReadFileAtRef(context.Background(), dir, ref, "Chart.yaml")
+		if err != nil {
+			t.Fatalf("ReadFileAtRef(%s): %v", ref, err)
+		}
+		if string(got) != "version: 1.2.0\n" {
+			t.Fatalf("ReadFileAtRef(%s) got %q", ref, string(got))
+		}
+	}
+}
+
+func TestReadFileAtRef_HashesAndRanges(t *testing.T) {
+	dir, repo := initRepoWithHead(t, map[string]string{"Chart.yaml": "version: 1.0.0\n"})
+	first, err := repo.Head()
+	if err != nil {
+		t.Fatalf("Head: %v", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Worktree: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("version: 1.1.0\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := wt.Add("Chart.yaml"); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	second, err := wt.Commit("bump", &git.CommitOptions{Author: testSignature()})
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	sha := first.Hash().String()
+	// A branch named like the first commit's hash but pointing at the second must not win.
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(sha), second)); err != nil {
+		t.Fatalf("SetReference: %v", err)
+	}
+
+	for _, ref := range []string{sha, sha + ".." + second.String(), "HEAD~1..HEAD"} {
+		got, err := ReadFileAtRef(context.Background(), dir, ref, "Chart.yaml")
+		if err != nil {
+			t.Fatalf("ReadFileAtRef(%s): %v", ref, err)
+		}
+		if string(got) != "version: 1.0.0\n" {
+			t.Fatalf("ReadFileAtRef(%s) got %q", ref, string(got))
+		}
+	}
+
+	for _, ref := range []string{"HEAD..nope", "HEAD~1...HEAD", "..HEAD", strings.Repeat("0", 40)} {
+		if _, err := ReadFileAtRef(context.Background(), dir, ref, "Chart.yaml"); err == nil {
+			t.Fatalf("ReadFileAtRef(%s): expected an error", ref)
+		}
+	}
+}
+
+func TestMergeBase(t *testing.T) {
+	dir, repo := initRepoWithHead(t, map[string]string{"Chart.yaml": "version: 1.0.0\n"})
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Worktree: %v", err)
+	}
+	forkPoint, err := repo.Head()
+	if err != nil {
+		t.Fatalf("Head: %v", err)
+	}
+	mainBranch := forkPoint.Name()
+
+	commit := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte(content), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		if _, err := wt.Add("Chart.yaml"); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if _, err := wt.Commit(content, &git.CommitOptions{Author: testSignature()}); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+	}
+
+	// feature branches off the fork point; main then advances independently.
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature"), Create: true}); err != nil {
+		t.Fatalf("Checkout feature: %v", err)
+	}
+	commit("version: 1.0.1\n")
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: mainBranch}); err != nil {
+		t.Fatalf("Checkout main: %v", err)
+	}
+	commit("version: 2.0.0\n")
+	if err := wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature")}); err != nil {
+		t.Fatalf("Checkout feature: %v", err)
+	}
+
+	base, err := MergeBase(context.Background(), dir, "HEAD", mainBranch.Short())
+	if err != nil {
+		t.Fatalf("MergeBase: %v", err)
+	}
+	if base != forkPoint.Hash().String() {
+		t.Fatalf("merge-base got %s want fork point %s", base, forkPoint.Hash())
+	}
+
+	got, err := ReadFileAtRef(context.Background(), dir, base, "Chart.yaml")
+	if err != nil {
+		t.Fatalf("ReadFileAtRef: %v", err)
+	}
+	if string(got) != "version: 1.0.0\n" {
+		t.Fatalf("base Chart.yaml got %q", string(got))
+	}
+}
@@ -2,8 +2,11 @@ package helmdeps
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
+	"os"
+	"path/filepath"
 	"sort"
 	"strings"
 
@@ -15,18 +18,68 @@ import (
 	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/cli"
 	"helm.sh/helm/v3/pkg/getter"
+	"helm.sh/helm/v3/pkg/helmpath"
 	"helm.sh/helm/v3/pkg/repo"
 )
 
+// ErrIndexUnavailable is wrapped by errors downloading a repository index, so callers can
+// tell an unreachable repository from a malformed Chart.yaml.
+var ErrIndexUnavailable = errors.New("repository index unavailable")
+
 // ResolvedDep is the result for one Chart.yaml dependency.
 type ResolvedDep struct {
-	Index int
-	Name  string
+	Index      int
+	Name       string
 	OldVersion string
 	NewVersion string
 	Repository string
+	// ResolvedRepository is the repository whose index supplied NewVersion. It equals
+	// Repository unless the version came from a mirror.
+	ResolvedRepository string
+}
+
+// SkipReason says why a dependency was left out of the update.
+type SkipReason string
+
+const (
+	// SkipNoRepository is a dependency without a repository (e.g. a chart vendored in charts/).
+	SkipNoRepository SkipReason = "no-repository"
+	// SkipOCIUnsupported is a dependency from an oci:// repository.
+	SkipOCIUnsupported SkipReason = "oci-unsupported"
+	// SkipUnsupportedRepository is a repository that is neither HTTP(S), OCI, nor a file://
+	// repository index, such as a file:// chart directory or a Helm repository alias.
+	SkipUnsupportedRepository SkipReason = "unsupported-repository"
+	// SkipNoIndexEntry is a chart missing from every consulted repository index.
+	SkipNoIndexEntry SkipReason = "no-index-entry"
+	// SkipNoMatchingVersion is a chart whose index lists no semver versions.
+	SkipNoMatchingVersion SkipReason = "no-matching-version"
+	// SkipConstraintUnsatisfiable is a chart with no version satisfying its constraint.
+	SkipConstraintUnsatisfiable SkipReason = "constraint-unsatisfiable"
+	// SkipDowngrade is a chart whose best version is lower than its current exact version,
+	// e.g. because a repository index lags behind; see Options.AllowDowngrade.
+	SkipDowngrade SkipReason = "downgrade"
+	// SkipKubeVersion is a chart whose satisfying versions all require Kubernetes versions the
+	// umbrella chart's kubeVersion does not; see Options.CheckKubeVersion.
+	SkipKubeVersion SkipReason = "kube-version"
+)
+
+// SkippedDep is a Chart.yaml dependency that could not be considered for an update.
+type SkippedDep struct {
+	Index      int
+	Name       string
+	Version    string
+	Repository string
+	Reason     SkipReason
 }
 
+// MirrorsAnnotationPrefix is the Chart.yaml annotation prefix used to list alternate
+// repositories for a dependency. The annotation key is the prefix followed by the
+// dependency name and the value is a comma-separated list of repository URLs:
+//
+//	annotations:
+//	  helm-chart-bumper/mirrors.redis: "https://mirror.example.com/charts"
+const MirrorsAnnotationPrefix = "helm-chart-bumper/mirrors."
+
 // ResolveLatestDependencies resolves latest versions for Chart.yaml dependencies using Helm's repo index
 // handling (HTTP(S) only).
 //
@@ -35,76 +88,335 @@ type ResolvedDep struct {
 // - Otherwise, choose the highest semver version available.
 //
 // Non-semver versions in the index are ignored.
-func ResolveLatestDependencies(ctx context.Context, chartYAMLPath string) ([]ResolvedDep, error) {
+//
+// If the dependency has mirrors listed via MirrorsAnnotationPrefix, they are consulted in
+// order whenever the primary repository lacks the chart or a satisfying version.
+//
+// A dependency pinned to an exact version may be limited to newer patches (ModePatch) or
+// minors (ModeMinor) of that version with a `# bump-dep: mode=...` comment (see
+// ScanDependencyModes) or, for all other dependencies, with opts.Mode.
+//
+// Dependencies that cannot be resolved are returned as skipped, with the reason; a dependency
+// already at its best version is neither resolved nor skipped.
+//
+// If opts is nil, indexes are downloaded anonymously into Helm's default cache.
+func ResolveLatestDependencies(ctx context.Context, chartYAMLPath string, opts *Options) ([]ResolvedDep, []SkippedDep, error) {
 	log := logutil.FromContext(ctx).With(zap.String("func", "helmdeps.ResolveLatestDependencies"), zap.String("chartYAMLPath", chartYAMLPath))
 	log.Debug("loading Chart.yaml for dependency resolution")
 	meta, err := chartutil.LoadChartfile(chartYAMLPath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if len(meta.Dependencies) == 0 {
-		return nil, nil
+		return nil, nil, nil
 	}
 
+	if opts == nil {
+		opts = &Options{}
+	}
+	modes, err := ScanDependencyModes(chartYAMLPath)
+	if err != nil {
+		return nil, nil, err
+	}
 	settings := cli.New()
 	getters := getter.All(settings)
-
-	indexCache := map[string]*repo.IndexFile{}
+	repoConfig := opts.RepositoryConfig
+	if repoConfig == "" {
+		repoConfig = settings.RepositoryConfig
+	}
+	cache := opts.IndexCache
+	if cache == nil {
+		cache = NewIndexCache()
+	}
+	chartDir := filepath.Dir(chartYAMLPath)
+	il := &indexLoader{opts: opts, getters: getters, names: repoNames(repoConfig), cache: cache.indexes, chartDir: chartDir}
+	var kubeOK func(*repo.ChartVersion) bool
+	if opts.CheckKubeVersion {
+		if kubeOK, err = kubeCompatible(meta.KubeVersion); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", chartYAMLPath, err)
+		}
+	}
 
 	var out []ResolvedDep
+	var skipped []SkippedDep
 	for i, dep := range meta.Dependencies {
 		if dep == nil {
 			continue
 		}
 		log.Debug("considering dependency", zap.Int("index", i), zap.String("name", dep.Name), zap.String("repo", dep.Repository), zap.String("versionExpr", dep.Version))
 		repoURL := strings.TrimSpace(dep.Repository)
-		if repoURL == "" {
-			continue
+		skip := func(reason SkipReason) {
+			skipped = append(skipped, SkippedDep{Index: i, Name: dep.Name, Version: dep.Version, Repository: repoURL, Reason: reason})
 		}
-		u, err := url.Parse(repoURL)
-		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		switch {
+		case repoURL == "":
+			skip(SkipNoRepository)
+			continue
+		case strings.HasPrefix(repoURL, "oci://"):
 			// For now, only HTTP(S). OCI chart deps could be added later.
+			skip(SkipOCIUnsupported)
+			continue
+		case IsFileRepo(repoURL):
+			if isLocalChart(fileRepoDir(repoURL, chartDir)) {
+				skip(SkipUnsupportedRepository)
+				continue
+			}
+		case !IsHTTPRepo(repoURL):
+			skip(SkipUnsupportedRepository)
 			continue
 		}
 
-		idx, ok := indexCache[repoURL]
+		mode, ok := modes[dep.Name]
 		if !ok {
-			cr, err := repo.NewChartRepository(&repo.Entry{URL: repoURL}, getters)
+			mode = opts.Mode
+		}
+		versionExpr := dep.Version
+		if c := modeConstraint(mode, dep.Version); c != "" {
+			log.Debug("limiting dependency by update mode", zap.String("name", dep.Name), zap.String("mode", string(mode)), zap.String("constraint", c))
+			versionExpr = c
+		} else if _, ok := exactVersion(dep.Version); ok {
+			// As a constraint, an exact pin only matches itself; it is what gets bumped, not a
+			// range to stay within.
+			log.Debug("dependency pinned to an exact version; taking the highest", zap.String("name", dep.Name))
+			versionExpr = ""
+		}
+
+		candidates := append([]string{repoURL}, mirrorsFor(meta.Annotations, dep.Name)...)
+		bestTag, fromRepo := "", ""
+		reason := SkipNoIndexEntry
+		for j, candURL := range candidates {
+			idx, err := il.load(ctx, candURL)
 			if err != nil {
-				return nil, err
+				if j < len(candidates)-1 {
+					log.Debug("failed loading repository index; trying next mirror", zap.String("repo", candURL), zap.Error(err))
+					continue
+				}
+				return nil, nil, err
 			}
-			indexPath, err := cr.DownloadIndexFile()
-			if err != nil {
-				return nil, err
+
+			cvs := idx.Entries[dep.Name]
+			if len(cvs) == 0 {
+				log.Debug("repository index lacks chart", zap.String("repo", candURL), zap.String("name", dep.Name))
+				continue
 			}
-			idx, err = repo.LoadIndexFile(indexPath)
+
+			t, err := pickBestSemver(cvs, versionExpr, kubeOK)
 			if err != nil {
-				return nil, err
+				return nil, nil, fmt.Errorf("dependency %s: %w", dep.Name, err)
+			}
+			if kubeOK != nil {
+				if newest, _ := pickBestSemver(cvs, versionExpr, nil); newest != t {
+					log.Debug("passing over versions incompatible with the chart's kubeVersion", zap.String("name", dep.Name), zap.String("newest", newest), zap.String("picked", t), zap.String("kubeVersion", meta.KubeVersion))
+					if t == "" {
+						reason = SkipKubeVersion
+						continue
+					}
+				}
+			}
+			if t == "" {
+				log.Debug("repository index has no satisfying version", zap.String("repo", candURL), zap.String("name", dep.Name))
+				reason = noVersionReason(cvs, versionExpr)
+				continue
 			}
-			indexCache[repoURL] = idx
+			bestTag, fromRepo = t, candURL
+			break
 		}
-
-		cvs := idx.Entries[dep.Name]
-		if len(cvs) == 0 {
+		if bestTag == "" {
+			skip(reason)
+			continue
+		}
+		if bestTag == dep.Version {
+			continue
+		}
+		if !opts.AllowDowngrade && isDowngrade(dep.Version, bestTag) {
+			skip(SkipDowngrade)
 			continue
 		}
+		out = append(out, ResolvedDep{Index: i, Name: dep.Name, OldVersion: dep.Version, NewVersion: bestTag, Repository: repoURL, ResolvedRepository: fromRepo})
+	}
+	return out, skipped, nil
+}
 
-		bestTag, err := pickBestSemver(cvs, dep.Version)
+// indexLoader downloads (or reuses cached) repository indexes, once per URL per run.
+// Relative file:// repositories are resolved against chartDir.
+type indexLoader struct {
+	opts     *Options
+	getters  getter.Providers
+	names    map[string]string
+	cache    map[string]*repo.IndexFile
+	chartDir string
+}
+
+func (il *indexLoader) load(ctx context.Context, repoURL string) (*repo.IndexFile, error) {
+	if IsFileRepo(repoURL) {
+		return il.loadFile(ctx, repoURL)
+	}
+	if idx, ok := il.cache[repoURL]; ok {
+		return idx, nil
+	}
+	log := logutil.FromContext(ctx).With(zap.String("func", "helmdeps.indexLoader.load"), zap.String("repo", repoURL))
+	entry := il.opts.entryFor(repoURL, il.names)
+
+	if il.opts.RepositoryCache != "" {
+		// Only Helm's own entries are reused: `helm repo update` refreshes them, while nothing
+		// would refresh an index this tool stored there.
+		if _, named := il.names[normalizeRepoURL(repoURL)]; named {
+			p := filepath.Join(il.opts.RepositoryCache, helmpath.CacheIndexFile(entry.Name))
+			if idx, err := repo.LoadIndexFile(p); err == nil {
+				log.Debug("using cached repository index", zap.String("path", p))
+				il.cache[repoURL] = idx
+				return idx, nil
+			}
+		}
+	}
+
+	cr, err := repo.NewChartRepository(entry, il.getters)
+	if err != nil {
+		return nil, err
+	}
+	if il.opts.RepositoryCache != "" {
+		// Download beside, not into, the user's cache, which stays Helm's to maintain.
+		tmp, err := os.MkdirTemp("", "helm-chart-bumper-index-")
 		if err != nil {
-			return nil, fmt.Errorf("dependency %s: %w", dep.Name, err)
+			return nil, err
 		}
-		if bestTag == "" {
+		cr.CachePath = tmp
+		defer func() {
+			// An abandoned download still writes here; leave it to finish.
+			if ctx.Err() == nil {
+				os.RemoveAll(tmp)
+			}
+		}()
+	}
+	log.Debug("downloading repository index", zap.Bool("auth", entry.Username != ""))
+	indexPath, err := downloadIndexFile(ctx, cr)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("download index %s: %w", repoURL, ctx.Err())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrIndexUnavailable, repoURL, err)
+	}
+	idx, err := repo.LoadIndexFile(indexPath)
+	if err != nil {
+		return nil, err
+	}
+	il.cache[repoURL] = idx
+	return idx, nil
+}
+
+// loadFile reads the index.yaml of a file:// repository directly, with no download.
+func (il *indexLoader) loadFile(ctx context.Context, repoURL string) (*repo.IndexFile, error) {
+	dir, err := filepath.Abs(fileRepoDir(repoURL, il.chartDir))
+	if err != nil {
+		return nil, err
+	}
+	// Key by absolute path: the same relative URL names different directories in different charts.
+	key := "file://" + dir
+	if idx, ok := il.cache[key]; ok {
+		return idx, nil
+	}
+	log := logutil.FromContext(ctx).With(zap.String("func", "helmdeps.indexLoader.loadFile"), zap.String("repo", repoURL))
+	p := filepath.Join(dir, "index.yaml")
+	if _, err := os.Stat(p); err != nil {
+		return nil, fmt.Errorf("file repository %s has no readable index.yaml: %w", repoURL, err)
+	}
+	log.Debug("reading repository index", zap.String("path", p))
+	idx, err := repo.LoadIndexFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("file repository %s: %w", repoURL, err)
+	}
+	il.cache[key] = idx
+	return idx, nil
+}
+
+// downloadIndexFile downloads cr's index, returning early if ctx is done first. Helm's
+// download takes no context, so an abandoned download finishes in the background.
+func downloadIndexFile(ctx context.Context, cr *repo.ChartRepository) (string, error) {
+	type result struct {
+		path string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		p, err := cr.DownloadIndexFile()
+		done <- result{p, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case r := <-done:
+		return r.path, r.err
+	}
+}
+
+// IsHTTPRepo reports whether repoURL is an HTTP(S) repository.
+func IsHTTPRepo(repoURL string) bool {
+	u, err := url.Parse(repoURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// IsFileRepo reports whether repoURL is a file:// URL, naming either a local chart directory
+// or a directory holding a repository index.yaml.
+func IsFileRepo(repoURL string) bool {
+	return strings.HasPrefix(repoURL, "file://")
+}
+
+// IsIndexRepo reports whether ResolveLatestDependencies resolves versions from repoURL: an
+// HTTP(S) repository, or a file:// directory that is not a chart. chartDir anchors relative
+// file:// paths, as in Helm.
+func IsIndexRepo(repoURL, chartDir string) bool {
+	return IsHTTPRepo(repoURL) || (IsFileRepo(repoURL) && !isLocalChart(fileRepoDir(repoURL, chartDir)))
+}
+
+// fileRepoDir returns the directory a file:// URL names, relative to chartDir unless absolute.
+func fileRepoDir(repoURL, chartDir string) string {
+	p := filepath.FromSlash(strings.TrimPrefix(repoURL, "file://"))
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(chartDir, p)
+	}
+	return p
+}
+
+// isLocalChart reports whether dir holds a chart, which Helm uses as the dependency itself,
+// rather than a repository index.
+func isLocalChart(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "Chart.yaml"))
+	return err == nil
+}
+
+// mirrorsFor returns the HTTP(S) mirror repositories listed for depName in annotations.
+func mirrorsFor(annotations map[string]string, depName string) []string {
+	v := annotations[MirrorsAnnotationPrefix+depName]
+	var out []string
+	for _, m := range strings.Split(v, ",") {
+		m = strings.TrimSpace(m)
+		if m == "" || !IsHTTPRepo(m) {
 			continue
 		}
-		if bestTag == dep.Version {
+		out = append(out, m)
+	}
+	return out
+}
+
+// noVersionReason says why pickBestSemver found nothing in a non-empty versions list.
+func noVersionReason(versions repo.ChartVersions, versionExpr string) SkipReason {
+	if _, err := semver.NewConstraint(versionExpr); err != nil || strings.TrimSpace(versionExpr) == "" {
+		return SkipNoMatchingVersion
+	}
+	for _, cv := range versions {
+		if cv == nil {
 			continue
 		}
-		out = append(out, ResolvedDep{Index: i, Name: dep.Name, OldVersion: dep.Version, NewVersion: bestTag, Repository: repoURL})
+		if _, err := semver.NewVersion(cv.Version); err == nil {
+			return SkipConstraintUnsatisfiable
+		}
 	}
-	return out, nil
+	return SkipNoMatchingVersion
 }
 
-func pickBestSemver(versions repo.ChartVersions, versionExpr string) (string, error) {
+// pickBestSemver returns the highest version satisfying versionExpr, considering only
+// versions accepted by ok when it is non-nil.
+func pickBestSemver(versions repo.ChartVersions, versionExpr string, ok func(*repo.ChartVersion) bool) (string, error) {
 	// Parse constraint if possible.
 	var c *semver.Constraints
 	if strings.TrimSpace(versionExpr) != "" {
@@ -129,6 +441,9 @@ func pickBestSemver(versions repo.ChartVersions, versionExpr string) (string, er
 		if c != nil && !c.Check(v) {
 			continue
 		}
+		if ok != nil && !ok(cv) {
+			continue
+		}
 		cands = append(cands, cand{tag: cv.Version, ver: v})
 	}
 	if len(cands) == 0 {
@@ -139,3 +454,17 @@ func pickBestSemver(versions repo.ChartVersions, versionExpr string) (string, er
 	})
 	return cands[len(cands)-1].tag, nil
 }
+
+// isDowngrade reports whether next is a lower semver than cur. Values that are not versions,
+// such as constraints, never count as downgrades.
+func isDowngrade(cur, next string) bool {
+	c, err := semver.NewVersion(strings.TrimSpace(cur))
+	if err != nil {
+		return false
+	}
+	n, err := semver.NewVersion(strings.TrimSpace(next))
+	if err != nil {
+		return false
+	}
+	return n.LessThan(c)
+}
@@ -0,0 +1,544 @@
+package helmdeps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/repo"
+	"sigs.k8s.io/yaml"
+)
+
+func serveIndex(t *testing.T, name string, versions ...string) *httptest.Server {
+	t.Helper()
+	body := "apiVersion: v1\nentries:\n"
+	if len(versions) > 0 {
+		body += fmt.Sprintf("  %s:\n", name)
+		for _, v := range versions {
+			body += fmt.Sprintf("    - name: %s\n      version: %s\n      urls: [%s-%s.tgz]\n", name, v, name, v)
+		}
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/index.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func writeChart(t *testing.T, content string) string {
+	t.Helper()
+	t.Setenv("HELM_CACHE_HOME", t.TempDir())
+	t.Setenv("HELM_CONFIG_HOME", t.TempDir())
+	dir := t.TempDir()
+	p := filepath.Join(dir, "Chart.yaml")
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return p
+}
+
+func TestResolveLatestDependencies_FallsBackToMirror(t *testing.T) {
+	primary := serveIndex(t, "redis", "1.0.0", "1.1.0")
+	mirror := serveIndex(t, "redis", "1.1.0", "2.0.0", "2.1.0")
+
+	p := writeChart(t, fmt.Sprintf(`apiVersion: v2
+name: x
+version: 0.1.0
+annotations:
+  %sredis: "%s"
+dependencies:
+  - name: redis
+    version: ^2.0.0
+    repository: %s
+`, MirrorsAnnotationPrefix, mirror.URL, primary.URL))
+
+	got, _, err := ResolveLatestDependencies(context.Background(), p, nil)
+	if err != nil {
+		t.Fatalf("ResolveLatestDependencies: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 resolved dep, got %#v", got)
+	}
+	if got[0].NewVersion != "2.1.0" {
+		t.Fatalf("NewVersion got %q want %q", got[0].NewVersion, "2.1.0")
+	}
+	if got[0].Repository != primary.URL {
+		t.Fatalf("Repository got %q want canonical %q", got[0].Repository, primary.URL)
+	}
+	if got[0].ResolvedRepository != mirror.URL {
+		t.Fatalf("ResolvedRepository got %q want %q", got[0].ResolvedRepository, mirror.URL)
+	}
+}
+
+func TestResolveLatestDependencies_PrefersPrimary(t *testing.T) {
+	primary := serveIndex(t, "redis", "2.0.0", "2.0.1")
+	mirror := serveIndex(t, "redis", "2.5.0")
+
+	p := writeChart(t, fmt.Sprintf(`apiVersion: v2
+name: x
+version: 0.1.0
+annotations:
+  %sredis: "%s"
+dependencies:
+  - name: redis
+    version: ^2.0.0
+    repository: %s
+`, MirrorsAnnotationPrefix, mirror.URL, primary.URL))
+
+	got, _, err := ResolveLatestDependencies(context.Background(), p, nil)
+	if err != nil {
+		t.Fatalf("ResolveLatestDependencies: %v", err)
+	}
+	if len(got) != 1 || got[0].NewVersion != "2.0.1" || got[0].ResolvedRepository != primary.URL {
+		t.Fatalf("unexpected result: %#v", got)
+	}
+}
+
+func TestResolveLatestDependencies_BasicAuth(t *testing.T) {
+	var sawUser, sawPass string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="charts"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		sawUser, sawPass = u, p
+		_, _ = w.Write([]byte("apiVersion: v1\nentries:\n  redis:\n    - name: redis\n      version: 1.1.0\n      urls: [redis-1.1.0.tgz]\n"))
+	}))
+	defer srv.Close()
+
+	p := writeChart(t, fmt.Sprintf("apiVersion: v2\nname: x\nversion: 0.1.0\ndependencies:\n  - name: redis\n    version: ^1.0.0\n    repository: %s\n", srv.URL))
+
+	// Without credentials the download fails.
+	if _, _, err := ResolveLatestDependencies(context.Background(), p, nil); err == nil {
+		t.Fatalf("expected error without credentials")
+	}
+
+	t.Setenv("CHARTS_TOKEN", "s3cret")
+	credsPath := filepath.Join(t.TempDir(), "creds.yaml")
+	creds := fmt.Sprintf("%s/:\n  username: robot\n  passwordEnv: CHARTS_TOKEN\n", srv.URL)
+	if err := os.WriteFile(credsPath, []byte(creds), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	c, err := LoadCredentialsFile(credsPath)
+	if err != nil {
+		t.Fatalf("LoadCredentialsFile: %v", err)
+	}
+
+	got, _, err := ResolveLatestDependencies(context.Background(), p, &Options{Credentials: c})
+	if err != nil {
+		t.Fatalf("ResolveLatestDependencies: %v", err)
+	}
+	if len(got) != 1 || got[0].NewVersion != "1.1.0" {
+		t.Fatalf("unexpected result: %#v", got)
+	}
+	if sawUser != "robot" || sawPass != "s3cret" {
+		t.Fatalf("basic auth got %q:%q", sawUser, sawPass)
+	}
+}
+
+func TestResolveLatestDependencies_ReusesRepositoryCache(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "should not be called", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	p := writeChart(t, fmt.Sprintf("apiVersion: v2\nname: x\nversion: 0.1.0\ndependencies:\n  - name: redis\n    version: ^1.0.0\n    repository: %s\n", srv.URL))
+
+	// Simulate `helm repo add bitnami <url> && helm repo update`.
+	dir := t.TempDir()
+	cacheDir := filepath.Join(dir, "repository")
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	index := "apiVersion: v1\nentries:\n  redis:\n    - name: redis\n      version: 1.2.0\n      urls: [redis-1.2.0.tgz]\n"
+	if err := os.WriteFile(filepath.Join(cacheDir, "bitnami-index.yaml"), []byte(index), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	reposPath := filepath.Join(dir, "repositories.yaml")
+	repos := fmt.Sprintf("apiVersion: v1\nrepositories:\n  - name: bitnami\n    url: %s\n", srv.URL)
+	if err := os.WriteFile(reposPath, []byte(repos), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	got, _, err := ResolveLatestDependencies(context.Background(), p, &Options{RepositoryCache: cacheDir, RepositoryConfig: reposPath})
+	if err != nil {
+		t.Fatalf("ResolveLatestDependencies: %v", err)
+	}
+	if len(got) != 1 || got[0].NewVersion != "1.2.0" {
+		t.Fatalf("unexpected result: %#v", got)
+	}
+	if requests != 0 {
+		t.Fatalf("expected cached index to be used, saw %d requests", requests)
+	}
+}
+
+func TestResolveLatestDependencies_DoesNotPersistUnnamedIndexes(t *testing.T) {
+	srv := serveIndex(t, "redis", "1.2.0")
+	p := writeChart(t, fmt.Sprintf("apiVersion: v2\nname: x\nversion: 0.1.0\ndependencies:\n  - name: redis\n    version: ^1.0.0\n    repository: %s\n", srv.URL))
+	cacheDir := t.TempDir()
+	opts := &Options{RepositoryCache: cacheDir, RepositoryConfig: filepath.Join(t.TempDir(), "repositories.yaml")}
+
+	// A stale index under the name this tool used to store downloads as is ignored.
+	stale := "apiVersion: v1\nentries:\n  redis:\n    - name: redis\n      version: 1.0.0\n      urls: [redis-1.0.0.tgz]\n"
+	if err := os.WriteFile(filepath.Join(cacheDir, cacheName(srv.URL)+"-index.yaml"), []byte(stale), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	got, _, err := ResolveLatestDependencies(context.Background(), p, opts)
+	if err != nil {
+		t.Fatalf("ResolveLatestDependencies: %v", err)
+	}
+	if len(got) != 1 || got[0].NewVersion != "1.2.0" {
+		t.Fatalf("unexpected result: %#v", got)
+	}
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatalf("ReadDir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected nothing added to the cache, found %d entries", len(entries))
+	}
+}
+
+func TestResolveLatestDependencies_UpdateModes(t *testing.T) {
+	srv := serveIndex(t, "redis", "19.0.3", "19.0.7", "19.4.1", "20.1.0")
+
+	for _, tc := range []struct {
+		mode UpdateMode
+		want string
+	}{
+		{ModeLatest, "20.1.0"}, // an exact pin is not a constraint
+		{ModePatch, "19.0.7"},
+		{ModeMinor, "19.4.1"},
+	} {
+		t.Run(string(tc.mode)+"/directive", func(t *testing.T) {
+			p := writeChart(t, fmt.Sprintf("apiVersion: v2\nname: x\nversion: 0.1.0\ndependencies:\n  # bump-dep: mode=%s\n  - name: redis\n    version: 19.0.3\n    repository: %s\n", tc.mode, srv.URL))
+			assertDepVersion(t, p, nil, tc.want)
+		})
+		t.Run(string(tc.mode)+"/policy", func(t *testing.T) {
+			p := writeChart(t, fmt.Sprintf("apiVersion: v2\nname: x\nversion: 0.1.0\ndependencies:\n  - name: redis\n    version: 19.0.3\n    repository: %s\n", srv.URL))
+			assertDepVersion(t, p, &Options{Mode: tc.mode}, tc.want)
+		})
+	}
+}
+
+func TestResolveLatestDependencies_ExactPinVsRange(t *testing.T) {
+	srv := serveIndex(t, "redis", "19.0.3", "19.0.7", "19.4.1", "20.1.0")
+
+	for current, want := range map[string]string{
+		"19.0.3":  "20.1.0",
+		"v19.0.3": "20.1.0",
+		"~19.0.0": "19.0.7",
+		"^19":     "19.4.1",
+		"19.0":    "19.0.7",
+	} {
+		t.Run(current, func(t *testing.T) {
+			p := writeChart(t, fmt.Sprintf("apiVersion: v2\nname: x\nversion: 0.1.0\ndependencies:\n  - name: redis\n    version: %q\n    repository: %s\n", current, srv.URL))
+			assertDepVersion(t, p, nil, want)
+		})
+	}
+}
+
+func TestResolveLatestDependencies_DirectiveOverridesPolicy(t *testing.T) {
+	srv := serveIndex(t, "redis", "19.0.3", "19.0.7", "19.4.1")
+	p := writeChart(t, fmt.Sprintf("apiVersion: v2\nname: x\nversion: 0.1.0\ndependencies:\n  # bump-dep: mode=minor\n  - repository: %s\n    name: redis\n    version: 19.0.3\n", srv.URL))
+	assertDepVersion(t, p, &Options{Mode: ModePatch}, "19.4.1")
+}
+
+func TestScanDependencyModes_Errors(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown mode": "dependencies:\n  # bump-dep: mode=major\n  - name: redis\n",
+		"not an item":  "# bump-dep: mode=patch\nname: x\n",
+		"no name":      "dependencies:\n  # bump-dep: mode=patch\n  - version: 1.0.0\nname: x\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := writeChart(t, content)
+			if _, err := ScanDependencyModes(p); err == nil {
+				t.Fatalf("expected error")
+			}
+		})
+	}
+}
+
+func assertDepVersion(t *testing.T, chartYAMLPath string, opts *Options, want string) {
+	t.Helper()
+	got, _, err := ResolveLatestDependencies(context.Background(), chartYAMLPath, opts)
+	if err != nil {
+		t.Fatalf("ResolveLatestDependencies: %v", err)
+	}
+	if want == "" {
+		if len(got) != 0 {
+			t.Fatalf("expected no update, got %#v", got)
+		}
+		return
+	}
+	if len(got) != 1 || got[0].NewVersion != want {
+		t.Fatalf("got %#v want NewVersion %q", got, want)
+	}
+}
+
+func TestResolveLatestDependencies_IndexUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+	p := writeChart(t, fmt.Sprintf("apiVersion: v2\nname: x\nversion: 0.1.0\ndependencies:\n  - name: redis\n    version: 1.0.0\n    repository: %s\n", srv.URL))
+
+	_, _, err := ResolveLatestDependencies(context.Background(), p, nil)
+	if !errors.Is(err, ErrIndexUnavailable) {
+		t.Fatalf("expected ErrIndexUnavailable, got %v", err)
+	}
+}
+
+func TestResolveLatestDependencies_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+	p := writeChart(t, fmt.Sprintf("apiVersion: v2\nname: x\nversion: 0.1.0\ndependencies:\n  - name: redis\n    version: 1.0.0\n    repository: %s\n", srv.URL))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := ResolveLatestDependencies(ctx, p, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrIndexUnavailable) {
+		t.Fatalf("expected a deadline error, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("timed out after %v", d)
+	}
+}
+
+func TestResolveLatestDependencies_SkipReasons(t *testing.T) {
+	srv := serveIndex(t, "redis", "1.0.0", "1.1.0")
+	p := writeChart(t, fmt.Sprintf(`apiVersion: v2
+name: x
+version: 0.1.0
+dependencies:
+  - name: vendored
+    version: 1.0.0
+  - name: oci
+    version: 1.0.0
+    repository: oci://ghcr.io/example/charts
+  - name: local
+    version: 1.0.0
+    repository: file://../local
+  - name: missing
+    version: 1.0.0
+    repository: %[1]s
+  - name: redis
+    version: ^2.0.0
+    repository: %[1]s
+`, srv.URL))
+	local := filepath.Join(filepath.Dir(p), "..", "local")
+	if err := os.MkdirAll(local, 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(local, "Chart.yaml"), []byte("apiVersion: v2\nname: local\nversion: 1.0.0\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	_, skipped, err := ResolveLatestDependencies(context.Background(), p, nil)
+	if err != nil {
+		t.Fatalf("ResolveLatestDependencies: %v", err)
+	}
+	want := []SkipReason{SkipNoRepository, SkipOCIUnsupported, SkipUnsupportedRepository, SkipNoIndexEntry, SkipConstraintUnsatisfiable}
+	if len(skipped) != len(want) {
+		t.Fatalf("got %d skipped deps want %d: %#v", len(skipped), len(want), skipped)
+	}
+	for i, sd := range skipped {
+		if sd.Index != i || sd.Reason != want[i] {
+			t.Fatalf("skipped[%d] got index %d reason %q want index %d reason %q", i, sd.Index, sd.Reason, i, want[i])
+		}
+	}
+}
+
+func TestResolveLatestDependencies_FileRepository(t *testing.T) {
+	p := writeChart(t, "apiVersion: v2\nname: x\nversion: 0.1.0\n")
+	repoDir := filepath.Join(filepath.Dir(p), "repo")
+	if err := os.MkdirAll(repoDir, 0o755); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	index := "apiVersion: v1\nentries:\n  redis:\n"
+	for _, v := range []string{"1.0.0", "1.2.0", "2.0.0"} {
+		index += fmt.Sprintf("    - name: redis\n      version: %s\n      urls: [redis-%s.tgz]\n", v, v)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "index.yaml"), []byte(index), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	for _, repoURL := range []string{"file://" + filepath.ToSlash(repoDir), "file://./repo"} {
+		chart := fmt.Sprintf("apiVersion: v2\nname: x\nversion: 0.1.0\ndependencies:\n  - name: redis\n    version: 1.0.0\n    repository: %s\n", repoURL)
+		if err := os.WriteFile(p, []byte(chart), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		resolved, skipped, err := ResolveLatestDependencies(context.Background(), p, &Options{Mode: ModeMinor})
+		if err != nil {
+			t.Fatalf("%s: ResolveLatestDependencies: %v", repoURL, err)
+		}
+		if len(skipped) != 0 || len(resolved) != 1 || resolved[0].NewVersion != "1.2.0" || resolved[0].ResolvedRepository != repoURL {
+			t.Fatalf("%s: got resolved %#v skipped %#v", repoURL, resolved, skipped)
+		}
+	}
+
+	missing := "file://" + filepath.ToSlash(filepath.Join(filepath.Dir(p), "nope"))
+	chart := fmt.Sprintf("apiVersion: v2\nname: x\nversion: 0.1.0\ndependencies:\n  - name: redis\n    version: 1.0.0\n    repository: %s\n", missing)
+	if err := os.WriteFile(p, []byte(chart), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	_, _, err := ResolveLatestDependencies(context.Background(), p, nil)
+	if err == nil || !strings.Contains(err.Error(), missing) || !strings.Contains(err.Error(), "index.yaml") || errors.Is(err, ErrIndexUnavailable) {
+		t.Fatalf("expected a non-transient error naming the missing index, got %v", err)
+	}
+}
+
+func TestResolveLatestDependencies_CheckKubeVersion(t *testing.T) {
+	body := `apiVersion: v1
+entries:
+  redis:
+    - {name: redis, version: 1.0.0, urls: [redis-1.0.0.tgz]}
+    - {name: redis, version: 1.1.0, kubeVersion: ">=1.21.0-0", urls: [redis-1.1.0.tgz]}
+    - {name: redis, version: 1.2.0, kubeVersion: ">=1.30.0-0", urls: [redis-1.2.0.tgz]}
+  valkey:
+    - {name: valkey, version: 2.0.0, urls: [valkey-2.0.0.tgz]}
+    - {name: valkey, version: 2.1.0, kubeVersion: ">=1.30.0-0", urls: [valkey-2.1.0.tgz]}
+`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	p := writeChart(t, fmt.Sprintf(`apiVersion: v2
+name: x
+version: 0.1.0
+kubeVersion: ">=1.25.0-0"
+dependencies:
+  - name: redis
+    version: ^1.0.0
+    repository: %[1]s
+  - name: valkey
+    version: ">2.0.0"
+    repository: %[1]s
+`, srv.URL))
+
+	resolved, _, err := ResolveLatestDependencies(context.Background(), p, nil)
+	if err != nil || len(resolved) != 2 || resolved[0].NewVersion != "1.2.0" {
+		t.Fatalf("without CheckKubeVersion got %#v, %v", resolved, err)
+	}
+
+	resolved, skipped, err := ResolveLatestDependencies(context.Background(), p, &Options{CheckKubeVersion: true})
+	if err != nil {
+		t.Fatalf("ResolveLatestDependencies: %v", err)
+	}
+	if len(resolved) != 1 || resolved[0].Name != "redis" || resolved[0].NewVersion != "1.1.0" {
+		t.Fatalf("resolved got %#v want redis 1.1.0", resolved)
+	}
+	if len(skipped) != 1 || skipped[0].Name != "valkey" || skipped[0].Reason != SkipKubeVersion {
+		t.Fatalf("skipped got %#v want valkey %s", skipped, SkipKubeVersion)
+	}
+}
+
+func TestKubeCompatible(t *testing.T) {
+	if ok, err := kubeCompatible(""); ok != nil || err != nil {
+		t.Fatalf("kubeCompatible(\"\") got a filter or %v", err)
+	}
+	if _, err := kubeCompatible("not a range"); err == nil {
+		t.Fatalf("kubeCompatible: expected an error for an invalid kubeVersion")
+	}
+	ok, err := kubeCompatible(">=1.25.0-0 <1.29.0-0")
+	if err != nil {
+		t.Fatalf("kubeCompatible: %v", err)
+	}
+	for kv, want := range map[string]bool{
+		"":                    true,
+		">=1.21.0-0":          true,
+		"<1.30.0-0":           true,
+		">=1.26.0-0":          false,
+		">=1.20.0-0 <1.28.0":  false,
+		"^1.20.0-0":           true,
+		"garbage constraint!": false,
+	} {
+		cv := &repo.ChartVersion{Metadata: &chart.Metadata{KubeVersion: kv}}
+		if got := ok(cv); got != want {
+			t.Fatalf("kubeVersion %q got %v want %v", kv, got, want)
+		}
+	}
+}
+
+func TestIsDowngrade(t *testing.T) {
+	for _, tc := range []struct {
+		cur, next string
+		want      bool
+	}{
+		{"2.0.0", "1.9.0", true},
+		{"1.0.0", "1.0.1", false},
+		{"v2.0.0", "1.0.0", true},
+		{"^2.0.0", "1.0.0", false},
+	} {
+		if got := isDowngrade(tc.cur, tc.next); got != tc.want {
+			t.Fatalf("isDowngrade(%q, %q) = %v want %v", tc.cur, tc.next, got, tc.want)
+		}
+	}
+}
+
+func TestNoVersionReason(t *testing.T) {
+	// Helm drops non-semver entries when loading an index, so an index without semver
+	// versions can't be served; check the classification directly.
+	versions := repo.ChartVersions{{Metadata: &chart.Metadata{Version: "nightly"}}}
+	if got := noVersionReason(versions, "^1.0.0"); got != SkipNoMatchingVersion {
+		t.Fatalf("got %q want %q", got, SkipNoMatchingVersion)
+	}
+	versions = repo.ChartVersions{{Metadata: &chart.Metadata{Version: "1.0.0"}}}
+	if got := noVersionReason(versions, "^2.0.0"); got != SkipConstraintUnsatisfiable {
+		t.Fatalf("got %q want %q", got, SkipConstraintUnsatisfiable)
+	}
+}
+
+func TestUpdateLock(t *testing.T) {
+	chartYAML := []byte("apiVersion: v2\nname: x\nversion: 0.1.0\ndependencies:\n  - name: redis\n    version: 1.0.1\n    repository: https://charts.example.com\n  - name: common\n    version: ^2.0.0\n    repository: https://charts.example.com\n")
+	now := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	// A constraint can't be locked without resolving it.
+	if _, _, err := UpdateLock(chartYAML, nil, now); err == nil {
+		t.Fatalf("expected an error creating a lock for a constraint")
+	}
+
+	existing := []byte("dependencies:\n- name: redis\n  repository: https://charts.example.com\n  version: 1.0.0\n- name: common\n  repository: https://charts.example.com\n  version: 2.3.0\ndigest: sha256:old\ngenerated: \"2024-01-01T00:00:00Z\"\n")
+	got, changed, err := UpdateLock(chartYAML, existing, now)
+	if err != nil {
+		t.Fatalf("UpdateLock: %v", err)
+	}
+	if !changed {
+		t.Fatalf("expected the lock to change")
+	}
+	var lock chart.Lock
+	if err := yaml.Unmarshal(got, &lock); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if lock.Dependencies[0].Version != "1.0.1" || lock.Dependencies[1].Version != "2.3.0" || !lock.Generated.Equal(now) {
+		t.Fatalf("unexpected lock:\n%s", got)
+	}
+
+	// Re-locking the same dependencies leaves the lock, and its timestamp, alone.
+	again, changed, err := UpdateLock(chartYAML, got, now.Add(time.Hour))
+	if err != nil || changed || string(again) != string(got) {
+		t.Fatalf("expected an unchanged lock, got changed=%v err=%v:\n%s", changed, err, again)
+	}
+}
@@ -0,0 +1,47 @@
+package helmdeps
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// kubeMaxMinor bounds the Kubernetes 1.x minor releases compared by kubeCompatible.
+const kubeMaxMinor = 100
+
+// kubeCompatible returns a filter accepting chart versions whose kubeVersion allows every
+// Kubernetes minor release (1.N.0) that the umbrella kubeVersion allows. Versions without a
+// kubeVersion are always accepted. It returns nil if umbrella is empty, since then there is
+// nothing to violate.
+func kubeCompatible(umbrella string) (func(*repo.ChartVersion) bool, error) {
+	if strings.TrimSpace(umbrella) == "" {
+		return nil, nil
+	}
+	uc, err := semver.NewConstraint(umbrella)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kubeVersion %q: %w", umbrella, err)
+	}
+	var supported []*semver.Version
+	for minor := uint64(0); minor < kubeMaxMinor; minor++ {
+		if v := semver.New(1, minor, 0, "", ""); uc.Check(v) {
+			supported = append(supported, v)
+		}
+	}
+	return func(cv *repo.ChartVersion) bool {
+		if cv.Metadata == nil || strings.TrimSpace(cv.KubeVersion) == "" {
+			return true
+		}
+		dc, err := semver.NewConstraint(cv.KubeVersion)
+		if err != nil {
+			return false
+		}
+		for _, v := range supported {
+			if !dc.Check(v) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
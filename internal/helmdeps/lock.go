@@ -0,0 +1,79 @@
+package helmdeps
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/provenance"
+	"sigs.k8s.io/yaml"
+)
+
+// LockFileName is the lockfile helm dependency update writes next to Chart.yaml.
+const LockFileName = "Chart.lock"
+
+// UpdateLock returns Chart.lock contents matching the dependencies in chartYAML, in the
+// format helm dependency update writes.
+//
+// existing is the current Chart.lock, or nil to create one. Its entries are kept, taking the
+// version from chartYAML where that is an exact version, so dependencies this tool does not
+// resolve stay locked as they were. A new lock, or one whose entries no longer line up with
+// chartYAML, needs an exact version for every dependency.
+//
+// It reports whether the result differs from existing; an unchanged lock keeps its
+// generated timestamp.
+func UpdateLock(chartYAML, existing []byte, now time.Time) ([]byte, bool, error) {
+	var meta chart.Metadata
+	if err := yaml.Unmarshal(chartYAML, &meta); err != nil {
+		return nil, false, fmt.Errorf("parse Chart.yaml: %w", err)
+	}
+	var old *chart.Lock
+	if existing != nil {
+		old = &chart.Lock{}
+		if err := yaml.Unmarshal(existing, old); err != nil {
+			return nil, false, fmt.Errorf("parse %s: %w", LockFileName, err)
+		}
+		if len(old.Dependencies) != len(meta.Dependencies) {
+			old.Dependencies = nil
+		}
+	}
+
+	locked := make([]*chart.Dependency, len(meta.Dependencies))
+	for i, dep := range meta.Dependencies {
+		version := dep.Version
+		if _, err := semver.StrictNewVersion(version); err != nil {
+			if old == nil || len(old.Dependencies) == 0 || old.Dependencies[i].Name != dep.Name {
+				return nil, false, fmt.Errorf("cannot lock dependency %s: version %q is not exact; run helm dependency update", dep.Name, version)
+			}
+			version = old.Dependencies[i].Version
+		}
+		locked[i] = &chart.Dependency{Name: dep.Name, Repository: dep.Repository, Version: version}
+	}
+
+	digest, err := hashReq(meta.Dependencies, locked)
+	if err != nil {
+		return nil, false, err
+	}
+	if old != nil && old.Digest == digest {
+		return existing, false, nil
+	}
+	out, err := yaml.Marshal(&chart.Lock{Generated: now, Digest: digest, Dependencies: locked})
+	if err != nil {
+		return nil, false, err
+	}
+	return out, true, nil
+}
+
+// hashReq is Helm's lockfile digest (internal/resolver.HashReq), which helm dependency build
+// checks to decide whether Chart.lock is in sync with Chart.yaml.
+func hashReq(req, lock []*chart.Dependency) (string, error) {
+	data, err := json.Marshal([2][]*chart.Dependency{req, lock})
+	if err != nil {
+		return "", err
+	}
+	s, err := provenance.Digest(bytes.NewBuffer(data))
+	return "sha256:" + s, err
+}
@@ -0,0 +1,161 @@
+package helmdeps
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// UpdateMode limits how far a dependency pinned to an exact version may move.
+type UpdateMode string
+
+const (
+	// ModeLatest picks the highest available version (subject to any constraint).
+	ModeLatest UpdateMode = "latest"
+	// ModePatch picks the latest patch within the current minor (19.0.3 -> 19.0.x).
+	ModePatch UpdateMode = "patch"
+	// ModeMinor picks the latest minor within the current major (19.0.3 -> 19.x.x).
+	ModeMinor UpdateMode = "minor"
+)
+
+// ParseUpdateMode validates a mode name. The empty string means ModeLatest.
+func ParseUpdateMode(s string) (UpdateMode, error) {
+	switch m := UpdateMode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "":
+		return ModeLatest, nil
+	case ModeLatest, ModePatch, ModeMinor:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown dependency update mode %q (want latest, patch, or minor)", s)
+	}
+}
+
+// modeConstraint derives the implicit constraint for mode from an exact current version. It
+// returns "" when mode does not restrict anything or current is not an exact version.
+func modeConstraint(mode UpdateMode, current string) string {
+	if mode == ModeLatest || mode == "" {
+		return ""
+	}
+	v, ok := exactVersion(current)
+	if !ok {
+		return ""
+	}
+	var upper semver.Version
+	switch mode {
+	case ModePatch:
+		upper = v.IncMinor()
+	case ModeMinor:
+		upper = v.IncMajor()
+	default:
+		return ""
+	}
+	return fmt.Sprintf(">= %s, < %s", v.String(), upper.String())
+}
+
+// exactVersion parses s as an exact version pin (19.0.3 or v19.0.3). Range expressions, including
+// partial versions like 19 or 19.0 that Helm reads as 19.x or 19.0.x, are not exact.
+func exactVersion(s string) (*semver.Version, bool) {
+	v, err := semver.StrictNewVersion(strings.TrimPrefix(strings.TrimSpace(s), "v"))
+	return v, err == nil
+}
+
+var (
+	reDepDirective = regexp.MustCompile(`^\s*#\s*bump-dep:\s*(.*)$`)
+	reDepItem      = regexp.MustCompile(`^(\s*)-\s+(.*)$`)
+	reDepName      = regexp.MustCompile(`^\s*name:\s*["']?([^"'#\s]+)["']?`)
+)
+
+// ScanDependencyModes reads `# bump-dep: mode=<latest|patch|minor>` comments from a Chart.yaml
+// and returns the mode for each dependency name. The comment must immediately precede the
+// dependency's list item:
+//
+//	dependencies:
+//	  # bump-dep: mode=patch
+//	  - name: redis
+//	    version: 19.0.3
+func ScanDependencyModes(chartYAMLPath string) (map[string]UpdateMode, error) {
+	f, err := os.Open(chartYAMLPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := map[string]UpdateMode{}
+	s := bufio.NewScanner(f)
+	lineNo := 0
+	var pending *UpdateMode
+	pendingLine := 0
+	// itemIndent is the indentation of the list item a directive applied to, while its name
+	// has not been seen yet; -1 otherwise.
+	itemIndent := -1
+	var itemMode UpdateMode
+	for s.Scan() {
+		lineNo++
+		line := s.Text()
+		if m := reDepDirective.FindStringSubmatch(line); m != nil {
+			mode, err := parseDepDirectiveArgs(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", chartYAMLPath, lineNo, err)
+			}
+			pending, pendingLine = &mode, lineNo
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		if pending != nil {
+			im := reDepItem.FindStringSubmatch(line)
+			if im == nil {
+				return nil, fmt.Errorf("%s:%d: bump-dep directive must precede a dependency list item", chartYAMLPath, pendingLine)
+			}
+			itemIndent, itemMode, pending = len(im[1]), *pending, nil
+			line = strings.Repeat(" ", itemIndent+2) + im[2]
+			indent = itemIndent + 2
+		} else if itemIndent >= 0 && indent <= itemIndent {
+			// Left the item without finding its name.
+			return nil, fmt.Errorf("%s:%d: bump-dep directive applies to a dependency without a name", chartYAMLPath, lineNo)
+		}
+
+		if itemIndent >= 0 && indent == itemIndent+2 {
+			if nm := reDepName.FindStringSubmatch(line); nm != nil {
+				out[nm[1]] = itemMode
+				itemIndent = -1
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if pending != nil || itemIndent >= 0 {
+		return nil, fmt.Errorf("%s:%d: bump-dep directive does not apply to a named dependency", chartYAMLPath, pendingLine)
+	}
+	return out, nil
+}
+
+func parseDepDirectiveArgs(argStr string) (UpdateMode, error) {
+	mode := ModeLatest
+	for _, a := range strings.Fields(argStr) {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok {
+			return "", fmt.Errorf("invalid bump-dep argument %q (want key=value)", a)
+		}
+		switch k {
+		case "mode":
+			m, err := ParseUpdateMode(v)
+			if err != nil {
+				return "", err
+			}
+			mode = m
+		default:
+			return "", fmt.Errorf("unknown bump-dep argument %q", k)
+		}
+	}
+	return mode, nil
+}
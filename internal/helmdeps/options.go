@@ -0,0 +1,135 @@
+package helmdeps
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	yaml "github.com/goccy/go-yaml"
+	"helm.sh/helm/v3/pkg/repo"
+)
+
+// Options control how Helm repositories are accessed. The zero value downloads every index
+// anonymously into Helm's default cache.
+type Options struct {
+	// Credentials are keyed by repository URL (trailing slashes ignored).
+	Credentials map[string]RepoCredentials
+	// RepositoryCache is an existing Helm repository cache directory (like
+	// $HELM_CACHE_HOME/repository). Index files already present there for repositories named
+	// in RepositoryConfig are used instead of being downloaded. Other repositories' indexes
+	// are downloaded to a temporary directory, so nothing is added to the cache.
+	RepositoryCache string
+	// RepositoryConfig is a Helm repositories.yaml used to map repository URLs to the names
+	// their cached index files use. Defaults to Helm's configured repositories.yaml.
+	RepositoryConfig string
+	// Mode is the update mode for dependencies without a `# bump-dep:` directive. The empty
+	// value means ModeLatest.
+	Mode UpdateMode
+	// IndexCache, if set, shares loaded repository indexes between ResolveLatestDependencies
+	// calls, e.g. for the subcharts of an umbrella chart.
+	IndexCache *IndexCache
+	// AllowDowngrade lets a dependency move to a version lower than its current exact
+	// version. Otherwise such a dependency is skipped with SkipDowngrade.
+	AllowDowngrade bool
+	// CheckKubeVersion passes over dependency versions whose kubeVersion (from the repository
+	// index) does not allow every Kubernetes release the chart's own kubeVersion allows. A
+	// dependency with no compatible version is skipped with SkipKubeVersion.
+	CheckKubeVersion bool
+}
+
+// IndexCache holds repository indexes by URL for the lifetime of a run. It is not safe for
+// concurrent use.
+type IndexCache struct {
+	indexes map[string]*repo.IndexFile
+}
+
+// NewIndexCache returns an empty cache.
+func NewIndexCache() *IndexCache {
+	return &IndexCache{indexes: map[string]*repo.IndexFile{}}
+}
+
+// RepoCredentials authenticate to one Helm repository. Literal values take precedence over
+// the *Env fields, which name environment variables to read instead.
+type RepoCredentials struct {
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	UsernameEnv string `yaml:"usernameEnv"`
+	PasswordEnv string `yaml:"passwordEnv"`
+	CertFile    string `yaml:"certFile"`
+	KeyFile     string `yaml:"keyFile"`
+	CAFile      string `yaml:"caFile"`
+}
+
+// LoadCredentialsFile reads a YAML map of repository URL to RepoCredentials:
+//
+//	https://charts.example.com:
+//	  usernameEnv: CHARTS_USER
+//	  passwordEnv: CHARTS_TOKEN
+func LoadCredentialsFile(path string) (map[string]RepoCredentials, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]RepoCredentials
+	if err := yaml.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("parse credentials file %q: %w", path, err)
+	}
+	out := make(map[string]RepoCredentials, len(m))
+	for u, c := range m {
+		out[normalizeRepoURL(u)] = c
+	}
+	return out, nil
+}
+
+// entryFor builds the repo.Entry for repoURL, applying any configured credentials.
+func (o *Options) entryFor(repoURL string, names map[string]string) *repo.Entry {
+	e := &repo.Entry{URL: repoURL, Name: names[normalizeRepoURL(repoURL)]}
+	if e.Name == "" {
+		e.Name = cacheName(repoURL)
+	}
+	c, ok := o.Credentials[normalizeRepoURL(repoURL)]
+	if !ok {
+		return e
+	}
+	e.Username = c.Username
+	if e.Username == "" && c.UsernameEnv != "" {
+		e.Username = os.Getenv(c.UsernameEnv)
+	}
+	e.Password = c.Password
+	if e.Password == "" && c.PasswordEnv != "" {
+		e.Password = os.Getenv(c.PasswordEnv)
+	}
+	e.CertFile = c.CertFile
+	e.KeyFile = c.KeyFile
+	e.CAFile = c.CAFile
+	return e
+}
+
+// repoNames maps repository URLs to their names in the Helm repositories.yaml at path.
+// A missing or unreadable file yields no names.
+func repoNames(path string) map[string]string {
+	names := map[string]string{}
+	if path == "" {
+		return names
+	}
+	f, err := repo.LoadFile(path)
+	if err != nil {
+		return names
+	}
+	for _, e := range f.Repositories {
+		names[normalizeRepoURL(e.URL)] = e.Name
+	}
+	return names
+}
+
+// cacheName derives a stable cache file name for a repository not known to Helm.
+func cacheName(repoURL string) string {
+	sum := sha256.Sum256([]byte(normalizeRepoURL(repoURL)))
+	return "bumper-" + hex.EncodeToString(sum[:8])
+}
+
+func normalizeRepoURL(u string) string {
+	return strings.TrimRight(strings.TrimSpace(u), "/")
+}
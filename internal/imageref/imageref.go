@@ -0,0 +1,23 @@
+// Package imageref validates the image repository names that directives, pins, and the
+// resolver accept.
+package imageref
+
+import (
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// ValidateRepository checks that imageRepo is a repository with an explicit registry, like
+// ghcr.io/org/image or localhost:5000/image. Registries are never defaulted to Docker Hub, so a
+// bare org/image is rejected.
+func ValidateRepository(imageRepo string) error {
+	repo, err := name.NewRepository(imageRepo, name.WithDefaultRegistry(""))
+	if err != nil {
+		return fmt.Errorf("invalid image repository %q: %w", imageRepo, err)
+	}
+	if repo.RegistryStr() == "" {
+		return fmt.Errorf("image repository must be a full path including the registry, like ghcr.io/org/image or localhost:5000/image: %q", imageRepo)
+	}
+	return nil
+}
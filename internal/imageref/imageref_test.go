@@ -0,0 +1,16 @@
+package imageref
+
+import "testing"
+
+func TestValidateRepository(t *testing.T) {
+	for _, repo := range []string{"ghcr.io/org/app", "localhost:5000/app", "reg.io:5000/org/app", "docker.io/library/nginx"} {
+		if err := ValidateRepository(repo); err != nil {
+			t.Fatalf("ValidateRepository(%q): %v", repo, err)
+		}
+	}
+	for _, repo := range []string{"app", "org/app", "ghcr.io/org/App", "ghcr.io/org/app:1.2.3", ""} {
+		if err := ValidateRepository(repo); err == nil {
+			t.Fatalf("ValidateRepository(%q): expected an error", repo)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package imageresolver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+
+	"go.uber.org/zap"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+)
+
+// RegistryAuth maps a registry host to the environment variables holding its credentials.
+// Credentials are read from the environment when a request is made, never from config.
+type RegistryAuth struct {
+	Host        string
+	UsernameEnv string
+	PasswordEnv string
+}
+
+// ParseRegistryAuth parses host=USERNAME_ENV:PASSWORD_ENV (e.g. quay.io=QUAY_USER:QUAY_TOKEN).
+func ParseRegistryAuth(spec string) (RegistryAuth, error) {
+	host, envs, ok := strings.Cut(strings.TrimSpace(spec), "=")
+	if !ok || host == "" {
+		return RegistryAuth{}, fmt.Errorf("invalid registry auth %q, expected host=USERNAME_ENV:PASSWORD_ENV", spec)
+	}
+	user, pass, ok := strings.Cut(envs, ":")
+	if !ok || user == "" || pass == "" {
+		return RegistryAuth{}, fmt.Errorf("invalid registry auth %q, expected host=USERNAME_ENV:PASSWORD_ENV", spec)
+	}
+	return RegistryAuth{Host: host, UsernameEnv: user, PasswordEnv: pass}, nil
+}
+
+// NewKeychain returns a keychain that uses auths for their hosts and DefaultKeychain otherwise.
+// If a host's environment variables are unset, that host falls back to DefaultKeychain too,
+// with a warning naming the missing variables.
+// githubTokenFile, if set, is read for the ghcr.io token when $GITHUB_TOKEN is unset, in place
+// of $GITHUB_TOKEN_FILE; see GitHubToken.
+func NewKeychain(auths []RegistryAuth, githubTokenFile string) authn.Keychain {
+	byHost := make(map[string]RegistryAuth, len(auths))
+	for _, a := range auths {
+		byHost[a.Host] = a
+	}
+	return envKeychain{byHost: byHost, fallback: ghcrKeychain{fallback: authn.DefaultKeychain, tokenFile: githubTokenFile}, warned: &sync.Map{}}
+}
+
+// GitHubToken returns $GITHUB_TOKEN or, if it is unset, the trimmed contents of tokenFile,
+// falling back to the file named by $GITHUB_TOKEN_FILE. Mounting the token as a file keeps it
+// out of the environment of every process in the job. It returns "" if none is set.
+func GitHubToken(tokenFile string) (string, error) {
+	if tok := os.Getenv("GITHUB_TOKEN"); tok != "" {
+		return tok, nil
+	}
+	if tokenFile == "" {
+		tokenFile = os.Getenv("GITHUB_TOKEN_FILE")
+	}
+	if tokenFile == "" {
+		return "", nil
+	}
+	b, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("read GitHub token file: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+type envKeychain struct {
+	byHost   map[string]RegistryAuth
+	fallback authn.Keychain
+	// warned records the hosts already warned about, so each is reported once per run.
+	warned *sync.Map
+}
+
+func (k envKeychain) Resolve(resource authn.Resource) (authn.Authenticator, error) {
+	return k.ResolveContext(context.Background(), resource)
+}
+
+// ResolveContext implements authn.ContextKeychain, logging to ctx's logger.
+func (k envKeychain) ResolveContext(ctx context.Context, resource authn.Resource) (authn.Authenticator, error) {
+	if a, ok := k.byHost[resource.RegistryStr()]; ok {
+		user, pass := os.Getenv(a.UsernameEnv), os.Getenv(a.PasswordEnv)
+		if user != "" && pass != "" {
+			return authn.FromConfig(authn.AuthConfig{Username: user, Password: pass}), nil
+		}
+		if _, seen := k.warned.LoadOrStore(a.Host, true); !seen {
+			var unset []string
+			for _, name := range []string{a.UsernameEnv, a.PasswordEnv} {
+				if os.Getenv(name) == "" {
+					unset = append(unset, name)
+				}
+			}
+			logutil.FromContext(ctx).Warn("registry auth variables unset; using the default keychain", zap.String("host", a.Host), zap.Strings("unset", unset))
+		}
+	}
+	return k.fallback.Resolve(resource)
+}
@@ -0,0 +1,199 @@
+package imageresolver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func resolveAuth(t *testing.T, kc authn.Keychain, repo string) *authn.AuthConfig {
+	t.Helper()
+	r, err := name.NewRepository(repo)
+	if err != nil {
+		t.Fatalf("NewRepository: %v", err)
+	}
+	a, err := kc.Resolve(r)
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	cfg, err := a.Authorization()
+	if err != nil {
+		t.Fatalf("Authorization: %v", err)
+	}
+	return cfg
+}
+
+func TestNewKeychain(t *testing.T) {
+	// Isolate from any ambient docker config.
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv("QUAY_USER", "robot")
+	t.Setenv("QUAY_TOKEN", "s3cret")
+	t.Setenv("GITHUB_ACTOR", "octocat")
+	t.Setenv("GITHUB_TOKEN", "ghp_x")
+
+	a, err := ParseRegistryAuth("quay.io=QUAY_USER:QUAY_TOKEN")
+	if err != nil {
+		t.Fatalf("ParseRegistryAuth: %v", err)
+	}
+	kc := NewKeychain([]RegistryAuth{a}, "")
+
+	if cfg := resolveAuth(t, kc, "quay.io/org/app"); cfg.Username != "robot" || cfg.Password != "s3cret" {
+		t.Fatalf("quay.io got %+v", cfg)
+	}
+	if cfg := resolveAuth(t, kc, "ghcr.io/org/app"); cfg.Username != "octocat" || cfg.Password != "ghp_x" {
+		t.Fatalf("ghcr.io got %+v", cfg)
+	}
+	if cfg := resolveAuth(t, kc, "registry.example.com/org/app"); cfg.Username != "" || cfg.Password != "" {
+		t.Fatalf("unconfigured host should be anonymous, got %+v", cfg)
+	}
+}
+
+func TestNewKeychain_WarnsOnUnsetVariables(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv("QUAY_USER", "robot")
+	t.Setenv("QUAY_TOKEN", "")
+	kc := NewKeychain([]RegistryAuth{{Host: "quay.io", UsernameEnv: "QUAY_USER", PasswordEnv: "QUAY_TOKEN"}}, "")
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	ctx := logutil.WithLogger(context.Background(), zap.New(core))
+	repo, _ := name.NewRepository("quay.io/org/app")
+	for range 2 {
+		a, err := authn.Resolve(ctx, kc, repo)
+		if err != nil {
+			t.Fatalf("Resolve: %v", err)
+		}
+		if a != authn.Anonymous {
+			t.Fatalf("expected the anonymous fallback, got %v", a)
+		}
+	}
+	if logs.Len() != 1 {
+		t.Fatalf("expected one warning for the host, got %d", logs.Len())
+	}
+	fields := logs.All()[0].ContextMap()
+	if unset, _ := fields["unset"].([]any); len(unset) != 1 || unset[0] != "QUAY_TOKEN" {
+		t.Fatalf("warning should name QUAY_TOKEN, got %v", fields)
+	}
+}
+
+func TestGitHubTokenFile(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv("GITHUB_ACTOR", "octocat")
+	t.Setenv("GITHUB_TOKEN", "")
+	dir := t.TempDir()
+	envFile, flagFile := filepath.Join(dir, "env-token"), filepath.Join(dir, "flag-token")
+	if err := os.WriteFile(envFile, []byte("ghp_env\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.WriteFile(flagFile, []byte("  ghp_flag\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	if cfg := resolveAuth(t, NewKeychain(nil, ""), "ghcr.io/org/app"); cfg.Username != "" || cfg.Password != "" {
+		t.Fatalf("without a token ghcr.io should be anonymous, got %+v", cfg)
+	}
+	t.Setenv("GITHUB_TOKEN_FILE", envFile)
+	if cfg := resolveAuth(t, NewKeychain(nil, ""), "ghcr.io/org/app"); cfg.Username != "octocat" || cfg.Password != "ghp_env" {
+		t.Fatalf("GITHUB_TOKEN_FILE: got %+v", cfg)
+	}
+	if cfg := resolveAuth(t, NewKeychain(nil, flagFile), "ghcr.io/org/app"); cfg.Password != "ghp_flag" {
+		t.Fatalf("token file: got %+v", cfg)
+	}
+	t.Setenv("GITHUB_TOKEN", "ghp_x")
+	if cfg := resolveAuth(t, NewKeychain(nil, flagFile), "ghcr.io/org/app"); cfg.Password != "ghp_x" {
+		t.Fatalf("GITHUB_TOKEN should take precedence, got %+v", cfg)
+	}
+
+	t.Setenv("GITHUB_TOKEN", "")
+	if _, err := GitHubToken(filepath.Join(dir, "missing")); err == nil {
+		t.Fatalf("expected an error for a missing token file")
+	}
+}
+
+func TestParseRegistryAuth_Invalid(t *testing.T) {
+	for _, spec := range []string{"quay.io", "quay.io=USER", "=USER:PASS", "quay.io=:PASS"} {
+		if _, err := ParseRegistryAuth(spec); err == nil {
+			t.Fatalf("ParseRegistryAuth(%q): expected error", spec)
+		}
+	}
+}
+
+func TestResolveTag_AnonymousFallbackOn403(t *testing.T) {
+	var authed, anon int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/tags/list") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") != "" {
+			authed++
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":[{"code":"DENIED","message":"token lacks scope"}]}`))
+			return
+		}
+		anon++
+		_, _ = w.Write([]byte(`{"name":"org/app","tags":["1.0.0","1.1.0"]}`))
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	t.Setenv("REG_USER", "robot")
+	t.Setenv("REG_TOKEN", "no-scope")
+	opts := &Options{Keychain: NewKeychain([]RegistryAuth{{Host: host, UsernameEnv: "REG_USER", PasswordEnv: "REG_TOKEN"}}, "")}
+
+	got, err := ResolveTag(context.Background(), host+"/org/app", "semver", "", "", false, opts)
+	if err != nil {
+		t.Fatalf("ResolveTag: %v", err)
+	}
+	if got != "1.1.0" {
+		t.Fatalf("got %q want %q", got, "1.1.0")
+	}
+	if authed != 1 || anon != 1 {
+		t.Fatalf("expected one authenticated and one anonymous request, got authed=%d anon=%d", authed, anon)
+	}
+}
+
+func TestResolveTag_NoAnonymousRetryWithoutCredentials(t *testing.T) {
+	var listed int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		listed++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`))
+	}))
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	t.Setenv("DOCKER_CONFIG", t.TempDir())
+	opts := &Options{Keychain: NewKeychain(nil, "")}
+
+	_, err := ResolveTag(context.Background(), host+"/org/private", "semver", "", "", false, opts)
+	if err == nil {
+		t.Fatalf("expected an error from a registry that rejects anonymous access")
+	}
+	if listed != 1 {
+		t.Fatalf("expected a single tag-list request when the keychain has no credentials, got %d", listed)
+	}
+}
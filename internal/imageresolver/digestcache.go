@@ -0,0 +1,94 @@
+package imageresolver
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+)
+
+// DigestCache caches manifest digests keyed by (repo, tag, platform).
+//
+// Digests are stable for a given tag until the tag moves, so entries expire after a TTL.
+// A DigestCache is safe for concurrent use and can optionally be persisted to disk.
+type DigestCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]digestEntry
+	now     func() time.Time
+}
+
+type digestEntry struct {
+	Digest  string    `json:"digest"`
+	Expires time.Time `json:"expires"`
+}
+
+// NewDigestCache returns an empty cache whose entries live for ttl.
+func NewDigestCache(ttl time.Duration) *DigestCache {
+	return &DigestCache{ttl: ttl, entries: map[string]digestEntry{}, now: time.Now}
+}
+
+// LoadDigestCache returns a cache seeded from the JSON file at path. A missing file yields an
+// empty cache. Expired entries are dropped.
+func LoadDigestCache(path string, ttl time.Duration) (*DigestCache, error) {
+	c := NewDigestCache(ttl)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries map[string]digestEntry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	now := c.now()
+	for k, e := range entries {
+		if now.Before(e.Expires) {
+			c.entries[k] = e
+		}
+	}
+	return c, nil
+}
+
+// Save writes unexpired entries to path as JSON.
+func (c *DigestCache) Save(path string) error {
+	c.mu.Lock()
+	now := c.now()
+	live := make(map[string]digestEntry, len(c.entries))
+	for k, e := range c.entries {
+		if now.Before(e.Expires) {
+			live[k] = e
+		}
+	}
+	c.mu.Unlock()
+
+	b, err := json.MarshalIndent(live, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+func (c *DigestCache) get(imageRepo, tag, platform string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[digestKey(imageRepo, tag, platform)]
+	if !ok || !c.now().Before(e.Expires) {
+		return "", false
+	}
+	return e.Digest, true
+}
+
+func (c *DigestCache) put(imageRepo, tag, platform, digest string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[digestKey(imageRepo, tag, platform)] = digestEntry{Digest: digest, Expires: c.now().Add(c.ttl)}
+}
+
+func digestKey(imageRepo, tag, platform string) string {
+	return imageRepo + ":" + tag + "@" + platform
+}
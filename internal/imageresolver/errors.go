@@ -0,0 +1,116 @@
+package imageresolver
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// ErrNoTags is returned when the repository exists but has no tags.
+var ErrNoTags = errors.New("no tags found")
+
+// ErrNoMatchingTags is returned when the repository has tags but the directive's filters
+// leave none to choose from.
+var ErrNoMatchingTags = errors.New("no tags match the filters")
+
+// ErrTagNotFound is returned by ResolveExactTag when the repository lacks the requested tag.
+var ErrTagNotFound = errors.New("tag not found")
+
+// ErrRegistryUnavailable matches (via errors.Is) a RegistryError of kind NetworkError: the
+// registry could not be reached or failed, and retrying later may succeed.
+var ErrRegistryUnavailable = errors.New("registry unavailable")
+
+// RegistryErrorKind classifies why a registry call failed.
+type RegistryErrorKind string
+
+const (
+	// NetworkError means the registry could not be reached, returned a server error, or
+	// throttled the request (429).
+	NetworkError RegistryErrorKind = "network"
+	// AuthError means the registry rejected the credentials (401/403).
+	AuthError RegistryErrorKind = "auth"
+	// NotFoundError means the registry does not know the repository or tag (e.g. a typo).
+	NotFoundError RegistryErrorKind = "not-found"
+	// RequestError means the call could not be made or was rejected for a reason retrying
+	// won't fix: a malformed repository or mirror, unreadable credentials, or another 4xx.
+	RequestError RegistryErrorKind = "request"
+)
+
+// RegistryError wraps a failed registry call with the image it was for and a coarse kind.
+// Use errors.As to inspect it; the underlying cause is available via Unwrap.
+type RegistryError struct {
+	Image string
+	Kind  RegistryErrorKind
+	Err   error
+}
+
+func (e *RegistryError) Error() string {
+	return fmt.Sprintf("registry %s error for %s: %v", e.Kind, e.Image, e.Err)
+}
+
+func (e *RegistryError) Unwrap() error { return e.Err }
+
+// Is reports whether a network RegistryError matches ErrRegistryUnavailable.
+func (e *RegistryError) Is(target error) bool {
+	return target == ErrRegistryUnavailable && e.Kind == NetworkError
+}
+
+func newRegistryError(imageRepo string, err error) error {
+	return &RegistryError{Image: imageRepo, Kind: classifyRegistryError(err), Err: err}
+}
+
+// classifyRegistryError sorts err into a kind. Only transport failures, 5xx and 429 are
+// NetworkError; anything else, including setup failures before a request is sent, is not
+// worth retrying.
+func classifyRegistryError(err error) RegistryErrorKind {
+	var te *transport.Error
+	if !errors.As(err, &te) {
+		return transportErrorKind(err)
+	}
+	for _, d := range te.Errors {
+		switch d.Code {
+		case transport.UnauthorizedErrorCode, transport.DeniedErrorCode:
+			return AuthError
+		case transport.NameUnknownErrorCode, transport.ManifestUnknownErrorCode:
+			return NotFoundError
+		case transport.TooManyRequestsErrorCode:
+			return NetworkError
+		}
+	}
+	return statusErrorKind(te.StatusCode)
+}
+
+// statusErrorKind classifies an HTTP error status.
+func statusErrorKind(code int) RegistryErrorKind {
+	switch {
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		return AuthError
+	case code == http.StatusNotFound:
+		return NotFoundError
+	case code == http.StatusTooManyRequests, code >= 500:
+		return NetworkError
+	}
+	return RequestError
+}
+
+// transportErrorKind classifies an error that carries no HTTP status: NetworkError if the
+// request failed in transit, RequestError otherwise.
+func transportErrorKind(err error) RegistryErrorKind {
+	// syscall.Errno satisfies net.Error, so rule out local file errors (e.g. an unreadable
+	// token file) first.
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return RequestError
+	}
+	var ne net.Error
+	var ue *url.Error
+	if errors.As(err, &ne) || errors.As(err, &ue) {
+		return NetworkError
+	}
+	return RequestError
+}
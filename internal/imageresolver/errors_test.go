@@ -0,0 +1,170 @@
+package imageresolver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// fakeTagsRegistry serves /v2/ and a fixed response for the tag list of any repository.
+func fakeTagsRegistry(t *testing.T, status int, body string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/tags/list") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestResolveTag_EmptyTagList(t *testing.T) {
+	host := fakeTagsRegistry(t, http.StatusOK, `{"name":"org/app","tags":[]}`)
+	_, err := ResolveTag(context.Background(), host+"/org/app", "semver", "", "", false, testOptions())
+	if !errors.Is(err, ErrNoTags) {
+		t.Fatalf("expected ErrNoTags, got %v", err)
+	}
+	var re *RegistryError
+	if errors.As(err, &re) {
+		t.Fatalf("empty tag list should not be a RegistryError: %v", err)
+	}
+}
+
+func TestResolveTag_NetworkError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	host := strings.TrimPrefix(srv.URL, "http://")
+	srv.Close()
+
+	_, err := ResolveTag(context.Background(), host+"/org/app", "semver", "", "", false, testOptions())
+	var re *RegistryError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected RegistryError, got %T: %v", err, err)
+	}
+	if re.Kind != NetworkError {
+		t.Fatalf("kind got %q want %q", re.Kind, NetworkError)
+	}
+	if errors.Is(err, ErrNoTags) {
+		t.Fatalf("network error should not be ErrNoTags")
+	}
+	if re.Unwrap() == nil {
+		t.Fatalf("expected underlying cause")
+	}
+	if !errors.Is(err, ErrRegistryUnavailable) {
+		t.Fatalf("network error should match ErrRegistryUnavailable: %v", err)
+	}
+}
+
+func TestResolveTag_AuthAndNotFound(t *testing.T) {
+	cases := []struct {
+		status int
+		body   string
+		want   RegistryErrorKind
+	}{
+		{http.StatusUnauthorized, `{"errors":[{"code":"UNAUTHORIZED","message":"authentication required"}]}`, AuthError},
+		{http.StatusNotFound, `{"errors":[{"code":"NAME_UNKNOWN","message":"repository name not known"}]}`, NotFoundError},
+	}
+	for _, c := range cases {
+		host := fakeTagsRegistry(t, c.status, c.body)
+		_, err := ResolveTag(context.Background(), host+"/org/app", "semver", "", "", false, testOptions())
+		var re *RegistryError
+		if !errors.As(err, &re) {
+			t.Fatalf("status %d: expected RegistryError, got %T: %v", c.status, err, err)
+		}
+		if re.Kind != c.want {
+			t.Fatalf("status %d: kind got %q want %q", c.status, re.Kind, c.want)
+		}
+		if errors.Is(err, ErrRegistryUnavailable) {
+			t.Fatalf("status %d: should not match ErrRegistryUnavailable: %v", c.status, err)
+		}
+	}
+}
+
+func TestClassifyRegistryError(t *testing.T) {
+	_, fileErr := os.ReadFile(filepath.Join(t.TempDir(), "missing"))
+	if fileErr == nil {
+		t.Fatalf("expected an error reading a missing file")
+	}
+	t.Setenv("GITHUB_TOKEN", "")
+	_, tokenErr := ghcrKeychain{tokenFile: filepath.Join(t.TempDir(), "missing")}.Resolve(name.MustParseReference("ghcr.io/org/app").Context())
+	if tokenErr == nil {
+		t.Fatalf("expected an error reading a missing token file")
+	}
+	_, mirrorErr := name.NewRepository("Bad Mirror!/org/app")
+	if mirrorErr == nil {
+		t.Fatalf("expected an error parsing a bad mirror")
+	}
+
+	cases := []struct {
+		desc string
+		err  error
+		want RegistryErrorKind
+	}{
+		{"net.Error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, NetworkError},
+		{"url.Error", &url.Error{Op: "Get", URL: "https://reg.invalid/v2/", Err: errors.New("EOF")}, NetworkError},
+		{"5xx", &transport.Error{StatusCode: http.StatusBadGateway}, NetworkError},
+		{"429", &transport.Error{StatusCode: http.StatusTooManyRequests}, NetworkError},
+		{"TOOMANYREQUESTS code", &transport.Error{StatusCode: http.StatusBadRequest, Errors: []transport.Diagnostic{{Code: transport.TooManyRequestsErrorCode}}}, NetworkError},
+		{"401", &transport.Error{StatusCode: http.StatusUnauthorized}, AuthError},
+		{"404", &transport.Error{StatusCode: http.StatusNotFound}, NotFoundError},
+		{"400", &transport.Error{StatusCode: http.StatusBadRequest}, RequestError},
+		{"unreadable file", fileErr, RequestError},
+		{"unreadable token file", tokenErr, RequestError},
+		{"bad mirror", mirrorErr, RequestError},
+		{"wrapped plain error", fmt.Errorf("setup: %w", errors.New("boom")), RequestError},
+	}
+	for _, c := range cases {
+		if got := classifyRegistryError(c.err); got != c.want {
+			t.Errorf("%s: kind got %q want %q", c.desc, got, c.want)
+		}
+	}
+}
+
+func TestResolveTag_NonTransientErrors(t *testing.T) {
+	badRequest := fakeTagsRegistry(t, http.StatusBadRequest, `{"errors":[{"code":"UNSUPPORTED","message":"unsupported"}]}`)
+	mirrored := testOptions()
+	mirrored.Mirrors = map[string]string{"registry.invalid": "Bad Mirror!"}
+	badToken := &Options{Keychain: ghcrKeychain{tokenFile: filepath.Join(t.TempDir(), "missing")}}
+	t.Setenv("GITHUB_TOKEN", "")
+
+	cases := []struct {
+		desc  string
+		image string
+		opts  *Options
+	}{
+		{"400 response", badRequest + "/org/app", testOptions()},
+		{"bad mirror", "registry.invalid/org/app", mirrored},
+		{"unreadable token file", "ghcr.io/org/app", badToken},
+	}
+	for _, c := range cases {
+		_, err := ResolveTag(context.Background(), c.image, "semver", "", "", false, c.opts)
+		var re *RegistryError
+		if !errors.As(err, &re) {
+			t.Fatalf("%s: expected RegistryError, got %T: %v", c.desc, err, err)
+		}
+		if re.Kind != RequestError {
+			t.Fatalf("%s: kind got %q want %q", c.desc, re.Kind, RequestError)
+		}
+		if errors.Is(err, ErrRegistryUnavailable) {
+			t.Fatalf("%s: should not match ErrRegistryUnavailable: %v", c.desc, err)
+		}
+	}
+}
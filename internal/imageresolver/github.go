@@ -0,0 +1,158 @@
+package imageresolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+
+	"go.uber.org/zap"
+)
+
+// SourceGitHubReleases selects from a GitHub repository's release tags.
+const SourceGitHubReleases = "github-releases"
+
+// DefaultGitHubAPIURL is used when neither GitHubReleases.BaseURL nor GITHUB_API_URL is set.
+const DefaultGitHubAPIURL = "https://api.github.com"
+
+// githubReleasesPerPage is the largest page size the GitHub API allows.
+const githubReleasesPerPage = 100
+
+// GitHubReleases is a TagResolver that selects from the tag names of a GitHub repository's
+// published releases (spec.Repo, as owner/name). Draft releases are ignored, and releases
+// marked as prereleases are only candidates with allowPrerelease.
+type GitHubReleases struct {
+	// BaseURL is the GitHub API root. Defaults to $GITHUB_API_URL, then DefaultGitHubAPIURL.
+	BaseURL string
+	// Token authenticates API requests. Defaults to GitHubToken(TokenFile); anonymous if empty.
+	Token string
+	// TokenFile is passed to GitHubToken when Token is empty.
+	TokenFile string
+	// Client sends API requests. Defaults to http.DefaultClient.
+	Client *http.Client
+	// MaxPages, if positive, caps how many pages of releases are read; a longer list is
+	// truncated with a warning. Zero or negative means no limit.
+	MaxPages int
+}
+
+type githubRelease struct {
+	TagName     string    `json:"tag_name"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// ResolveTag implements TagResolver.
+func (g *GitHubReleases) ResolveTag(ctx context.Context, spec TagSpec) (string, error) {
+	if strings.Count(spec.Repo, "/") != 1 {
+		return "", fmt.Errorf("source=%s requires repo=<owner>/<name>; got %q", SourceGitHubReleases, spec.Repo)
+	}
+	releases, err := g.list(ctx, spec.Repo)
+	if err != nil {
+		return "", err
+	}
+	published := map[string]time.Time{}
+	var tags []string
+	for _, r := range releases {
+		if r.Draft || (r.Prerelease && !spec.AllowPrerelease) {
+			continue
+		}
+		published[r.TagName] = r.PublishedAt
+		tags = append(tags, r.TagName)
+	}
+	if len(tags) == 0 {
+		return "", fmt.Errorf("%w for github.com/%s releases", ErrNoTags, spec.Repo)
+	}
+	return selectTag(ctx, spec, tags, func(t string) (time.Time, error) {
+		return published[t], nil
+	})
+}
+
+// list reads every page of repo's releases.
+func (g *GitHubReleases) list(ctx context.Context, repo string) ([]githubRelease, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "imageresolver.GitHubReleases.list"), zap.String("repo", repo))
+	base := g.BaseURL
+	if base == "" {
+		base = os.Getenv("GITHUB_API_URL")
+	}
+	if base == "" {
+		base = DefaultGitHubAPIURL
+	}
+	token := g.Token
+	if token == "" {
+		var err error
+		if token, err = GitHubToken(g.TokenFile); err != nil {
+			return nil, err
+		}
+	}
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	maxPages := g.MaxPages
+
+	var out []githubRelease
+	for page := 1; ; page++ {
+		if maxPages > 0 && page > maxPages {
+			log.Warn("release list truncated; newer releases may be missed", zap.Int("pages", maxPages), zap.Int("releases", len(out)))
+			break
+		}
+		u := fmt.Sprintf("%s/repos/%s/releases?per_page=%d&page=%d", strings.TrimRight(base, "/"), repo, githubReleasesPerPage, page)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		var rs []githubRelease
+		if err := doGitHubRequest(client, req, &rs); err != nil {
+			return nil, &RegistryError{Image: "github.com/" + repo, Kind: githubErrorKind(err), Err: err}
+		}
+		out = append(out, rs...)
+		if len(rs) < githubReleasesPerPage {
+			break
+		}
+	}
+	log.Debug("listed releases", zap.Int("count", len(out)))
+	logutil.Event(ctx, logutil.EventTagsListed, zap.String("repo", repo), zap.String("source", SourceGitHubReleases), zap.Int("count", len(out)))
+	return out, nil
+}
+
+// githubStatusError is a non-2xx GitHub API response.
+type githubStatusError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *githubStatusError) Error() string { return "GitHub API returned " + e.Status }
+
+func doGitHubRequest(client *http.Client, req *http.Request, v any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return &githubStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decode %s: %w", req.URL.Path, err)
+	}
+	return nil
+}
+
+func githubErrorKind(err error) RegistryErrorKind {
+	var se *githubStatusError
+	if !errors.As(err, &se) {
+		return transportErrorKind(err)
+	}
+	return statusErrorKind(se.StatusCode)
+}
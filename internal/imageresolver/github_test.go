@@ -0,0 +1,73 @@
+package imageresolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newFakeGitHub serves /repos/org/proj/releases from pages of releases.
+func newFakeGitHub(t *testing.T, pages ...[]githubRelease) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/org/proj/releases" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer t0ken" {
+			t.Errorf("Authorization header %q", got)
+		}
+		var page int
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		rs := []githubRelease{}
+		if page >= 1 && page <= len(pages) {
+			rs = pages[page-1]
+		}
+		_ = json.NewEncoder(w).Encode(rs)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGitHubReleases(t *testing.T) {
+	var first []githubRelease
+	for i := 0; i < githubReleasesPerPage; i++ {
+		first = append(first, githubRelease{TagName: fmt.Sprintf("v1.%d.0", i)})
+	}
+	second := []githubRelease{
+		{TagName: "v2.0.0"},
+		{TagName: "v2.1.0-rc.1", Prerelease: true},
+		{TagName: "v3.0.0", Draft: true},
+	}
+	srv := newFakeGitHub(t, first, second)
+	g := &GitHubReleases{BaseURL: srv.URL, Token: "t0ken"}
+
+	for allow, want := range map[bool]string{false: "v2.0.0", true: "v2.1.0-rc.1"} {
+		got, err := g.ResolveTag(context.Background(), TagSpec{Image: "ghcr.io/org/proj", Repo: "org/proj", AllowPrerelease: allow})
+		if err != nil {
+			t.Fatalf("allowPrerelease=%v: %v", allow, err)
+		}
+		if got != want {
+			t.Fatalf("allowPrerelease=%v: got %q want %q", allow, got, want)
+		}
+	}
+
+	got, err := g.ResolveTag(context.Background(), TagSpec{Image: "ghcr.io/org/proj", Repo: "org/proj", Constraint: "<2"})
+	if err != nil || got != "v1.99.0" {
+		t.Fatalf("constraint: got %q, %v", got, err)
+	}
+
+	_, err = g.ResolveTag(context.Background(), TagSpec{Image: "ghcr.io/org/proj", Repo: "org/missing"})
+	var re *RegistryError
+	if !errors.As(err, &re) || re.Kind != NotFoundError {
+		t.Fatalf("expected a not-found RegistryError, got %v", err)
+	}
+
+	if _, err := g.ResolveTag(context.Background(), TagSpec{Image: "ghcr.io/org/proj"}); err == nil {
+		t.Fatalf("expected an error without repo")
+	}
+}
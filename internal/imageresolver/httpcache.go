@@ -0,0 +1,207 @@
+package imageresolver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPCache is an http.RoundTripper that caches registry tag-list and manifest responses on
+// disk so they can be shared across runs.
+//
+// Responses are reused without contacting the registry while their Cache-Control max-age
+// holds. Afterwards, a response with an ETag is revalidated with If-None-Match and a 304
+// is served from the cache. Responses marked no-store are never cached.
+//
+// Entries are keyed by URL and Accept header only, not credentials, so a cache directory
+// should not be shared between users with different registry access.
+type HTTPCache struct {
+	dir  string
+	next http.RoundTripper
+	now  func() time.Time
+}
+
+type httpCacheEntry struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt time.Time   `json:"storedAt"`
+}
+
+// NewHTTPCache returns a cache persisted under dir that sends requests through next
+// (http.DefaultTransport if nil).
+func NewHTTPCache(dir string, next http.RoundTripper) (*HTTPCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &HTTPCache{dir: dir, next: next, now: time.Now}, nil
+}
+
+// RoundTrip implements http.RoundTripper.
+func (c *HTTPCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !cacheableRequest(req) {
+		return c.next.RoundTrip(req)
+	}
+	path := c.path(req)
+	entry, ok := c.load(path)
+	if ok && c.fresh(entry) {
+		return entry.response(req), nil
+	}
+
+	sent := req
+	if ok && entry.Header.Get("ETag") != "" {
+		sent = req.Clone(req.Context())
+		sent.Header.Set("If-None-Match", entry.Header.Get("ETag"))
+	}
+	resp, err := c.next.RoundTrip(sent)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		_ = resp.Body.Close()
+		for _, h := range []string{"Cache-Control", "ETag", "Expires"} {
+			if v := resp.Header.Get(h); v != "" {
+				entry.Header.Set(h, v)
+			}
+		}
+		entry.StoredAt = c.now()
+		c.store(path, entry)
+		return entry.response(req), nil
+	}
+
+	if resp.StatusCode != http.StatusOK || !cacheableResponse(resp.Header) {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	c.store(path, httpCacheEntry{Status: resp.StatusCode, Header: resp.Header, Body: body, StoredAt: c.now()})
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// cacheableRequest limits caching to registry tag-list and manifest reads.
+func cacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	if hasDirective(req.Header, "no-store") {
+		return false
+	}
+	p := req.URL.Path
+	return strings.HasPrefix(p, "/v2/") && (strings.HasSuffix(p, "/tags/list") || strings.Contains(p, "/manifests/"))
+}
+
+func cacheableResponse(h http.Header) bool {
+	if hasDirective(h, "no-store") {
+		return false
+	}
+	_, hasMaxAge := maxAge(h)
+	return h.Get("ETag") != "" || hasMaxAge
+}
+
+func (c *HTTPCache) fresh(e httpCacheEntry) bool {
+	if hasDirective(e.Header, "no-cache") {
+		return false
+	}
+	age, ok := maxAge(e.Header)
+	return ok && c.now().Before(e.StoredAt.Add(age))
+}
+
+func (e httpCacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(e.Status) + " " + http.StatusText(e.Status),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+func (c *HTTPCache) path(req *http.Request) string {
+	sum := sha256.Sum256([]byte(req.URL.String() + "\n" + req.Header.Get("Accept")))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// load and store treat the cache as best effort: unreadable entries are misses and write
+// failures are ignored.
+func (c *HTTPCache) load(path string) (httpCacheEntry, bool) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return httpCacheEntry{}, false
+	}
+	var e httpCacheEntry
+	if err := json.Unmarshal(b, &e); err != nil || e.Header == nil {
+		return httpCacheEntry{}, false
+	}
+	return e, true
+}
+
+func (c *HTTPCache) store(path string, e httpCacheEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	// Concurrent requests for the same URL may store at once; each writes its own temp file.
+	f, err := os.CreateTemp(c.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || os.Rename(f.Name(), path) != nil {
+		_ = os.Remove(f.Name())
+	}
+}
+
+func cacheControl(h http.Header) []string {
+	var out []string
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			out = append(out, strings.ToLower(strings.TrimSpace(d)))
+		}
+	}
+	return out
+}
+
+func hasDirective(h http.Header, name string) bool {
+	for _, d := range cacheControl(h) {
+		if d == name {
+			return true
+		}
+	}
+	return false
+}
+
+func maxAge(h http.Header) (time.Duration, bool) {
+	for _, d := range cacheControl(h) {
+		if v, ok := strings.CutPrefix(d, "max-age="); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return 0, false
+			}
+			return time.Duration(n) * time.Second, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,119 @@
+package imageresolver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newETagRegistry serves a tag list for org/app with an ETag, answering 304 to a matching
+// If-None-Match. It records the If-None-Match of each tag-list request.
+func newETagRegistry(t *testing.T, cacheControl string) (string, *[]string, *atomic.Int64) {
+	t.Helper()
+	var seen []string
+	var notModified atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/org/app/tags/list":
+			seen = append(seen, r.Header.Get("If-None-Match"))
+			if cacheControl != "" {
+				w.Header().Set("Cache-Control", cacheControl)
+			}
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				notModified.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"name":"org/app","tags":["1.0.0","1.1.0"]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://"), &seen, &notModified
+}
+
+func TestHTTPCache_RevalidatesWithETag(t *testing.T) {
+	host, seen, notModified := newETagRegistry(t, "")
+	dir := t.TempDir()
+
+	// Two runs, each with its own cache instance over the same directory.
+	for run := 0; run < 2; run++ {
+		cache, err := NewHTTPCache(dir, nil)
+		if err != nil {
+			t.Fatalf("NewHTTPCache: %v", err)
+		}
+		opts := testOptions()
+		opts.Transport = cache
+		got, err := ResolveTag(context.Background(), host+"/org/app", "semver", "", "", false, opts)
+		if err != nil {
+			t.Fatalf("run %d: ResolveTag: %v", run, err)
+		}
+		if got != "1.1.0" {
+			t.Fatalf("run %d: got %q want %q", run, got, "1.1.0")
+		}
+	}
+
+	if len(*seen) != 2 || (*seen)[0] != "" || (*seen)[1] != `"v1"` {
+		t.Fatalf("If-None-Match per request got %q want [\"\" \"\\\"v1\\\"\"]", *seen)
+	}
+	if notModified.Load() != 1 {
+		t.Fatalf("expected one 304, got %d", notModified.Load())
+	}
+}
+
+func TestHTTPCache_FreshEntrySkipsRegistry(t *testing.T) {
+	host, seen, _ := newETagRegistry(t, "max-age=60")
+	cache, err := NewHTTPCache(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("NewHTTPCache: %v", err)
+	}
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	opts := testOptions()
+	opts.Transport = cache
+
+	for i := 0; i < 2; i++ {
+		if _, err := ResolveTag(context.Background(), host+"/org/app", "semver", "", "", false, opts); err != nil {
+			t.Fatalf("ResolveTag: %v", err)
+		}
+	}
+	if len(*seen) != 1 {
+		t.Fatalf("expected the fresh entry to be reused, registry saw %d tag-list requests", len(*seen))
+	}
+
+	// Once stale, the entry is revalidated.
+	now = now.Add(2 * time.Minute)
+	if _, err := ResolveTag(context.Background(), host+"/org/app", "semver", "", "", false, opts); err != nil {
+		t.Fatalf("ResolveTag: %v", err)
+	}
+	if len(*seen) != 2 || (*seen)[1] != `"v1"` {
+		t.Fatalf("expected revalidation with If-None-Match, got %q", *seen)
+	}
+}
+
+func TestHTTPCache_NoStore(t *testing.T) {
+	host, seen, _ := newETagRegistry(t, "no-store")
+	cache, err := NewHTTPCache(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("NewHTTPCache: %v", err)
+	}
+	opts := testOptions()
+	opts.Transport = cache
+	for i := 0; i < 2; i++ {
+		if _, err := ResolveTag(context.Background(), host+"/org/app", "semver", "", "", false, opts); err != nil {
+			t.Fatalf("ResolveTag: %v", err)
+		}
+	}
+	if len(*seen) != 2 || (*seen)[1] != "" {
+		t.Fatalf("expected no conditional request for a no-store response, got %q", *seen)
+	}
+}
@@ -0,0 +1,72 @@
+package imageresolver
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// IgnoreList is a set of tags that are never selected, such as yanked releases. An entry
+// ignores a tag for every image, or for one image repository only.
+type IgnoreList struct {
+	global   map[string]bool
+	perImage map[string]map[string]bool
+}
+
+// LoadIgnoreList reads an ignore list file. Each line is either a tag, ignored for every
+// image, or an image repository and a tag separated by whitespace
+// (ghcr.io/org/app 1.4.0). Blank lines and lines starting with # are skipped.
+func LoadIgnoreList(path string) (*IgnoreList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read ignore list: %w", err)
+	}
+	defer f.Close()
+
+	l := &IgnoreList{global: map[string]bool{}, perImage: map[string]map[string]bool{}}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch fields := strings.Fields(line); len(fields) {
+		case 1:
+			l.global[fields[0]] = true
+		case 2:
+			if l.perImage[fields[0]] == nil {
+				l.perImage[fields[0]] = map[string]bool{}
+			}
+			l.perImage[fields[0]][fields[1]] = true
+		default:
+			return nil, fmt.Errorf("%s:%d: expected a tag or an image and a tag, got %q", path, n, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("read ignore list: %w", err)
+	}
+	return l, nil
+}
+
+// Ignored reports whether tag is ignored for imageRepo. A nil list ignores nothing.
+func (l *IgnoreList) Ignored(imageRepo, tag string) bool {
+	if l == nil {
+		return false
+	}
+	return l.global[tag] || l.perImage[imageRepo][tag]
+}
+
+// filter returns tags without those ignored for imageRepo.
+func (l *IgnoreList) filter(imageRepo string, tags []string) []string {
+	if l == nil {
+		return tags
+	}
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if !l.Ignored(imageRepo, t) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package imageresolver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeIgnoreList(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "ignore-tags")
+	if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return p
+}
+
+func TestIgnoreList(t *testing.T) {
+	host := newTestRegistry(t)
+	app, other := host+"/org/app", host+"/org/other"
+	for _, tag := range []string{"1.2.0", "1.3.0", "1.4.0"} {
+		pushImage(t, app, tag, nil)
+		pushImage(t, other, tag, nil)
+	}
+
+	l, err := LoadIgnoreList(writeIgnoreList(t, "# yanked releases\n1.4.0\n\n"+app+" 1.3.0\n"))
+	if err != nil {
+		t.Fatalf("LoadIgnoreList: %v", err)
+	}
+	opts := testOptions()
+	opts.IgnoreTags = l
+
+	// 1.4.0 is ignored everywhere; 1.3.0 only for org/app.
+	for repo, want := range map[string]string{app: "1.2.0", other: "1.3.0"} {
+		for _, strategy := range []struct{ name, tagRegex string }{{"semver", ""}, {"regex", `^1\.\d+\.0$`}} {
+			got, err := ResolveTag(context.Background(), repo, strategy.name, "", strategy.tagRegex, false, opts)
+			if err != nil {
+				t.Fatalf("ResolveTag(%s, %s): %v", repo, strategy.name, err)
+			}
+			if got != want {
+				t.Fatalf("ResolveTag(%s, %s) got %q want %q", repo, strategy.name, got, want)
+			}
+		}
+	}
+	if _, err := ResolveTag(context.Background(), app, "literal", "", `^1\.4\.0$`, false, opts); err == nil {
+		t.Fatalf("expected strategy=literal to find no candidate for an ignored tag")
+	}
+	if _, err := ResolveExactTag(context.Background(), other, "1.4.0", opts); err == nil {
+		t.Fatalf("expected strategy=exact to refuse an ignored tag")
+	}
+
+	all, err := LoadIgnoreList(writeIgnoreList(t, "1.2.0\n1.3.0\n1.4.0\n"))
+	if err != nil {
+		t.Fatalf("LoadIgnoreList: %v", err)
+	}
+	opts.IgnoreTags = all
+	if _, err := ResolveTag(context.Background(), app, "semver", "", "", false, opts); !errors.Is(err, ErrNoMatchingTags) {
+		t.Fatalf("expected ErrNoMatchingTags when every tag is ignored, got %v", err)
+	}
+}
+
+func TestLoadIgnoreList_Invalid(t *testing.T) {
+	if _, err := LoadIgnoreList(writeIgnoreList(t, "ghcr.io/org/app 1.2.3 extra\n")); err == nil {
+		t.Fatalf("expected an error for a line with three fields")
+	}
+	if _, err := LoadIgnoreList(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatalf("expected an error for a missing file")
+	}
+}
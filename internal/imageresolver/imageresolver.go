@@ -1,40 +1,83 @@
 package imageresolver
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"regexp"
+	"slices"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/joejulian/helm-chart-bumper-action/internal/imageref"
 	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+	"github.com/joejulian/helm-chart-bumper-action/internal/selectexpr"
 
 	"go.uber.org/zap"
 
 	"github.com/Masterminds/semver/v3"
 	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/crane"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 )
 
-// Options control registry access and selection.
+// Options control registry access. Per-lookup selection rules are in TagSpec.
 //
 // Full image repository path is required (e.g. ghcr.io/org/app). No implicit docker.io.
 type Options struct {
 	Keychain authn.Keychain
-	Context  context.Context
+	// DigestCache, if set, is consulted by ResolveDigest before contacting the registry.
+	DigestCache *DigestCache
+	// Transport, if set, carries registry requests (e.g. an HTTPCache).
+	Transport http.RoundTripper
+	// TagCache, if set, shares tag lists between ResolveTag calls for the same repository.
+	TagCache *TagListCache
+	// MaxTagPages, if positive, caps how many pages of a paginated tag list are fetched; a
+	// longer list is truncated with a warning. Zero or negative means no limit.
+	MaxTagPages int
+	// RateLimiter, if set, is waited on before each registry request (each tag-list page,
+	// manifest, or image config fetch). Share one across a run to bound its request rate.
+	RateLimiter *RateLimiter
+	// Sources are alternate tag sources by name, selected by a directive's source= field
+	// (see ResolveTagFrom).
+	Sources map[string]TagResolver
+	// Mirrors maps a registry or repository prefix to a pull-through mirror that is contacted
+	// in its place (see ParseMirror). Results are still reported for the original repository.
+	Mirrors map[string]string
+	// IgnoreTags, if set, lists tags that are never selected (see LoadIgnoreList).
+	IgnoreTags *IgnoreList
 }
 
+// maxMinAgeChecks bounds how many candidates MinAge looks up creation times for, since each
+// lookup fetches a manifest and config blob.
+const maxMinAgeChecks = 5
+
 type cand struct {
 	tag string
 	ver *semver.Version
 }
 
 func defaultOptions() Options {
-	return Options{Keychain: ghcrKeychain{fallback: authn.DefaultKeychain}, Context: context.Background()}
+	return Options{Keychain: DefaultKeychain()}
+}
+
+// remoteOptions returns the remote options shared by every registry call, without auth.
+func (o *Options) remoteOptions(ctx context.Context) []remote.Option {
+	ro := []remote.Option{remote.WithContext(ctx)}
+	if o.Transport != nil {
+		ro = append(ro, remote.WithTransport(o.Transport))
+	}
+	return ro
+}
+
+// DefaultKeychain returns the keychain used when no Options are provided: Docker credentials,
+// falling back to GITHUB_TOKEN (or GITHUB_TOKEN_FILE) for ghcr.io.
+func DefaultKeychain() authn.Keychain {
+	return ghcrKeychain{fallback: authn.DefaultKeychain}
 }
 
 // ResolveTag returns the selected tag for an image based on strategy.
@@ -44,79 +87,332 @@ func defaultOptions() Options {
 // - semver: choose highest semver tag (optionally constrained). Excludes prereleases unless allowPrerelease=true.
 // - regex: filter tags by tagRegex. If regex has a capture group containing a semver, ordering uses that.
 // - literal: requires tagRegex that matches exactly one tag; that tag is returned.
+//
+// It is a wrapper around Resolver.ResolveTag; use a TagSpec for the other selection rules.
 func ResolveTag(ctx context.Context, imageRepo, strategy, constraint, tagRegex string, allowPrerelease bool, opts *Options) (string, error) {
-	log := logutil.FromContext(ctx).With(zap.String("func", "imageresolver.ResolveTag"), zap.String("image", imageRepo), zap.String("strategy", strategy))
-	log.Debug("resolving tag", zap.String("constraint", constraint), zap.String("tagRegex", tagRegex), zap.Bool("allowPrerelease", allowPrerelease))
+	spec := TagSpec{Image: imageRepo, Strategy: strategy, Constraint: constraint, TagRegex: tagRegex, AllowPrerelease: allowPrerelease}
+	return resolverFor(opts).ResolveTag(ctx, spec)
+}
+
+// ResolveTag selects a tag for spec from the image's registry tags; see the package-level
+// ResolveTag for the strategies. It implements TagResolver.
+func (r *Resolver) ResolveTag(ctx context.Context, spec TagSpec) (string, error) {
+	imageRepo := spec.Image
+	log := logutil.FromContext(ctx).With(zap.String("func", "imageresolver.ResolveTag"), zap.String("image", imageRepo), zap.String("strategy", spec.Strategy))
+	log.Debug("resolving tag", zap.String("constraint", spec.Constraint), zap.String("tagRegex", spec.TagRegex), zap.Bool("allowPrerelease", spec.AllowPrerelease))
 	if imageRepo == "" {
 		return "", fmt.Errorf("image repository must be provided")
 	}
-	if !strings.Contains(imageRepo, "/") || !strings.Contains(imageRepo, ".") {
-		// Keep this strict; user requested full path always.
-		return "", fmt.Errorf("image repository must be a full path like ghcr.io/org/image: %q", imageRepo)
-	}
-	if opts == nil {
-		o := defaultOptions()
-		o.Context = ctx
-		opts = &o
-	} else if opts.Context == nil {
-		opts.Context = ctx
+	if err := imageref.ValidateRepository(imageRepo); err != nil {
+		return "", err
 	}
-
-	strategy = strings.TrimSpace(strategy)
-	if strategy == "" {
-		strategy = "semver"
+	opts := &r.opts
+	if spec.IgnoreTags == nil {
+		spec.IgnoreTags = opts.IgnoreTags
 	}
 
-	craneOpts := []crane.Option{crane.WithAuthFromKeychain(opts.Keychain), crane.WithContext(opts.Context)}
-	tags, err := crane.ListTags(imageRepo, craneOpts...)
+	tags, err := listTags(ctx, imageRepo, opts)
 	if err != nil {
 		return "", err
 	}
 	if len(tags) == 0 {
-		return "", fmt.Errorf("no tags found for %s", imageRepo)
+		return "", fmt.Errorf("%w for %s", ErrNoTags, imageRepo)
 	}
+	return selectTag(ctx, spec, tags, func(t string) (time.Time, error) {
+		return imageCreated(ctx, imageRepo, t, opts)
+	})
+}
 
+// selectTag applies spec to tags listed from any source for spec.Image. created returns a
+// tag's creation time, for MinAge and selectExpr's age variable.
+func selectTag(ctx context.Context, spec TagSpec, tags []string, created func(tag string) (time.Time, error)) (string, error) {
+	imageRepo, constraint, tagRegex, allowPrerelease := spec.Image, spec.Constraint, spec.TagRegex, spec.AllowPrerelease
+	strategy := strings.TrimSpace(spec.Strategy)
+	if strategy == "" {
+		strategy = "semver"
+	}
+	log := logutil.FromContext(ctx).With(zap.String("func", "imageresolver.selectTag"), zap.String("image", imageRepo), zap.String("strategy", strategy))
 	switch strategy {
-	case "semver":
-		return pickSemverTag(tags, constraint, allowPrerelease)
-	case "regex":
-		if tagRegex == "" {
-			return "", fmt.Errorf("strategy=regex requires tagRegex")
-		}
-		return pickRegexTag(tags, tagRegex, allowPrerelease)
-	case "literal":
+	case "regex", "literal":
 		if tagRegex == "" {
-			return "", fmt.Errorf("strategy=literal requires tagRegex")
+			return "", fmt.Errorf("strategy=%s requires tagRegex", strategy)
 		}
-		return pickLiteralTag(tags, tagRegex)
+	case "semver":
 	default:
 		return "", fmt.Errorf("unknown strategy: %q", strategy)
 	}
+	pick := func(tags []string) (tag string, err error) {
+		switch strategy {
+		case "semver":
+			if spec.PreferStableOnGraduation {
+				if g, ok := graduatedTag(tags, spec.CurrentTag, constraint); ok {
+					logutil.Detail(ctx, log, "prerelease graduated to stable", zap.String("current", spec.CurrentTag), zap.String("stable", g))
+					return g, nil
+				}
+			}
+			if spec.SelectExpr != "" {
+				return pickExprTag(ctx, tags, spec.SelectExpr, spec.SelectOrder, constraint, allowPrerelease, spec.CurrentTag, created)
+			}
+			return pickSemverTag(tags, constraint, allowPrerelease, spec.CurrentTag)
+		case "regex":
+			return pickRegexTag(tags, tagRegex, constraint, allowPrerelease)
+		default:
+			return pickLiteralTag(tags, tagRegex)
+		}
+	}
+	if spec.IgnoreTags != nil {
+		n := len(tags)
+		if tags = spec.IgnoreTags.filter(imageRepo, tags); len(tags) < n {
+			log.Debug("dropped ignored tags", zap.Int("ignored", n-len(tags)))
+		}
+		if len(tags) == 0 {
+			return "", fmt.Errorf("%w for %s: every tag is on the ignore list", ErrNoMatchingTags, imageRepo)
+		}
+	}
+	var restore map[string]string
+	if spec.Variant != "" {
+		if strategy != "semver" {
+			return "", fmt.Errorf("variant requires strategy=semver")
+		}
+		if spec.Suffix != "" {
+			return "", fmt.Errorf("variant and suffix cannot be combined")
+		}
+		tags, restore = variantTags(tags, spec.Variant)
+		if len(tags) == 0 {
+			return "", fmt.Errorf("%w for %s: no tag has variant %q", ErrNoMatchingTags, imageRepo, spec.Variant)
+		}
+		log.Debug("filtered tags to variant", zap.String("variant", spec.Variant), zap.Int("tags", len(tags)))
+		spec.CurrentTag, _ = cutVariant(spec.CurrentTag, spec.Variant)
+	}
+	if spec.Channel != "" || spec.Suffix != "" {
+		if strategy != "semver" {
+			return "", fmt.Errorf("channel and suffix require strategy=semver")
+		}
+		var r map[string]string
+		tags, r = channelTags(tags, spec.Channel, spec.Suffix)
+		if len(tags) == 0 {
+			return "", fmt.Errorf("%w for %s: no tag in channel %q with suffix %q", ErrNoMatchingTags, imageRepo, spec.Channel, spec.Suffix)
+		}
+		log.Debug("filtered tags to channel", zap.String("channel", spec.Channel), zap.String("suffix", spec.Suffix), zap.Int("tags", len(tags)))
+		spec.CurrentTag = strings.TrimSuffix(spec.CurrentTag, spec.Suffix)
+		if restore != nil {
+			for k, v := range r {
+				r[k] = restore[v]
+			}
+		}
+		restore = r
+	}
+	if restore != nil {
+		inner := created
+		created = func(t string) (time.Time, error) { return inner(restore[t]) }
+	}
+	tag, err := pick(tags)
+	if err == nil && spec.MinAge > 0 {
+		tag, err = oldEnoughTag(ctx, tags, tag, spec.MinAge, pick, created)
+	}
+	if err != nil {
+		return "", err
+	}
+	if restore != nil {
+		tag = restore[tag]
+	}
+	logutil.Event(ctx, logutil.EventCandidateSelected, zap.String("image", imageRepo), zap.String("strategy", strategy), zap.String("tag", tag))
+	return tag, nil
+}
+
+// channelTags returns the tags in channel that end in suffix, with suffix removed, and a map
+// from each returned tag back to the original.
+func channelTags(tags []string, channel, suffix string) ([]string, map[string]string) {
+	var kept []string
+	restore := map[string]string{}
+	for _, t := range tags {
+		v, ok := strings.CutSuffix(t, suffix)
+		if !ok || v == "" {
+			continue
+		}
+		if channel != "" && v != channel && !strings.HasPrefix(v, channel+".") {
+			continue
+		}
+		kept = append(kept, v)
+		restore[v] = t
+	}
+	return kept, restore
+}
+
+// variantTags returns the leading versions of the tags of variant, e.g. 1.21.4 for
+// 1.21.4-alpine3.18, and a map from each back to the tag with the highest variant number
+// for that version.
+func variantTags(tags []string, variant string) ([]string, map[string]string) {
+	var kept []string
+	restore := map[string]string{}
+	best := map[string]string{}
+	for _, t := range tags {
+		v, num := cutVariant(t, variant)
+		if v == t || v == "" || !isVariantNumber(num) {
+			continue
+		}
+		prev, seen := best[v]
+		if !seen {
+			kept = append(kept, v)
+		} else if compareVariantNumbers(num, prev) <= 0 {
+			continue
+		}
+		best[v], restore[v] = num, t
+	}
+	return kept, restore
+}
+
+// cutVariant splits tag at its last "-"+variant into the leading version and the number
+// after variant. A tag without variant is returned whole with an empty number.
+func cutVariant(tag, variant string) (version, num string) {
+	i := strings.LastIndex(tag, "-"+variant)
+	if i < 0 {
+		return tag, ""
+	}
+	return tag[:i], tag[i+1+len(variant):]
+}
+
+// isVariantNumber reports whether s is empty or dot-separated decimal numbers, like 3.18.
+func isVariantNumber(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, p := range strings.Split(s, ".") {
+		if p == "" || strings.Trim(p, "0123456789") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// compareVariantNumbers compares two variant numbers part by part as integers, so 3.18 is
+// above 3.9. A missing number sorts lowest, and 3.18 is above 3.
+func compareVariantNumbers(a, b string) int {
+	if a == "" || b == "" {
+		return strings.Compare(a, b)
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, y := strings.TrimLeft(as[i], "0"), strings.TrimLeft(bs[i], "0")
+		if len(x) != len(y) {
+			return cmp.Compare(len(x), len(y))
+		}
+		if c := strings.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(as), len(bs))
+}
+
+// oldEnoughTag returns tag if its image is at least minAge old. Otherwise it drops tag from the
+// candidates and picks again, giving up after maxMinAgeChecks lookups.
+func oldEnoughTag(ctx context.Context, tags []string, tag string, minAge time.Duration, pick func([]string) (string, error), created func(tag string) (time.Time, error)) (string, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "imageresolver.oldEnoughTag"), zap.Duration("minAge", minAge))
+	now := time.Now()
+	for i := 1; ; i++ {
+		c, err := created(tag)
+		if err != nil {
+			return "", fmt.Errorf("creation time of %s: %w", tag, err)
+		}
+		if now.Sub(c) >= minAge {
+			return tag, nil
+		}
+		log.Debug("skipping tag younger than minAge", zap.String("tag", tag), zap.Time("created", c))
+		if i == maxMinAgeChecks {
+			return "", fmt.Errorf("none of the %d best candidates is older than minAge %s", maxMinAgeChecks, minAge)
+		}
+		young := tag
+		tags = slices.DeleteFunc(slices.Clone(tags), func(t string) bool { return t == young })
+		if tag, err = pick(tags); err != nil {
+			return "", fmt.Errorf("no candidate older than minAge %s: %w", minAge, err)
+		}
+	}
 }
 
 // ResolveDigest resolves the manifest digest for imageRepo:tag.
 // If platform is non-empty (e.g. linux/amd64), it selects that platform in an index.
+// It is a wrapper around Resolver.ResolveDigest.
 func ResolveDigest(ctx context.Context, imageRepo, tag, platform string, opts *Options) (string, error) {
+	return resolverFor(opts).ResolveDigest(ctx, imageRepo, tag, platform)
+}
+
+// ResolveDigest resolves the manifest digest for imageRepo:tag, through the digest cache.
+// If platform is non-empty (e.g. linux/amd64), it selects that platform in an index.
+func (r *Resolver) ResolveDigest(ctx context.Context, imageRepo, tag, platform string) (string, error) {
 	log := logutil.FromContext(ctx).With(zap.String("func", "imageresolver.ResolveDigest"), zap.String("image", imageRepo), zap.String("tag", tag), zap.String("platform", platform))
 	log.Debug("resolving digest")
 	if imageRepo == "" || tag == "" {
 		return "", fmt.Errorf("image repository and tag are required to resolve digest")
 	}
-	if opts == nil {
-		o := defaultOptions()
-		o.Context = ctx
-		opts = &o
-	} else if opts.Context == nil {
-		opts.Context = ctx
+	opts := &r.opts
+
+	if opts.DigestCache != nil {
+		if d, ok := opts.DigestCache.get(imageRepo, tag, platform); ok {
+			log.Debug("digest cache hit", zap.String("digest", d))
+			return d, nil
+		}
 	}
 
-	refStr := imageRepo + ":" + tag
+	refStr := opts.mirrored(imageRepo) + ":" + tag
 	ref, err := name.ParseReference(refStr)
 	if err != nil {
 		return "", err
 	}
 
-	remoteOpts := []remote.Option{remote.WithAuthFromKeychain(opts.Keychain), remote.WithContext(opts.Context)}
+	remoteOpts := opts.remoteOptions(ctx)
+	if platform != "" {
+		plat, err := parsePlatform(platform)
+		if err != nil {
+			return "", err
+		}
+		remoteOpts = append(remoteOpts, remote.WithPlatform(*plat))
+	}
+
+	var desc *remote.Descriptor
+	err = withAnonymousRetry(ctx, opts.Keychain, ref.Context(), func(kc authn.Keychain) error {
+		if err := opts.RateLimiter.Wait(ctx); err != nil {
+			return err
+		}
+		var err error
+		desc, err = remote.Get(ref, append(remoteOpts, remote.WithAuthFromKeychain(kc))...)
+		return err
+	})
+	if err != nil {
+		return "", newRegistryError(imageRepo, err)
+	}
+	digest := desc.Descriptor.Digest.String()
+	if opts.DigestCache != nil {
+		opts.DigestCache.put(imageRepo, tag, platform, digest)
+	}
+	return digest, nil
+}
+
+// ResolveLabel reads the image config for imageRepo:tag and returns the value of the given label
+// (e.g. org.opencontainers.image.version). If platform is non-empty, it selects that platform in an index.
+// It is a wrapper around Resolver.ResolveLabel.
+func ResolveLabel(ctx context.Context, imageRepo, tag, label, platform string, opts *Options) (string, error) {
+	return resolverFor(opts).ResolveLabel(ctx, imageRepo, tag, label, platform)
+}
+
+// ResolveLabel returns the value of label in the image config of imageRepo:tag. If platform is
+// non-empty, it selects that platform in an index.
+func (r *Resolver) ResolveLabel(ctx context.Context, imageRepo, tag, label, platform string) (string, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "imageresolver.ResolveLabel"), zap.String("image", imageRepo), zap.String("tag", tag), zap.String("label", label), zap.String("platform", platform))
+	log.Debug("resolving label")
+	if imageRepo == "" || tag == "" {
+		return "", fmt.Errorf("image repository and tag are required to resolve label")
+	}
+	if label == "" {
+		return "", fmt.Errorf("label name is required")
+	}
+	opts := &r.opts
+
+	ref, err := name.ParseReference(opts.mirrored(imageRepo) + ":" + tag)
+	if err != nil {
+		return "", err
+	}
+
+	remoteOpts := opts.remoteOptions(ctx)
 	if platform != "" {
 		plat, err := parsePlatform(platform)
 		if err != nil {
@@ -125,27 +421,181 @@ func ResolveDigest(ctx context.Context, imageRepo, tag, platform string, opts *O
 		remoteOpts = append(remoteOpts, remote.WithPlatform(*plat))
 	}
 
-	desc, err := remote.Get(ref, remoteOpts...)
+	var cfg *v1.ConfigFile
+	err = withAnonymousRetry(ctx, opts.Keychain, ref.Context(), func(kc authn.Keychain) error {
+		if err := opts.RateLimiter.Wait(ctx); err != nil {
+			return err
+		}
+		img, err := remote.Image(ref, append(remoteOpts, remote.WithAuthFromKeychain(kc))...)
+		if err != nil {
+			return err
+		}
+		cfg, err = img.ConfigFile()
+		return err
+	})
+	if err != nil {
+		return "", newRegistryError(imageRepo, err)
+	}
+	v, ok := cfg.Config.Labels[label]
+	if !ok || v == "" {
+		return "", fmt.Errorf("image %s has no label %q", ref.String(), label)
+	}
+	log.Debug("resolved label", zap.String("value", v))
+	return v, nil
+}
+
+// ResolveExactTag returns tag after checking that imageRepo has it, for pinning a known tag.
+// It is a wrapper around Resolver.ResolveExactTag.
+func ResolveExactTag(ctx context.Context, imageRepo, tag string, opts *Options) (string, error) {
+	return resolverFor(opts).ResolveExactTag(ctx, imageRepo, tag)
+}
+
+// ResolveExactTag returns tag after checking that imageRepo has it and that it isn't ignored.
+func (r *Resolver) ResolveExactTag(ctx context.Context, imageRepo, tag string) (string, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "imageresolver.ResolveExactTag"), zap.String("image", imageRepo), zap.String("tag", tag))
+	log.Debug("verifying tag exists")
+	opts := &r.opts
+	tags, err := listTags(ctx, imageRepo, opts)
 	if err != nil {
 		return "", err
 	}
-	return desc.Descriptor.Digest.String(), nil
+	if !slices.Contains(tags, tag) {
+		return "", fmt.Errorf("%w: %s:%s", ErrTagNotFound, imageRepo, tag)
+	}
+	if opts.IgnoreTags.Ignored(imageRepo, tag) {
+		return "", fmt.Errorf("%s:%s is on the ignore list", imageRepo, tag)
+	}
+	logutil.Event(ctx, logutil.EventCandidateSelected, zap.String("image", imageRepo), zap.String("strategy", "exact"), zap.String("tag", tag))
+	return tag, nil
+}
+
+// listTags lists imageRepo's tags, through opts.TagCache if set.
+func listTags(ctx context.Context, imageRepo string, opts *Options) ([]string, error) {
+	list := func() ([]string, error) {
+		repo, err := name.NewRepository(opts.mirrored(imageRepo))
+		if err != nil {
+			return nil, newRegistryError(imageRepo, err)
+		}
+		var tags []string
+		err = withAnonymousRetry(ctx, opts.Keychain, repo, func(kc authn.Keychain) error {
+			var err error
+			tags, err = listTagPages(ctx, repo, imageRepo, opts, kc)
+			return err
+		})
+		if err != nil {
+			return nil, newRegistryError(imageRepo, err)
+		}
+		logutil.Event(ctx, logutil.EventTagsListed, zap.String("image", imageRepo), zap.Int("count", len(tags)))
+		return tags, nil
+	}
+	if opts.TagCache == nil {
+		return list()
+	}
+	return opts.TagCache.get(imageRepo, list)
+}
+
+// listTagPages follows the registry's Link headers through every page of repo's tag list,
+// stopping with a warning after opts.MaxTagPages pages if that is positive. imageRepo is repo
+// before mirroring.
+func listTagPages(ctx context.Context, repo name.Repository, imageRepo string, opts *Options, kc authn.Keychain) ([]string, error) {
+	maxPages := opts.MaxTagPages
+	puller, err := remote.NewPuller(append(opts.remoteOptions(ctx), remote.WithAuthFromKeychain(kc))...)
+	if err != nil {
+		return nil, err
+	}
+	// Lister fetches the first page; Next fetches each later one.
+	if err := opts.RateLimiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+	pages, err := puller.Lister(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	tags := []string{}
+	for n := 0; pages.HasNext(); n++ {
+		if maxPages > 0 && n == maxPages {
+			logutil.FromContext(ctx).Warn("tag list truncated; newer tags may be missed",
+				zap.String("image", imageRepo), zap.Int("pages", n), zap.Int("tags", len(tags)))
+			break
+		}
+		if n > 0 {
+			if err := opts.RateLimiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		page, err := pages.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+	}
+	return tags, nil
+}
+
+// withAnonymousRetry calls fn with keychain and, if the registry rejects the credentials it
+// holds for res (401/403), retries once anonymously. Credentials without access to a public
+// image (e.g. a GITHUB_TOKEN lacking read:packages) can fail where an anonymous pull
+// succeeds. When the keychain has no credentials for res the call was already anonymous, so
+// there is nothing to retry.
+func withAnonymousRetry(ctx context.Context, keychain authn.Keychain, res authn.Resource, fn func(authn.Keychain) error) error {
+	err := fn(keychain)
+	if err == nil || classifyRegistryError(err) != AuthError {
+		return err
+	}
+	if auth, kerr := authn.Resolve(ctx, keychain, res); kerr != nil || auth == authn.Anonymous {
+		return err
+	}
+	logutil.FromContext(ctx).Debug("authenticated registry call rejected; retrying anonymously", zap.Error(err))
+	if anonErr := fn(anonymousKeychain{}); anonErr != nil {
+		// Report the original failure; it carries the more useful cause.
+		return err
+	}
+	return nil
+}
+
+type anonymousKeychain struct{}
+
+func (anonymousKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return authn.Anonymous, nil
+}
+
+// ValidatePlatform checks that p is an os/arch platform such as linux/amd64.
+func ValidatePlatform(p string) error {
+	_, err := parsePlatform(p)
+	return err
 }
 
 func parsePlatform(p string) (*v1.Platform, error) {
 	parts := strings.Split(p, "/")
-	if len(parts) != 2 {
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
 		return nil, fmt.Errorf("invalid platform %q, expected os/arch (e.g. linux/amd64)", p)
 	}
 	return &v1.Platform{OS: parts[0], Architecture: parts[1]}, nil
 }
 
-func pickSemverTag(tags []string, constraint string, allowPrerelease bool) (string, error) {
+func pickSemverTag(tags []string, constraint string, allowPrerelease bool, current string) (string, error) {
+	cands, err := semverCandidates(tags, constraint, allowPrerelease)
+	if err != nil {
+		return "", err
+	}
+	bestVer := cands[len(cands)-1].ver
+	bestTags := make([]string, 0, 2)
+	for _, it := range cands {
+		if it.ver.Equal(bestVer) {
+			bestTags = append(bestTags, it.tag)
+		}
+	}
+	return preferPrefixStyle(bestTags, current), nil
+}
+
+// semverCandidates returns the semver tags that satisfy constraint and allowPrerelease, sorted
+// from lowest to highest. It errors if there are none.
+func semverCandidates(tags []string, constraint string, allowPrerelease bool) ([]cand, error) {
 	var c *semver.Constraints
 	if strings.TrimSpace(constraint) != "" {
 		cc, err := semver.NewConstraint(constraint)
 		if err != nil {
-			return "", fmt.Errorf("invalid constraint %q: %w", constraint, err)
+			return nil, fmt.Errorf("invalid constraint %q: %w", constraint, err)
 		}
 		c = cc
 	}
@@ -156,43 +606,203 @@ func pickSemverTag(tags []string, constraint string, allowPrerelease bool) (stri
 		if err != nil {
 			continue
 		}
-		if !allowPrerelease && v.Prerelease() != "" {
-			continue
-		}
-		if c != nil && !c.Check(v) {
+		// A constraint decides prereleases itself: it only admits one when a comparator in the
+		// matching range names a prerelease (>=2.0.0-rc.1, or >=3.0.0-0 in an || range). Such a
+		// constraint asks for prereleases explicitly, so it gets them even without
+		// allowPrerelease; other constraints never match a prerelease.
+		if c != nil {
+			if !c.Check(v) {
+				continue
+			}
+		} else if !allowPrerelease && v.Prerelease() != "" {
 			continue
 		}
 		cands = append(cands, cand{tag: t, ver: v})
 	}
 	if len(cands) == 0 {
 		if c != nil {
-			return "", fmt.Errorf("no semver tags match constraint %q", constraint)
+			return nil, fmt.Errorf("no semver tags match constraint %q", constraint)
 		}
-		return "", fmt.Errorf("no semver tags found")
+		return nil, fmt.Errorf("no semver tags found")
 	}
-
 	sort.Slice(cands, func(i, j int) bool { return cands[i].ver.LessThan(cands[j].ver) })
-	bestVer := cands[len(cands)-1].ver
-	bestTags := make([]string, 0, 2)
-	for _, it := range cands {
-		if it.ver.Equal(bestVer) {
-			bestTags = append(bestTags, it.tag)
-		}
-	}
+	return cands, nil
+}
+
+// preferPrefixStyle picks among tags that share one semver.
+func preferPrefixStyle(bestTags []string, current string) string {
 	if len(bestTags) == 1 {
-		return bestTags[0], nil
+		return bestTags[0]
 	}
-	// Prefer no 'v' prefix when multiple tags map to same semver.
+	// When multiple tags map to the same semver, keep the current tag's 'v' prefix style
+	// (v1.2.3 stays v-prefixed); without a current tag, prefer no 'v' prefix.
+	wantV := strings.HasPrefix(strings.TrimSpace(current), "v")
 	sort.Strings(bestTags)
 	for _, t := range bestTags {
-		if !strings.HasPrefix(t, "v") {
-			return t, nil
+		if strings.HasPrefix(t, "v") == wantV {
+			return t
+		}
+	}
+	return bestTags[0]
+}
+
+// pickExprTag returns the highest semver tag that satisfies constraint and allowPrerelease
+// and for which expr is true. Without order, candidates are evaluated from the highest version
+// down and the first match wins. With order, every candidate is evaluated and the match with
+// the highest order key wins, ties going to the higher version. created is called at most once
+// per tag, and only for candidates whose evaluation reaches the age variable.
+func pickExprTag(ctx context.Context, tags []string, expr, order, constraint string, allowPrerelease bool, current string, created func(tag string) (time.Time, error)) (string, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "imageresolver.pickExprTag"), zap.String("selectExpr", expr), zap.String("selectOrder", order))
+	e, err := selectexpr.Compile(expr)
+	if err != nil {
+		return "", err
+	}
+	var o *selectexpr.Expr
+	if order != "" {
+		if o, err = selectexpr.Compile(order); err != nil {
+			return "", err
+		}
+	}
+	cands, err := semverCandidates(tags, constraint, allowPrerelease)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	ages := map[string]time.Duration{}
+	vars := func(c cand) selectexpr.Vars {
+		return func(name string) (any, error) {
+			switch name {
+			case "major":
+				return c.ver.Major(), nil
+			case "minor":
+				return c.ver.Minor(), nil
+			case "patch":
+				return c.ver.Patch(), nil
+			case "prerelease":
+				return c.ver.Prerelease(), nil
+			case "tag":
+				return c.tag, nil
+			case "age":
+				if age, ok := ages[c.tag]; ok {
+					return age, nil
+				}
+				t, err := created(c.tag)
+				if err != nil {
+					return nil, err
+				}
+				ages[c.tag] = now.Sub(t)
+				return ages[c.tag], nil
+			default:
+				return nil, fmt.Errorf("unknown variable %q", name)
+			}
+		}
+	}
+	// Walk one version at a time so that tags sharing a version (1.2.3 and v1.2.3) are
+	// decided together, as pickSemverTag does.
+	var best []string
+	var bestKey selectexpr.Key
+	bestGroup := -1
+	for hi := len(cands); hi > 0; {
+		lo := hi - 1
+		for lo > 0 && cands[lo-1].ver.Equal(cands[hi-1].ver) {
+			lo--
+		}
+		var matched []string
+		for _, c := range cands[lo:hi] {
+			ok, err := e.Eval(vars(c))
+			if err != nil {
+				return "", fmt.Errorf("tag %s: %w", c.tag, err)
+			}
+			if !ok {
+				continue
+			}
+			if o == nil {
+				matched = append(matched, c.tag)
+				continue
+			}
+			k, err := o.EvalKey(vars(c))
+			if err != nil {
+				return "", fmt.Errorf("tag %s: %w", c.tag, err)
+			}
+			switch cmp := k.Compare(bestKey); {
+			case bestGroup < 0 || cmp > 0:
+				best, bestKey, bestGroup = []string{c.tag}, k, hi
+			case cmp == 0 && bestGroup == hi:
+				best = append(best, c.tag)
+			}
+		}
+		if len(matched) > 0 {
+			log.Debug("selectExpr matched", zap.Strings("tags", matched), zap.Int("ageLookups", len(ages)))
+			return preferPrefixStyle(matched, current), nil
+		}
+		hi = lo
+	}
+	if len(best) > 0 {
+		log.Debug("selectOrder ranked first", zap.Strings("tags", best), zap.Int("ageLookups", len(ages)))
+		return preferPrefixStyle(best, current), nil
+	}
+	return "", fmt.Errorf("no tags match selectExpr %q", expr)
+}
+
+// imageCreated returns the creation time recorded in the image config for imageRepo:tag.
+func imageCreated(ctx context.Context, imageRepo, tag string, opts *Options) (time.Time, error) {
+	ref, err := name.ParseReference(opts.mirrored(imageRepo) + ":" + tag)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var cfg *v1.ConfigFile
+	err = withAnonymousRetry(ctx, opts.Keychain, ref.Context(), func(kc authn.Keychain) error {
+		if err := opts.RateLimiter.Wait(ctx); err != nil {
+			return err
+		}
+		img, err := remote.Image(ref, append(opts.remoteOptions(ctx), remote.WithAuthFromKeychain(kc))...)
+		if err != nil {
+			return err
 		}
+		cfg, err = img.ConfigFile()
+		return err
+	})
+	if err != nil {
+		return time.Time{}, newRegistryError(imageRepo, err)
 	}
-	return bestTags[0], nil
+	return cfg.Created.Time, nil
 }
 
-func pickRegexTag(tags []string, tagRegex string, allowPrerelease bool) (string, error) {
+// graduatedTag returns the stable tag for current's version if current is a prerelease and that
+// stable release exists (and satisfies constraint, when set).
+func graduatedTag(tags []string, current, constraint string) (string, bool) {
+	cur, err := semver.NewVersion(strings.TrimSpace(current))
+	if err != nil || cur.Prerelease() == "" {
+		return "", false
+	}
+	stable, err := cur.SetPrerelease("")
+	if err != nil {
+		return "", false
+	}
+	stable, err = stable.SetMetadata("")
+	if err != nil {
+		return "", false
+	}
+	var matches []string
+	for _, t := range tags {
+		v, err := semver.NewVersion(t)
+		if err != nil || !v.Equal(&stable) {
+			continue
+		}
+		matches = append(matches, t)
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+	// Reuse pickSemverTag for constraint checks and tie-breaking between equivalent tags.
+	t, err := pickSemverTag(matches, constraint, false, current)
+	if err != nil {
+		return "", false
+	}
+	return t, true
+}
+
+func pickRegexTag(tags []string, tagRegex, constraint string, allowPrerelease bool) (string, error) {
 	re, err := regexp.Compile(tagRegex)
 	if err != nil {
 		return "", fmt.Errorf("invalid tagRegex %q: %w", tagRegex, err)
@@ -200,6 +810,17 @@ func pickRegexTag(tags []string, tagRegex string, allowPrerelease bool) (string,
 
 	// If regex has at least one capturing group, try to parse group 1 as semver.
 	useCaptureSemver := re.NumSubexp() >= 1
+	var c *semver.Constraints
+	if strings.TrimSpace(constraint) != "" {
+		if !useCaptureSemver {
+			return "", fmt.Errorf("constraint %q needs a capture group in tagRegex %q to compare against", constraint, tagRegex)
+		}
+		cc, err := semver.NewConstraint(constraint)
+		if err != nil {
+			return "", fmt.Errorf("invalid constraint %q: %w", constraint, err)
+		}
+		c = cc
+	}
 	cands := make([]cand, 0)
 	for _, t := range tags {
 		m := re.FindStringSubmatch(t)
@@ -211,7 +832,12 @@ func pickRegexTag(tags []string, tagRegex string, allowPrerelease bool) (string,
 			if err != nil {
 				continue
 			}
-			if !allowPrerelease && v.Prerelease() != "" {
+			// As in pickSemverTag, a constraint decides prereleases itself.
+			if c != nil {
+				if !c.Check(v) {
+					continue
+				}
+			} else if !allowPrerelease && v.Prerelease() != "" {
 				continue
 			}
 			cands = append(cands, cand{tag: t, ver: v})
@@ -220,6 +846,9 @@ func pickRegexTag(tags []string, tagRegex string, allowPrerelease bool) (string,
 		}
 	}
 	if len(cands) == 0 {
+		if c != nil {
+			return "", fmt.Errorf("no tags match tagRegex %q with constraint %q", tagRegex, constraint)
+		}
 		return "", fmt.Errorf("no tags match tagRegex %q", tagRegex)
 	}
 
@@ -274,14 +903,15 @@ func candsTags(cs []cand) []string {
 // while still working with private repos when GITHUB_TOKEN has access.
 type ghcrKeychain struct {
 	fallback authn.Keychain
+	// tokenFile is passed to GitHubToken.
+	tokenFile string
 }
 
 func (g ghcrKeychain) Resolve(resource authn.Resource) (authn.Authenticator, error) {
 	// Try default first.
 	if g.fallback != nil {
 		a, err := g.fallback.Resolve(resource)
-		if err == nil {
-			// Default keychain may return anonymous; that's fine.
+		if err == nil && a != authn.Anonymous {
 			return a, nil
 		}
 	}
@@ -289,7 +919,10 @@ func (g ghcrKeychain) Resolve(resource authn.Resource) (authn.Authenticator, err
 	if resource.RegistryStr() != "ghcr.io" {
 		return authn.Anonymous, nil
 	}
-	tok := os.Getenv("GITHUB_TOKEN")
+	tok, err := GitHubToken(g.tokenFile)
+	if err != nil {
+		return nil, err
+	}
 	actor := os.Getenv("GITHUB_ACTOR")
 	if tok == "" || actor == "" {
 		return authn.Anonymous, nil
@@ -0,0 +1,660 @@
+package imageresolver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+)
+
+// newTestRegistry starts an in-memory registry and returns its host (e.g. 127.0.0.1:1234).
+func newTestRegistry(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(registry.New())
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+// newCountingRegistry is like newTestRegistry but also counts manifest requests.
+func newCountingRegistry(t *testing.T) (string, *atomic.Int64) {
+	t.Helper()
+	var n atomic.Int64
+	reg := registry.New()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			n.Add(1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://"), &n
+}
+
+func pushImage(t *testing.T, repo, tag string, labels map[string]string) {
+	t.Helper()
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("random.Image: %v", err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatalf("ConfigFile: %v", err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.Config.Labels = labels
+	img, err = mutate.ConfigFile(img, cfg)
+	if err != nil {
+		t.Fatalf("mutate.ConfigFile: %v", err)
+	}
+	ref, err := name.ParseReference(repo + ":" + tag)
+	if err != nil {
+		t.Fatalf("ParseReference: %v", err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("remote.Write: %v", err)
+	}
+}
+
+func testOptions() *Options {
+	return &Options{Keychain: authn.NewMultiKeychain()}
+}
+
+func TestResolveTag_LocalhostRegistry(t *testing.T) {
+	// A registry named by localhost and a port, without a dot, resolves like any other.
+	host := strings.Replace(newTestRegistry(t), "127.0.0.1", "localhost", 1)
+	pushImage(t, host+"/app", "1.2.3", nil)
+	if tag, err := ResolveTag(context.Background(), host+"/app", "semver", "", "", false, testOptions()); err != nil || tag != "1.2.3" {
+		t.Fatalf("ResolveTag got %q, %v", tag, err)
+	}
+}
+
+func TestResolveLabel(t *testing.T) {
+	repo := newTestRegistry(t) + "/org/app"
+	pushImage(t, repo, "1.2.3", map[string]string{
+		"org.opencontainers.image.version": "1.2.3-build.7",
+		"com.example.replicas":             "3",
+	})
+
+	got, err := ResolveLabel(context.Background(), repo, "1.2.3", "com.example.replicas", "", testOptions())
+	if err != nil {
+		t.Fatalf("ResolveLabel: %v", err)
+	}
+	if got != "3" {
+		t.Fatalf("got %q want %q", got, "3")
+	}
+
+	if _, err := ResolveLabel(context.Background(), repo, "1.2.3", "missing", "", testOptions()); err == nil {
+		t.Fatalf("expected error for missing label")
+	}
+}
+
+func TestResolveDigest_CachesByTag(t *testing.T) {
+	host, manifests := newCountingRegistry(t)
+	repo := host + "/org/app"
+	pushImage(t, repo, "1.2.3", nil)
+
+	opts := testOptions()
+	opts.DigestCache = NewDigestCache(time.Minute)
+
+	first, err := ResolveDigest(context.Background(), repo, "1.2.3", "", opts)
+	if err != nil {
+		t.Fatalf("ResolveDigest: %v", err)
+	}
+	before := manifests.Load()
+	second, err := ResolveDigest(context.Background(), repo, "1.2.3", "", opts)
+	if err != nil {
+		t.Fatalf("ResolveDigest: %v", err)
+	}
+	if first != second {
+		t.Fatalf("digest changed between lookups: %q vs %q", first, second)
+	}
+	if got := manifests.Load(); got != before {
+		t.Fatalf("expected cache hit, registry saw %d extra manifest requests", got-before)
+	}
+}
+
+func TestDigestCache_Expires(t *testing.T) {
+	c := NewDigestCache(time.Minute)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	c.put("ghcr.io/org/app", "1.2.3", "", "sha256:abc")
+	if _, ok := c.get("ghcr.io/org/app", "1.2.3", ""); !ok {
+		t.Fatalf("expected hit before TTL")
+	}
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.get("ghcr.io/org/app", "1.2.3", ""); ok {
+		t.Fatalf("expected miss after TTL")
+	}
+}
+
+func TestPickSemverTag_Constraints(t *testing.T) {
+	tags := []string{"1.1.0", "1.2.0", "1.9.0", "2.0.0", "2.5.0", "3.0.0-rc.1", "3.0.0", "3.1.0-beta.1", "3.1.0"}
+	for _, c := range []struct {
+		constraint      string
+		allowPrerelease bool
+		want            string
+	}{
+		{">=1.2.0 <2.0.0 || >=3.0.0", false, "3.1.0"},
+		{">=1.2.0 <2.0.0 || >=4.0.0", false, "1.9.0"},
+		{"<1.5.0 || >=2.0.0 <3.0.0", false, "2.5.0"},
+		// Naming a prerelease in the constraint admits prereleases without allowPrerelease.
+		{"1.x || 3.0.0-rc.1", false, "3.0.0-rc.1"},
+		{"<2.0.0 || >=3.1.0-alpha <=3.1.0-rc", false, "3.1.0-beta.1"},
+		// A constraint without prereleases never matches one, allowPrerelease or not.
+		{">=3.0.0 <3.1.0", true, "3.0.0"},
+	} {
+		got, err := pickSemverTag(tags, c.constraint, c.allowPrerelease, "")
+		if err != nil {
+			t.Fatalf("pickSemverTag(%q): %v", c.constraint, err)
+		}
+		if got != c.want {
+			t.Fatalf("pickSemverTag(%q, allowPrerelease=%v) got %q want %q", c.constraint, c.allowPrerelease, got, c.want)
+		}
+	}
+	if _, err := pickSemverTag(tags, ">=4.0.0 || <1.0.0", false, ""); err == nil {
+		t.Fatalf("expected an error when no range matches")
+	}
+}
+
+func TestPickRegexTag_Constraint(t *testing.T) {
+	tags := []string{"app-v1.4.0", "app-v1.9.2", "app-v2.0.0-rc.1", "app-v2.0.0", "app-v2.1.0", "other-v1.99.0"}
+	re := `^app-v(\d+\.\d+\.\d+(?:-[0-9A-Za-z.]+)?)$`
+	for _, c := range []struct {
+		constraint string
+		want       string
+	}{
+		{"", "app-v2.1.0"},
+		{"<2.0.0", "app-v1.9.2"},
+		{"~1.4.0", "app-v1.4.0"},
+		{">=2.0.0-rc.1 <=2.0.0-rc.9", "app-v2.0.0-rc.1"},
+	} {
+		got, err := pickRegexTag(tags, re, c.constraint, false)
+		if err != nil {
+			t.Fatalf("pickRegexTag(%q): %v", c.constraint, err)
+		}
+		if got != c.want {
+			t.Fatalf("pickRegexTag(%q) got %q want %q", c.constraint, got, c.want)
+		}
+	}
+
+	_, err := pickRegexTag(tags, re, ">=3.0.0", false)
+	if err == nil || !strings.Contains(err.Error(), `constraint ">=3.0.0"`) {
+		t.Fatalf("expected an error naming the constraint when it excludes every match, got %v", err)
+	}
+	if _, err := pickRegexTag(tags, `^app-v`, "<2.0.0", false); err == nil || !strings.Contains(err.Error(), "capture group") {
+		t.Fatalf("expected an error for a constraint without a capture group, got %v", err)
+	}
+}
+
+func TestPickSemverTag_StableOutranksItsPrerelease(t *testing.T) {
+	got, err := pickSemverTag([]string{"2.0.0-rc.3", "2.0.0"}, "", true, "")
+	if err != nil {
+		t.Fatalf("pickSemverTag: %v", err)
+	}
+	if got != "2.0.0" {
+		t.Fatalf("got %q want %q", got, "2.0.0")
+	}
+}
+
+func TestResolveTag_PreferStableOnGraduation(t *testing.T) {
+	repo := newTestRegistry(t) + "/org/app"
+	for _, tag := range []string{"2.0.0-rc.3", "2.0.0", "2.1.0-rc.1"} {
+		pushImage(t, repo, tag, nil)
+	}
+
+	// Without the option, the highest prerelease of the newer line wins.
+	got, err := ResolveTag(context.Background(), repo, "semver", "", "", true, testOptions())
+	if err != nil {
+		t.Fatalf("ResolveTag: %v", err)
+	}
+	if got != "2.1.0-rc.1" {
+		t.Fatalf("got %q want %q", got, "2.1.0-rc.1")
+	}
+
+	spec := TagSpec{Image: repo, Strategy: "semver", AllowPrerelease: true, CurrentTag: "2.0.0-rc.3", PreferStableOnGraduation: true}
+	got, err = ResolveTagFrom(context.Background(), "", spec, testOptions())
+	if err != nil {
+		t.Fatalf("ResolveTag: %v", err)
+	}
+	if got != "2.0.0" {
+		t.Fatalf("got %q want graduated %q", got, "2.0.0")
+	}
+
+	// Not yet graduated: fall back to normal selection.
+	spec.CurrentTag = "2.1.0-rc.1"
+	got, err = ResolveTagFrom(context.Background(), "", spec, testOptions())
+	if err != nil {
+		t.Fatalf("ResolveTag: %v", err)
+	}
+	if got != "2.1.0-rc.1" {
+		t.Fatalf("got %q want %q", got, "2.1.0-rc.1")
+	}
+}
+
+func TestResolveTag_SelectExpr(t *testing.T) {
+	repo := newTestRegistry(t) + "/org/app"
+	now := time.Now()
+	for tag, age := range map[string]time.Duration{
+		"1.0.0": 30 * 24 * time.Hour,
+		"1.1.0": 10 * 24 * time.Hour,
+		"1.2.0": 24 * time.Hour,
+		"2.0.0": 30 * 24 * time.Hour,
+	} {
+		pushImageCreated(t, repo, tag, now.Add(-age))
+	}
+
+	spec := TagSpec{Image: repo, Strategy: "semver", SelectExpr: "major == 1 && age > 7d"}
+	got, err := ResolveTagFrom(context.Background(), "", spec, testOptions())
+	if err != nil {
+		t.Fatalf("ResolveTag: %v", err)
+	}
+	if got != "1.1.0" {
+		t.Fatalf("got %q want %q", got, "1.1.0")
+	}
+}
+
+func TestPickExprTag_FiltersFirstAndStopsAtFirstMatch(t *testing.T) {
+	now := time.Now()
+	ages := map[string]time.Duration{"1.0.0": 30 * 24 * time.Hour, "1.1.0": 10 * 24 * time.Hour, "1.2.0": time.Hour}
+	var looked []string
+	created := func(tag string) (time.Time, error) {
+		looked = append(looked, tag)
+		return now.Add(-ages[tag]), nil
+	}
+	tags := []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0-rc.1", "2.0.0"}
+	got, err := pickExprTag(context.Background(), tags, "age > 7d", "", "<2.0.0", false, "", created)
+	if err != nil {
+		t.Fatalf("pickExprTag: %v", err)
+	}
+	if got != "1.1.0" {
+		t.Fatalf("got %q want %q", got, "1.1.0")
+	}
+	// 2.0.0 fails the constraint and 1.3.0-rc.1 is a prerelease, so neither is looked up;
+	// 1.0.0 is never reached.
+	if want := []string{"1.2.0", "1.1.0"}; !slices.Equal(looked, want) {
+		t.Fatalf("looked up %v, want %v", looked, want)
+	}
+}
+
+func TestPickExprTag_LooksUpAgesWithoutCap(t *testing.T) {
+	// The ten newest tags are recent; the lookups continue until an old enough one is found.
+	now := time.Now()
+	var tags []string
+	for i := 0; i <= 10; i++ {
+		tags = append(tags, fmt.Sprintf("1.%d.0", i))
+	}
+	lookups := 0
+	created := func(tag string) (time.Time, error) {
+		lookups++
+		if tag == "1.0.0" {
+			return now.Add(-30 * 24 * time.Hour), nil
+		}
+		return now, nil
+	}
+	got, err := pickExprTag(context.Background(), tags, "age > 7d", "", "", false, "", created)
+	if err != nil {
+		t.Fatalf("pickExprTag: %v", err)
+	}
+	if got != "1.0.0" || lookups != len(tags) {
+		t.Fatalf("got %q after %d lookups, want 1.0.0 after %d", got, lookups, len(tags))
+	}
+}
+
+func TestPickExprTag_Order(t *testing.T) {
+	now := time.Now()
+	ages := map[string]time.Duration{"1.0.0": 30 * 24 * time.Hour, "1.1.0": 10 * 24 * time.Hour, "1.2.0": time.Hour, "2.0.0": 2 * time.Hour}
+	lookups := map[string]int{}
+	created := func(tag string) (time.Time, error) {
+		lookups[tag]++
+		return now.Add(-ages[tag]), nil
+	}
+	tags := []string{"1.0.0", "1.1.0", "1.2.0", "2.0.0"}
+	for _, tc := range []struct{ expr, order, want string }{
+		// The oldest matching build, not the highest version.
+		{"major == 1", "age", "1.0.0"},
+		// The most recent build: 1.2.0 beats the higher 2.0.0.
+		{"true", "-age", "1.2.0"},
+		// Ties go to the higher version.
+		{"true", "major", "2.0.0"},
+		{"major == 1", "minor", "1.2.0"},
+	} {
+		clear(lookups)
+		got, err := pickExprTag(context.Background(), tags, tc.expr, tc.order, "", false, "", created)
+		if err != nil {
+			t.Fatalf("%s / %s: %v", tc.expr, tc.order, err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s / %s: got %q want %q", tc.expr, tc.order, got, tc.want)
+		}
+		for tag, n := range lookups {
+			if n > 1 {
+				t.Fatalf("%s / %s: looked up %s %d times", tc.expr, tc.order, tag, n)
+			}
+		}
+	}
+}
+
+func TestPickExprTag_KeepsPrefixStyle(t *testing.T) {
+	got, err := pickExprTag(context.Background(), []string{"1.2.0", "v1.2.0", "1.3.0"}, "minor == 2", "", "", false, "v1.1.0", nil)
+	if err != nil {
+		t.Fatalf("pickExprTag: %v", err)
+	}
+	if got != "v1.2.0" {
+		t.Fatalf("got %q want %q", got, "v1.2.0")
+	}
+}
+
+func TestResolveTag_MinAge(t *testing.T) {
+	repo := newTestRegistry(t) + "/org/app"
+	now := time.Now()
+	for tag, age := range map[string]time.Duration{
+		"1.0.0": 30 * 24 * time.Hour,
+		"1.1.0": 72 * time.Hour,
+		"1.2.0": time.Hour,
+	} {
+		pushImageCreated(t, repo, tag, now.Add(-age))
+	}
+
+	spec := TagSpec{Image: repo, Strategy: "semver", MinAge: 48 * time.Hour}
+	got, err := ResolveTagFrom(context.Background(), "", spec, testOptions())
+	if err != nil {
+		t.Fatalf("ResolveTag: %v", err)
+	}
+	if got != "1.1.0" {
+		t.Fatalf("got %q want %q", got, "1.1.0")
+	}
+
+	spec.MinAge = 60 * 24 * time.Hour
+	if _, err := ResolveTagFrom(context.Background(), "", spec, testOptions()); err == nil {
+		t.Fatalf("expected an error when every candidate is too new")
+	}
+}
+
+func TestResolveTag_MinAgeChecksOnlyTopCandidates(t *testing.T) {
+	repo := newTestRegistry(t) + "/org/app"
+	now := time.Now()
+	pushImageCreated(t, repo, "1.0.0", now.Add(-30*24*time.Hour))
+	for i := 1; i <= maxMinAgeChecks; i++ {
+		pushImageCreated(t, repo, fmt.Sprintf("1.%d.0", i), now)
+	}
+
+	spec := TagSpec{Image: repo, Strategy: "semver", MinAge: 24 * time.Hour}
+	_, err := ResolveTagFrom(context.Background(), "", spec, testOptions())
+	if err == nil || !strings.Contains(err.Error(), "none of the 5 best candidates") {
+		t.Fatalf("expected the lookup to stop after %d candidates, got %v", maxMinAgeChecks, err)
+	}
+}
+
+func TestResolveTag_ChannelAndSuffix(t *testing.T) {
+	repo := newTestRegistry(t) + "/org/postgres"
+	for _, tag := range []string{"15.8", "15.8-alpine", "16", "16.3", "16.4", "16.3-alpine", "16.4-alpine", "16-bookworm", "16.4-bookworm", "160.1", "17.0-alpine"} {
+		pushImage(t, repo, tag, nil)
+	}
+
+	for _, tc := range []struct {
+		name, channel, suffix, current, want string
+	}{
+		{name: "channel only", channel: "16", current: "16.3", want: "16.4"},
+		{name: "suffix only", suffix: "-alpine", current: "16.3-alpine", want: "17.0-alpine"},
+		{name: "channel and suffix", channel: "16", suffix: "-alpine", current: "16.3-alpine", want: "16.4-alpine"},
+		{name: "major-only tags", channel: "16", suffix: "-bookworm", current: "16-bookworm", want: "16.4-bookworm"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := TagSpec{Image: repo, Strategy: "semver", Channel: tc.channel, Suffix: tc.suffix, CurrentTag: tc.current}
+			got, err := ResolveTagFrom(context.Background(), "", spec, testOptions())
+			if err != nil {
+				t.Fatalf("ResolveTag: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %q want %q", got, tc.want)
+			}
+		})
+	}
+
+	spec := TagSpec{Image: repo, Strategy: "semver", Channel: "16", Suffix: "-slim"}
+	if _, err := ResolveTagFrom(context.Background(), "", spec, testOptions()); !errors.Is(err, ErrNoMatchingTags) {
+		t.Fatalf("expected ErrNoMatchingTags for an empty channel, got %v", err)
+	}
+}
+
+func TestResolveTag_Variant(t *testing.T) {
+	repo := newTestRegistry(t) + "/org/nginx"
+	for _, tag := range []string{"1.21.3-alpine3.19", "1.21.4", "1.21.4-alpine", "1.21.4-alpine3.9", "1.21.4-alpine3.17", "1.21.4-alpine3.18", "1.21.4-alpine-slim", "1.21.5-bookworm"} {
+		pushImage(t, repo, tag, nil)
+	}
+
+	for _, tc := range []struct {
+		name, constraint, channel, current, want string
+	}{
+		{name: "highest variant of the highest version", current: "1.21.3-alpine3.19", want: "1.21.4-alpine3.18"},
+		{name: "constraint on the leading version", constraint: "<1.21.4", current: "1.21.3-alpine3.19", want: "1.21.3-alpine3.19"},
+		{name: "channel on the leading version", channel: "1.21.3", want: "1.21.3-alpine3.19"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := TagSpec{Image: repo, Strategy: "semver", Constraint: tc.constraint, Variant: "alpine", Channel: tc.channel, CurrentTag: tc.current}
+			got, err := ResolveTagFrom(context.Background(), "", spec, testOptions())
+			if err != nil {
+				t.Fatalf("ResolveTag: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("got %q want %q", got, tc.want)
+			}
+		})
+	}
+
+	spec := TagSpec{Image: repo, Strategy: "semver", Variant: "debian"}
+	if _, err := ResolveTagFrom(context.Background(), "", spec, testOptions()); !errors.Is(err, ErrNoMatchingTags) {
+		t.Fatalf("expected ErrNoMatchingTags for a missing variant, got %v", err)
+	}
+}
+
+func TestCompareVariantNumbers(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"3.18", "3.17", 1},
+		{"3.18", "3.9", 1},
+		{"3.10", "3.10", 0},
+		{"4", "3.19", 1},
+		{"3.18.1", "3.18", 1},
+		{"", "3.18", -1},
+		{"03.2", "3.10", -1},
+	} {
+		if got := compareVariantNumbers(c.a, c.b); got != c.want {
+			t.Fatalf("compareVariantNumbers(%q, %q) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func pushImageCreated(t *testing.T, repo, tag string, created time.Time) {
+	t.Helper()
+	img, err := random.Image(64, 1)
+	if err != nil {
+		t.Fatalf("random.Image: %v", err)
+	}
+	img, err = mutate.CreatedAt(img, v1.Time{Time: created})
+	if err != nil {
+		t.Fatalf("mutate.CreatedAt: %v", err)
+	}
+	ref, err := name.ParseReference(repo + ":" + tag)
+	if err != nil {
+		t.Fatalf("ParseReference: %v", err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("remote.Write: %v", err)
+	}
+}
+
+func TestResolveTag_TagCache(t *testing.T) {
+	host := newTestRegistry(t)
+	repo := host + "/org/app"
+	pushImage(t, repo, "1.2.3", nil)
+	pushImage(t, repo, "1.3.0", nil)
+
+	var lists int
+	opts := testOptions()
+	opts.TagCache = NewTagListCache()
+	opts.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(req.URL.Path, "/tags/list") {
+			lists++
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})
+	for _, c := range []struct{ constraint, want string }{{"", "1.3.0"}, {"~1.2.0", "1.2.3"}} {
+		got, err := ResolveTag(context.Background(), repo, "semver", c.constraint, "", false, opts)
+		if err != nil {
+			t.Fatalf("ResolveTag(%q): %v", c.constraint, err)
+		}
+		if got != c.want {
+			t.Fatalf("ResolveTag(%q) got %q want %q", c.constraint, got, c.want)
+		}
+	}
+	if lists != 1 {
+		t.Fatalf("tag list requested %d times, want 1", lists)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestResolveTag_KeepsVPrefixStyle(t *testing.T) {
+	repo := newTestRegistry(t) + "/org/app"
+	for _, tag := range []string{"1.2.3", "v1.2.3", "1.2.4", "v1.2.4"} {
+		pushImage(t, repo, tag, nil)
+	}
+	for current, want := range map[string]string{"v1.2.3": "v1.2.4", "1.2.3": "1.2.4", "": "1.2.4"} {
+		spec := TagSpec{Image: repo, Strategy: "semver", CurrentTag: current}
+		got, err := ResolveTagFrom(context.Background(), "", spec, testOptions())
+		if err != nil {
+			t.Fatalf("ResolveTag(current=%q): %v", current, err)
+		}
+		if got != want {
+			t.Fatalf("ResolveTag(current=%q) got %q want %q", current, got, want)
+		}
+	}
+}
+
+// newPaginatingRegistry serves pages of tags for org/app, linking each page to the next with
+// a Link header and a last= query parameter.
+func newPaginatingRegistry(t *testing.T, pages [][]string) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/" {
+			return
+		}
+		if r.URL.Path != "/v2/org/app/tags/list" {
+			http.NotFound(w, r)
+			return
+		}
+		i := 0
+		if last := r.URL.Query().Get("last"); last != "" {
+			for i < len(pages) && pages[i][len(pages[i])-1] != last {
+				i++
+			}
+			i++
+		}
+		if i >= len(pages) {
+			http.NotFound(w, r)
+			return
+		}
+		if i+1 < len(pages) {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/org/app/tags/list?last=%s&n=2>; rel="next"`, pages[i][len(pages[i])-1]))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"name": "org/app", "tags": pages[i]})
+	}))
+	t.Cleanup(srv.Close)
+	return strings.TrimPrefix(srv.URL, "http://")
+}
+
+func TestResolveTag_FollowsTagListPages(t *testing.T) {
+	repo := newPaginatingRegistry(t, [][]string{{"1.0.0", "1.1.0"}, {"1.2.0", "1.3.0"}, {"1.4.0", "latest"}}) + "/org/app"
+
+	got, err := ResolveTag(context.Background(), repo, "semver", "", "", false, testOptions())
+	if err != nil {
+		t.Fatalf("ResolveTag: %v", err)
+	}
+	if got != "1.4.0" {
+		t.Fatalf("got %q want 1.4.0 from the last page", got)
+	}
+
+	opts := testOptions()
+	opts.MaxTagPages = 2
+	core, logs := observer.New(zapcore.WarnLevel)
+	ctx := logutil.WithLogger(context.Background(), zap.New(core))
+	got, err = ResolveTag(ctx, repo, "semver", "", "", false, opts)
+	if err != nil {
+		t.Fatalf("ResolveTag(MaxTagPages=2): %v", err)
+	}
+	if got != "1.3.0" {
+		t.Fatalf("MaxTagPages=2: got %q want 1.3.0", got)
+	}
+	if logs.FilterMessage("tag list truncated; newer tags may be missed").Len() != 1 {
+		t.Fatalf("expected a truncation warning, got %v", logs.All())
+	}
+}
+
+func TestResolveExactTag(t *testing.T) {
+	repo := newTestRegistry(t) + "/org/app"
+	pushImage(t, repo, "1.2.3", nil)
+	pushImage(t, repo, "1.2.4", nil)
+
+	got, err := ResolveExactTag(context.Background(), repo, "1.2.3", testOptions())
+	if err != nil {
+		t.Fatalf("ResolveExactTag: %v", err)
+	}
+	if got != "1.2.3" {
+		t.Fatalf("got %q want 1.2.3", got)
+	}
+	if _, err := ResolveExactTag(context.Background(), repo, "1.2.5", testOptions()); !errors.Is(err, ErrTagNotFound) {
+		t.Fatalf("expected ErrTagNotFound, got %v", err)
+	}
+}
+
+func TestResolveTagAndDigest(t *testing.T) {
+	host, manifests := newCountingRegistry(t)
+	repo := host + "/org/app"
+	pushImage(t, repo, "1.2.3", nil)
+	pushImage(t, repo, "1.3.0", nil)
+	ref, err := name.ParseReference(repo + ":1.3.0")
+	if err != nil {
+		t.Fatalf("ParseReference: %v", err)
+	}
+	want, err := remote.Head(ref)
+	if err != nil {
+		t.Fatalf("remote.Head: %v", err)
+	}
+
+	before := manifests.Load()
+	tag, digest, err := ResolveTagAndDigest(context.Background(), "", TagSpec{Image: repo, Strategy: "semver"}, "", testOptions())
+	if err != nil {
+		t.Fatalf("ResolveTagAndDigest: %v", err)
+	}
+	if tag != "1.3.0" || digest != want.Digest.String() {
+		t.Fatalf("got %s@%s want 1.3.0@%s", tag, digest, want.Digest)
+	}
+	if got := manifests.Load() - before; got != 1 {
+		t.Fatalf("expected one manifest request, got %d", got)
+	}
+}
@@ -0,0 +1,33 @@
+package imageresolver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseMirror parses from=to (e.g. docker.io=registry.internal/dockerhub), a registry or
+// repository prefix and the mirror to contact in its place.
+func ParseMirror(spec string) (from, to string, err error) {
+	from, to, ok := strings.Cut(strings.TrimSpace(spec), "=")
+	from, to = strings.TrimSuffix(from, "/"), strings.TrimSuffix(to, "/")
+	if !ok || from == "" || to == "" {
+		return "", "", fmt.Errorf("invalid registry mirror %q, expected from=to", spec)
+	}
+	return from, to, nil
+}
+
+// mirrored returns imageRepo with the longest matching Options.Mirrors prefix replaced by its
+// mirror. A prefix matches the whole repository or a leading run of its path segments, so
+// docker.io matches docker.io/library/postgres but not docker.iox/app.
+func (o *Options) mirrored(imageRepo string) string {
+	best := ""
+	for from := range o.Mirrors {
+		if len(from) > len(best) && (imageRepo == from || strings.HasPrefix(imageRepo, from+"/")) {
+			best = from
+		}
+	}
+	if best == "" {
+		return imageRepo
+	}
+	return o.Mirrors[best] + strings.TrimPrefix(imageRepo, best)
+}
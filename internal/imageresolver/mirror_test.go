@@ -0,0 +1,58 @@
+package imageresolver
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseMirror(t *testing.T) {
+	from, to, err := ParseMirror(" docker.io=registry.internal/dockerhub/ ")
+	if err != nil || from != "docker.io" || to != "registry.internal/dockerhub" {
+		t.Fatalf("ParseMirror got %q, %q, %v", from, to, err)
+	}
+	for _, spec := range []string{"docker.io", "=registry.internal", "docker.io="} {
+		if _, _, err := ParseMirror(spec); err == nil {
+			t.Fatalf("ParseMirror(%q): expected an error", spec)
+		}
+	}
+}
+
+func TestMirrored(t *testing.T) {
+	o := &Options{Mirrors: map[string]string{
+		"docker.io":            "mirror.internal/dockerhub",
+		"docker.io/bitnami":    "mirror.internal/bitnami",
+		"ghcr.io/org/app":      "mirror.internal/app",
+		"quay.io/prometheus/x": "mirror.internal/x",
+	}}
+	for in, want := range map[string]string{
+		"docker.io/library/postgres": "mirror.internal/dockerhub/library/postgres",
+		"docker.io/bitnami/redis":    "mirror.internal/bitnami/redis",
+		"ghcr.io/org/app":            "mirror.internal/app",
+		"ghcr.io/org/application":    "ghcr.io/org/application",
+		"docker.iox/app":             "docker.iox/app",
+	} {
+		if got := o.mirrored(in); got != want {
+			t.Fatalf("mirrored(%q) got %q want %q", in, got, want)
+		}
+	}
+}
+
+func TestResolveThroughMirror(t *testing.T) {
+	mirror := newTestRegistry(t) + "/dockerhub"
+	pushImage(t, mirror+"/org/app", "1.2.3", map[string]string{"version": "1.2.3"})
+	pushImage(t, mirror+"/org/app", "1.3.0", nil)
+
+	// The canonical registry does not exist, so every lookup must go through the mirror.
+	opts := testOptions()
+	opts.Mirrors = map[string]string{"registry.invalid": mirror}
+	tag, err := ResolveTag(context.Background(), "registry.invalid/org/app", "semver", "", "", false, opts)
+	if err != nil || tag != "1.3.0" {
+		t.Fatalf("ResolveTag got %q, %v want 1.3.0", tag, err)
+	}
+	if _, err := ResolveDigest(context.Background(), "registry.invalid/org/app", tag, "", opts); err != nil {
+		t.Fatalf("ResolveDigest: %v", err)
+	}
+	if v, err := ResolveLabel(context.Background(), "registry.invalid/org/app", "1.2.3", "version", "", opts); err != nil || v != "1.2.3" {
+		t.Fatalf("ResolveLabel got %q, %v", v, err)
+	}
+}
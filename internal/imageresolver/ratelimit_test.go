@@ -0,0 +1,37 @@
+package imageresolver
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(1, 1)
+	now := time.Unix(0, 0)
+	l.now = func() time.Time { return now }
+	l.sleep = func(_ context.Context, d time.Duration) error {
+		now = now.Add(d)
+		return nil
+	}
+
+	const n = 5
+	start := now
+	for i := 0; i < n; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	// The first call uses the initial token; each later one waits a second.
+	if got, want := now.Sub(start), (n-1)*time.Second; got < want {
+		t.Fatalf("%d calls took %v, want at least %v", n, got, want)
+	}
+
+	if NewRateLimiter(0, 1) != nil {
+		t.Fatalf("expected no limiter for rps <= 0")
+	}
+	var unlimited *RateLimiter
+	if err := unlimited.Wait(context.Background()); err != nil {
+		t.Fatalf("nil limiter Wait: %v", err)
+	}
+}
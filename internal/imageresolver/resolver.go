@@ -0,0 +1,32 @@
+package imageresolver
+
+// Resolver looks up tags, digests, and labels with the registry access shared by a run: its
+// keychain, transport, digest and tag-list caches, rate limiter, tag sources, mirrors, and
+// ignore list. Construct one per run with NewResolver and share it, so every lookup reuses
+// the same caches and request budget. It is safe for concurrent use.
+type Resolver struct {
+	opts Options
+}
+
+// NewResolver returns a Resolver using the registry access settings in opts. A nil Keychain
+// means DefaultKeychain.
+func NewResolver(opts Options) *Resolver {
+	if opts.Keychain == nil {
+		opts.Keychain = DefaultKeychain()
+	}
+	return &Resolver{opts: opts}
+}
+
+// DigestCache returns the Resolver's digest cache, if any, e.g. to save it after a run.
+func (r *Resolver) DigestCache() *DigestCache {
+	return r.opts.DigestCache
+}
+
+// resolverFor returns a Resolver sharing the caches and settings of opts, for the
+// package-level functions. Nil opts means the defaults.
+func resolverFor(opts *Options) *Resolver {
+	if opts == nil {
+		return &Resolver{opts: defaultOptions()}
+	}
+	return &Resolver{opts: *opts}
+}
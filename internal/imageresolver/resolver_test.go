@@ -0,0 +1,107 @@
+package imageresolver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// handlerTransport serves every request from an in-process handler, whatever its host, and
+// counts them.
+type handlerTransport struct {
+	h http.Handler
+	n atomic.Int64
+}
+
+func (t *handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n.Add(1)
+	if req.Body == nil {
+		req = req.Clone(req.Context())
+		req.Body = http.NoBody
+	}
+	rec := httptest.NewRecorder()
+	t.h.ServeHTTP(rec, req)
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+func TestResolver_FakeTransport(t *testing.T) {
+	ctx := context.Background()
+	tr := &handlerTransport{h: registry.New()}
+	repo := "registry.invalid/org/app"
+
+	digests := map[string]v1.Hash{}
+	for _, tag := range []string{"1.2.3", "1.3.0"} {
+		img, err := random.Image(64, 1)
+		if err != nil {
+			t.Fatalf("random.Image: %v", err)
+		}
+		ref, err := name.ParseReference(repo + ":" + tag)
+		if err != nil {
+			t.Fatalf("ParseReference: %v", err)
+		}
+		if err := remote.Write(ref, img, remote.WithTransport(tr)); err != nil {
+			t.Fatalf("remote.Write: %v", err)
+		}
+		if digests[tag], err = img.Digest(); err != nil {
+			t.Fatalf("Digest: %v", err)
+		}
+	}
+
+	r := NewResolver(Options{
+		Keychain:    authn.NewMultiKeychain(),
+		Transport:   tr,
+		TagCache:    NewTagListCache(),
+		DigestCache: NewDigestCache(time.Minute),
+	})
+
+	tr.n.Store(0)
+	tag, err := r.ResolveTag(ctx, TagSpec{Image: repo, Strategy: "semver"})
+	if err != nil {
+		t.Fatalf("ResolveTag: %v", err)
+	}
+	if tag != "1.3.0" {
+		t.Fatalf("ResolveTag = %q, want 1.3.0", tag)
+	}
+	dig, err := r.ResolveDigest(ctx, repo, tag, "")
+	if err != nil {
+		t.Fatalf("ResolveDigest: %v", err)
+	}
+	if dig != digests["1.3.0"].String() {
+		t.Fatalf("ResolveDigest = %q, want %q", dig, digests["1.3.0"])
+	}
+	if tr.n.Load() == 0 {
+		t.Fatalf("expected lookups to go through the injected transport")
+	}
+
+	// Repeated lookups, including through the package-level wrappers sharing the same
+	// caches, are answered without touching the registry.
+	before := tr.n.Load()
+	tag, err = r.ResolveTag(ctx, TagSpec{Image: repo, Strategy: "semver", Constraint: "~1.2.0"})
+	if err != nil {
+		t.Fatalf("ResolveTag with constraint: %v", err)
+	}
+	if tag != "1.2.3" {
+		t.Fatalf("ResolveTag with constraint = %q, want 1.2.3", tag)
+	}
+	if _, err := r.ResolveDigest(ctx, repo, "1.3.0", ""); err != nil {
+		t.Fatalf("ResolveDigest (cached): %v", err)
+	}
+	if _, err := ResolveDigest(ctx, repo, "1.3.0", "", &r.opts); err != nil {
+		t.Fatalf("ResolveDigest wrapper: %v", err)
+	}
+	if got := tr.n.Load(); got != before {
+		t.Fatalf("cached lookups made %d registry requests, want 0", got-before)
+	}
+}
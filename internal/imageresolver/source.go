@@ -0,0 +1,122 @@
+package imageresolver
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TagSpec is a tag selection request, as made by a '# bump:' directive.
+type TagSpec struct {
+	// Image is the full image repository the selected tag is for.
+	Image string
+	// Strategy is semver, regex, or literal; see ResolveTag.
+	Strategy        string
+	Constraint      string
+	TagRegex        string
+	AllowPrerelease bool
+	// CurrentTag is the value currently pinned for the image, if known. Selection rules that
+	// depend on the starting point (e.g. PreferStableOnGraduation) use it, and strategy=semver
+	// keeps its 'v' prefix style when both v1.2.4 and 1.2.4 exist.
+	CurrentTag string
+	// PreferStableOnGraduation makes strategy=semver pick the stable release of CurrentTag's
+	// version (2.0.0 for 2.0.0-rc.3) once it exists, even if a higher prerelease of a newer
+	// line is available and AllowPrerelease is set.
+	PreferStableOnGraduation bool
+	// SelectExpr, if set, filters strategy=semver candidates with a selectexpr expression over
+	// major, minor, patch, prerelease, tag, and age. age is the time since the image config's
+	// created timestamp, which the builder sets; registries do not record push times. With a
+	// tag source other than the registry, age is measured from that source's publish time.
+	// Ages are looked up lazily, once per tag.
+	SelectExpr string
+	// SelectOrder, if set with SelectExpr, ranks the matching candidates by a selectexpr
+	// expression over the same variables (e.g. -age for the most recently built); the
+	// highest key wins and ties go to the higher semver. Without it the highest semver wins.
+	SelectOrder string
+	// MinAge, if positive, skips candidates whose image was created less than MinAge ago.
+	// Creation times are looked up for at most the maxMinAgeChecks best candidates.
+	MinAge time.Duration
+	// Channel and Suffix narrow strategy=semver to one release line of an image that
+	// publishes several, like postgres' 16.4-alpine. Only tags ending in Suffix are
+	// candidates, compared with Suffix removed; Channel then keeps those equal to it or
+	// starting with Channel followed by a dot, so channel 16 matches 16 and 16.4 but not 160.
+	Channel string
+	Suffix  string
+	// Variant, e.g. alpine, narrows strategy=semver to tags like 1.21.4-alpine3.18: a version,
+	// a dash, Variant, and an optional dotted number. Candidates are compared by the leading
+	// version; among tags sharing it, the highest number after Variant wins. Channel and the
+	// other semver options apply to the leading version. It cannot be combined with Suffix.
+	Variant string
+	// IgnoreTags lists tags the source must not select. ResolveTag and ResolveTagFrom fill it
+	// from Options.IgnoreTags when unset.
+	IgnoreTags *IgnoreList
+	// Repo names the project at a non-registry source, e.g. org/proj for github-releases.
+	Repo string
+}
+
+// TagResolver selects a tag for spec from some list of versions.
+type TagResolver interface {
+	ResolveTag(ctx context.Context, spec TagSpec) (string, error)
+}
+
+// SourceRegistry is the default tag source: the image's own registry tags.
+const SourceRegistry = "registry"
+
+// SourceOCI selects the version of a Helm chart stored in an OCI registry. Chart versions are
+// the chart repository's tags, so it lists them as SourceRegistry does; the caller names the
+// chart repository as TagSpec.Image.
+const SourceOCI = "oci"
+
+// RegistryTags is the default TagResolver, which selects from the tags in the image's
+// registry.
+type RegistryTags struct {
+	Options *Options
+}
+
+// ResolveTag implements TagResolver with Resolver.ResolveTag.
+func (r RegistryTags) ResolveTag(ctx context.Context, spec TagSpec) (string, error) {
+	return resolverFor(r.Options).ResolveTag(ctx, spec)
+}
+
+// ResolveTagFrom selects a tag for spec from the named source. It is a wrapper around
+// Resolver.ResolveTagFrom.
+func ResolveTagFrom(ctx context.Context, source string, spec TagSpec, opts *Options) (string, error) {
+	return resolverFor(opts).ResolveTagFrom(ctx, source, spec)
+}
+
+// ResolveTagFrom selects a tag for spec from the named source: the registry when source is
+// empty, SourceRegistry, or SourceOCI, otherwise the Resolver's Sources[source].
+func (r *Resolver) ResolveTagFrom(ctx context.Context, source string, spec TagSpec) (string, error) {
+	if source == "" || source == SourceRegistry || source == SourceOCI {
+		return r.ResolveTag(ctx, spec)
+	}
+	s := r.opts.Sources[source]
+	if s == nil {
+		return "", fmt.Errorf("unknown tag source %q", source)
+	}
+	if spec.IgnoreTags == nil {
+		spec.IgnoreTags = r.opts.IgnoreTags
+	}
+	return s.ResolveTag(ctx, spec)
+}
+
+// ResolveTagAndDigest selects a tag and resolves its digest. It is a wrapper around
+// Resolver.ResolveTagAndDigest.
+func ResolveTagAndDigest(ctx context.Context, source string, spec TagSpec, platform string, opts *Options) (tag, digest string, err error) {
+	return resolverFor(opts).ResolveTagAndDigest(ctx, source, spec, platform)
+}
+
+// ResolveTagAndDigest selects a tag for spec as ResolveTagFrom does and returns it together
+// with the manifest digest of spec.Image at that tag, for platform if set, so callers can
+// record what the tag pointed to. The digest lookup goes through the digest cache.
+func (r *Resolver) ResolveTagAndDigest(ctx context.Context, source string, spec TagSpec, platform string) (tag, digest string, err error) {
+	tag, err = r.ResolveTagFrom(ctx, source, spec)
+	if err != nil {
+		return "", "", err
+	}
+	digest, err = r.ResolveDigest(ctx, spec.Image, tag, platform)
+	if err != nil {
+		return "", "", err
+	}
+	return tag, digest, nil
+}
@@ -0,0 +1,30 @@
+package imageresolver
+
+import (
+	"context"
+	"testing"
+)
+
+type fakeTagResolver struct{ got TagSpec }
+
+func (f *fakeTagResolver) ResolveTag(_ context.Context, spec TagSpec) (string, error) {
+	f.got = spec
+	return "9.9.9", nil
+}
+
+func TestResolveTagFrom(t *testing.T) {
+	fake := &fakeTagResolver{}
+	opts := &Options{Sources: map[string]TagResolver{"custom": fake}}
+	spec := TagSpec{Image: "ghcr.io/org/app", Strategy: "semver", Repo: "org/app", CurrentTag: "1.0.0"}
+	got, err := ResolveTagFrom(context.Background(), "custom", spec, opts)
+	if err != nil {
+		t.Fatalf("ResolveTagFrom: %v", err)
+	}
+	if got != "9.9.9" || fake.got != spec {
+		t.Fatalf("got %q with spec %#v", got, fake.got)
+	}
+
+	if _, err := ResolveTagFrom(context.Background(), "nope", spec, opts); err == nil {
+		t.Fatalf("expected an error for an unknown source")
+	}
+}
@@ -0,0 +1,48 @@
+package imageresolver
+
+import "sync"
+
+// TagListCache remembers each repository's tag list for the lifetime of a run, so several
+// directives on the same image share one registry call.
+//
+// Concurrent lookups of the same repository wait for the first call rather than issuing
+// their own. Failures are cached too: a repository that could not be listed once is not
+// retried within the run. A TagListCache is safe for concurrent use.
+type TagListCache struct {
+	mu      sync.Mutex
+	entries map[string]*tagListEntry
+}
+
+type tagListEntry struct {
+	done chan struct{}
+	tags []string
+	err  error
+}
+
+// NewTagListCache returns an empty cache.
+func NewTagListCache() *TagListCache {
+	return &TagListCache{entries: map[string]*tagListEntry{}}
+}
+
+// get returns the cached tags for repo, calling list to fill the entry on first use. Callers
+// receive their own copy of the slice.
+func (c *TagListCache) get(repo string, list func() ([]string, error)) ([]string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[repo]
+	if !ok {
+		e = &tagListEntry{done: make(chan struct{})}
+		c.entries[repo] = e
+	}
+	c.mu.Unlock()
+
+	if ok {
+		<-e.done
+	} else {
+		e.tags, e.err = list()
+		close(e.done)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return append([]string(nil), e.tags...), nil
+}
@@ -8,6 +8,15 @@ import (
 
 type ctxKey struct{}
 
+// Lifecycle event names emitted via Event. They are part of the --emit-events contract;
+// do not rename them.
+const (
+	EventDirectiveDiscovered = "directive_discovered"
+	EventTagsListed          = "tags_listed"
+	EventCandidateSelected   = "candidate_selected"
+	EventValueWritten        = "value_written"
+)
+
 // WithLogger returns a new context with the provided logger attached.
 func WithLogger(ctx context.Context, log *zap.Logger) context.Context {
 	if ctx == nil {
@@ -33,3 +42,70 @@ func FromContext(ctx context.Context) *zap.Logger {
 	}
 	return zap.NewNop()
 }
+
+type eventsKey struct{}
+
+// WithEvents returns a new context in which Event emits lifecycle events.
+func WithEvents(ctx context.Context, enabled bool) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, eventsKey{}, enabled)
+}
+
+// EventsEnabled reports whether lifecycle events are enabled in ctx.
+func EventsEnabled(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	v, _ := ctx.Value(eventsKey{}).(bool)
+	return v
+}
+
+// Event logs a lifecycle event as a distinct info entry with a stable "event" field,
+// when events are enabled in ctx (see WithEvents). It is a no-op otherwise.
+func Event(ctx context.Context, name string, fields ...zap.Field) {
+	if !EventsEnabled(ctx) {
+		return
+	}
+	FromContext(ctx).Info(name, append([]zap.Field{zap.String("event", name)}, fields...)...)
+}
+
+// Verbosity thresholds for -v. From DetailVerbosity, Detail entries (what each directive and
+// dependency resolved to) are logged at info; from DebugVerbosity everything is logged at
+// debug; from TraceVerbosity entries also carry their caller and warnings a stack trace.
+const (
+	DetailVerbosity = 1
+	DebugVerbosity  = 3
+	TraceVerbosity  = 6
+)
+
+type verbosityKey struct{}
+
+// WithVerbosity returns a new context carrying the -v verbosity v.
+func WithVerbosity(ctx context.Context, v int) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, verbosityKey{}, v)
+}
+
+// Verbosity returns the verbosity set in ctx by WithVerbosity, or 0.
+func Verbosity(ctx context.Context) int {
+	if ctx == nil {
+		return 0
+	}
+	v, _ := ctx.Value(verbosityKey{}).(int)
+	return v
+}
+
+// Detail logs a resolution detail to log: at info when ctx's verbosity is at least
+// DetailVerbosity, and at debug otherwise, so callers without a verbosity keep seeing it in
+// their debug logs.
+func Detail(ctx context.Context, log *zap.Logger, msg string, fields ...zap.Field) {
+	if Verbosity(ctx) >= DetailVerbosity {
+		log.Info(msg, fields...)
+		return
+	}
+	log.Debug(msg, fields...)
+}
@@ -0,0 +1,95 @@
+package ocichart
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/joejulian/helm-chart-bumper-action/internal/logutil"
+
+	"go.uber.org/zap"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ChartLayerMediaType is the media type Helm uses for the chart tarball layer of an OCI artifact.
+const ChartLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+
+// ReadChartYAML pulls the Helm chart artifact at ref (e.g. oci://ghcr.io/org/charts/app:1.2.3)
+// and returns the chart's top-level Chart.yaml.
+//
+// If keychain is nil, authn.DefaultKeychain is used.
+func ReadChartYAML(ctx context.Context, ref string, keychain authn.Keychain) ([]byte, error) {
+	log := logutil.FromContext(ctx).With(zap.String("func", "ocichart.ReadChartYAML"), zap.String("ref", ref))
+	if keychain == nil {
+		keychain = authn.DefaultKeychain
+	}
+
+	r, err := name.ParseReference(strings.TrimPrefix(ref, "oci://"))
+	if err != nil {
+		return nil, fmt.Errorf("parse OCI chart reference %q: %w", ref, err)
+	}
+	log.Debug("fetching chart artifact", zap.String("parsed", r.String()))
+
+	img, err := remote.Image(r, remote.WithAuthFromKeychain(keychain), remote.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("fetch OCI chart %q: %w", ref, err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("list layers of %q: %w", ref, err)
+	}
+	for _, l := range layers {
+		mt, err := l.MediaType()
+		if err != nil {
+			return nil, err
+		}
+		if string(mt) != ChartLayerMediaType {
+			continue
+		}
+		rc, err := l.Compressed()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		b, err := chartYAMLFromTarball(rc)
+		if err != nil {
+			return nil, fmt.Errorf("read chart tarball of %q: %w", ref, err)
+		}
+		log.Debug("read Chart.yaml from artifact", zap.Int("len", len(b)))
+		return b, nil
+	}
+	return nil, fmt.Errorf("OCI artifact %q has no %s layer", ref, ChartLayerMediaType)
+}
+
+// chartYAMLFromTarball returns <chart>/Chart.yaml from a gzipped chart tarball.
+func chartYAMLFromTarball(r io.Reader) ([]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("Chart.yaml not found in chart tarball")
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Only the top-level chart; subcharts live under <chart>/charts/.
+		p := path.Clean(strings.TrimPrefix(h.Name, "./"))
+		if path.Base(p) == "Chart.yaml" && path.Dir(path.Dir(p)) == "." {
+			return io.ReadAll(tr)
+		}
+	}
+}
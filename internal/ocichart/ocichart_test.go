@@ -0,0 +1,76 @@
+package ocichart
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+func chartTarball(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for n, c := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: n, Mode: 0o644, Size: int64(len(c))}); err != nil {
+			t.Fatalf("WriteHeader: %v", err)
+		}
+		if _, err := tw.Write([]byte(c)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar Close: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadChartYAML(t *testing.T) {
+	srv := httptest.NewServer(registry.New())
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	want := "apiVersion: v2\nname: app\nversion: 1.2.3\nappVersion: 4.5.6\n"
+	tgz := chartTarball(t, map[string]string{
+		"app/Chart.yaml":              want,
+		"app/charts/redis/Chart.yaml": "apiVersion: v2\nname: redis\nversion: 9.9.9\n",
+	})
+	img, err := mutate.AppendLayers(empty.Image, static.NewLayer(tgz, types.MediaType(ChartLayerMediaType)))
+	if err != nil {
+		t.Fatalf("AppendLayers: %v", err)
+	}
+	img = mutate.MediaType(img, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, "application/vnd.cncf.helm.config.v1+json")
+
+	ref, err := name.ParseReference(host + "/charts/app:1.2.3")
+	if err != nil {
+		t.Fatalf("ParseReference: %v", err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("remote.Write: %v", err)
+	}
+
+	got, err := ReadChartYAML(context.Background(), "oci://"+host+"/charts/app:1.2.3", authn.NewMultiKeychain())
+	if err != nil {
+		t.Fatalf("ReadChartYAML: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("got %q want %q", string(got), want)
+	}
+}
@@ -0,0 +1,523 @@
+// Package selectexpr implements the small expression language used by the `selectExpr`
+// directive field to filter tag candidates and by `selectOrder` to rank them.
+//
+// Grammar:
+//
+//	expr    := and ('||' and)*
+//	and     := unary ('&&' unary)*
+//	unary   := '!' unary | compare
+//	compare := operand (('==' | '!=' | '<' | '<=' | '>' | '>=') operand)?
+//	operand := '-' operand | '(' expr ')' | ident | number | duration | string | 'true' | 'false'
+//
+// Durations are a number followed by s, m, h, d (days), or w (weeks), e.g. 7d.
+// Strings use single or double quotes. Variables are resolved lazily, so with
+// `major == 1 && age > 7d` the age of a candidate is only looked up when major is 1.
+package selectexpr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is a compiled expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Vars resolves a variable by name. Supported value types are int, float64, string, bool,
+// and time.Duration.
+type Vars func(name string) (any, error)
+
+// Compile parses src.
+func Compile(src string) (*Expr, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", src, err)
+	}
+	p := &parser{toks: toks}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("expression %q: %w", src, err)
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("expression %q: unexpected %q", src, p.peek().text)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the source expression.
+func (e *Expr) String() string { return e.src }
+
+// Uses reports whether the expression references the variable name.
+func (e *Expr) Uses(name string) bool { return e.root.uses(name) }
+
+// Eval evaluates the expression against vars. It must produce a bool.
+func (e *Expr) Eval(vars Vars) (bool, error) {
+	v, err := e.root.eval(vars)
+	if err != nil {
+		return false, fmt.Errorf("expression %q: %w", e.src, err)
+	}
+	if v.kind != kindBool {
+		return false, fmt.Errorf("expression %q: result is a %s, not a bool", e.src, v.kind)
+	}
+	return v.b, nil
+}
+
+// Key is an ordering key computed by EvalKey.
+type Key struct{ v value }
+
+// EvalKey evaluates the expression against vars as an ordering key. It must produce a number,
+// duration, or string.
+func (e *Expr) EvalKey(vars Vars) (Key, error) {
+	v, err := e.root.eval(vars)
+	if err != nil {
+		return Key{}, fmt.Errorf("expression %q: %w", e.src, err)
+	}
+	if v.kind == kindBool {
+		return Key{}, fmt.Errorf("expression %q: result is a bool, not a number, duration, or string", e.src)
+	}
+	return Key{v: v}, nil
+}
+
+// Compare returns -1, 0, or 1 as k sorts before, with, or after o. Keys of different kinds
+// sort by kind name.
+func (k Key) Compare(o Key) int {
+	if k.v.kind != o.v.kind {
+		return strings.Compare(string(k.v.kind), string(o.v.kind))
+	}
+	switch k.v.kind {
+	case kindNumber:
+		return cmp3(k.v.n < o.v.n, k.v.n > o.v.n)
+	case kindDuration:
+		return cmp3(k.v.d < o.v.d, k.v.d > o.v.d)
+	default:
+		return strings.Compare(k.v.s, o.v.s)
+	}
+}
+
+// --- values ---
+
+type kind string
+
+const (
+	kindBool     kind = "bool"
+	kindNumber   kind = "number"
+	kindString   kind = "string"
+	kindDuration kind = "duration"
+)
+
+type value struct {
+	kind kind
+	b    bool
+	n    float64
+	s    string
+	d    time.Duration
+}
+
+func fromAny(name string, x any) (value, error) {
+	switch v := x.(type) {
+	case bool:
+		return value{kind: kindBool, b: v}, nil
+	case int:
+		return value{kind: kindNumber, n: float64(v)}, nil
+	case uint64:
+		return value{kind: kindNumber, n: float64(v)}, nil
+	case float64:
+		return value{kind: kindNumber, n: v}, nil
+	case string:
+		return value{kind: kindString, s: v}, nil
+	case time.Duration:
+		return value{kind: kindDuration, d: v}, nil
+	default:
+		return value{}, fmt.Errorf("variable %q has unsupported type %T", name, x)
+	}
+}
+
+// --- AST ---
+
+type node interface {
+	eval(Vars) (value, error)
+	uses(name string) bool
+}
+
+type literal struct{ v value }
+
+func (l literal) eval(Vars) (value, error) { return l.v, nil }
+func (l literal) uses(string) bool         { return false }
+
+type ident struct{ name string }
+
+func (i ident) eval(vars Vars) (value, error) {
+	if vars == nil {
+		return value{}, fmt.Errorf("unknown variable %q", i.name)
+	}
+	x, err := vars(i.name)
+	if err != nil {
+		return value{}, err
+	}
+	return fromAny(i.name, x)
+}
+func (i ident) uses(name string) bool { return i.name == name }
+
+type not struct{ x node }
+
+func (n not) eval(vars Vars) (value, error) {
+	v, err := n.x.eval(vars)
+	if err != nil {
+		return value{}, err
+	}
+	if v.kind != kindBool {
+		return value{}, fmt.Errorf("'!' needs a bool, got %s", v.kind)
+	}
+	return value{kind: kindBool, b: !v.b}, nil
+}
+func (n not) uses(name string) bool { return n.x.uses(name) }
+
+type neg struct{ x node }
+
+func (n neg) eval(vars Vars) (value, error) {
+	v, err := n.x.eval(vars)
+	if err != nil {
+		return value{}, err
+	}
+	switch v.kind {
+	case kindNumber:
+		return value{kind: kindNumber, n: -v.n}, nil
+	case kindDuration:
+		return value{kind: kindDuration, d: -v.d}, nil
+	}
+	return value{}, fmt.Errorf("'-' needs a number or duration, got %s", v.kind)
+}
+func (n neg) uses(name string) bool { return n.x.uses(name) }
+
+type logical struct {
+	op   string
+	l, r node
+}
+
+func (n logical) eval(vars Vars) (value, error) {
+	l, err := n.l.eval(vars)
+	if err != nil {
+		return value{}, err
+	}
+	if l.kind != kindBool {
+		return value{}, fmt.Errorf("%q needs bools, got %s", n.op, l.kind)
+	}
+	// Short-circuit so expensive variables are only resolved when needed.
+	if (n.op == "&&" && !l.b) || (n.op == "||" && l.b) {
+		return l, nil
+	}
+	r, err := n.r.eval(vars)
+	if err != nil {
+		return value{}, err
+	}
+	if r.kind != kindBool {
+		return value{}, fmt.Errorf("%q needs bools, got %s", n.op, r.kind)
+	}
+	return r, nil
+}
+func (n logical) uses(name string) bool { return n.l.uses(name) || n.r.uses(name) }
+
+type compare struct {
+	op   string
+	l, r node
+}
+
+func (n compare) eval(vars Vars) (value, error) {
+	l, err := n.l.eval(vars)
+	if err != nil {
+		return value{}, err
+	}
+	r, err := n.r.eval(vars)
+	if err != nil {
+		return value{}, err
+	}
+	if l.kind != r.kind {
+		return value{}, fmt.Errorf("cannot compare %s %s %s", l.kind, n.op, r.kind)
+	}
+	var c int
+	switch l.kind {
+	case kindNumber:
+		c = cmp3(l.n < r.n, l.n > r.n)
+	case kindDuration:
+		c = cmp3(l.d < r.d, l.d > r.d)
+	case kindString:
+		c = strings.Compare(l.s, r.s)
+	case kindBool:
+		if n.op != "==" && n.op != "!=" {
+			return value{}, fmt.Errorf("bools only support == and !=")
+		}
+		c = cmp3(false, l.b != r.b)
+	}
+	var b bool
+	switch n.op {
+	case "==":
+		b = c == 0
+	case "!=":
+		b = c != 0
+	case "<":
+		b = c < 0
+	case "<=":
+		b = c <= 0
+	case ">":
+		b = c > 0
+	case ">=":
+		b = c >= 0
+	}
+	return value{kind: kindBool, b: b}, nil
+}
+func (n compare) uses(name string) bool { return n.l.uses(name) || n.r.uses(name) }
+
+func cmp3(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// --- lexer ---
+
+type token struct {
+	kind string // "ident", "number", "duration", "string", "op", "("/")"
+	text string
+}
+
+func lex(src string) ([]token, error) {
+	var out []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(' || c == ')':
+			out = append(out, token{kind: string(c), text: string(c)})
+			i++
+		case c == '-':
+			out = append(out, token{kind: "op", text: "-"})
+			i++
+		case strings.ContainsRune("=!<>&|", rune(c)):
+			op := string(c)
+			if i+1 < len(src) {
+				two := src[i : i+2]
+				switch two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					op = two
+				}
+			}
+			if op == "=" || op == "&" || op == "|" {
+				return nil, fmt.Errorf("unknown operator %q at %d", op, i)
+			}
+			out = append(out, token{kind: "op", text: op})
+			i += len(op)
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(src[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			out = append(out, token{kind: "string", text: src[i+1 : i+1+end]})
+			i += end + 2
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			if j < len(src) && strings.ContainsRune("smhdw", rune(src[j])) {
+				out = append(out, token{kind: "duration", text: src[i : j+1]})
+				j++
+			} else {
+				out = append(out, token{kind: "number", text: src[i:j]})
+			}
+			i = j
+		case isIdentByte(c):
+			j := i
+			for j < len(src) && (isIdentByte(src[j]) || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			out = append(out, token{kind: "ident", text: src[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q at %d", c, i)
+		}
+	}
+	return out, nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// ParseDuration parses a duration in the expression syntax: a non-negative number followed by
+// s, m, h, d (days), or w (weeks), e.g. 48h or 7d.
+func ParseDuration(s string) (time.Duration, error) {
+	if len(s) < 2 || s[0] < '0' || s[0] > '9' || !strings.ContainsRune("smhdw", rune(s[len(s)-1])) {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	unit := s[len(s)-1]
+	n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	var base time.Duration
+	switch unit {
+	case 's':
+		base = time.Second
+	case 'm':
+		base = time.Minute
+	case 'h':
+		base = time.Hour
+	case 'd':
+		base = 24 * time.Hour
+	case 'w':
+		base = 7 * 24 * time.Hour
+	}
+	return time.Duration(n * float64(base)), nil
+}
+
+// --- parser ---
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) done() bool { return p.pos >= len(p.toks) }
+
+func (p *parser) peek() token {
+	if p.done() {
+		return token{}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *parser) acceptOp(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.kind != "op" {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.text == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) parseOr() (node, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("||"); !ok {
+			return l, nil
+		}
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = logical{op: "||", l: l, r: r}
+	}
+}
+
+func (p *parser) parseAnd() (node, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.acceptOp("&&"); !ok {
+			return l, nil
+		}
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = logical{op: "&&", l: l, r: r}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if _, ok := p.acceptOp("!"); ok {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return not{x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (node, error) {
+	l, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.acceptOp("==", "!=", "<", "<=", ">", ">=")
+	if !ok {
+		return l, nil
+	}
+	r, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return compare{op: op, l: l, r: r}, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	if p.done() {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	if _, ok := p.acceptOp("-"); ok {
+		x, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return neg{x: x}, nil
+	}
+	t := p.toks[p.pos]
+	p.pos++
+	switch t.kind {
+	case "(":
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != ")" {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		p.pos++
+		return x, nil
+	case "number":
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		return literal{v: value{kind: kindNumber, n: n}}, nil
+	case "duration":
+		d, err := ParseDuration(t.text)
+		if err != nil {
+			return nil, err
+		}
+		return literal{v: value{kind: kindDuration, d: d}}, nil
+	case "string":
+		return literal{v: value{kind: kindString, s: t.text}}, nil
+	case "ident":
+		switch t.text {
+		case "true":
+			return literal{v: value{kind: kindBool, b: true}}, nil
+		case "false":
+			return literal{v: value{kind: kindBool, b: false}}, nil
+		}
+		return ident{name: t.text}, nil
+	default:
+		return nil, fmt.Errorf("unexpected %q", t.text)
+	}
+}
@@ -0,0 +1,125 @@
+package selectexpr
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func vars(m map[string]any) Vars {
+	return func(name string) (any, error) {
+		v, ok := m[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown variable %q", name)
+		}
+		return v, nil
+	}
+}
+
+func TestEval(t *testing.T) {
+	env := vars(map[string]any{
+		"major":      1,
+		"minor":      4,
+		"prerelease": "",
+		"tag":        "v1.4.0",
+		"age":        10 * 24 * time.Hour,
+	})
+	cases := []struct {
+		expr string
+		want bool
+	}{
+		{"major == 1 && age > 7d", true},
+		{"major == 1 && age > 2w", false},
+		{"major >= 2 || minor < 5", true},
+		{"!(major == 1)", false},
+		{"prerelease == ''", true},
+		{`tag != "v1.4.0"`, false},
+		{"(major == 1 || major == 2) && age <= 240h", true},
+		{"true", true},
+	}
+	for _, c := range cases {
+		e, err := Compile(c.expr)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", c.expr, err)
+		}
+		got, err := e.Eval(env)
+		if err != nil {
+			t.Fatalf("Eval(%q): %v", c.expr, err)
+		}
+		if got != c.want {
+			t.Fatalf("Eval(%q)=%v want %v", c.expr, got, c.want)
+		}
+	}
+}
+
+func TestEval_ShortCircuitsLazyVars(t *testing.T) {
+	e, err := Compile("major == 1 && age > 7d")
+	if err != nil {
+		t.Fatalf("Compile: %v", err)
+	}
+	got, err := e.Eval(func(name string) (any, error) {
+		if name == "age" {
+			t.Fatalf("age should not be resolved when major != 1")
+		}
+		return 2, nil
+	})
+	if err != nil || got {
+		t.Fatalf("Eval got %v, %v", got, err)
+	}
+	if !e.Uses("age") || e.Uses("minor") {
+		t.Fatalf("Uses reported wrong variables")
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	for _, src := range []string{"", "major ==", "major = 1", "(major == 1", "major == 1 minor", "'open"} {
+		if _, err := Compile(src); err == nil {
+			t.Fatalf("Compile(%q): expected error", src)
+		}
+	}
+	e, _ := Compile("major == '1'")
+	if _, err := e.Eval(vars(map[string]any{"major": 1})); err == nil {
+		t.Fatalf("expected type mismatch error")
+	}
+}
+
+func TestEvalKey(t *testing.T) {
+	older := vars(map[string]any{"minor": 1, "age": 10 * 24 * time.Hour, "tag": "1.1.0"})
+	newer := vars(map[string]any{"minor": 2, "age": time.Hour, "tag": "1.2.0"})
+	cases := []struct {
+		expr string
+		want int
+	}{
+		{"age", 1},
+		{"-age", -1},
+		{"minor", -1},
+		{"-minor", 1},
+		{"tag", -1},
+	}
+	for _, c := range cases {
+		e, err := Compile(c.expr)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", c.expr, err)
+		}
+		a, err := e.EvalKey(older)
+		if err != nil {
+			t.Fatalf("EvalKey(%q): %v", c.expr, err)
+		}
+		b, err := e.EvalKey(newer)
+		if err != nil {
+			t.Fatalf("EvalKey(%q): %v", c.expr, err)
+		}
+		if got := a.Compare(b); got != c.want {
+			t.Fatalf("%q: older vs newer got %d want %d", c.expr, got, c.want)
+		}
+	}
+	for _, src := range []string{"age > 7d", "-tag"} {
+		e, err := Compile(src)
+		if err != nil {
+			t.Fatalf("Compile(%q): %v", src, err)
+		}
+		if _, err := e.EvalKey(older); err == nil {
+			t.Fatalf("EvalKey(%q): expected error", src)
+		}
+	}
+}
@@ -15,6 +15,33 @@ const (
 	MajorChange
 )
 
+func (l ChangeLevel) String() string {
+	switch l {
+	case NoChange:
+		return "none"
+	case PatchChange:
+		return "patch"
+	case MinorChange:
+		return "minor"
+	case MajorChange:
+		return "major"
+	}
+	return fmt.Sprintf("ChangeLevel(%d)", int(l))
+}
+
+// ParseChangeLevel parses "patch", "minor", or "major" (case-insensitive).
+func ParseChangeLevel(s string) (ChangeLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "patch":
+		return PatchChange, nil
+	case "minor":
+		return MinorChange, nil
+	case "major":
+		return MajorChange, nil
+	}
+	return NoChange, fmt.Errorf("unknown change level %q (want patch, minor, or major)", s)
+}
+
 func Max(a, b ChangeLevel) ChangeLevel {
 	if a > b {
 		return a
@@ -91,3 +118,71 @@ func BumpChartVersion(current string, lvl ChangeLevel) (string, error) {
 		return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch), nil
 	}
 }
+
+// BumpRCVersion bumps current for a release-candidate workflow:
+//
+//   - If current is a prerelease on a release line at least as large as lvl, its trailing
+//     counter is incremented (1.3.0-rc.1 -> 1.3.0-rc.2).
+//   - Otherwise the release part is bumped by lvl and a fresh -rc.1 is started
+//     (1.2.3 -> 1.3.0-rc.1 on a minor change, 1.3.0-rc.2 -> 2.0.0-rc.1 on a major change).
+//
+// NoChange leaves current as-is.
+func BumpRCVersion(current string, lvl ChangeLevel) (string, error) {
+	v, pre, err := parsePrerelease(current)
+	if err != nil {
+		return "", err
+	}
+	if lvl == NoChange {
+		return current, nil
+	}
+	if pre != "" && lvl <= releaseLine(v) {
+		return fmt.Sprintf("%d.%d.%d-%s", v.Major, v.Minor, v.Patch, incrementPrerelease(pre)), nil
+	}
+	next, err := BumpChartVersion(fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch), lvl)
+	if err != nil {
+		return "", err
+	}
+	return next + "-rc.1", nil
+}
+
+// parsePrerelease parses a version as Parse does, also accepting a -prerelease suffix and
+// build metadata. It returns the prerelease without the dash; build metadata is dropped.
+// Only the release-candidate workflow uses it, so default bumps keep rejecting prereleases.
+func parsePrerelease(s string) (Version, string, error) {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "+")
+	s, pre, hasPre := strings.Cut(s, "-")
+	if hasPre && pre == "" {
+		return Version{}, "", fmt.Errorf("invalid semver prerelease: %q", s)
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return Version{}, "", err
+	}
+	return v, pre, nil
+}
+
+// releaseLine infers which bump produced a prerelease version: x.0.0 is a major line,
+// x.y.0 a minor line, anything else a patch line.
+func releaseLine(v Version) ChangeLevel {
+	switch {
+	case v.Minor == 0 && v.Patch == 0:
+		return MajorChange
+	case v.Patch == 0:
+		return MinorChange
+	default:
+		return PatchChange
+	}
+}
+
+// incrementPrerelease increments the trailing numeric identifier of pre ("rc.1" -> "rc.2"),
+// appending ".1" if there is none ("rc" -> "rc.1").
+func incrementPrerelease(pre string) string {
+	ids := strings.Split(pre, ".")
+	last := ids[len(ids)-1]
+	n, err := strconv.Atoi(last)
+	if err != nil {
+		return pre + ".1"
+	}
+	ids[len(ids)-1] = strconv.Itoa(n + 1)
+	return strings.Join(ids, ".")
+}
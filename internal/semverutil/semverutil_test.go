@@ -31,4 +31,43 @@ func TestBumpChartVersion(t *testing.T) {
 	if got, _ := BumpChartVersion("1.2.3", MajorChange); got != "2.0.0" {
 		t.Fatalf("major bump got %s", got)
 	}
+
+}
+
+func TestBumpRCVersion(t *testing.T) {
+	cases := []struct {
+		cur  string
+		lvl  ChangeLevel
+		want string
+	}{
+		{"1.3.0-rc.1", PatchChange, "1.3.0-rc.2"},
+		{"1.3.0-rc.9", MinorChange, "1.3.0-rc.10"},
+		{"1.2.3", MinorChange, "1.3.0-rc.1"},
+		{"1.2.4-rc.1", MinorChange, "1.3.0-rc.1"},
+		{"1.3.0-rc.2", MajorChange, "2.0.0-rc.1"},
+		{"1.3.0-rc.2", NoChange, "1.3.0-rc.2"},
+		{"1.2.3+build.5", PatchChange, "1.2.4-rc.1"},
+		{"v1.3.0-rc.1", PatchChange, "1.3.0-rc.2"},
+	}
+	for _, c := range cases {
+		got, err := BumpRCVersion(c.cur, c.lvl)
+		if err != nil {
+			t.Fatalf("BumpRCVersion(%q,%v): %v", c.cur, c.lvl, err)
+		}
+		if got != c.want {
+			t.Fatalf("BumpRCVersion(%q,%v)=%q want %q", c.cur, c.lvl, got, c.want)
+		}
+	}
+}
+
+func TestParseChangeLevel(t *testing.T) {
+	for _, l := range []ChangeLevel{PatchChange, MinorChange, MajorChange} {
+		got, err := ParseChangeLevel(l.String())
+		if err != nil || got != l {
+			t.Fatalf("ParseChangeLevel(%q) = %v, %v", l.String(), got, err)
+		}
+	}
+	if _, err := ParseChangeLevel("none"); err == nil {
+		t.Fatalf("expected an error for none")
+	}
 }
@@ -0,0 +1,41 @@
+package textdiff
+
+import "testing"
+
+func TestUnified(t *testing.T) {
+	for name, tc := range map[string]struct {
+		a, b, want string
+	}{
+		"equal": {a: "x: 1\n", b: "x: 1\n", want: ""},
+		"single line": {
+			a: "image:\n  repository: ghcr.io/org/app\n  tag: 1.2.3\npullPolicy: Always\n",
+			b: "image:\n  repository: ghcr.io/org/app\n  tag: 1.3.0\npullPolicy: Always\n",
+			want: "--- a/values.yaml\n+++ b/values.yaml\n@@ -1,4 +1,4 @@\n" +
+				" image:\n   repository: ghcr.io/org/app\n-  tag: 1.2.3\n+  tag: 1.3.0\n pullPolicy: Always\n",
+		},
+		"separate hunks": {
+			a: "a\n1\n2\n3\n4\n5\n6\n7\n8\nb\n",
+			b: "A\n1\n2\n3\n4\n5\n6\n7\n8\nB\n",
+			want: "--- a/values.yaml\n+++ b/values.yaml\n" +
+				"@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n" +
+				"@@ -7,4 +7,4 @@\n 6\n 7\n 8\n-b\n+B\n",
+		},
+		"no trailing newline": {
+			a: "x: 1",
+			b: "x: 2",
+			want: "--- a/values.yaml\n+++ b/values.yaml\n@@ -1 +1 @@\n" +
+				"-x: 1\n\\ No newline at end of file\n+x: 2\n\\ No newline at end of file\n",
+		},
+		"new file": {
+			a:    "",
+			b:    "x: 1\n",
+			want: "--- a/values.yaml\n+++ b/values.yaml\n@@ -0,0 +1 @@\n+x: 1\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := Unified("values.yaml", []byte(tc.a), []byte(tc.b)); got != tc.want {
+				t.Fatalf("got:\n%s\nwant:\n%s", got, tc.want)
+			}
+		})
+	}
+}
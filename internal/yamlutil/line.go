@@ -0,0 +1,130 @@
+package yamlutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scalarLine is a single `key: value` line split around its value, for files that cannot be
+// parsed as YAML (e.g. Helm templates).
+type scalarLine struct {
+	prefix string // indentation, optional "- ", key, and ": "
+	value  string // the value token as written, including any quotes
+	suffix string // trailing whitespace, comment, and line ending
+}
+
+func splitScalarLine(line string) (scalarLine, error) {
+	i := strings.Index(line, ": ")
+	if i < 0 {
+		return scalarLine{}, fmt.Errorf("expected a `key: value` line, got %q", strings.TrimSpace(line))
+	}
+	start := i + 2
+	for start < len(line) && line[start] == ' ' {
+		start++
+	}
+	v, suffix, err := cutScalar(line[start:])
+	if err != nil {
+		return scalarLine{}, fmt.Errorf("%w in %q", err, strings.TrimSpace(line))
+	}
+	if strings.Contains(v, "{{") {
+		return scalarLine{}, fmt.Errorf("value %s is a template expression, not a literal", v)
+	}
+	return scalarLine{prefix: line[:start], value: v, suffix: suffix}, nil
+}
+
+// cutScalar splits text at the end of the scalar token it starts with, including any quotes.
+func cutScalar(text string) (value, suffix string, err error) {
+	var end int
+	switch {
+	case text == "" || strings.HasPrefix(text, "#"):
+		return "", "", fmt.Errorf("no value")
+	case text[0] == '"':
+		end = closingDoubleQuote(text)
+	case text[0] == '\'':
+		end = closingSingleQuote(text)
+	default:
+		end = len(strings.TrimRight(text, "\r\n"))
+		if c := strings.Index(text, " #"); c >= 0 && c < end {
+			end = c
+		}
+		end = len(strings.TrimRight(text[:end], " \t"))
+	}
+	if end < 0 {
+		return "", "", fmt.Errorf("unterminated quoted value")
+	}
+	return text[:end], text[end:], nil
+}
+
+// unquoteScalar returns the value of a scalar token as written.
+func unquoteScalar(v string) (string, error) {
+	switch v[0] {
+	case '"':
+		return strconv.Unquote(v)
+	case '\'':
+		return strings.ReplaceAll(v[1:len(v)-1], "''", "'"), nil
+	}
+	return v, nil
+}
+
+// closingDoubleQuote returns the length of the double-quoted scalar at the start of s, or -1.
+func closingDoubleQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// closingSingleQuote returns the length of the single-quoted scalar at the start of s, or -1.
+func closingSingleQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		if s[i] != '\'' {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '\'' {
+			i++
+			continue
+		}
+		return i + 1
+	}
+	return -1
+}
+
+// LineValue returns the unquoted scalar value of a single `key: value` line.
+func LineValue(line string) (string, error) {
+	sl, err := splitScalarLine(line)
+	if err != nil {
+		return "", err
+	}
+	return unquoteScalar(sl.value)
+}
+
+// SetLineValue replaces the scalar value of a single `key: value` line, keeping its quoting
+// style, indentation, and trailing comment. Returns whether the value changed.
+func SetLineValue(line, newValue string) (string, bool, error) {
+	cur, err := LineValue(line)
+	if err != nil {
+		return line, false, err
+	}
+	if cur == newValue {
+		return line, false, nil
+	}
+	sl, _ := splitScalarLine(line)
+	style := plainStyle
+	switch sl.value[0] {
+	case '"':
+		style = doubleQuoteStyle
+	case '\'':
+		style = singleQuoteStyle
+	}
+	if style == plainStyle && !plainSafe(newValue) {
+		style = doubleQuoteStyle
+	}
+	v, _ := quotedScalar{value: newValue, style: style}.MarshalYAML()
+	return sl.prefix + string(v) + sl.suffix, true, nil
+}
@@ -1,17 +1,46 @@
 package yamlutil
 
 import (
+	"bytes"
 	"fmt"
+	"maps"
+	"slices"
 	"strconv"
 	"strings"
 
 	yaml "github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+	"github.com/goccy/go-yaml/token"
 )
 
 // File represents a reversible YAML document: decoded value + comment sidecar.
 type File struct {
 	Value any
 	CM    yaml.CommentMap
+
+	// src is the parsed source, used to recover the quoting style of scalars replaced by
+	// SetString.
+	src *ast.File
+	// crlf and noFinalNewline record the source's line endings, which Render reproduces so
+	// an otherwise unchanged file renders identically.
+	crlf           bool
+	noFinalNewline bool
+
+	// orig is the source text. lossy records that re-encoding it with goccy changes its
+	// comment layout, e.g. by dropping the blank lines between comment blocks; Render then
+	// applies the scalar replacements in edits to orig instead, unless structural is set by a
+	// change that can't be made by rewriting a single value in place.
+	orig       []byte
+	lossy      bool
+	edits      map[token.Position]scalarEdit
+	structural bool
+}
+
+// scalarEdit replaces the value token of length n at byte offset off of a source line.
+type scalarEdit struct {
+	line, off, n int
+	text         string
 }
 
 func ParseBytes(b []byte) (*File, error) {
@@ -25,11 +54,51 @@ func ParseBytes(b []byte) (*File, error) {
 	); err != nil {
 		return nil, err
 	}
-	return &File{Value: v, CM: cm}, nil
+	src, err := parser.ParseBytes(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	f := &File{
+		Value:          v,
+		CM:             cm,
+		src:            src,
+		crlf:           bytes.Contains(b, []byte("\r\n")),
+		noFinalNewline: len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")),
+		orig:           b,
+		edits:          map[token.Position]scalarEdit{},
+	}
+	out, err := f.encode()
+	f.lossy = err != nil || !slices.Equal(commentLayout(out), commentLayout(string(b)))
+	return f, nil
 }
 
-// Render re-encodes YAML while re-injecting comments captured in CM.
+// commentLayout returns the comment-only and blank lines of s, in order, with their
+// indentation.
+func commentLayout(s string) []string {
+	var out []string
+	for _, l := range strings.Split(s, "\n") {
+		l = strings.TrimRight(l, "\r")
+		if t := strings.TrimSpace(l); t == "" || strings.HasPrefix(t, "#") {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Render re-encodes YAML while re-injecting comments captured in CM. It uses the line
+// endings of the parsed source: CRLF if the source had any, and a final newline only if the
+// source ended with one. If re-encoding would alter the source's comment layout, e.g. dropping
+// blank lines between comment blocks, and only SetString scalar replacements were made, those
+// lines are rewritten in the source text instead, leaving the rest of the file untouched.
 func Render(f *File) (string, error) {
+	if f.lossy && !f.structural && f.orig != nil {
+		return f.patchSource(), nil
+	}
+	return f.encode()
+}
+
+// encode re-encodes f with goccy.
+func (f *File) encode() (string, error) {
 	out, err := yaml.MarshalWithOptions(
 		f.Value,
 		yaml.WithComment(f.CM),
@@ -38,11 +107,32 @@ func Render(f *File) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return string(out), nil
+	s := string(out)
+	if f.noFinalNewline {
+		s = strings.TrimRight(s, "\r\n")
+	} else if s != "" && !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	if f.crlf {
+		s = strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+	}
+	return s, nil
 }
 
-// GetString reads a scalar value at yamlPath and returns it as a string.
+// GetString reads a scalar value at yamlPath and returns it as a string. For a wildcard path
+// (see ExpandPath), the value of the first match is returned.
 func GetString(f *File, yamlPath string) (string, bool, error) {
+	yamlPath, err := NormalizePath(f, yamlPath)
+	if err != nil {
+		return "", false, err
+	}
+	if strings.Contains(yamlPath, "[*]") {
+		paths, err := ExpandPath(f, yamlPath)
+		if err != nil || len(paths) == 0 {
+			return "", false, err
+		}
+		yamlPath = paths[0]
+	}
 	p, err := yaml.PathString(yamlPath)
 	if err != nil {
 		return "", false, err
@@ -63,9 +153,61 @@ func GetString(f *File, yamlPath string) (string, bool, error) {
 	}
 }
 
+// ScalarValue returns the value of a scalar written as text after a key's colon, as GetString
+// reports it: quotes and any trailing comment are dropped and plain scalars are decoded, so
+// `1.20 # pinned` gives 1.2. A null gives "".
+func ScalarValue(text string) (string, error) {
+	v, _, err := cutScalar(strings.TrimLeft(text, " "))
+	if err != nil {
+		return "", fmt.Errorf("%w in %q", err, text)
+	}
+	if v[0] == '"' || v[0] == '\'' {
+		return unquoteScalar(v)
+	}
+	var out any
+	if err := yaml.Unmarshal([]byte(v), &out); err != nil {
+		return "", fmt.Errorf("decode scalar %q: %w", v, err)
+	}
+	switch x := out.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return x, nil
+	default:
+		return fmt.Sprint(x), nil
+	}
+}
+
 // SetString sets a scalar string at yamlPath by mutating the decoded object graph.
-// Returns whether it changed.
+// A wildcard path (see ExpandPath) sets every match. Returns whether it changed.
 func SetString(f *File, yamlPath string, newValue string) (bool, error) {
+	yamlPath, err := NormalizePath(f, yamlPath)
+	if err != nil {
+		return false, err
+	}
+	if strings.Contains(yamlPath, "[*]") {
+		paths, err := ExpandPath(f, yamlPath)
+		if err != nil {
+			return false, err
+		}
+		if len(paths) == 0 {
+			return false, fmt.Errorf("path %q matches no elements", yamlPath)
+		}
+		changed := false
+		for _, p := range paths {
+			// Elements without the key are left alone rather than gaining it.
+			if _, ok, _ := GetString(f, p); !ok {
+				continue
+			}
+			c, err := SetString(f, p, newValue)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+		return changed, nil
+	}
+
 	cur, ok, _ := GetString(f, yamlPath)
 	if ok && cur == newValue {
 		return false, nil
@@ -76,12 +218,387 @@ func SetString(f *File, yamlPath string, newValue string) (bool, error) {
 		return false, err
 	}
 
-	if err := setAtPath(&f.Value, steps, newValue); err != nil {
+	var v any = newValue
+	if style := f.quoteStyle(yamlPath); style != plainStyle || plainSafe(newValue) {
+		v = quotedScalar{value: newValue, style: style}
+	}
+	if err := setAtPath(&f.Value, steps, v); err != nil {
 		return false, err
 	}
+	if ok {
+		f.recordEdit(yamlPath, cur, newValue)
+	} else {
+		// A new key has no source line to rewrite.
+		f.structural = true
+	}
 	return true, nil
 }
 
+// recordEdit records replacing the scalar at yamlPath, whose value is cur, with newValue in
+// the source text, keeping its quoting style and trailing comment. Scalars that aren't a
+// single token on one line, such as block scalars, aliases, and anchored values, mark the
+// change structural instead.
+func (f *File) recordEdit(yamlPath, cur, newValue string) {
+	if f.src == nil || f.orig == nil {
+		f.structural = true
+		return
+	}
+	p, err := yaml.PathString(yamlPath)
+	if err != nil {
+		f.structural = true
+		return
+	}
+	n, err := p.FilterFile(f.src)
+	if err != nil || n == nil || n.GetToken() == nil {
+		f.structural = true
+		return
+	}
+	switch n.Type() {
+	case ast.StringType, ast.IntegerType, ast.FloatType, ast.BoolType, ast.InfinityType, ast.NanType:
+	default:
+		f.structural = true
+		return
+	}
+	pos := *n.GetToken().Position
+	e, seen := f.edits[pos]
+	if !seen {
+		lines := strings.SplitAfter(string(f.orig), "\n")
+		if pos.Line < 1 || pos.Line > len(lines) {
+			f.structural = true
+			return
+		}
+		line := lines[pos.Line-1]
+		runes := []rune(line)
+		if pos.Column < 1 || pos.Column > len(runes) {
+			f.structural = true
+			return
+		}
+		off := len(string(runes[:pos.Column-1]))
+		tok, _, err := cutScalar(line[off:])
+		// The token must read back as the current value, or it isn't the whole scalar
+		// (e.g. a plain scalar continued on the next line).
+		if v, verr := ScalarValue(line[off:]); err != nil || verr != nil || v != cur {
+			f.structural = true
+			return
+		}
+		e = scalarEdit{line: pos.Line - 1, off: off, n: len(tok), text: tok}
+	}
+	style := plainStyle
+	switch e.text[0] {
+	case '"':
+		style = doubleQuoteStyle
+	case '\'':
+		style = singleQuoteStyle
+	}
+	if style == plainStyle && !plainSafe(newValue) {
+		style = doubleQuoteStyle
+	}
+	out, _ := quotedScalar{value: newValue, style: style}.MarshalYAML()
+	e.text = string(out)
+	f.edits[pos] = e
+}
+
+// patchSource returns the source text with the recorded scalar edits applied.
+func (f *File) patchSource() string {
+	lines := strings.SplitAfter(string(f.orig), "\n")
+	edits := slices.Collect(maps.Values(f.edits))
+	// Later edits on a line first, so earlier offsets stay valid.
+	slices.SortFunc(edits, func(a, b scalarEdit) int { return b.off - a.off })
+	for _, e := range edits {
+		l := lines[e.line]
+		lines[e.line] = l[:e.off] + e.text + l[e.off+e.n:]
+	}
+	return strings.Join(lines, "")
+}
+
+// lookup walks the decoded object graph to the node at yamlPath ("$" for the document
+// root). ok is false if the path does not exist.
+func lookup(f *File, yamlPath string) (any, bool, error) {
+	yamlPath, err := NormalizePath(f, yamlPath)
+	if err != nil {
+		return nil, false, err
+	}
+	cur := f.Value
+	if yamlPath == "$" {
+		return cur, true, nil
+	}
+	steps, err := parseSimpleYAMLPath(yamlPath)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, s := range steps {
+		switch {
+		case s.key != nil:
+			ms, ok := cur.(yaml.MapSlice)
+			if !ok {
+				return nil, false, nil
+			}
+			child, ok := mapSliceGet(ms, *s.key)
+			if !ok {
+				return nil, false, nil
+			}
+			cur = child
+		case s.index != nil:
+			arr, ok := cur.([]any)
+			if !ok || *s.index < 0 || *s.index >= len(arr) {
+				return nil, false, nil
+			}
+			cur = arr[*s.index]
+		}
+	}
+	return cur, true, nil
+}
+
+// GetSlice returns the sequence at yamlPath from the decoded object graph. Elements are
+// shared with the document, not copied: mappings are yaml.MapSlice and nested sequences
+// []any. ok is false if the path does not exist; a value that is not a sequence is an error.
+func GetSlice(f *File, yamlPath string) ([]any, bool, error) {
+	v, ok, err := lookup(f, yamlPath)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	arr, isSlice := v.([]any)
+	if !isSlice {
+		return nil, false, fmt.Errorf("value at %s is not a sequence", yamlPath)
+	}
+	return arr, true, nil
+}
+
+// GetMap returns the mapping at yamlPath ("$" for the document root) from the decoded object
+// graph, in document order and shared with the document. ok is false if the path does not
+// exist; a value that is not a mapping is an error.
+func GetMap(f *File, yamlPath string) (yaml.MapSlice, bool, error) {
+	v, ok, err := lookup(f, yamlPath)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	ms, isMap := v.(yaml.MapSlice)
+	if !isMap {
+		return nil, false, fmt.Errorf("value at %s is not a mapping", yamlPath)
+	}
+	return ms, true, nil
+}
+
+// DeleteKey removes the mapping key or sequence element at yamlPath, keeping the order of
+// its siblings and the comments attached to them; comments on the removed node are dropped
+// with it. It reports whether anything was removed; a missing path is not an error.
+func DeleteKey(f *File, yamlPath string) (bool, error) {
+	yamlPath, err := NormalizePath(f, yamlPath)
+	if err != nil {
+		return false, err
+	}
+	steps, err := parseSimpleYAMLPath(yamlPath)
+	if err != nil {
+		return false, err
+	}
+	parentPath := formatPath(steps[:len(steps)-1])
+	parent, ok, err := lookup(f, parentPath)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	leaf := steps[len(steps)-1]
+	var out any
+	switch {
+	case leaf.key != nil:
+		ms, isMap := parent.(yaml.MapSlice)
+		if !isMap {
+			return false, nil
+		}
+		i := slices.IndexFunc(ms, func(it yaml.MapItem) bool { return it.Key == *leaf.key })
+		if i < 0 {
+			return false, nil
+		}
+		out = slices.Delete(slices.Clone(ms), i, i+1)
+		f.dropComments(yamlPath)
+	case leaf.index != nil:
+		arr, isSlice := parent.([]any)
+		i := *leaf.index
+		if !isSlice || i < 0 || i >= len(arr) {
+			return false, nil
+		}
+		out = slices.Delete(slices.Clone(arr), i, i+1)
+		f.shiftComments(parentPath, i)
+	}
+	if err := setAtPathAssign(&f.Value, steps[:len(steps)-1], out); err != nil {
+		return false, err
+	}
+	f.structural = true
+	return true, nil
+}
+
+// dropComments removes the comments on the node at yamlPath and its descendants.
+func (f *File) dropComments(yamlPath string) {
+	for k := range f.CM {
+		if k == yamlPath || strings.HasPrefix(k, yamlPath+".") || strings.HasPrefix(k, yamlPath+"[") {
+			delete(f.CM, k)
+		}
+	}
+}
+
+// shiftComments drops the comments on element removed of the sequence at seqPath and moves
+// those on later elements down one index, following the elements.
+func (f *File) shiftComments(seqPath string, removed int) {
+	f.dropComments(fmt.Sprintf("%s[%d]", seqPath, removed))
+	moved := yaml.CommentMap{}
+	for k, c := range f.CM {
+		rest, ok := strings.CutPrefix(k, seqPath+"[")
+		if !ok {
+			continue
+		}
+		end := strings.IndexByte(rest, ']')
+		if end < 0 {
+			continue
+		}
+		idx, err := strconv.Atoi(rest[:end])
+		if err != nil || idx < removed {
+			continue
+		}
+		delete(f.CM, k)
+		moved[fmt.Sprintf("%s[%d]%s", seqPath, idx-1, rest[end+1:])] = c
+	}
+	maps.Copy(f.CM, moved)
+}
+
+// MapKeys returns the keys of the mapping at yamlPath ("$" for the document root), in
+// document order. ok is false if the path does not exist or is not a mapping.
+func MapKeys(f *File, yamlPath string) ([]string, bool) {
+	ms, ok, err := GetMap(f, yamlPath)
+	if err != nil || !ok {
+		return nil, false
+	}
+	keys := make([]string, 0, len(ms))
+	for _, it := range ms {
+		if ks, ok := it.Key.(string); ok {
+			keys = append(keys, ks)
+		}
+	}
+	return keys, true
+}
+
+type quoteStyle int
+
+const (
+	plainStyle quoteStyle = iota
+	singleQuoteStyle
+	doubleQuoteStyle
+)
+
+// quoteStyle reports how the scalar at yamlPath was quoted in the source document.
+func (f *File) quoteStyle(yamlPath string) quoteStyle {
+	if f.src == nil {
+		return plainStyle
+	}
+	p, err := yaml.PathString(yamlPath)
+	if err != nil {
+		return plainStyle
+	}
+	n, err := p.FilterFile(f.src)
+	if err != nil || n == nil {
+		return plainStyle
+	}
+	tk := n.GetToken()
+	if tk == nil {
+		return plainStyle
+	}
+	switch tk.Type {
+	case token.SingleQuoteType:
+		return singleQuoteStyle
+	case token.DoubleQuoteType:
+		return doubleQuoteStyle
+	default:
+		return plainStyle
+	}
+}
+
+// quotedScalar is a string value that renders with a fixed quoting style, so replacing
+// `tag: "1.2.3"` yields `tag: "1.2.4"` rather than goccy's preferred style.
+type quotedScalar struct {
+	value string
+	style quoteStyle
+}
+
+func (q quotedScalar) MarshalYAML() ([]byte, error) {
+	switch q.style {
+	case plainStyle:
+		return []byte(q.value), nil
+	case singleQuoteStyle:
+		return []byte("'" + strings.ReplaceAll(q.value, "'", "''") + "'"), nil
+	default:
+		return []byte(strconv.Quote(q.value)), nil
+	}
+}
+
+// plainSafe reports whether s reads back as the same scalar when written unquoted. A plain
+// source value like `tag: 1.2` stays plain even though goccy would quote the string "1.3".
+func plainSafe(s string) bool {
+	if s == "" || s != strings.TrimSpace(s) || strings.ContainsAny(s, "\n#") {
+		return false
+	}
+	var m map[string]any
+	if err := yaml.Unmarshal([]byte("v: "+s), &m); err != nil {
+		return false
+	}
+	v, ok := m["v"]
+	if !ok || v == nil {
+		return false
+	}
+	switch v.(type) {
+	case map[string]any, []any:
+		return false
+	}
+	return fmt.Sprint(v) == s
+}
+
+// ExpandPath resolves each [*] in yamlPath to every index of the sequence at that point,
+// returning concrete paths in document order ($.containers[*].image becomes
+// $.containers[0].image, $.containers[1].image, ...). A path without wildcards is returned
+// as-is.
+func ExpandPath(f *File, yamlPath string) ([]string, error) {
+	head, rest, ok := strings.Cut(yamlPath, "[*]")
+	if !ok {
+		return []string{yamlPath}, nil
+	}
+	steps, err := parseSimpleYAMLPath(head)
+	if err != nil {
+		return nil, err
+	}
+	cur := f.Value
+	for _, s := range steps {
+		switch {
+		case s.key != nil:
+			ms, ok := cur.(yaml.MapSlice)
+			if !ok {
+				return nil, fmt.Errorf("expected map at %q in %q", *s.key, yamlPath)
+			}
+			child, ok := mapSliceGet(ms, *s.key)
+			if !ok {
+				return nil, fmt.Errorf("key not found: %q", *s.key)
+			}
+			cur = child
+		case s.index != nil:
+			arr, ok := cur.([]any)
+			if !ok || *s.index < 0 || *s.index >= len(arr) {
+				return nil, fmt.Errorf("index [%d] out of range in %q", *s.index, yamlPath)
+			}
+			cur = arr[*s.index]
+		}
+	}
+	arr, ok := cur.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected sequence at %q, got %T", head, cur)
+	}
+	var out []string
+	for i := range arr {
+		more, err := ExpandPath(f, fmt.Sprintf("%s[%d]%s", head, i, rest))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, more...)
+	}
+	return out, nil
+}
+
 type pathStep struct {
 	key   *string
 	index *int
@@ -92,64 +609,238 @@ type pathStep struct {
 //	$.key
 //	$.key.child
 //	$.arr[0].key
+//	$.'key.with.dots'.child
+//	$.annotations["key.with.dots"]
 func parseSimpleYAMLPath(p string) ([]pathStep, error) {
 	if p == "$" {
 		return nil, fmt.Errorf("path refers to root; expected $.key: %q", p)
 	}
-	if !strings.HasPrefix(p, "$.") {
+	if !strings.HasPrefix(p, "$.") && !strings.HasPrefix(p, `$["`) {
 		return nil, fmt.Errorf("unsupported path (expected to start with $.): %q", p)
 	}
-	p = strings.TrimPrefix(p, "$.")
-	parts := strings.Split(p, ".")
-	steps := make([]pathStep, 0, len(parts))
-
-	for _, part := range parts {
-		if part == "" {
-			return nil, fmt.Errorf("invalid path segment in %q", p)
+	rest, wantKey := strings.CutPrefix(p[1:], ".")
+	var steps []pathStep
+	for wantKey || rest != "" {
+		if wantKey {
+			k, r, err := cutPathKey(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%v in %q", err, p)
+			}
+			steps = append(steps, pathStep{key: &k})
+			rest, wantKey = r, false
+			continue
 		}
-
-		// segment can be: "foo" or "foo[3]" or "[3]" (we don't expect bare indexes here)
-		for {
-			br := strings.IndexByte(part, '[')
-			if br == -1 {
-				k := part
+		switch rest[0] {
+		case '.':
+			rest, wantKey = rest[1:], true
+		case '[':
+			if strings.HasPrefix(rest, `["`) {
+				k, r, err := cutBracketKey(rest)
+				if err != nil {
+					return nil, fmt.Errorf("%v in %q", err, p)
+				}
 				steps = append(steps, pathStep{key: &k})
-				break
+				rest = r
+				continue
+			}
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("unclosed index in %q", p)
 			}
+			idx, err := strconv.Atoi(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid index %q in %q", rest[1:end], p)
+			}
+			steps = append(steps, pathStep{index: &idx})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q after index in %q", rest[0], p)
+		}
+	}
+	return steps, nil
+}
 
-			// key before [
-			if br > 0 {
-				k := part[:br]
-				steps = append(steps, pathStep{key: &k})
+// cutPathKey splits the mapping key at the start of s from the rest of the path. A key in
+// single quotes may contain '.', '[', and '*'; \' escapes a quote within it.
+func cutPathKey(s string) (key, rest string, err error) {
+	if !strings.HasPrefix(s, "'") {
+		end := strings.IndexAny(s, ".[")
+		if end == -1 {
+			end = len(s)
+		}
+		if end == 0 {
+			return "", "", fmt.Errorf("empty path segment")
+		}
+		return s[:end], s[end:], nil
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
 			}
+		case '\'':
+			if b.Len() == 0 {
+				return "", "", fmt.Errorf("empty path segment")
+			}
+			return b.String(), s[i+1:], nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", "", fmt.Errorf("unclosed quote")
+}
 
-			end := strings.IndexByte(part[br:], ']')
-			if end == -1 {
-				return nil, fmt.Errorf("unclosed index in segment %q", part)
+// cutBracketKey splits a ["key"] segment, a double-quoted string with Go escapes, from the
+// start of s.
+func cutBracketKey(s string) (key, rest string, err error) {
+	q, err := strconv.QuotedPrefix(s[1:])
+	if err != nil || !strings.HasPrefix(s[1+len(q):], "]") {
+		return "", "", fmt.Errorf("invalid quoted key")
+	}
+	key, _ = strconv.Unquote(q)
+	if key == "" {
+		return "", "", fmt.Errorf("empty path segment")
+	}
+	return key, s[len(q)+2:], nil
+}
+
+// QuoteKey returns key as a $.-syntax path segment, single-quoted if it contains path
+// syntax such as '.', the way goccy/go-yaml writes CommentMap keys.
+func QuoteKey(key string) string {
+	if strings.ContainsAny(key, ".*[]") || strings.HasPrefix(key, "'") {
+		return "'" + strings.ReplaceAll(key, "'", `\'`) + "'"
+	}
+	return key
+}
+
+// ParentPath returns the path of the node containing the one at yamlPath, or "$" for a
+// top-level key. Quoted keys are respected.
+func ParentPath(yamlPath string) string {
+	cut := -1
+	for i := 0; i < len(yamlPath); i++ {
+		switch c := yamlPath[i]; c {
+		case '\'', '"':
+			for i++; i < len(yamlPath) && yamlPath[i] != c; i++ {
+				if yamlPath[i] == '\\' {
+					i++
+				}
 			}
-			end += br
+		case '.', '[':
+			cut = i
+		}
+	}
+	if cut <= 1 {
+		return "$"
+	}
+	return yamlPath[:cut]
+}
 
-			idxStr := part[br+1 : end]
-			idx, err := strconv.Atoi(idxStr)
-			if err != nil {
-				return nil, fmt.Errorf("invalid index %q in segment %q", idxStr, part)
+// formatPath renders steps in the $.key[0] syntax, quoting keys that contain path syntax the
+// way goccy/go-yaml does, so the result also matches CommentMap keys.
+func formatPath(steps []pathStep) string {
+	var b strings.Builder
+	b.WriteString("$")
+	for _, s := range steps {
+		if s.index != nil {
+			fmt.Fprintf(&b, "[%d]", *s.index)
+			continue
+		}
+		b.WriteString(".")
+		b.WriteString(QuoteKey(*s.key))
+	}
+	return b.String()
+}
+
+// NormalizePath returns yamlPath in the $.key.child[0] syntax, with keys quoted as by
+// QuoteKey. A path starting with '/' is an RFC 6901 JSON Pointer (/key/child/0); its numeric
+// tokens are sequence indexes where f has a sequence at that point, and mapping keys
+// otherwise. Bracket-quoted keys ($.annotations["example.com/name"]) are rewritten as
+// single-quoted ones. Other paths are returned as-is. Every function taking a yamlPath
+// accepts any of these forms.
+func NormalizePath(f *File, yamlPath string) (string, error) {
+	if !strings.HasPrefix(yamlPath, "/") {
+		return normalizeBracketKeys(yamlPath)
+	}
+	var steps []pathStep
+	cur := f.Value
+	for _, tok := range strings.Split(yamlPath[1:], "/") {
+		if err := checkPointerEscapes(tok); err != nil {
+			return "", fmt.Errorf("JSON pointer %q: %w", yamlPath, err)
+		}
+		tok = pointerUnescaper.Replace(tok)
+		if arr, ok := cur.([]any); ok {
+			idx, err := strconv.Atoi(tok)
+			if err != nil || idx < 0 || (len(tok) > 1 && tok[0] == '0') {
+				return "", fmt.Errorf("JSON pointer %q: invalid sequence index %q", yamlPath, tok)
 			}
 			steps = append(steps, pathStep{index: &idx})
+			cur = nil
+			if idx < len(arr) {
+				cur = arr[idx]
+			}
+			continue
+		}
+		if tok == "" {
+			return "", fmt.Errorf("JSON pointer %q: empty keys are not supported", yamlPath)
+		}
+		k := tok
+		steps = append(steps, pathStep{key: &k})
+		ms, _ := cur.(yaml.MapSlice)
+		cur, _ = mapSliceGet(ms, k)
+	}
+	return formatPath(steps), nil
+}
 
-			// remainder after ]
-			if end+1 >= len(part) {
-				break
+// normalizeBracketKeys rewrites each ["key"] segment of p as .'key', leaving the rest of the
+// path, including [*] wildcards, as written.
+func normalizeBracketKeys(p string) (string, error) {
+	if !strings.Contains(p, `["`) {
+		return p, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		switch {
+		case p[i] == '\'':
+			// Copy a single-quoted key through unchanged.
+			j := i + 1
+			for ; j < len(p) && p[j] != '\''; j++ {
+				if p[j] == '\\' {
+					j++
+				}
 			}
-			part = part[end+1:]
-			if part == "" {
-				break
+			b.WriteString(p[i:min(j+1, len(p))])
+			i = j
+		case strings.HasPrefix(p[i:], `["`):
+			k, rest, err := cutBracketKey(p[i:])
+			if err != nil {
+				return "", fmt.Errorf("%v in %q", err, p)
 			}
+			b.WriteString("." + QuoteKey(k))
+			i = len(p) - len(rest) - 1
+		default:
+			b.WriteByte(p[i])
 		}
 	}
-	return steps, nil
+	return b.String(), nil
+}
+
+// pointerUnescaper decodes JSON Pointer escapes; a single left-to-right pass turns ~01 into
+// ~1, as RFC 6901 requires.
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+func checkPointerEscapes(tok string) error {
+	for i := 0; i < len(tok); i++ {
+		if tok[i] == '~' && (i+1 == len(tok) || (tok[i+1] != '0' && tok[i+1] != '1')) {
+			return fmt.Errorf("invalid escape in %q", tok)
+		}
+	}
+	return nil
 }
 
-func setAtPath(root *any, steps []pathStep, newValue string) error {
+func setAtPath(root *any, steps []pathStep, newValue any) error {
 	var cur any = *root
 
 	// Walk to parent of leaf
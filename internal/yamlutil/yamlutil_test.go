@@ -1,6 +1,11 @@
 package yamlutil
 
-import "testing"
+import (
+	"strings"
+	"testing"
+
+	yaml "github.com/goccy/go-yaml"
+)
 
 func TestSetStringPreservesComment(t *testing.T) {
 	in := []byte(`# chart comment
@@ -47,3 +52,478 @@ func stringsContains(s, sub string) bool {
 	}
 	return false
 }
+
+func TestSetStringPreservesQuoting(t *testing.T) {
+	in := []byte(`plain: 1.2.3
+single: '1.2.3'
+double: "1.2.3" # inline
+float: 1.2
+digest: "sha256:abc"
+`)
+	f, err := ParseBytes(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, v := range map[string]string{
+		"$.plain":  "1.2.4",
+		"$.single": "1.2.4",
+		"$.double": "1.2.4",
+		"$.float":  "1.3",
+		"$.digest": "sha256:def",
+	} {
+		if _, err := SetString(f, path, v); err != nil {
+			t.Fatalf("SetString(%s): %v", path, err)
+		}
+	}
+	out, err := Render(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `plain: 1.2.4
+single: '1.2.4'
+double: "1.2.4" # inline
+float: 1.3
+digest: "sha256:def"
+`
+	if out != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+	}
+	if v, _, _ := GetString(f, "$.single"); v != "1.2.4" {
+		t.Fatalf("GetString got %q", v)
+	}
+}
+
+func TestSetStringQuotesUnsafePlainValue(t *testing.T) {
+	f, err := ParseBytes([]byte("tag: latest\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetString(f, "$.tag", "@sha256:abc"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Render(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contains(out, `"@sha256:abc"`) {
+		t.Fatalf("expected value quoted, got:\n%s", out)
+	}
+}
+
+func TestSetStringWildcard(t *testing.T) {
+	in := []byte(`spec:
+  containers:
+  - name: server
+    image: ghcr.io/org/app:1.2.3
+  - name: sidecar
+    image: ghcr.io/org/app:1.2.3
+  - name: no-image
+`)
+	f, err := ParseBytes(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths, err := ExpandPath(f, "$.spec.containers[*].image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 3 || paths[1] != "$.spec.containers[1].image" {
+		t.Fatalf("ExpandPath got %v", paths)
+	}
+	if v, ok, _ := GetString(f, "$.spec.containers[*].image"); !ok || v != "ghcr.io/org/app:1.2.3" {
+		t.Fatalf("GetString got %q, %v", v, ok)
+	}
+
+	changed, err := SetString(f, "$.spec.containers[*].image", "ghcr.io/org/app:1.3.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatalf("expected change")
+	}
+	out, err := Render(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `spec:
+  containers:
+  - name: server
+    image: ghcr.io/org/app:1.3.0
+  - name: sidecar
+    image: ghcr.io/org/app:1.3.0
+  - name: no-image
+`
+	if out != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+	}
+
+	if changed, err := SetString(f, "$.spec.containers[*].image", "ghcr.io/org/app:1.3.0"); err != nil || changed {
+		t.Fatalf("second set: changed=%v err=%v", changed, err)
+	}
+}
+
+func TestSetLineValue(t *testing.T) {
+	for _, c := range []struct{ in, v, want string }{
+		{"  tag: 1.2.3\n", "1.2.4", "  tag: 1.2.4\n"},
+		{"  - image: \"1.2.3\" # pinned\n", "1.2.4", "  - image: \"1.2.4\" # pinned\n"},
+		{"tag: '1.2.3'", "1.2.4", "tag: '1.2.4'"},
+		{"tag: 1.2.3", "1.2.4 beta", "tag: 1.2.4 beta"},
+		{"tag: 1.2.3 # c", "a: b", "tag: \"a: b\" # c"},
+	} {
+		got, changed, err := SetLineValue(c.in, c.v)
+		if err != nil {
+			t.Fatalf("SetLineValue(%q): %v", c.in, err)
+		}
+		if !changed || got != c.want {
+			t.Fatalf("SetLineValue(%q) got %q (changed=%v) want %q", c.in, got, changed, c.want)
+		}
+	}
+	if _, _, err := SetLineValue("tag: {{ .Values.tag }}", "1.2.4"); err == nil {
+		t.Fatalf("expected an error for a template expression")
+	}
+}
+
+func TestScalarValue(t *testing.T) {
+	for in, want := range map[string]string{
+		`"1.2.3" # pinned`: "1.2.3",
+		`'it''s'`:          "it's",
+		"1.2.3 # c":        "1.2.3",
+		"1.20":             "1.2",
+		"~":                "",
+	} {
+		if got, err := ScalarValue(in); err != nil || got != want {
+			t.Fatalf("ScalarValue(%q) got %q, %v want %q", in, got, err, want)
+		}
+	}
+}
+
+func TestRenderPreservesLineEndings(t *testing.T) {
+	for name, in := range map[string]string{
+		"trailing newline":    "name: test\nversion: 1.2.3\n",
+		"no trailing newline": "name: test\nversion: 1.2.3",
+		"crlf":                "name: test\r\nversion: 1.2.3\r\n",
+		"crlf no newline":     "name: test\r\nversion: 1.2.3",
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := ParseBytes([]byte(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := Render(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != in {
+				t.Fatalf("unchanged render got %q want %q", out, in)
+			}
+			if _, err := SetString(f, "$.version", "1.2.4"); err != nil {
+				t.Fatal(err)
+			}
+			out, err = Render(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := strings.ReplaceAll(in, "1.2.3", "1.2.4"); out != want {
+				t.Fatalf("updated render got %q want %q", out, want)
+			}
+		})
+	}
+}
+
+func TestGetSliceAndMap(t *testing.T) {
+	f, err := ParseBytes([]byte(`name: test
+dependencies:
+  - name: redis
+    version: 19.0.0
+  - name: pg
+    version: 1.0.0
+image:
+  repository: ghcr.io/org/app
+  tag: "1.2.3"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deps, ok, err := GetSlice(f, "$.dependencies")
+	if err != nil || !ok || len(deps) != 2 {
+		t.Fatalf("GetSlice: %v ok=%v err=%v", deps, ok, err)
+	}
+	if ms, isMap := deps[1].(yaml.MapSlice); !isMap || len(ms) != 2 || ms[0].Value != "pg" {
+		t.Fatalf("unexpected dependency %#v", deps[1])
+	}
+
+	img, ok, err := GetMap(f, "$.image")
+	if err != nil || !ok || len(img) != 2 || img[0].Key != "repository" || img[1].Value != "1.2.3" {
+		t.Fatalf("GetMap: %#v ok=%v err=%v", img, ok, err)
+	}
+	if dep, ok, err := GetMap(f, "$.dependencies[0]"); err != nil || !ok || dep[1].Value != "19.0.0" {
+		t.Fatalf("GetMap index: %#v ok=%v err=%v", dep, ok, err)
+	}
+
+	if _, ok, err := GetMap(f, "$.missing"); ok || err != nil {
+		t.Fatalf("missing path: ok=%v err=%v", ok, err)
+	}
+	if _, _, err := GetSlice(f, "$.image"); err == nil {
+		t.Fatalf("expected an error reading a mapping as a sequence")
+	}
+	if _, _, err := GetMap(f, "$.name"); err == nil {
+		t.Fatalf("expected an error reading a scalar as a mapping")
+	}
+}
+
+func TestSetStringPreservesCommentBlocks(t *testing.T) {
+	in := `# Values for app.
+# Maintained by the platform team;
+# see docs/values.md.
+
+image:
+  # The image repository.
+  # Mirrors must carry every tag.
+  repository: ghcr.io/org/app
+
+  # -- Image tag.
+  # Bumped by CI.
+
+  # Keep in sync with appVersion.
+  tag: "1.2.3" # pinned
+  pullPolicy: IfNotPresent
+
+# Sidecar settings
+# follow.
+sidecar:
+  tag: 0.9.0
+`
+	f, err := ParseBytes([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, v := range map[string]string{"$.image.tag": "1.3.0", "$.sidecar.tag": "0.10.0"} {
+		if changed, err := SetString(f, path, v); err != nil || !changed {
+			t.Fatalf("SetString(%s): changed=%v err=%v", path, changed, err)
+		}
+	}
+	out, err := Render(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(strings.Replace(in, `tag: "1.2.3"`, `tag: "1.3.0"`, 1), "tag: 0.9.0", "tag: 0.10.0", 1)
+	if out != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestSetStringStructuralChangeReencodes(t *testing.T) {
+	in := "# header\n\nimage:\n  tag: 1.2.3\n  notes: |\n    line one\n"
+	f, err := ParseBytes([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A block scalar can't be rewritten in place, so the whole document is re-encoded.
+	if _, err := SetString(f, "$.image.notes", "replaced"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetString(f, "$.image.tag", "1.3.0"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Render(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "# header") || !strings.Contains(out, "tag: 1.3.0") || !strings.Contains(out, "notes: replaced") {
+		t.Fatalf("unexpected render:\n%s", out)
+	}
+}
+
+func TestSetStringKeepingCommentLayoutReencodes(t *testing.T) {
+	// Only a lost comment layout triggers the in-place rewrite; other formatting is still
+	// normalized as goccy re-encodes it.
+	in := "# header\nimage:\n  tag:    1.2.3\n"
+	f, err := ParseBytes([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SetString(f, "$.image.tag", "1.3.0"); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Render(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "# header\nimage:\n  tag: 1.3.0\n"; out != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+	}
+}
+
+func TestDeleteKey(t *testing.T) {
+	in := `name: test
+deprecated: true
+image:
+  repository: ghcr.io/org/app # repo
+  tag: "1.2.3"
+  digest: sha256:abc
+dependencies:
+  - name: old
+    version: 1.0.0
+  - name: redis # cache
+    version: 19.0.0
+`
+	for name, tc := range map[string]struct {
+		path string
+		want string
+	}{
+		"top-level": {"$.deprecated", strings.Replace(in, "deprecated: true\n", "", 1)},
+		"nested":    {"$.image.digest", strings.Replace(in, "  digest: sha256:abc\n", "", 1)},
+		"element":   {"$.dependencies[0]", strings.Replace(in, "  - name: old\n    version: 1.0.0\n", "", 1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := ParseBytes([]byte(in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			removed, err := DeleteKey(f, tc.path)
+			if err != nil || !removed {
+				t.Fatalf("DeleteKey(%s): removed=%v err=%v", tc.path, removed, err)
+			}
+			out, err := Render(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Compare with the expected document as Render normalizes it.
+			wf, err := ParseBytes([]byte(tc.want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := Render(wf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != want {
+				t.Fatalf("got:\n%s\nwant:\n%s", out, want)
+			}
+		})
+	}
+
+	f, err := ParseBytes([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"$.missing", "$.image.missing", "$.dependencies[5]", "$.name.child"} {
+		if removed, err := DeleteKey(f, p); removed || err != nil {
+			t.Fatalf("DeleteKey(%s): removed=%v err=%v", p, removed, err)
+		}
+	}
+}
+
+func TestJSONPointerPaths(t *testing.T) {
+	src := `image:
+  tag: 1.2.3
+containers:
+  - name: app
+    image: ghcr.io/org/app:1.0.0
+annotations:
+  example.com/version: "1.0"
+  "0": zero
+`
+	for _, tc := range []struct{ pointer, dotted, want string }{
+		{"/image/tag", "$.image.tag", "1.2.3"},
+		{"/containers/0/image", "$.containers[0].image", "ghcr.io/org/app:1.0.0"},
+		{"/annotations/example.com~1version", "$.annotations.'example.com/version'", "1.0"},
+		{"/annotations/0", "$.annotations.0", "zero"},
+	} {
+		f, err := ParseBytes([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := NormalizePath(f, tc.pointer); err != nil || got != tc.dotted {
+			t.Fatalf("NormalizePath(%s) = %q, %v; want %q", tc.pointer, got, err, tc.dotted)
+		}
+		for _, p := range []string{tc.pointer, tc.dotted} {
+			if got, ok, err := GetString(f, p); err != nil || !ok || got != tc.want {
+				t.Fatalf("GetString(%s) = %q, %v, %v; want %q", p, got, ok, err, tc.want)
+			}
+		}
+
+		// Setting through either syntax renders the same document.
+		var out []string
+		for _, p := range []string{tc.pointer, tc.dotted} {
+			f, _ := ParseBytes([]byte(src))
+			if changed, err := SetString(f, p, "9.9.9"); err != nil || !changed {
+				t.Fatalf("SetString(%s): changed=%v err=%v", p, changed, err)
+			}
+			r, err := Render(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, r)
+		}
+		if out[0] != out[1] || !strings.Contains(out[0], "9.9.9") {
+			t.Fatalf("pointer and dotted SetString differ for %s:\n%s\nvs\n%s", tc.pointer, out[0], out[1])
+		}
+	}
+
+	f, _ := ParseBytes([]byte(src))
+	for _, bad := range []string{"/containers/x/image", "/containers/01", "/image/~2"} {
+		if _, _, err := GetString(f, bad); err == nil {
+			t.Fatalf("GetString(%s): expected an error", bad)
+		}
+	}
+	if ok, err := DeleteKey(f, "/annotations/example.com~1version"); err != nil || !ok {
+		t.Fatalf("DeleteKey: ok=%v err=%v", ok, err)
+	}
+	if _, ok, _ := GetString(f, "$.annotations.'example.com/version'"); ok {
+		t.Fatalf("key not deleted")
+	}
+}
+
+func TestDottedKeys(t *testing.T) {
+	src := `metadata:
+  annotations:
+    nginx.ingress.kubernetes.io/rewrite-target: /
+    # keep me
+    example.com/version: "1.0"
+  ingress.class: nginx
+`
+	f, err := ParseBytes([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ bracket, quoted, want string }{
+		{`$.metadata.annotations["nginx.ingress.kubernetes.io/rewrite-target"]`, `$.metadata.annotations.'nginx.ingress.kubernetes.io/rewrite-target'`, "/"},
+		{`$.metadata.annotations["example.com/version"]`, `$.metadata.annotations.'example.com/version'`, "1.0"},
+		{`$.metadata["ingress.class"]`, `$.metadata.'ingress.class'`, "nginx"},
+	} {
+		if got, err := NormalizePath(f, tc.bracket); err != nil || got != tc.quoted {
+			t.Fatalf("NormalizePath(%s) = %q, %v; want %q", tc.bracket, got, err, tc.quoted)
+		}
+		for _, p := range []string{tc.bracket, tc.quoted} {
+			if got, ok, err := GetString(f, p); err != nil || !ok || got != tc.want {
+				t.Fatalf("GetString(%s) = %q, %v, %v; want %q", p, got, ok, err, tc.want)
+			}
+		}
+	}
+
+	if _, err := SetString(f, `$.metadata.annotations["example.com/version"]`, "2.0"); err != nil {
+		t.Fatalf("SetString: %v", err)
+	}
+	if _, err := SetString(f, `$.metadata.'ingress.class'`, "traefik"); err != nil {
+		t.Fatalf("SetString: %v", err)
+	}
+	got, err := Render(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.NewReplacer(`"1.0"`, `"2.0"`, "class: nginx", "class: traefik").Replace(src)
+	if got != want {
+		t.Fatalf("unexpected render:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := ParentPath(`$.metadata.annotations.'example.com/version'`); got != "$.metadata.annotations" {
+		t.Fatalf("ParentPath got %q", got)
+	}
+	for _, bad := range []string{`$.metadata["unterminated]`, `$.metadata[""]`} {
+		if _, _, err := GetString(f, bad); err == nil {
+			t.Fatalf("GetString(%s): expected an error", bad)
+		}
+	}
+}